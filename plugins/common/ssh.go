@@ -390,7 +390,11 @@ func (task SshTask) Execute(ctx context.Context) (SshResult, error) {
 	exitCode := 0
 	execErr := cmd.Wait()
 	if execErr != nil {
-		if exitError, ok := execErr.(*exec.ExitError); ok {
+		// commands run over an ssh session report their exit status via ssh.ExitError
+		var sshExitError *ssh.ExitError
+		if errors.As(execErr, &sshExitError) {
+			exitCode = sshExitError.ExitStatus()
+		} else if exitError, ok := execErr.(*exec.ExitError); ok {
 			exitCode = exitError.ExitCode()
 		}
 	}
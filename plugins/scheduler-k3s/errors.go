@@ -0,0 +1,102 @@
+package scheduler_k3s
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrK3sNotInstalled is the kind of error returned when k3s is not installed on the current host
+var ErrK3sNotInstalled = errors.New("k3s not installed")
+
+// ErrNodeNotFound is the kind of error returned when a node does not exist in the cluster
+var ErrNodeNotFound = errors.New("node not found")
+
+// ErrKubernetesUnreachable is the kind of error returned when the kubernetes api cannot be reached
+var ErrKubernetesUnreachable = errors.New("kubernetes api unreachable")
+
+// ErrSshFailed is the kind of error returned when a command cannot be run on a remote host over ssh
+var ErrSshFailed = errors.New("ssh failed")
+
+// ErrCommandFailed is the kind of error returned when a local or remote command exits with a non-zero exit code
+var ErrCommandFailed = errors.New("command failed")
+
+// KindError is an error that can be matched against its kind via errors.Is, while keeping its original message
+type KindError struct {
+	// Kind is the sentinel error describing the category of the failure
+	Kind error
+
+	// Message is the message displayed to the user
+	Message string
+
+	// Err is the underlying error, if any
+	Err error
+}
+
+// Error returns the message of the error
+func (e *KindError) Error() string {
+	return e.Message
+}
+
+// Is returns whether the target is the kind of the error
+func (e *KindError) Is(target error) bool {
+	return target == e.Kind
+}
+
+// Unwrap returns the underlying error
+func (e *KindError) Unwrap() error {
+	return e.Err
+}
+
+// newKindError returns a KindError of the specified kind, using the message of the underlying error if none is specified
+func newKindError(kind error, err error, message string) error {
+	if message == "" && err != nil {
+		message = err.Error()
+	}
+
+	return &KindError{
+		Kind:    kind,
+		Message: message,
+		Err:     err,
+	}
+}
+
+// ExitCodeError is returned when a local or remote command exits with a non-zero exit code
+type ExitCodeError struct {
+	// Description describes the command that was run
+	Description string
+
+	// ExitCode is the exit code of the command
+	ExitCode int
+
+	// Output is the combined stderr and stdout of the command, if it was captured
+	Output string
+}
+
+// Error returns the message of the error, including the command output if it was captured
+func (e *ExitCodeError) Error() string {
+	if e.Output == "" {
+		return fmt.Sprintf("Invalid exit code from %s: %d", e.Description, e.ExitCode)
+	}
+
+	return fmt.Sprintf("Invalid exit code from %s: %d\n%s", e.Description, e.ExitCode, e.Output)
+}
+
+// Is returns whether the target is ErrCommandFailed
+func (e *ExitCodeError) Is(target error) bool {
+	return target == ErrCommandFailed
+}
+
+// exitCodeError returns an error for a command that exited non-zero, including its captured output when it was not streamed
+func exitCodeError(description string, exitCode int, stdout string, stderr string) error {
+	output := strings.TrimSpace(strings.TrimSpace(stderr) + "\n" + strings.TrimSpace(stdout))
+	if shouldStreamStdio() {
+		output = ""
+	}
+
+	return &ExitCodeError{
+		Description: description,
+		ExitCode:    exitCode,
+		Output:      output,
+	}
+}
@@ -1,14 +1,26 @@
 package scheduler_k3s
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -20,15 +32,22 @@ import (
 	nginxvhosts "github.com/dokku/dokku/plugins/nginx-vhosts"
 	resty "github.com/go-resty/resty/v2"
 	kedav1alpha1 "github.com/kedacore/keda/v2/apis/keda/v1alpha1"
+	"golang.org/x/net/http/httpproxy"
 	"golang.org/x/sync/errgroup"
 	"gopkg.in/yaml.v3"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8slabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/wait"
 	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	"k8s.io/client-go/tools/remotecommand"
 	"k8s.io/kubectl/pkg/util/term"
 	"k8s.io/kubernetes/pkg/client/conditions"
@@ -36,6 +55,466 @@ import (
 	"mvdan.cc/sh/v3/shell"
 )
 
+// AddClusterNodeInput contains all the information needed to add a node to the k3s cluster
+type AddClusterNodeInput struct {
+	// Role is the role of the node to add, either server or worker
+	Role string
+
+	// RemoteHost is the ssh url of the host to add
+	RemoteHost string
+
+	// ServerIP overrides the ip address of the server node to join through
+	ServerIP string
+
+	// AllowUknownHosts is whether to allow unknown ssh hosts
+	AllowUknownHosts bool
+
+	// TaintScheduling is whether to taint a server node against app workloads
+	TaintScheduling bool
+
+	// DryRun is whether to print the commands that would be run without joining the node
+	DryRun bool
+
+	// ForceReinstall is whether to run the k3s installer even if k3s is already installed
+	ForceReinstall bool
+
+	// NoWaitReady is whether to skip waiting for the node to become ready
+	NoWaitReady bool
+
+	// SkipDependencies is whether to skip installing apt dependencies
+	SkipDependencies bool
+
+	// Arch is the expected cpu architecture of the host
+	Arch string
+
+	// RegistryTestImage is an image to pull on the node to verify registry access
+	RegistryTestImage string
+
+	// Pool is the name of the node pool to add the node to
+	Pool string
+
+	// JoinServer overrides the url of the kubernetes api the node joins
+	JoinServer string
+
+	// Labels are key=value labels to apply to the node
+	Labels []string
+
+	// SshUser overrides the user in the ssh url
+	SshUser string
+
+	// SshPort overrides the port in the ssh url
+	SshPort int
+
+	// CacheInstaller is whether to copy a cached k3s installer to the host
+	CacheInstaller bool
+
+	// RefreshInstaller is whether to download the cached k3s installer again
+	RefreshInstaller bool
+
+	// SkipConnectivityCheck is whether to skip checking the host can reach the kubernetes api
+	SkipConnectivityCheck bool
+}
+
+// CertificateExpiry contains the expiry information of an app certificate
+type CertificateExpiry struct {
+	// AppName is the name of the app
+	AppName string
+
+	// Name is the name of the certificate
+	Name string
+
+	// Namespace is the namespace the certificate is in
+	Namespace string
+
+	// NotAfter is the time the certificate expires
+	NotAfter string
+
+	// DaysRemaining is the number of days until the certificate expires
+	DaysRemaining int
+
+	// Ready is whether the certificate is ready
+	Ready bool
+
+	// RenewalStatus is the reason or message of the certificate's ready condition
+	RenewalStatus string
+}
+
+// String returns a string representation of the certificate expiry
+func (c CertificateExpiry) String() string {
+	return fmt.Sprintf("%s|%s|%s|%s|%d|%s|%s", c.AppName, c.Name, c.Namespace, c.NotAfter, c.DaysRemaining, strconv.FormatBool(c.Ready), c.RenewalStatus)
+}
+
+// CheckReplicaSpreadInput contains all the information needed to check the spread of an app's replicas
+type CheckReplicaSpreadInput struct {
+	// AppName is the name of the app
+	AppName string
+
+	// Clientset is the kubernetes clientset
+	Clientset KubernetesClient
+
+	// DeploymentID is the id of the deployment to check
+	DeploymentID string
+
+	// Namespace is the namespace the app is deployed to
+	Namespace string
+
+	// Processes is a map of process types to their replica counts
+	Processes map[string]int32
+}
+
+// ClusterCapacity contains the total capacity of all ready nodes in the cluster
+type ClusterCapacity struct {
+	// ReadyNodes is the number of ready nodes
+	ReadyNodes int
+
+	// Resources is the capacity of each resource across all ready nodes
+	Resources []ResourceCapacity
+}
+
+// ClusterInfo contains the control-plane and etcd quorum health of the cluster
+type ClusterInfo struct {
+	// ServerNodes is the number of server nodes in the cluster
+	ServerNodes int
+
+	// ReadyServerNodes is the number of ready server nodes in the cluster
+	ReadyServerNodes int
+
+	// Quorum is the number of server nodes required for etcd quorum
+	Quorum int
+
+	// HasQuorum is whether enough server nodes are ready to maintain etcd quorum
+	HasQuorum bool
+
+	// FailureTolerance is the number of ready server nodes that can fail before quorum is lost
+	FailureTolerance int
+
+	// AtRisk is whether the cluster is one server failure away from losing quorum
+	AtRisk bool
+}
+
+// InitConfig is the effective configuration used to initialize the cluster
+type InitConfig struct {
+	// InitializedAt is the time the cluster was initialized
+	InitializedAt time.Time `json:"initialized_at"`
+
+	// K3sVersion is the version of k3s running on the initial server
+	K3sVersion string `json:"k3s_version"`
+
+	// K3sChannel is the release channel k3s was installed from, if any
+	K3sChannel string `json:"k3s_channel,omitempty"`
+
+	// IngressClass is the ingress controller installed in the cluster
+	IngressClass string `json:"ingress_class"`
+
+	// ServerIP is the ip address of the initial server
+	ServerIP string `json:"server_ip"`
+
+	// TaintScheduling is whether the initial server was tainted to only run critical addons
+	TaintScheduling bool `json:"taint_scheduling"`
+
+	// Disable is the list of packaged k3s components that were disabled
+	Disable []string `json:"disable"`
+
+	// FlannelBackend is the flannel backend used for the cluster network
+	FlannelBackend string `json:"flannel_backend"`
+
+	// FlannelWireguardPort is the udp port used by the wireguard-native flannel backend, if customized
+	FlannelWireguardPort int `json:"flannel_wireguard_port,omitempty"`
+
+	// IPFamily is the ip family of the cluster network
+	IPFamily string `json:"ip_family"`
+
+	// ClusterCIDR is the cidr range used for pod ips
+	ClusterCIDR string `json:"cluster_cidr"`
+
+	// ServiceCIDR is the cidr range used for service ips
+	ServiceCIDR string `json:"service_cidr"`
+
+	// DataDir is the k3s data directory
+	DataDir string `json:"data_dir"`
+
+	// ExpectNodes is the number of nodes the cluster was expected to grow to when initialized
+	ExpectNodes int `json:"expect_nodes"`
+}
+
+// ClusterExport is a backup of the Dokku-managed metadata for the nodes in the cluster
+type ClusterExport struct {
+	// ExportedAt is the time the export was created
+	ExportedAt time.Time `json:"exported_at"`
+
+	// InitConfig is the configuration the cluster was initialized with, if it was recorded on this host
+	InitConfig *InitConfig `json:"init_config,omitempty"`
+
+	// Nodes is the metadata for each node in the cluster
+	Nodes []ClusterExportNode `json:"nodes"`
+}
+
+// ClusterExportNode is the Dokku-managed metadata for a single node
+type ClusterExportNode struct {
+	// Name is the kubernetes node name
+	Name string `json:"name"`
+
+	// Role is the role property recorded for the node
+	Role string `json:"role,omitempty"`
+
+	// RemoteHost is the remote-host property recorded for the node
+	RemoteHost string `json:"remote_host,omitempty"`
+
+	// Annotations are the dokku.com/ annotations set on the node
+	Annotations map[string]string `json:"annotations"`
+
+	// Labels are the labels managed by Dokku set on the node
+	Labels map[string]string `json:"labels"`
+}
+
+// DoctorCheck is the result of a single diagnostic check run against the cluster
+type DoctorCheck struct {
+	// Name is the name of the check
+	Name string `json:"name"`
+
+	// Passed is whether the check passed
+	Passed bool `json:"passed"`
+
+	// Message describes the result of the check
+	Message string `json:"message"`
+
+	// Remediation is a hint for fixing a failed check
+	Remediation string `json:"remediation,omitempty"`
+}
+
+// NodeWorkload is a pod running on a node that is about to be removed
+type NodeWorkload struct {
+	// Namespace is the namespace of the pod
+	Namespace string `json:"namespace"`
+
+	// Pod is the name of the pod
+	Pod string `json:"pod"`
+
+	// Owner is the kind and name of the controller managing the pod
+	Owner string `json:"owner"`
+
+	// PodDisruptionBudget is the name of the pod disruption budget covering the pod, if any
+	PodDisruptionBudget string `json:"pod_disruption_budget"`
+
+	// PersistentVolumeClaims are the persistent volume claims mounted by the pod
+	PersistentVolumeClaims []string `json:"persistent_volume_claims"`
+
+	// Risks describes why removing the node may cause downtime for the pod
+	Risks []string `json:"risks"`
+}
+
+// Risky returns whether removing the node may cause downtime for the pod
+func (w NodeWorkload) Risky() bool {
+	return len(w.Risks) > 0
+}
+
+// NodeVolume is a longhorn volume with no healthy replica outside of a node that is about to be removed
+type NodeVolume struct {
+	// Volume is the name of the longhorn volume
+	Volume string `json:"volume"`
+
+	// PersistentVolumeClaim is the namespace and name of the claim bound to the volume, if any
+	PersistentVolumeClaim string `json:"persistent_volume_claim"`
+
+	// HealthyReplicas is the number of healthy replicas of the volume across the cluster
+	HealthyReplicas int `json:"healthy_replicas"`
+
+	// Pods are the pods mounting the claim, wherever they are scheduled
+	Pods []string `json:"pods"`
+}
+
+// NodeRemovalSummary lists the workloads and volume replicas on a node that is about to be removed
+type NodeRemovalSummary struct {
+	// Node is the name of the node
+	Node string `json:"node"`
+
+	// Risky is whether removing the node may lose volume data or cause downtime for stateful pods
+	Risky bool `json:"risky"`
+
+	// Workloads are the pods running on the node, excluding daemonset and mirror pods
+	Workloads []NodeWorkload `json:"workloads"`
+
+	// Volumes are the longhorn volumes whose only healthy replicas are stored on the node
+	Volumes []NodeVolume `json:"volumes"`
+}
+
+// KubeconfigSummary is a structured view of a kubeconfig context with credentials redacted by default
+type KubeconfigSummary struct {
+	// CurrentContext is the current context set in the kubeconfig
+	CurrentContext string `json:"current_context"`
+
+	// Context is the context that was summarized
+	Context string `json:"context"`
+
+	// Cluster is the name of the cluster used by the context
+	Cluster string `json:"cluster"`
+
+	// Server is the url of the kubernetes api server
+	Server string `json:"server"`
+
+	// User is the name of the user used by the context
+	User string `json:"user"`
+
+	// HasCertificateAuthorityData is whether the cluster has certificate authority data
+	HasCertificateAuthorityData bool `json:"has_certificate_authority_data"`
+
+	// HasClientCertificateData is whether the user has client certificate data
+	HasClientCertificateData bool `json:"has_client_certificate_data"`
+
+	// HasClientKeyData is whether the user has client key data
+	HasClientKeyData bool `json:"has_client_key_data"`
+
+	// HasToken is whether the user has a bearer token
+	HasToken bool `json:"has_token"`
+
+	// CertificateAuthorityData is the base64-encoded certificate authority data, only set when secrets are included
+	CertificateAuthorityData string `json:"certificate_authority_data,omitempty"`
+
+	// ClientCertificateData is the base64-encoded client certificate data, only set when secrets are included
+	ClientCertificateData string `json:"client_certificate_data,omitempty"`
+
+	// ClientKeyData is the base64-encoded client key data, only set when secrets are included
+	ClientKeyData string `json:"client_key_data,omitempty"`
+
+	// Token is the bearer token, only set when secrets are included
+	Token string `json:"token,omitempty"`
+}
+
+// AirgapFile is a file staged on the Dokku server to copy into place on a node for air-gapped installs
+type AirgapFile struct {
+	// Source is the path to the file on the Dokku server
+	Source string
+
+	// Destination is the path to write the file to on the node
+	Destination string
+}
+
+// AppRuntimeStatus is the live state of an app's workloads in the cluster
+type AppRuntimeStatus struct {
+	// RunningPods is the number of running pods for the app
+	RunningPods int
+
+	// PendingPods is the number of pods for the app waiting to be scheduled or started
+	PendingPods int
+
+	// FailedPods is the number of failed pods for the app
+	FailedPods int
+
+	// Revision is the currently deployed helm release revision for the app
+	Revision string
+}
+
+// CopyFileToNodeInput contains all the information needed to copy a file to a node
+type CopyFileToNodeInput struct {
+	// AllowUknownHosts allows connecting to hosts with unknown host keys
+	AllowUknownHosts bool
+
+	// Contents is the contents of the file
+	Contents []byte
+
+	// Path is the path to write the file to on the node
+	Path string
+
+	// RemoteHost is the ssh url of the node
+	RemoteHost string
+
+	// Restart is whether to restart k3s so the file is loaded
+	Restart bool
+
+	// Service is the k3s systemd service running on the node
+	Service string
+}
+
+// VerifyRegistryOnNodeInput contains all the information needed to verify the registry config on a node
+type VerifyRegistryOnNodeInput struct {
+	// AllowUknownHosts allows connecting to hosts with unknown host keys
+	AllowUknownHosts bool
+
+	// Contents is the rendered registries.yaml expected on the node
+	Contents []byte
+
+	// RemoteHost is the ssh url of the node
+	RemoteHost string
+}
+
+// PullImageOnNodeInput contains all the information needed to pull an image on a node
+type PullImageOnNodeInput struct {
+	// AllowUknownHosts allows connecting to hosts with unknown host keys
+	AllowUknownHosts bool
+
+	// Image is the image to pull
+	Image string
+
+	// RemoteHost is the ssh url of the node
+	RemoteHost string
+}
+
+// UpdateNodeTokenInput contains all the information needed to update the k3s token on a node
+type UpdateNodeTokenInput struct {
+	// AllowUknownHosts allows connecting to hosts with unknown host keys
+	AllowUknownHosts bool
+
+	// NewToken is the token to write into the k3s service
+	NewToken string
+
+	// OldToken is the token currently in the k3s service
+	OldToken string
+
+	// RemoteHost is the ssh url of the node
+	RemoteHost string
+
+	// Restart is whether to restart k3s so the new token is loaded
+	Restart bool
+
+	// Service is the k3s systemd service running on the node
+	Service string
+}
+
+// CompleteNodeJoinInput contains all the information needed to complete a node join
+type CompleteNodeJoinInput struct {
+	// Clientset is the kubernetes clientset
+	Clientset KubernetesClient
+
+	// Labels are additional labels to apply to the node, overriding role labels with the same key
+	Labels map[string]string
+
+	// JoinedAt is the time the node joined the cluster, or the zero time to leave the joined-at annotation unchanged
+	JoinedAt time.Time
+
+	// NodeName is the name of the node
+	NodeName string
+
+	// RemoteHost is the remote host the node was joined from
+	RemoteHost string
+
+	// Role is the role the node was joined as
+	Role string
+}
+
+// RemoveClusterNodeInput contains all the information needed to remove a node from the cluster
+type RemoveClusterNodeInput struct {
+	// Clientset is the kubernetes clientset
+	Clientset KubernetesClient
+
+	// DrainGracePeriod is the amount of time evicted pods are given to terminate
+	DrainGracePeriod time.Duration
+
+	// DrainTimeout is the amount of time to wait for all pods to be evicted
+	DrainTimeout time.Duration
+
+	// NodeName is the name of the node to remove
+	NodeName string
+
+	// NoDrain skips draining the node before removing it
+	NoDrain bool
+
+	// SshPort overrides the stored ssh port when non-zero
+	SshPort int
+
+	// SshUser overrides the stored ssh user when non-empty
+	SshUser string
+}
+
 // EnterPodInput contains all the information needed to enter a pod
 type EnterPodInput struct {
 	// Clientset is the kubernetes clientset
@@ -57,11 +536,32 @@ type EnterPodInput struct {
 	WaitTimeout int
 }
 
+// FinalizeInitializeInput contains all the information needed to finalize a k3s initialization
+type FinalizeInitializeInput struct {
+	// Clientset is the kubernetes clientset
+	Clientset KubernetesClient
+
+	// IngressClass is the ingress class to install charts for
+	IngressClass string
+
+	// NodeName is the name of the local server node
+	NodeName string
+}
+
 // Node contains information about a node
 type Node struct {
 	// Name is the name of the node
 	Name string
 
+	// Age is the amount of time since the node joined the cluster
+	Age string
+
+	// JoinStatus is whether all join steps were completed for the node
+	JoinStatus string
+
+	// JoinIssues is a list of join steps that were not completed for the node
+	JoinIssues []string
+
 	// Roles is the roles of the node
 	Roles []string
 
@@ -73,11 +573,196 @@ type Node struct {
 
 	// Version is the version of the node
 	Version string
+
+	// InternalIP is the internal ip address of the node
+	InternalIP string
+
+	// ExternalIP is the external ip address of the node
+	ExternalIP string
+
+	// Schedulable is whether new pods can be scheduled on the node
+	Schedulable bool
+
+	// Architecture is the cpu architecture of the node
+	Architecture string
+
+	// Pool is the name of the node pool the node belongs to
+	Pool string
+
+	// JoinedAt is the time the node was joined to the cluster by dokku
+	JoinedAt string
+
+	// ManagedBy is the tool that manages the node
+	ManagedBy string
+
+	// CPUCapacity is the number of cpu cores on the node
+	CPUCapacity string
+
+	// CPUAllocatable is the number of cpu cores available to pods on the node
+	CPUAllocatable string
+
+	// MemoryCapacity is the total memory on the node
+	MemoryCapacity string
+
+	// MemoryAllocatable is the memory available to pods on the node
+	MemoryAllocatable string
 }
 
 // String returns a string representation of the node
 func (n Node) String() string {
-	return fmt.Sprintf("%s|%s|%s|%s", n.Name, strconv.FormatBool(n.Ready), strings.Join(n.Roles, ","), n.Version)
+	return fmt.Sprintf("%s|%s|%s|%s|%s|%s", n.Name, strconv.FormatBool(n.Ready), strings.Join(n.Roles, ","), n.Version, n.Age, n.JoinStatus)
+}
+
+// ExtendedString returns a string representation of the node including its addresses, architecture, pool, join metadata, and resources
+func (n Node) ExtendedString() string {
+	return fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s|%s|%s|%s|%s|%s", n.String(), n.InternalIP, n.ExternalIP, strconv.FormatBool(n.Schedulable), n.Architecture, n.Pool, n.JoinedAt, n.ManagedBy, n.CPUCapacity, n.CPUAllocatable, n.MemoryCapacity, n.MemoryAllocatable)
+}
+
+// NodePool contains the nodes that belong to a node pool
+type NodePool struct {
+	// Name is the name of the pool, or empty for nodes without a pool
+	Name string
+
+	// Nodes is the names of the nodes in the pool
+	Nodes []string
+
+	// ReadyNodes is the number of ready nodes in the pool
+	ReadyNodes int
+}
+
+// String returns a string representation of the node pool
+func (p NodePool) String() string {
+	name := p.Name
+	if name == "" {
+		name = "-"
+	}
+	return fmt.Sprintf("%s|%d|%d|%s", name, len(p.Nodes), p.ReadyNodes, strings.Join(p.Nodes, ","))
+}
+
+// ResourceLimitsAudit contains the resource limits status of an app process type
+type ResourceLimitsAudit struct {
+	// AppName is the name of the app
+	AppName string
+
+	// ProcessType is the process type of the deployment
+	ProcessType string
+
+	// Namespace is the namespace the deployment is in
+	Namespace string
+
+	// MissingCPULimit is whether any container in the deployment is missing a cpu limit
+	MissingCPULimit bool
+
+	// MissingMemoryLimit is whether any container in the deployment is missing a memory limit
+	MissingMemoryLimit bool
+}
+
+// String returns a string representation of the resource limits audit
+func (r ResourceLimitsAudit) String() string {
+	cpuLimit := "set"
+	if r.MissingCPULimit {
+		cpuLimit = "missing"
+	}
+
+	memoryLimit := "set"
+	if r.MissingMemoryLimit {
+		memoryLimit = "missing"
+	}
+
+	return fmt.Sprintf("%s|%s|%s|%s|%s", r.AppName, r.ProcessType, r.Namespace, cpuLimit, memoryLimit)
+}
+
+// PendingPod contains information about a pod that has not been scheduled
+type PendingPod struct {
+	// Name is the name of the pod
+	Name string
+
+	// Namespace is the namespace the pod is in
+	Namespace string
+
+	// AppName is the name of the app the pod belongs to
+	AppName string
+
+	// Age is the amount of time since the pod was created
+	Age string
+
+	// Reason is the reason the pod has not been scheduled
+	Reason string
+}
+
+// String returns a string representation of the pending pod
+func (p PendingPod) String() string {
+	return fmt.Sprintf("%s|%s|%s|%s|%s", p.Namespace, p.Name, p.AppName, p.Age, p.Reason)
+}
+
+// HelmChartStatus contains the declared and deployed versions of a helm chart
+type HelmChartStatus struct {
+	// ReleaseName is the name of the helm release
+	ReleaseName string `json:"release_name"`
+
+	// Namespace is the namespace the release is installed in
+	Namespace string `json:"namespace"`
+
+	// DeclaredVersion is the chart version dokku installs
+	DeclaredVersion string `json:"declared_version"`
+
+	// DeployedVersion is the chart version of the latest successfully deployed revision
+	DeployedVersion string `json:"deployed_version"`
+
+	// Revision is the latest revision of the release
+	Revision int `json:"revision"`
+
+	// Status is the status of the latest revision, or not-installed if the release does not exist
+	Status string `json:"status"`
+
+	// Drift is whether the deployed release does not match the declared chart
+	Drift bool `json:"drift"`
+}
+
+// String returns a string representation of the helm chart status
+func (s HelmChartStatus) String() string {
+	return fmt.Sprintf("%s|%s|%s|%s|%d|%s|%t", s.Namespace, s.ReleaseName, s.DeclaredVersion, s.DeployedVersion, s.Revision, s.Status, s.Drift)
+}
+
+// ResourceCapacity contains the allocatable, requested, and remaining amount of a resource
+type ResourceCapacity struct {
+	// Name is the name of the resource
+	Name string
+
+	// Allocatable is the total allocatable amount of the resource
+	Allocatable string
+
+	// Requested is the total amount of the resource requested by pods
+	Requested string
+
+	// Headroom is the amount of the resource that has not been requested
+	Headroom string
+
+	// FreePercent is the percentage of the allocatable resource that has not been requested
+	FreePercent float64
+}
+
+// String returns a string representation of the resource capacity
+func (r ResourceCapacity) String() string {
+	return fmt.Sprintf("%s|%s|%s|%s|%.1f%%", r.Name, r.Allocatable, r.Requested, r.Headroom, r.FreePercent)
+}
+
+// StreamServiceJournalInput contains all the information needed to stream the journal of a k3s service
+type StreamServiceJournalInput struct {
+	// AllowUknownHosts is whether to allow unknown ssh hosts
+	AllowUknownHosts bool
+
+	// Follow is whether to keep streaming new journal entries
+	Follow bool
+
+	// Lines is the number of journal lines to show
+	Lines int64
+
+	// RemoteHost is the ssh url of the node, or empty for the local host
+	RemoteHost string
+
+	// Service is the systemd unit to show the journal of
+	Service string
 }
 
 // StartCommandInput contains all the information needed to get the start command
@@ -105,6 +790,75 @@ type WaitForNodeToExistInput struct {
 	Namespace  string
 	RetryCount int
 	NodeName   string
+
+	// Backoff is the initial amount of time to wait between attempts
+	Backoff time.Duration
+
+	// MaxBackoff is the maximum amount of time to wait between attempts
+	MaxBackoff time.Duration
+
+	// Timeout is the total amount of time to wait for the node, with no limit if zero
+	Timeout time.Duration
+}
+
+// WaitForNodeReadyInput contains all the information needed to wait for a node to become ready
+type WaitForNodeReadyInput struct {
+	// Clientset is the kubernetes clientset
+	Clientset KubernetesClient
+
+	// NodeName is the name of the node to wait for
+	NodeName string
+
+	// Backoff is the initial amount of time to wait between attempts
+	Backoff time.Duration
+
+	// MaxBackoff is the maximum amount of time to wait between attempts
+	MaxBackoff time.Duration
+
+	// Timeout is the total amount of time to wait for the node, with no limit if zero
+	Timeout time.Duration
+}
+
+// CoreComponent is a workload installed during initialization that deploys depend on
+type CoreComponent struct {
+	// Name is the name displayed for the component
+	Name string
+
+	// Kind is the kind of the workload, either deployment or daemonset
+	Kind string
+
+	// Namespace is the namespace the workload runs in
+	Namespace string
+
+	// ResourceName is the name of the workload
+	ResourceName string
+}
+
+// WaitForCoreComponentsReadyInput contains all the information needed to wait for the core components to be ready
+type WaitForCoreComponentsReadyInput struct {
+	// Clientset is the kubernetes clientset
+	Clientset KubernetesClient
+
+	// Components are the core components to wait for
+	Components []CoreComponent
+
+	// Timeout is the amount of time to wait for the components to be ready
+	Timeout time.Duration
+}
+
+// WaitForAppDeploymentsReadyInput contains all the information needed to wait for an app's deployments to be ready
+type WaitForAppDeploymentsReadyInput struct {
+	// AppName is the name of the app
+	AppName string
+
+	// Clientset is the kubernetes clientset
+	Clientset KubernetesClient
+
+	// Namespace is the namespace the app is deployed to
+	Namespace string
+
+	// Timeout is the amount of time to wait for the deployments to be ready
+	Timeout time.Duration
 }
 
 type WaitForPodBySelectorRunningInput struct {
@@ -237,7 +991,34 @@ func applyKedaClusterTriggerAuthentications(ctx context.Context, triggerType str
 	return nil
 }
 
+// applyMetalLBAddressPool configures the metallb address pool from the metallb-address-pool property
+func applyMetalLBAddressPool(ctx context.Context, clientset KubernetesClient) error {
+	if getGlobalLoadbalancer() != "metallb" {
+		common.LogVerboseQuiet("Skipping metallb address pool, loadbalancer is not metallb")
+		return nil
+	}
+
+	addresses, err := parseMetalLBAddressPool(getGlobalMetalLBAddressPool())
+	if err != nil {
+		return err
+	}
+
+	// the metallb webhook may still be starting immediately after the chart is installed
+	return retryHelmCall(ctx, "configuring metallb address pool", func() error {
+		return clientset.ApplyMetalLBAddressPool(ctx, ApplyMetalLBAddressPoolInput{
+			Addresses: addresses,
+			Name:      MetalLBAddressPoolName,
+			Namespace: MetalLBNamespace,
+		})
+	})
+}
+
 func applyClusterIssuers(ctx context.Context) error {
+	if !getGlobalInstallCertManager() {
+		common.LogVerboseQuiet("Skipping cluster issuers, install-cert-manager is false")
+		return nil
+	}
+
 	chartDir, err := os.MkdirTemp("", "cluster-issuer-chart-")
 	if err != nil {
 		return fmt.Errorf("Error creating cluster-issuer chart directory: %w", err)
@@ -264,6 +1045,8 @@ func applyClusterIssuers(ctx context.Context) error {
 	// create the values.yaml
 	letsencryptEmailStag := getGlobalLetsencryptEmailStag()
 	letsencryptEmailProd := getGlobalLetsencryptEmailProd()
+	letsencryptServer := getGlobalLetsencryptServer()
+	customServer := getCustomLetsencryptServer(letsencryptServer)
 
 	clusterIssuerValues := ClusterIssuerValues{
 		ClusterIssuers: map[string]ClusterIssuer{
@@ -272,14 +1055,21 @@ func applyClusterIssuers(ctx context.Context) error {
 				Enabled:      letsencryptEmailStag != "",
 				IngressClass: getGlobalIngressClass(),
 				Name:         "letsencrypt-stag",
-				Server:       "https://acme-staging-v02.api.letsencrypt.org/directory",
+				Server:       LetsencryptStagServer,
 			},
 			"letsencrypt-prod": {
 				Email:        letsencryptEmailProd,
 				Enabled:      letsencryptEmailProd != "",
 				IngressClass: getGlobalIngressClass(),
 				Name:         "letsencrypt-prod",
-				Server:       "https://acme-v02.api.letsencrypt.org/directory",
+				Server:       LetsencryptProdServer,
+			},
+			LetsencryptCustomIssuerName: {
+				Email:        letsencryptEmailProd,
+				Enabled:      letsencryptEmailProd != "" && customServer != "",
+				IngressClass: getGlobalIngressClass(),
+				Name:         LetsencryptCustomIssuerName,
+				Server:       customServer,
 			},
 		},
 	}
@@ -343,1317 +1133,6150 @@ func applyClusterIssuers(ctx context.Context) error {
 	return nil
 }
 
-func createKubernetesNamespace(ctx context.Context, namespaceName string) error {
-	clientset, err := NewKubernetesClient()
+// getClusterCapacity sums the allocatable resources and pod requests across all ready nodes
+func getClusterCapacity(ctx context.Context, clientset KubernetesClient) (ClusterCapacity, error) {
+	nodes, err := clientset.ListNodes(ctx, ListNodesInput{})
 	if err != nil {
-		return err
+		return ClusterCapacity{}, fmt.Errorf("Unable to list nodes: %w", err)
 	}
 
-	namespace := corev1.Namespace{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: namespaceName,
-			Annotations: map[string]string{
-				"dokku.com/managed": "true",
-			},
-			Labels: map[string]string{
-				"dokku.com/managed": "true",
-			},
-		},
+	resourceNames := []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory}
+	allocatable := v1.ResourceList{}
+	requested := v1.ResourceList{}
+	readyNodes := map[string]bool{}
+	for _, node := range nodes {
+		if !kubernetesNodeToNode(node).Ready {
+			continue
+		}
+
+		readyNodes[node.Name] = true
+		for _, name := range resourceNames {
+			quantity := allocatable[name]
+			quantity.Add(node.Status.Allocatable[name])
+			allocatable[name] = quantity
+		}
 	}
-	_, err = clientset.CreateNamespace(ctx, CreateNamespaceInput{
-		Name: namespace,
-	})
+
+	pods, err := clientset.ListPods(ctx, ListPodsInput{})
 	if err != nil {
-		return err
+		return ClusterCapacity{}, fmt.Errorf("Unable to list pods: %w", err)
 	}
 
-	return nil
-}
+	for _, pod := range pods {
+		if !readyNodes[pod.Spec.NodeName] {
+			continue
+		}
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
 
-func enterPod(ctx context.Context, input EnterPodInput) error {
-	coreclient, err := corev1client.NewForConfig(&input.Clientset.RestConfig)
-	if err != nil {
-		return fmt.Errorf("Error creating corev1 client: %w", err)
+		for _, name := range resourceNames {
+			quantity := requested[name]
+			quantity.Add(getPodResourceRequest(pod, name))
+			requested[name] = quantity
+		}
 	}
 
-	labelSelector := []string{}
-	for k, v := range input.SelectedPod.Labels {
-		labelSelector = append(labelSelector, fmt.Sprintf("%s=%s", k, v))
+	capacity := ClusterCapacity{
+		ReadyNodes: len(readyNodes),
+		Resources:  []ResourceCapacity{},
 	}
+	for _, name := range resourceNames {
+		allocatableQuantity := allocatable[name]
+		requestedQuantity := requested[name]
+		headroom := allocatableQuantity.DeepCopy()
+		headroom.Sub(requestedQuantity)
 
-	if input.WaitTimeout > 0 {
-		input.WaitTimeout = 5
+		freePercent := float64(0)
+		if allocatableQuantity.MilliValue() > 0 {
+			freePercent = float64(headroom.MilliValue()) / float64(allocatableQuantity.MilliValue()) * 100
+		}
+
+		capacity.Resources = append(capacity.Resources, ResourceCapacity{
+			Name:        string(name),
+			Allocatable: allocatableQuantity.String(),
+			Requested:   requestedQuantity.String(),
+			Headroom:    headroom.String(),
+			FreePercent: freePercent,
+		})
 	}
 
-	err = waitForPodBySelectorRunning(ctx, WaitForPodBySelectorRunningInput{
-		Clientset:     input.Clientset,
-		Namespace:     input.SelectedPod.Namespace,
-		LabelSelector: strings.Join(labelSelector, ","),
-		PodName:       input.SelectedPod.Name,
-		Timeout:       input.WaitTimeout,
-		Waiter:        isPodReady,
-	})
+	return capacity, nil
+}
+
+// checkReplicaSpread returns the process types whose replicas are spread across nodes more unevenly than their topology constraints allow
+func checkReplicaSpread(ctx context.Context, input CheckReplicaSpreadInput) ([]string, error) {
+	nodes, err := input.Clientset.ListNodes(ctx, ListNodesInput{})
 	if err != nil {
-		return fmt.Errorf("Error waiting for pod to be ready: %w", err)
+		return []string{}, fmt.Errorf("Unable to list nodes: %w", err)
 	}
 
-	defaultContainerName, hasDefaultContainer := input.SelectedPod.Annotations["kubectl.kubernetes.io/default-container"]
-	if input.SelectedContainerName == "" && hasDefaultContainer {
-		input.SelectedContainerName = defaultContainerName
+	schedulableNodes := []v1.Node{}
+	for _, node := range nodes {
+		if node.Spec.Unschedulable || !kubernetesNodeToNode(node).Ready {
+			continue
+		}
+
+		tainted := false
+		for _, taint := range node.Spec.Taints {
+			if taint.Effect == v1.TaintEffectNoSchedule || taint.Effect == v1.TaintEffectNoExecute {
+				tainted = true
+				break
+			}
+		}
+		if !tainted {
+			schedulableNodes = append(schedulableNodes, node)
+		}
 	}
-	if input.SelectedContainerName == "" {
-		return fmt.Errorf("No container specified and no default container found")
+
+	processTypes := []string{}
+	for processType := range input.Processes {
+		processTypes = append(processTypes, processType)
 	}
+	sort.Strings(processTypes)
 
-	req := coreclient.RESTClient().Post().
-		Resource("pods").
-		Namespace(input.SelectedPod.Namespace).
-		Name(input.SelectedPod.Name).
-		SubResource("exec")
+	clustered := []string{}
+	for _, processType := range processTypes {
+		replicas := int(input.Processes[processType])
+		if replicas < 2 {
+			continue
+		}
 
-	req.Param("container", input.SelectedContainerName)
-	req.Param("stdin", "true")
-	req.Param("stdout", "true")
-	req.Param("stderr", "true")
-	req.Param("tty", "true")
+		pods, err := input.Clientset.ListPods(ctx, ListPodsInput{
+			Namespace:     input.Namespace,
+			LabelSelector: fmt.Sprintf("app.kubernetes.io/part-of=%s,app.kubernetes.io/name=%s", input.AppName, processType),
+		})
+		if err != nil {
+			return []string{}, fmt.Errorf("Unable to list pods: %w", err)
+		}
 
-	if input.Entrypoint != "" {
-		req.Param("command", input.Entrypoint)
-	}
-	for _, cmd := range input.Command {
-		req.Param("command", cmd)
-	}
+		distribution := map[string]int{}
+		spec, scheduled := v1.PodSpec{}, false
+		for _, pod := range pods {
+			if pod.DeletionTimestamp != nil || pod.Spec.NodeName == "" {
+				continue
+			}
+			if pod.Annotations["app.kubernetes.io/version"] != input.DeploymentID {
+				continue
+			}
 
-	t := term.TTY{
-		In:  os.Stdin,
-		Out: os.Stdout,
-		Raw: true,
-	}
-	size := t.GetSize()
-	sizeQueue := t.MonitorSize(size)
+			distribution[pod.Spec.NodeName]++
+			spec, scheduled = pod.Spec, true
+		}
 
-	return t.Safe(func() error {
-		exec, err := remotecommand.NewSPDYExecutor(&input.Clientset.RestConfig, "POST", req.URL())
-		if err != nil {
-			return fmt.Errorf("Error creating executor: %w", err)
+		nodeNames := []string{}
+		for nodeName := range distribution {
+			nodeNames = append(nodeNames, nodeName)
 		}
+		sort.Strings(nodeNames)
 
-		return exec.StreamWithContext(ctx, remotecommand.StreamOptions{
-			Stdin:             os.Stdin,
-			Stdout:            os.Stdout,
-			Stderr:            os.Stderr,
-			Tty:               true,
-			TerminalSizeQueue: sizeQueue,
-		})
-	})
-}
+		counts := []string{}
+		for _, nodeName := range nodeNames {
+			counts = append(counts, fmt.Sprintf("%s=%d", nodeName, distribution[nodeName]))
+		}
+		common.LogVerboseQuiet(fmt.Sprintf("%s replicas by node: %s", processType, strings.Join(counts, ", ")))
 
-func extractStartCommand(input StartCommandInput) string {
-	command := ""
-	if input.ImageSourceType == "herokuish" {
-		return "/start " + input.ProcessType
-	}
+		if !scheduled {
+			continue
+		}
 
-	resp, err := common.CallPlugnTrigger(common.PlugnTriggerInput{
-		Trigger: "config-get",
-		Args:    []string{input.AppName, "DOKKU_START_CMD"},
-	})
-	if err == nil && resp.ExitCode == 0 && len(resp.Stdout) > 0 {
-		command = strings.TrimSpace(resp.Stdout)
+		// only nodes the pods could have been scheduled on count towards the spread
+		eligible := map[string]int{}
+		for _, node := range schedulableNodes {
+			if k8slabels.SelectorFromSet(spec.NodeSelector).Matches(k8slabels.Set(node.Labels)) {
+				eligible[node.Name] = 0
+			}
+		}
+		for nodeName, count := range distribution {
+			eligible[nodeName] = count
+		}
+
+		if getNodeSkew(eligible) > getHostnameMaxSkew(spec) {
+			clustered = append(clustered, processType)
+		}
 	}
 
-	if input.ImageSourceType == "dockerfile" {
-		resp, err := common.CallPlugnTrigger(common.PlugnTriggerInput{
-			Trigger: "config-get",
-			Args:    []string{input.AppName, "DOKKU_DOCKERFILE_START_CMD"},
-		})
-		if err == nil && resp.ExitCode == 0 && len(resp.Stdout) > 0 {
-			command = strings.TrimSpace(resp.Stdout)
+	return clustered, nil
+}
+
+// getHostnameMaxSkew returns the maximum difference in replicas between nodes that a pod spec's constraints allow,
+// falling back to the kube-scheduler's default topology spread constraints when none are set
+func getHostnameMaxSkew(spec v1.PodSpec) int {
+	for _, constraint := range spec.TopologySpreadConstraints {
+		if constraint.TopologyKey == v1.LabelHostname {
+			return int(constraint.MaxSkew)
 		}
 	}
 
-	if command == "" {
-		results, _ := common.CallPlugnTrigger(common.PlugnTriggerInput{
-			Trigger: "procfile-get-command",
-			Args:    []string{input.AppName, input.ProcessType, fmt.Sprint(input.Port)},
-		})
-		command = results.StdoutContents()
+	if spec.Affinity != nil && spec.Affinity.PodAntiAffinity != nil {
+		antiAffinity := spec.Affinity.PodAntiAffinity
+		for _, term := range antiAffinity.RequiredDuringSchedulingIgnoredDuringExecution {
+			if term.TopologyKey == v1.LabelHostname {
+				return 1
+			}
+		}
+		for _, term := range antiAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+			if term.PodAffinityTerm.TopologyKey == v1.LabelHostname {
+				return 1
+			}
+		}
 	}
 
-	return command
+	return DefaultHostnameMaxSkew
 }
 
-// getAnnotations retrieves annotations for a given app and process type
-func getAnnotations(appName string, processType string) (ProcessAnnotations, error) {
-	annotations := ProcessAnnotations{}
-	certificateAnnotations, err := getAnnotation(appName, processType, "certificate")
-	if err != nil {
-		return annotations, err
+// getNodeSkew returns the difference between the most and least replicas running on any one node
+func getNodeSkew(distribution map[string]int) int {
+	if len(distribution) == 0 {
+		return 0
 	}
-	annotations.CertificateAnnotations = certificateAnnotations
 
-	cronJobAnnotations, err := getAnnotation(appName, processType, "cronjob")
-	if err != nil {
-		return annotations, err
+	counts := []int{}
+	for _, count := range distribution {
+		counts = append(counts, count)
 	}
-	annotations.CronJobAnnotations = cronJobAnnotations
 
-	deploymentAnnotations, err := getAnnotation(appName, processType, "deployment")
-	if err != nil {
-		return annotations, err
-	}
-	annotations.DeploymentAnnotations = deploymentAnnotations
+	return slices.Max(counts) - slices.Min(counts)
+}
 
-	ingressAnnotations, err := getIngressAnnotations(appName, processType)
-	if err != nil {
-		return annotations, err
-	}
-	annotations.IngressAnnotations = ingressAnnotations
+// callRemoteStep executes a command on a remote host via ssh, failing if it does not complete within the timeout
+func callRemoteStep(ctx context.Context, timeout time.Duration, description string, input common.SshCommandInput) (common.SshResult, error) {
+	stepCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-	jobAnnotations, err := getAnnotation(appName, processType, "job")
-	if err != nil {
-		return annotations, err
+	result, err := common.CallSshCommandWithContext(stepCtx, input)
+	if ctx.Err() != nil {
+		return result, fmt.Errorf("Unable to call %s: cancelled: %w", description, ctx.Err())
 	}
-	annotations.JobAnnotations = jobAnnotations
-
-	kedaScalingObjectAnnotations, err := getAnnotation(appName, processType, "keda_scaled_object")
-	if err != nil {
-		return annotations, err
+	if errors.Is(stepCtx.Err(), context.DeadlineExceeded) {
+		return result, fmt.Errorf("Unable to call %s: timed out after %s", description, timeout)
 	}
-	annotations.KedaScalingObjectAnnotations = kedaScalingObjectAnnotations
 
-	kedaSecretAnnotations, err := getAnnotation(appName, processType, "keda_secret")
-	if err != nil {
-		return annotations, err
-	}
-	annotations.KedaSecretAnnotations = kedaSecretAnnotations
+	return result, remoteStepError(description, result, err)
+}
 
-	kedaTriggerAuthenticationAnnotations, err := getAnnotation(appName, processType, "keda_trigger_authentication")
-	if err != nil {
-		return annotations, err
+// remoteStepError returns an error for a finished remote command, treating a non-zero exit as a command failure rather than an ssh failure
+func remoteStepError(description string, result common.SshResult, err error) error {
+	// the ssh helper also returns an error when the remote command exits non-zero, so the exit code is checked first
+	if result.ExitCode > 0 {
+		return exitCodeError(description, result.ExitCode, result.Stdout, result.Stderr)
 	}
-	annotations.KedaTriggerAuthenticationAnnotations = kedaTriggerAuthenticationAnnotations
-
-	podAnnotations, err := getAnnotation(appName, processType, "pod")
 	if err != nil {
-		return annotations, err
+		return newKindError(ErrSshFailed, err, fmt.Sprintf("Unable to call %s: %s", description, err.Error()))
 	}
-	annotations.PodAnnotations = podAnnotations
 
-	secretAnnotations, err := getAnnotation(appName, processType, "secret")
+	return nil
+}
+
+// removeRemoteInstaller removes a partially staged k3s installer from a remote host
+func removeRemoteInstaller(remoteHost string, allowUknownHosts bool) {
+	common.LogVerboseQuiet("Removing k3s installer from remote host")
+	_, err := callRemoteStep(context.Background(), RemoteCommandTimeout, "rm command over ssh", common.SshCommandInput{
+		Command:          "rm",
+		Args:             []string{"-f", "/tmp/k3s-installer.sh"},
+		AllowUknownHosts: allowUknownHosts,
+		RemoteHost:       remoteHost,
+	})
 	if err != nil {
-		return annotations, err
+		common.LogWarn(fmt.Sprintf("Unable to remove k3s installer from remote host: %s", err.Error()))
 	}
-	annotations.SecretAnnotations = secretAnnotations
+}
 
-	serviceAnnotations, err := getAnnotation(appName, processType, "service")
+// checkSshConnection verifies that a remote host is reachable over ssh and that sudo can be used without a password
+func checkSshConnection(remoteHost string, allowUknownHosts bool) error {
+	_, err := common.CallSshCommand(common.SshCommandInput{
+		Command:          "true",
+		AllowUknownHosts: allowUknownHosts,
+		RemoteHost:       remoteHost,
+	})
 	if err != nil {
-		return annotations, err
+		var netErr net.Error
+		switch {
+		case strings.Contains(err.Error(), "knownhosts"):
+			return newKindError(ErrSshFailed, err, fmt.Sprintf("Unable to verify the host key for %s, add the host to the known_hosts file of the dokku user or specify --insecure-allow-unknown-hosts: %s", remoteHost, err.Error()))
+		case strings.Contains(err.Error(), "unable to authenticate"):
+			return newKindError(ErrSshFailed, err, fmt.Sprintf("Unable to authenticate to %s over ssh, ensure the ssh key of the dokku user is authorized on the remote host: %s", remoteHost, err.Error()))
+		case errors.As(err, &netErr):
+			return newKindError(ErrSshFailed, err, fmt.Sprintf("Unable to reach %s over ssh, ensure the host is reachable from the dokku server: %s", remoteHost, err.Error()))
+		}
+		return newKindError(ErrSshFailed, err, fmt.Sprintf("Unable to connect to %s over ssh: %s", remoteHost, err.Error()))
 	}
-	annotations.ServiceAnnotations = serviceAnnotations
 
-	serviceAccountAnnotations, err := getAnnotation(appName, processType, "serviceaccount")
+	_, err = common.CallSshCommand(common.SshCommandInput{
+		Command:          "true",
+		AllowUknownHosts: allowUknownHosts,
+		RemoteHost:       remoteHost,
+		Sudo:             true,
+	})
 	if err != nil {
-		return annotations, err
+		return newKindError(ErrSshFailed, err, fmt.Sprintf("Unable to use sudo on %s, the remote user must be root or have passwordless sudo enabled: %s", remoteHost, err.Error()))
 	}
-	annotations.ServiceAccountAnnotations = serviceAccountAnnotations
 
-	traefikIngressRouteAnnotations, err := getAnnotation(appName, processType, "traefik_ingressroute")
-	if err != nil {
-		return annotations, err
-	}
-	annotations.TraefikIngressRouteAnnotations = traefikIngressRouteAnnotations
+	return nil
+}
 
-	traefikMiddlewareAnnotations, err := getAnnotation(appName, processType, "traefik_middleware")
-	if err != nil {
-		return annotations, err
+// checkLocalDependencies returns an error if a binary provided by the k3s dependencies is missing on the local host
+func checkLocalDependencies() error {
+	for _, binary := range getK3sDependencyBinaries() {
+		if _, err := exec.LookPath(binary); err != nil {
+			return fmt.Errorf("Missing %s binary, install the k3s dependencies or omit --skip-dependencies: %w", binary, err)
+		}
 	}
-	annotations.TraefikMiddlewareAnnotations = traefikMiddlewareAnnotations
 
-	return annotations, nil
+	return nil
 }
 
-// GetAutoscalingInput contains all the information needed to get autoscaling config
-type GetAutoscalingInput struct {
-	// AppName is the name of the app
-	AppName string
-
-	// ProcessType is the process type
-	ProcessType string
-
-	// Replicas is the number of replicas
-	Replicas int
+// checkLocalAclDependencies returns an error if the setfacl binary used to grant access to the registry config is missing
+func checkLocalAclDependencies() error {
+	for _, binary := range []string{"setfacl", "getfacl"} {
+		if _, err := exec.LookPath(binary); err != nil {
+			return fmt.Errorf("Missing %s binary, install the acl package before initializing the cluster: %w", binary, err)
+		}
+	}
 
-	// KedaValues is the keda values
-	KedaValues GlobalKedaValues
+	return nil
 }
 
-// getAutoscaling retrieves autoscaling config for a given app and process type
-func getAutoscaling(input GetAutoscalingInput) (ProcessAutoscaling, error) {
-	config, ok, err := appjson.GetAutoscalingConfig(input.AppName, input.ProcessType, input.Replicas)
-	if err != nil {
-		common.LogWarn(fmt.Sprintf("Error getting autoscaling config for %s: %v", input.AppName, err))
-		return ProcessAutoscaling{}, err
+// checkRemoteDependencies returns an error if a binary provided by the k3s dependencies is missing on the remote host
+func checkRemoteDependencies(ctx context.Context, remoteHost string, allowUknownHosts bool) error {
+	for _, binary := range getK3sDependencyBinaries() {
+		whichCmd, err := common.CallSshCommandWithContext(ctx, common.SshCommandInput{
+			Command:          "which",
+			Args:             []string{binary},
+			AllowUknownHosts: allowUknownHosts,
+			RemoteHost:       remoteHost,
+			Sudo:             true,
+		})
+		if ctx.Err() != nil {
+			return fmt.Errorf("cancelled: %w", ctx.Err())
+		}
+		// a non-zero exit means which ran and did not find the binary, any other error means ssh itself failed
+		if whichCmd.ExitCode != 0 {
+			return fmt.Errorf("Missing %s binary on %s, install the k3s dependencies or omit --skip-dependencies", binary, remoteHost)
+		}
+		if err != nil {
+			return newKindError(ErrSshFailed, err, fmt.Sprintf("Unable to check for the %s binary on %s over ssh: %s", binary, remoteHost, err.Error()))
+		}
 	}
 
-	if !ok {
-		common.LogWarn(fmt.Sprintf("No autoscaling config found for %s", input.AppName))
-		return ProcessAutoscaling{}, nil
+	return nil
+}
+
+// checkLocalWireguardSupport returns an error if the wireguard kernel module cannot be loaded on the local host
+func checkLocalWireguardSupport() error {
+	modprobeCmd, err := common.CallExecCommand(common.ExecCommandInput{
+		Command: "modprobe",
+		Args:    []string{"wireguard"},
+	})
+	if err == nil && modprobeCmd.ExitCode == 0 {
+		return nil
 	}
 
-	replacements := map[string]string{
-		"APP_NAME":        input.AppName,
-		"PROCESS_TYPE":    input.ProcessType,
-		"DEPLOYMENT_NAME": fmt.Sprintf("%s-%s", input.AppName, input.ProcessType),
+	// wireguard may be built into the kernel rather than provided as a module
+	if common.DirectoryExists("/sys/module/wireguard") {
+		return nil
 	}
 
-	triggers := []ProcessAutoscalingTrigger{}
-	for _, trigger := range config.Triggers {
-		metadata := map[string]string{}
-		for key, value := range trigger.Metadata {
-			tmpl, err := template.New("").Delims("[[", "]]").Parse(value)
-			if err != nil {
-				return ProcessAutoscaling{}, fmt.Errorf("Error parsing autoscaling trigger metadata: %w", err)
-			}
+	return fmt.Errorf("The wireguard kernel module is not available on this host, which is required by the wireguard-native flannel backend. Use a kernel with wireguard support, or set a different backend before initializing the cluster via 'dokku scheduler-k3s:set --global flannel-backend vxlan'")
+}
 
-			var output bytes.Buffer
-			if err := tmpl.Execute(&output, replacements); err != nil {
-				return ProcessAutoscaling{}, fmt.Errorf("Error executing autoscaling trigger metadata template: %w", err)
-			}
-			metadata[key] = output.String()
+// checkRemoteWireguardSupport returns an error if the wireguard kernel module cannot be loaded on a remote host
+func checkRemoteWireguardSupport(ctx context.Context, remoteHost string, allowUknownHosts bool) error {
+	modprobeCmd, err := common.CallSshCommandWithContext(ctx, common.SshCommandInput{
+		Command:          "modprobe",
+		Args:             []string{"wireguard"},
+		AllowUknownHosts: allowUknownHosts,
+		RemoteHost:       remoteHost,
+		Sudo:             true,
+	})
+	if ctx.Err() != nil {
+		return fmt.Errorf("cancelled: %w", ctx.Err())
+	}
+	// a non-zero exit means modprobe ran and could not load the module, any other error means ssh itself failed
+	if modprobeCmd.ExitCode <= 0 {
+		if err != nil {
+			return newKindError(ErrSshFailed, err, fmt.Sprintf("Unable to check for wireguard support on %s over ssh: %s", remoteHost, err.Error()))
 		}
+		return nil
+	}
 
-		trigger := ProcessAutoscalingTrigger{
-			Name:     trigger.Name,
-			Type:     trigger.Type,
-			Metadata: metadata,
+	// wireguard may be built into the kernel rather than provided as a module
+	testCmd, err := common.CallSshCommandWithContext(ctx, common.SshCommandInput{
+		Command:          "test",
+		Args:             []string{"-d", "/sys/module/wireguard"},
+		AllowUknownHosts: allowUknownHosts,
+		RemoteHost:       remoteHost,
+	})
+	if ctx.Err() != nil {
+		return fmt.Errorf("cancelled: %w", ctx.Err())
+	}
+	if testCmd.ExitCode <= 0 {
+		if err != nil {
+			return newKindError(ErrSshFailed, err, fmt.Sprintf("Unable to check for wireguard support on %s over ssh: %s", remoteHost, err.Error()))
 		}
+		return nil
+	}
 
-		if auth, ok := input.KedaValues.Authentications[trigger.Type]; ok {
-			trigger.AuthenticationRef = &ProcessAutoscalingTriggerAuthenticationRef{
-				Name: auth.Name,
-				Kind: string(auth.Kind),
-			}
-		} else if auth, ok := input.KedaValues.GlobalAuthentications[trigger.Type]; ok {
-			trigger.AuthenticationRef = &ProcessAutoscalingTriggerAuthenticationRef{
-				Name: auth.Name,
-				Kind: string(auth.Kind),
-			}
-		}
+	return fmt.Errorf("The wireguard kernel module is not available on %s, which is required by the wireguard-native flannel backend the cluster uses. The node would join but never become ready, use a kernel with wireguard support on the host", remoteHost)
+}
 
-		triggers = append(triggers, trigger)
+// getRemoteArchitecture returns the cpu architecture of a remote host, using the names kubernetes reports for nodes
+func getRemoteArchitecture(ctx context.Context, remoteHost string, allowUknownHosts bool) (string, error) {
+	unameCmd, err := common.CallSshCommandWithContext(ctx, common.SshCommandInput{
+		Command:          "uname",
+		Args:             []string{"-m"},
+		AllowUknownHosts: allowUknownHosts,
+		RemoteHost:       remoteHost,
+	})
+	if err := remoteStepError("uname command over ssh", unameCmd, err); err != nil {
+		return "", err
 	}
 
-	autoscaling := ProcessAutoscaling{
-		CooldownPeriodSeconds:  ptr.Deref(config.CooldownPeriodSeconds, 300),
-		Enabled:                len(triggers) > 0,
-		MaxReplicas:            ptr.Deref(config.MaxQuantity, 0),
-		MinReplicas:            ptr.Deref(config.MinQuantity, 0),
-		PollingIntervalSeconds: ptr.Deref(config.PollingIntervalSeconds, 30),
-		Triggers:               triggers,
-		Type:                   "keda",
+	machine := strings.TrimSpace(unameCmd.Stdout)
+	architectures := map[string]string{
+		"aarch64": "arm64",
+		"arm64":   "arm64",
+		"armv7l":  "arm",
+		"s390x":   "s390x",
+		"x86_64":  "amd64",
+	}
+	if architecture, ok := architectures[machine]; ok {
+		return architecture, nil
 	}
 
-	return autoscaling, nil
+	return machine, nil
 }
 
-// getKedaValues retrieves keda values for a given app and process type
-func getKedaValues(ctx context.Context, clientset KubernetesClient, appName string) (GlobalKedaValues, error) {
-	properties, err := common.PropertyGetAllByPrefix("scheduler-k3s", appName, TriggerAuthPropertyPrefix)
+// verifyRegistryOnNode returns an error if the registry config on a remote node does not match the expected contents
+func verifyRegistryOnNode(ctx context.Context, input VerifyRegistryOnNodeInput) error {
+	catCmd, err := common.CallSshCommandWithContext(ctx, common.SshCommandInput{
+		Command:          "cat",
+		Args:             []string{RegistryConfigPath},
+		AllowUknownHosts: input.AllowUknownHosts,
+		RemoteHost:       input.RemoteHost,
+		Sudo:             true,
+	})
+	if err := remoteStepError("cat command over ssh", catCmd, err); err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(catCmd.Stdout) != strings.TrimSpace(string(input.Contents)) {
+		return fmt.Errorf("Registry config at %s does not match the configured registry mirrors", RegistryConfigPath)
+	}
+
+	return nil
+}
+
+// pullImageOnNode pulls an image via the container runtime of a remote node
+func pullImageOnNode(ctx context.Context, input PullImageOnNodeInput) error {
+	pullCmd, err := common.CallSshCommandWithContext(ctx, common.SshCommandInput{
+		Command:          "/usr/local/bin/k3s",
+		Args:             []string{"crictl", "pull", input.Image},
+		AllowUknownHosts: input.AllowUknownHosts,
+		RemoteHost:       input.RemoteHost,
+		Sudo:             true,
+	})
+	if err := remoteStepError("crictl pull command over ssh", pullCmd, err); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// getNodePools groups the nodes in the cluster by their pool label
+func getNodePools(ctx context.Context, clientset KubernetesClient) ([]NodePool, error) {
+	nodes, err := clientset.ListNodes(ctx, ListNodesInput{})
 	if err != nil {
-		return GlobalKedaValues{}, fmt.Errorf("Error getting trigger-auth properties: %w", err)
+		return nil, fmt.Errorf("Unable to list nodes: %w", err)
 	}
 
-	auths := map[string]KedaAuthentication{}
-	for key, value := range properties {
-		parts := strings.SplitN(strings.TrimPrefix(key, TriggerAuthPropertyPrefix), ".", 2)
-		if len(parts) != 2 {
-			return GlobalKedaValues{}, fmt.Errorf("Invalid trigger-auth property format: %s", key)
+	pools := map[string]*NodePool{}
+	for _, node := range nodes {
+		n := kubernetesNodeToNode(node)
+		pool, ok := pools[n.Pool]
+		if !ok {
+			pool = &NodePool{Name: n.Pool, Nodes: []string{}}
+			pools[n.Pool] = pool
 		}
 
-		authType := parts[0]
-		secretKey := parts[1]
-		if len(secretKey) == 0 {
-			return GlobalKedaValues{}, fmt.Errorf("Invalid trigger-auth property format: %s", key)
+		pool.Nodes = append(pool.Nodes, n.Name)
+		if n.Ready {
+			pool.ReadyNodes++
 		}
+	}
 
-		if _, ok := auths[authType]; !ok {
-			auths[authType] = KedaAuthentication{
-				Name:    fmt.Sprintf("%s-%s", appName, authType),
-				Type:    authType,
-				Kind:    KedaAuthenticationKind_TriggerAuthentication,
-				Secrets: make(map[string]string),
-			}
+	names := []string{}
+	for name := range pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	output := []NodePool{}
+	for _, name := range names {
+		sort.Strings(pools[name].Nodes)
+		output = append(output, *pools[name])
+	}
+
+	return output, nil
+}
+
+// getDoctorChecks runs diagnostic checks against the local k3s installation and the cluster
+func getDoctorChecks(ctx context.Context) []DoctorCheck {
+	checks := []DoctorCheck{}
+
+	installed := DoctorCheck{Name: "k3s installed", Passed: true, Message: "/usr/local/bin/k3s exists"}
+	if !common.FileExists("/usr/local/bin/k3s") {
+		installed = DoctorCheck{
+			Name:        "k3s installed",
+			Message:     "/usr/local/bin/k3s does not exist",
+			Remediation: "Run scheduler-k3s:initialize to install k3s",
 		}
+	}
+	checks = append(checks, installed)
 
-		auths[authType].Secrets[secretKey] = base64.StdEncoding.EncodeToString([]byte(value))
+	service := DoctorCheck{Name: "k3s service active", Passed: true, Message: "k3s service is active"}
+	serviceCmd, err := common.CallExecCommand(common.ExecCommandInput{
+		Command: "systemctl",
+		Args:    []string{"is-active", "k3s"},
+	})
+	if err != nil || serviceCmd.ExitCode != 0 {
+		state := serviceCmd.StdoutContents()
+		if state == "" {
+			state = "unknown"
+		}
+		service = DoctorCheck{
+			Name:        "k3s service active",
+			Message:     fmt.Sprintf("k3s service is %s", state),
+			Remediation: "Check the service logs via 'journalctl -u k3s' and start it via 'systemctl start k3s'",
+		}
 	}
+	checks = append(checks, service)
 
-	items, err := clientset.ListClusterTriggerAuthentications(ctx, ListClusterTriggerAuthenticationsInput{})
-	if err != nil {
-		if !k8serrors.IsNotFound(err) {
-			return GlobalKedaValues{}, fmt.Errorf("Error listing cluster trigger authentications: %w", err)
+	kubeconfigPath := getKubeconfigPath()
+	kubeconfig := DoctorCheck{Name: "kubeconfig readable", Passed: true, Message: fmt.Sprintf("%s is readable", kubeconfigPath)}
+	if err := validateKubeconfigPath(kubeconfigPath); err != nil {
+		kubeconfig = DoctorCheck{
+			Name:        "kubeconfig readable",
+			Message:     err.Error(),
+			Remediation: "Ensure the kubeconfig exists and is readable by the dokku user, or set the kubeconfig-path property",
 		}
+	}
+	checks = append(checks, kubeconfig)
 
-		common.LogWarn(fmt.Sprintf("Error listing cluster trigger authentications: %v", err))
-		common.LogWarn("Continuing with no cluster trigger authentications")
-		common.LogWarn("This may be due to the keda helm chart not being installed")
-		items = []kedav1alpha1.ClusterTriggerAuthentication{}
+	registry := DoctorCheck{Name: "registry config access", Passed: true, Message: fmt.Sprintf("%s is writable by dokku", RegistryConfigPath)}
+	if err := verifyRegistryConfigAccess(common.GetenvWithDefault("DOKKU_SYSTEM_USER", "dokku")); err != nil {
+		registry = DoctorCheck{
+			Name:        "registry config access",
+			Message:     err.Error(),
+			Remediation: fmt.Sprintf("Grant access via 'setfacl -m user:dokku:rw %s' as root", RegistryConfigPath),
+		}
 	}
+	checks = append(checks, registry)
+	checks = append(checks, getDoctorInitConfigCheck())
+	checks = append(checks, getDoctorDNSConfigCheck())
 
-	globalAuths := map[string]KedaAuthentication{}
-	for _, item := range items {
-		globalAuths[item.Name] = KedaAuthentication{
-			Name: item.Name,
-			Kind: KedaAuthenticationKind_ClusterTriggerAuthentication,
-			Type: item.Name,
+	api := DoctorCheck{Name: "kubernetes api reachable", Passed: true, Message: "kubernetes api responded"}
+	clientset, err := NewKubernetesClient()
+	if err == nil {
+		err = clientset.Ping()
+	}
+	if err != nil {
+		api = DoctorCheck{
+			Name:        "kubernetes api reachable",
+			Message:     err.Error(),
+			Remediation: "Ensure the k3s service is running and the kubeconfig points at a reachable server",
 		}
+		checks = append(checks, api)
+		for _, name := range []string{"nodes ready", "cert-manager pods running", "longhorn pods running"} {
+			checks = append(checks, DoctorCheck{
+				Name:        name,
+				Message:     "Skipped, the kubernetes api is not reachable",
+				Remediation: "Fix the kubernetes api reachable check first",
+			})
+		}
+		return checks
 	}
+	checks = append(checks, api)
 
-	return GlobalKedaValues{
-		Authentications:       auths,
-		GlobalAuthentications: globalAuths,
-	}, nil
-}
+	checks = append(checks, getDoctorNodesCheck(ctx, clientset))
+	if getGlobalInstallCertManager() {
+		checks = append(checks, getDoctorPodsCheck(ctx, clientset, "cert-manager pods running", "cert-manager"))
+	} else {
+		checks = append(checks, DoctorCheck{Name: "cert-manager pods running", Passed: true, Message: "Skipped, install-cert-manager is false"})
+	}
+	if getGlobalInstallLonghorn() {
+		checks = append(checks, getDoctorPodsCheck(ctx, clientset, "longhorn pods running", "longhorn-system"))
+	} else {
+		checks = append(checks, DoctorCheck{Name: "longhorn pods running", Passed: true, Message: "Skipped, install-longhorn is false"})
+	}
 
-// getGlobalAnnotations retrieves global annotations for a given app
-func getGlobalAnnotations(appName string) (ProcessAnnotations, error) {
-	return getAnnotations(appName, GlobalProcessType)
+	return checks
 }
 
-// getAnnotation retrieves an annotation for a given app, process type, and resource type
-func getAnnotation(appName string, processType string, resourceType string) (map[string]string, error) {
-	annotations := map[string]string{}
-	annotationsList, err := common.PropertyListGet("scheduler-k3s", appName, fmt.Sprintf("%s.%s", processType, resourceType))
+// getDoctorInitConfigCheck checks that the init configuration snapshot was recorded
+func getDoctorInitConfigCheck() DoctorCheck {
+	config, ok, err := readInitConfig()
 	if err != nil {
-		return annotations, err
+		return DoctorCheck{
+			Name:        "init config recorded",
+			Message:     err.Error(),
+			Remediation: fmt.Sprintf("Remove or fix %s", getInitConfigPath()),
+		}
 	}
-
-	for _, annotation := range annotationsList {
-		parts := strings.SplitN(annotation, ": ", 2)
-		if len(parts) != 2 {
-			return annotations, fmt.Errorf("Invalid annotation format: %s", annotation)
+	if !ok {
+		return DoctorCheck{
+			Name:        "init config recorded",
+			Message:     fmt.Sprintf("%s does not exist", getInitConfigPath()),
+			Remediation: "The cluster was initialized before the init config was recorded, cluster-add will fall back to the current properties",
 		}
-
-		annotations[parts[0]] = parts[1]
 	}
 
-	return annotations, nil
+	return DoctorCheck{
+		Name:    "init config recorded",
+		Passed:  true,
+		Message: fmt.Sprintf("k3s %s, flannel backend %s, disabled %s, cluster cidr %s, service cidr %s", config.K3sVersion, config.FlannelBackend, strings.Join(config.Disable, ","), config.ClusterCIDR, config.ServiceCIDR),
+	}
 }
 
-func getDeployTimeout(appName string) string {
-	return common.PropertyGetDefault("scheduler-k3s", appName, "deploy-timeout", "")
-}
+// getDoctorDNSConfigCheck displays the dns configuration and checks that the CoreDNS custom config was written
+func getDoctorDNSConfigCheck() DoctorCheck {
+	stubDomains, err := getDNSStubDomains()
+	if err != nil {
+		return DoctorCheck{
+			Name:        "dns config",
+			Message:     err.Error(),
+			Remediation: "Reconfigure the stub domains via scheduler-k3s:dns:configure",
+		}
+	}
 
-func getGlobalDeployTimeout() string {
-	return common.PropertyGetDefault("scheduler-k3s", "--global", "deploy-timeout", "300s")
-}
+	upstreams := "node resolv.conf"
+	if servers := getDNSUpstreamServers(); len(servers) > 0 {
+		upstreams = strings.Join(servers, ",")
+	}
 
-func getComputedDeployTimeout(appName string) string {
-	deployTimeout := getDeployTimeout(appName)
-	if deployTimeout == "" {
-		deployTimeout = getGlobalDeployTimeout()
+	domains := []string{}
+	for _, stubDomain := range stubDomains {
+		domains = append(domains, fmt.Sprintf("%s=%s", stubDomain.Domain, strings.Join(stubDomain.Servers, ",")))
+	}
+	if len(domains) == 0 {
+		domains = append(domains, "none")
 	}
 
-	return deployTimeout
-}
+	message := fmt.Sprintf("upstream servers %s, stub domains %s", upstreams, strings.Join(domains, " "))
+	if len(stubDomains) > 0 && !common.FileExists(getCoreDNSCustomManifestPath()) {
+		return DoctorCheck{
+			Name:        "dns config",
+			Message:     fmt.Sprintf("%s, but %s does not exist", message, getCoreDNSCustomManifestPath()),
+			Remediation: "Run scheduler-k3s:dns:configure to rewrite the CoreDNS custom config",
+		}
+	}
 
-func getImagePullSecrets(appName string) string {
-	return common.PropertyGetDefault("scheduler-k3s", appName, "image-pull-secrets", "")
+	return DoctorCheck{Name: "dns config", Passed: true, Message: message}
 }
 
-func getGlobalImagePullSecrets() string {
-	return common.PropertyGetDefault("scheduler-k3s", "--global", "image-pull-secrets", "")
-}
+// getDoctorNodesCheck checks that all nodes in the cluster are ready
+func getDoctorNodesCheck(ctx context.Context, clientset KubernetesClient) DoctorCheck {
+	nodes, err := clientset.ListNodes(ctx, ListNodesInput{})
+	if err != nil {
+		return DoctorCheck{
+			Name:        "nodes ready",
+			Message:     fmt.Sprintf("Unable to list nodes: %s", err.Error()),
+			Remediation: "Check the kubernetes api is healthy",
+		}
+	}
 
-func getComputedImagePullSecrets(appName string) string {
-	imagePullSecrets := getImagePullSecrets(appName)
-	if imagePullSecrets == "" {
-		imagePullSecrets = getGlobalImagePullSecrets()
+	notReady := []string{}
+	for _, node := range nodes {
+		if !kubernetesNodeToNode(node).Ready {
+			notReady = append(notReady, node.Name)
+		}
 	}
 
-	return imagePullSecrets
-}
+	if len(notReady) > 0 {
+		return DoctorCheck{
+			Name:        "nodes ready",
+			Message:     fmt.Sprintf("%d of %d node(s) not ready: %s", len(notReady), len(nodes), strings.Join(notReady, ", ")),
+			Remediation: "Check the k3s or k3s-agent service on each node that is not ready",
+		}
+	}
 
-func getGlobalIngressClass() string {
-	return common.PropertyGetDefault("scheduler-k3s", "--global", "ingress-class", DefaultIngressClass)
+	return DoctorCheck{Name: "nodes ready", Passed: true, Message: fmt.Sprintf("%d node(s) ready", len(nodes))}
 }
 
-func getIngressAnnotations(appName string, processType string) (map[string]string, error) {
-	type annotation struct {
-		annotation      string
-		getter          func(appName string) string
-		locationSnippet func(value string) string
-		serverSnippet   func(value string) string
+// getDoctorPodsCheck checks that all pods in a namespace are running
+func getDoctorPodsCheck(ctx context.Context, clientset KubernetesClient, name string, namespace string) DoctorCheck {
+	pods, err := clientset.ListPods(ctx, ListPodsInput{
+		Namespace: namespace,
+	})
+	if err != nil {
+		return DoctorCheck{
+			Name:        name,
+			Message:     fmt.Sprintf("Unable to list pods in %s: %s", namespace, err.Error()),
+			Remediation: "Check the kubernetes api is healthy",
+		}
 	}
 
-	locationLines := []string{}
-	serverLines := []string{}
+	if len(pods) == 0 {
+		return DoctorCheck{
+			Name:        name,
+			Message:     fmt.Sprintf("No pods found in %s", namespace),
+			Remediation: "Run scheduler-k3s:initialize --finalize to reinstall the helm charts",
+		}
+	}
 
-	properties := map[string]annotation{
-		"access-log-path": {
-			getter: nginxvhosts.ComputedAccessLogPath,
-			serverSnippet: func(value string) string {
-				if value == "" {
-					return ""
-				}
-				return fmt.Sprintf("access_log %s;", value)
-			},
-		},
-		"bind-address-ipv4": {
-			getter: nginxvhosts.ComputedBindAddressIPv4,
-		},
-		"bind-address-ipv6": {
-			getter: nginxvhosts.ComputedBindAddressIPv6,
-		},
-		"client-max-body-size": {
-			annotation: "nginx.ingress.kubernetes.io/proxy-body-size",
-			getter:     nginxvhosts.ComputedClientMaxBodySize,
-		},
-		"disable-custom-config": {
-			getter: nginxvhosts.ComputedDisableCustomConfig,
-		},
-		"error-log-path": {
-			getter: nginxvhosts.ComputedErrorLogPath,
-			serverSnippet: func(value string) string {
-				if value == "" {
-					return ""
-				}
-				return fmt.Sprintf("error_log %s;", value)
-			},
-		},
-		// todo: handle hsts properly
-		"hsts-include-subdomains": {
-			getter: nginxvhosts.ComputedHSTSIncludeSubdomains,
-		},
-		"hsts-max-age": {
-			getter: nginxvhosts.ComputedHSTSMaxAge,
-		},
-		"hsts-preload": {
-			getter: nginxvhosts.ComputedHSTSPreload,
-		},
-		"hsts": {
-			getter: nginxvhosts.ComputedHSTS,
-		},
-		"nginx-conf-sigil-path": {
-			getter: nginxvhosts.ComputedNginxConfSigilPath,
-		},
-		"proxy-buffer-size": {
-			annotation: "nginx.ingress.kubernetes.io/proxy-buffer-size",
-			getter:     nginxvhosts.ComputedProxyBufferSize,
-		},
-		"proxy-buffering": {
-			annotation: "nginx.ingress.kubernetes.io/proxy-buffering",
-			getter:     nginxvhosts.ComputedProxyBuffering,
-		},
-		"proxy-buffers": {
-			annotation: "nginx.ingress.kubernetes.io/proxy-buffers-number",
-			getter:     nginxvhosts.ComputedProxyBuffers,
-		},
-		"proxy-busy-buffers-size": {
-			getter: nginxvhosts.ComputedProxyBusyBuffersSize,
-			locationSnippet: func(value string) string {
-				if value == "" {
-					return ""
-				}
-				return fmt.Sprintf("proxy_busy_buffers_size %s;", value)
-			},
-		},
-		"proxy-read-timeout": {
-			annotation: "nginx.ingress.kubernetes.io/proxy-read-timeout",
-			getter:     nginxvhosts.ComputedProxyReadTimeout,
-		},
-		"underscore-in-headers": {
-			getter: nginxvhosts.ComputedUnderscoreInHeaders,
-			serverSnippet: func(value string) string {
-				if value == "" {
-					return ""
-				}
-				return fmt.Sprintf("underscores_in_headers %s;", value)
+	notRunning := []string{}
+	for _, pod := range pods {
+		if pod.Status.Phase != v1.PodRunning && pod.Status.Phase != v1.PodSucceeded {
+			notRunning = append(notRunning, fmt.Sprintf("%s (%s)", pod.Name, pod.Status.Phase))
+		}
+	}
+
+	if len(notRunning) > 0 {
+		return DoctorCheck{
+			Name:        name,
+			Message:     fmt.Sprintf("%d of %d pod(s) not running: %s", len(notRunning), len(pods), strings.Join(notRunning, ", ")),
+			Remediation: fmt.Sprintf("Inspect the pods via 'kubectl -n %s describe pods'", namespace),
+		}
+	}
+
+	return DoctorCheck{Name: name, Passed: true, Message: fmt.Sprintf("%d pod(s) running", len(pods))}
+}
+
+// getClusterInfo computes the etcd quorum health of the cluster from its server nodes
+func getClusterInfo(ctx context.Context, clientset KubernetesClient) (ClusterInfo, error) {
+	nodes, err := clientset.ListNodes(ctx, ListNodesInput{})
+	if err != nil {
+		return ClusterInfo{}, fmt.Errorf("Unable to list nodes: %w", err)
+	}
+
+	info := ClusterInfo{}
+	for _, node := range nodes {
+		n := kubernetesNodeToNode(node)
+		if !slices.Contains(n.Roles, "control-plane") && !slices.Contains(n.Roles, "master") {
+			continue
+		}
+
+		info.ServerNodes++
+		if n.Ready {
+			info.ReadyServerNodes++
+		}
+	}
+
+	info.Quorum = info.ServerNodes/2 + 1
+	info.HasQuorum = info.ReadyServerNodes >= info.Quorum
+	info.FailureTolerance = max(info.ReadyServerNodes-info.Quorum, 0)
+	info.AtRisk = info.ReadyServerNodes <= info.Quorum
+
+	return info, nil
+}
+
+// compareK3sVersions compares two k3s versions, returning -1, 0, or 1 if a is older than, equal to, or newer than b
+func compareK3sVersions(a string, b string) (int, error) {
+	parse := func(version string) ([]int, error) {
+		matches := k3sVersionRegex.FindStringSubmatch(version)
+		if matches == nil {
+			return nil, fmt.Errorf("Invalid k3s version: %s", version)
+		}
+
+		// a release candidate sorts before the release it precedes
+		rc := math.MaxInt
+		if matches[5] != "" {
+			rc, _ = strconv.Atoi(matches[5])
+		}
+
+		major, _ := strconv.Atoi(matches[1])
+		minor, _ := strconv.Atoi(matches[2])
+		patch, _ := strconv.Atoi(matches[3])
+		k3s, _ := strconv.Atoi(matches[6])
+		return []int{major, minor, patch, rc, k3s}, nil
+	}
+
+	aParts, err := parse(a)
+	if err != nil {
+		return 0, err
+	}
+	bParts, err := parse(b)
+	if err != nil {
+		return 0, err
+	}
+
+	return slices.Compare(aParts, bParts), nil
+}
+
+// copyFileToNode writes a file to a remote node over ssh, optionally restarting k3s afterwards
+func copyFileToNode(ctx context.Context, input CopyFileToNodeInput) error {
+	mkdirCmd, err := common.CallSshCommandWithContext(ctx, common.SshCommandInput{
+		Command:          "mkdir",
+		Args:             []string{"-p", filepath.Dir(input.Path)},
+		AllowUknownHosts: input.AllowUknownHosts,
+		RemoteHost:       input.RemoteHost,
+		Sudo:             true,
+	})
+	if err := remoteStepError("mkdir command over ssh", mkdirCmd, err); err != nil {
+		return err
+	}
+
+	teeCmd, err := common.CallSshCommandWithContext(ctx, common.SshCommandInput{
+		Command:          "tee",
+		Args:             []string{input.Path},
+		AllowUknownHosts: input.AllowUknownHosts,
+		RemoteHost:       input.RemoteHost,
+		Stdin:            bytes.NewReader(input.Contents),
+		Sudo:             true,
+	})
+	// tee echoes the file back on stdout, which may include registry credentials
+	teeCmd.Stdout = ""
+	if err := remoteStepError("tee command over ssh", teeCmd, err); err != nil {
+		return err
+	}
+
+	if !input.Restart {
+		return nil
+	}
+
+	restartCmd, err := common.CallSshCommandWithContext(ctx, common.SshCommandInput{
+		Command:          "systemctl",
+		Args:             []string{"restart", input.Service},
+		AllowUknownHosts: input.AllowUknownHosts,
+		RemoteHost:       input.RemoteHost,
+		Sudo:             true,
+	})
+	if err := remoteStepError("systemctl restart command over ssh", restartCmd, err); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// updateNodeToken replaces the k3s token in the systemd service of a remote node over ssh
+func updateNodeToken(ctx context.Context, input UpdateNodeTokenInput) error {
+	sedCmd, err := common.CallSshCommandWithContext(ctx, common.SshCommandInput{
+		Command:          "sed",
+		Args:             []string{"-i", fmt.Sprintf("s/%s/%s/g", input.OldToken, input.NewToken), fmt.Sprintf("/etc/systemd/system/%s.service", input.Service)},
+		AllowUknownHosts: input.AllowUknownHosts,
+		RemoteHost:       input.RemoteHost,
+		Sudo:             true,
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to call sed command over ssh: %w", err)
+	}
+	if sedCmd.ExitCode != 0 {
+		return fmt.Errorf("Invalid exit code from sed command over ssh: %d", sedCmd.ExitCode)
+	}
+
+	reloadCmd, err := common.CallSshCommandWithContext(ctx, common.SshCommandInput{
+		Command:          "systemctl",
+		Args:             []string{"daemon-reload"},
+		AllowUknownHosts: input.AllowUknownHosts,
+		RemoteHost:       input.RemoteHost,
+		Sudo:             true,
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to call systemctl daemon-reload command over ssh: %w", err)
+	}
+	if reloadCmd.ExitCode != 0 {
+		return fmt.Errorf("Invalid exit code from systemctl daemon-reload command over ssh: %d", reloadCmd.ExitCode)
+	}
+
+	if !input.Restart {
+		return nil
+	}
+
+	restartCmd, err := common.CallSshCommandWithContext(ctx, common.SshCommandInput{
+		Command:          "systemctl",
+		Args:             []string{"restart", input.Service},
+		AllowUknownHosts: input.AllowUknownHosts,
+		RemoteHost:       input.RemoteHost,
+		Sudo:             true,
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to call systemctl restart command over ssh: %w", err)
+	}
+	if restartCmd.ExitCode != 0 {
+		return fmt.Errorf("Invalid exit code from systemctl restart command over ssh: %d", restartCmd.ExitCode)
+	}
+
+	return nil
+}
+
+// hasTaintedControlPlane returns whether any control-plane node only allows critical addons
+func hasTaintedControlPlane(ctx context.Context, clientset KubernetesClient) (bool, error) {
+	nodes, err := clientset.ListNodes(ctx, ListNodesInput{})
+	if err != nil {
+		return false, fmt.Errorf("Unable to list nodes: %w", err)
+	}
+
+	for _, node := range nodes {
+		n := kubernetesNodeToNode(node)
+		if !slices.Contains(n.Roles, "control-plane") && !slices.Contains(n.Roles, "master") {
+			continue
+		}
+
+		for _, taint := range node.Spec.Taints {
+			if taint.Key == "CriticalAddonsOnly" && taint.Effect == corev1.TaintEffectNoSchedule {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// completeNodeJoin labels and annotates a node that has joined the cluster
+func completeNodeJoin(ctx context.Context, input CompleteNodeJoinInput) error {
+	roleLabels := getServerLabels()
+	if input.Role == "worker" {
+		roleLabels = WorkerLabels
+	}
+
+	labels := map[string]string{}
+	for key, value := range roleLabels {
+		labels[key] = value
+	}
+	for key, value := range input.Labels {
+		labels[key] = value
+	}
+
+	for key, value := range labels {
+		common.LogInfo2Quiet(fmt.Sprintf("Labeling node %s=%s", key, value))
+		err := input.Clientset.LabelNode(ctx, LabelNodeInput{
+			Name:  input.NodeName,
+			Key:   key,
+			Value: value,
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to patch node: %w", err)
+		}
+	}
+
+	if input.RemoteHost == "" {
+		if input.Role == "worker" {
+			return fmt.Errorf("Unable to annotate node %s, no remote host is known for the node", input.NodeName)
+		}
+		return nil
+	}
+
+	annotations := map[string]string{
+		"dokku.com/remote-host": input.RemoteHost,
+		NodeManagedByAnnotation: NodeManagedByValue,
+	}
+	if !input.JoinedAt.IsZero() {
+		annotations[NodeJoinedAtAnnotation] = input.JoinedAt.UTC().Format(time.RFC3339)
+	}
+
+	common.LogInfo2Quiet("Annotating node with connection information")
+	err := input.Clientset.AnnotateNodeValues(ctx, AnnotateNodeValuesInput{
+		Name:        input.NodeName,
+		Annotations: annotations,
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to patch node: %w", err)
+	}
+
+	return nil
+}
+
+func createKubernetesNamespace(ctx context.Context, namespaceName string) error {
+	clientset, err := NewKubernetesClient()
+	if err != nil {
+		return err
+	}
+
+	namespace := corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: namespaceName,
+			Annotations: map[string]string{
+				"dokku.com/managed": "true",
 			},
-		},
-		"x-forwarded-for-value": {
-			getter: nginxvhosts.ComputedXForwardedForValue,
-			locationSnippet: func(value string) string {
-				if value == "" {
-					return ""
-				}
-				return fmt.Sprintf("proxy_set_header X-Forwarded-For %s;", value)
+			Labels: map[string]string{
+				"dokku.com/managed": "true",
 			},
 		},
-		"x-forwarded-port-value": {
-			getter: nginxvhosts.ComputedXForwardedPortValue,
-			locationSnippet: func(value string) string {
-				if value == "" {
-					return ""
-				}
-				return fmt.Sprintf("proxy_set_header X-Forwarded-Port %s;", value)
+	}
+	_, err = clientset.CreateNamespace(ctx, CreateNamespaceInput{
+		Name: namespace,
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// getImagePullSecretNamespaces returns the namespaces an image pull secret for an app is created in
+func getImagePullSecretNamespaces(appName string) []string {
+	namespace := getGlobalNamespace()
+	if appName != "--global" {
+		namespace = getComputedNamespace(appName)
+	}
+
+	namespaces := []string{namespace}
+	if namespace != "default" {
+		namespaces = append(namespaces, "default")
+	}
+
+	return namespaces
+}
+
+// renderDockerConfigJSON renders the .dockerconfigjson contents for a single registry credential
+func renderDockerConfigJSON(server string, username string, password string) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"auths": map[string]interface{}{
+			server: map[string]string{
+				"username": username,
+				"password": password,
+				"auth":     base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", username, password))),
 			},
 		},
-		"x-forwarded-proto-value": {
-			getter: nginxvhosts.ComputedXForwardedProtoValue,
-			locationSnippet: func(value string) string {
-				if value == "" {
-					return ""
+	})
+}
+
+// downloadK3sInstaller downloads the k3s installer script, retrying transient network failures
+// the raw response body is returned, as resty trims trailing whitespace from the string form of a response
+func downloadK3sInstaller(ctx context.Context) ([]byte, error) {
+	client := newRestyClient()
+	backoff := 2 * time.Second
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, K3sInstallerDownloadTimeout)
+		resp, err := client.R().
+			SetContext(attemptCtx).
+			Get("https://get.k3s.io")
+		timedOut := errors.Is(attemptCtx.Err(), context.DeadlineExceeded)
+		cancel()
+
+		var netErr net.Error
+		retryable := false
+		switch {
+		case ctx.Err() != nil:
+			return nil, fmt.Errorf("Cancelled k3s installer download: %w", ctx.Err())
+		case timedOut:
+			err = fmt.Errorf("timed out after %s", K3sInstallerDownloadTimeout)
+			retryable = true
+		case err != nil:
+			retryable = errors.As(err, &netErr)
+		case resp == nil:
+			err = errors.New("missing response")
+		case resp.StatusCode() >= 500:
+			err = fmt.Errorf("invalid status code %d", resp.StatusCode())
+			retryable = true
+		case resp.StatusCode() != 200:
+			return nil, fmt.Errorf("Invalid status code for k3s installer script: %d", resp.StatusCode())
+		default:
+			installer := resp.Body()
+			if err := validateK3sInstallerContents(installer); err != nil {
+				return nil, err
+			}
+			return installer, nil
+		}
+
+		if attempt >= K3sInstallerDownloadAttempts || !retryable {
+			return nil, fmt.Errorf("Unable to download k3s installer after %d attempt(s): %w", attempt, err)
+		}
+
+		common.LogWarn(fmt.Sprintf("Unable to download k3s installer, retrying in %s: %s", backoff, err.Error()))
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("Cancelled k3s installer download: %w", ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// getCachedK3sInstaller returns the path to a cached copy of the k3s installer, downloading it if the cache is missing or does not match its recorded checksum
+func getCachedK3sInstaller(ctx context.Context, refresh bool) (string, error) {
+	installerPath := filepath.Join(common.GetDataDirectory("scheduler-k3s"), K3sInstallerCacheFilename)
+	checksumPath := installerPath + ".sha256"
+
+	if !refresh {
+		expected, err := os.ReadFile(checksumPath)
+		if err == nil {
+			actual, err := getFileChecksum(installerPath)
+			if err == nil && actual == strings.TrimSpace(string(expected)) {
+				common.LogVerboseQuiet(fmt.Sprintf("Using cached k3s installer: %s", installerPath))
+				return installerPath, nil
+			}
+			common.LogWarn("Cached k3s installer does not match its recorded checksum, downloading it again")
+		}
+	}
+
+	installer, err := downloadK3sInstaller(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if err := common.CreateDataDirectory("scheduler-k3s"); err != nil {
+		return "", fmt.Errorf("Unable to create data directory: %w", err)
+	}
+
+	err = common.WriteBytesToFile(common.WriteBytesToFileInput{
+		Bytes:    installer,
+		Filename: installerPath,
+		Mode:     os.FileMode(0755),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Unable to write cached k3s installer: %w", err)
+	}
+
+	checksum := sha256.Sum256(installer)
+	err = common.WriteStringToFile(common.WriteStringToFileInput{
+		Content:  hex.EncodeToString(checksum[:]),
+		Filename: checksumPath,
+		Mode:     os.FileMode(0644),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Unable to write cached k3s installer checksum: %w", err)
+	}
+
+	return installerPath, nil
+}
+
+// getFileChecksum returns the hex-encoded sha256 checksum of a file
+func getFileChecksum(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	checksum := sha256.Sum256(b)
+	return hex.EncodeToString(checksum[:]), nil
+}
+
+// verifyRemoteInstallerChecksum returns an error if the k3s installer copied to a remote host does not match the expected checksum
+func verifyRemoteInstallerChecksum(ctx context.Context, remoteHost string, allowUknownHosts bool, expected string) error {
+	sumCmd, err := callRemoteStep(ctx, RemoteCommandTimeout, "sha256sum command over ssh", common.SshCommandInput{
+		Command:          "sha256sum",
+		Args:             []string{"/tmp/k3s-installer.sh"},
+		AllowUknownHosts: allowUknownHosts,
+		RemoteHost:       remoteHost,
+	})
+	if err != nil {
+		return err
+	}
+
+	fields := strings.Fields(sumCmd.Stdout)
+	if len(fields) == 0 || fields[0] != expected {
+		return fmt.Errorf("Invalid checksum for k3s installer on remote host, expected %s", expected)
+	}
+
+	return nil
+}
+
+func enterPod(ctx context.Context, input EnterPodInput) error {
+	coreclient, err := corev1client.NewForConfig(&input.Clientset.RestConfig)
+	if err != nil {
+		return fmt.Errorf("Error creating corev1 client: %w", err)
+	}
+
+	labelSelector := []string{}
+	for k, v := range input.SelectedPod.Labels {
+		labelSelector = append(labelSelector, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	if input.WaitTimeout > 0 {
+		input.WaitTimeout = 5
+	}
+
+	err = waitForPodBySelectorRunning(ctx, WaitForPodBySelectorRunningInput{
+		Clientset:     input.Clientset,
+		Namespace:     input.SelectedPod.Namespace,
+		LabelSelector: strings.Join(labelSelector, ","),
+		PodName:       input.SelectedPod.Name,
+		Timeout:       input.WaitTimeout,
+		Waiter:        isPodReady,
+	})
+	if err != nil {
+		return fmt.Errorf("Error waiting for pod to be ready: %w", err)
+	}
+
+	defaultContainerName, hasDefaultContainer := input.SelectedPod.Annotations["kubectl.kubernetes.io/default-container"]
+	if input.SelectedContainerName == "" && hasDefaultContainer {
+		input.SelectedContainerName = defaultContainerName
+	}
+	if input.SelectedContainerName == "" {
+		return fmt.Errorf("No container specified and no default container found")
+	}
+
+	req := coreclient.RESTClient().Post().
+		Resource("pods").
+		Namespace(input.SelectedPod.Namespace).
+		Name(input.SelectedPod.Name).
+		SubResource("exec")
+
+	req.Param("container", input.SelectedContainerName)
+	req.Param("stdin", "true")
+	req.Param("stdout", "true")
+	req.Param("stderr", "true")
+	req.Param("tty", "true")
+
+	if input.Entrypoint != "" {
+		req.Param("command", input.Entrypoint)
+	}
+	for _, cmd := range input.Command {
+		req.Param("command", cmd)
+	}
+
+	t := term.TTY{
+		In:  os.Stdin,
+		Out: os.Stdout,
+		Raw: true,
+	}
+	size := t.GetSize()
+	sizeQueue := t.MonitorSize(size)
+
+	return t.Safe(func() error {
+		exec, err := remotecommand.NewSPDYExecutor(&input.Clientset.RestConfig, "POST", req.URL())
+		if err != nil {
+			return fmt.Errorf("Error creating executor: %w", err)
+		}
+
+		return exec.StreamWithContext(ctx, remotecommand.StreamOptions{
+			Stdin:             os.Stdin,
+			Stdout:            os.Stdout,
+			Stderr:            os.Stderr,
+			Tty:               true,
+			TerminalSizeQueue: sizeQueue,
+		})
+	})
+}
+
+func extractStartCommand(input StartCommandInput) string {
+	command := ""
+	if input.ImageSourceType == "herokuish" {
+		return "/start " + input.ProcessType
+	}
+
+	resp, err := common.CallPlugnTrigger(common.PlugnTriggerInput{
+		Trigger: "config-get",
+		Args:    []string{input.AppName, "DOKKU_START_CMD"},
+	})
+	if err == nil && resp.ExitCode == 0 && len(resp.Stdout) > 0 {
+		command = strings.TrimSpace(resp.Stdout)
+	}
+
+	if input.ImageSourceType == "dockerfile" {
+		resp, err := common.CallPlugnTrigger(common.PlugnTriggerInput{
+			Trigger: "config-get",
+			Args:    []string{input.AppName, "DOKKU_DOCKERFILE_START_CMD"},
+		})
+		if err == nil && resp.ExitCode == 0 && len(resp.Stdout) > 0 {
+			command = strings.TrimSpace(resp.Stdout)
+		}
+	}
+
+	if command == "" {
+		results, _ := common.CallPlugnTrigger(common.PlugnTriggerInput{
+			Trigger: "procfile-get-command",
+			Args:    []string{input.AppName, input.ProcessType, fmt.Sprint(input.Port)},
+		})
+		command = results.StdoutContents()
+	}
+
+	return command
+}
+
+// finalizeInitialize applies manifests, labels the server node and installs helm charts and helper commands
+// each step is safe to re-run against a cluster where it has already been executed
+func finalizeInitialize(ctx context.Context, input FinalizeInitializeInput) error {
+	for _, manifest := range KubernetesManifests {
+		common.LogInfo2Quiet(fmt.Sprintf("Installing %s@%s", manifest.Name, manifest.Version))
+		_, err := input.Clientset.ApplyKubernetesManifest(ctx, ApplyKubernetesManifestInput{
+			Manifest: manifest.Path,
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to apply kubernetes manifest: %w", err)
+		}
+	}
+
+	for key, value := range getServerLabels() {
+		common.LogInfo2Quiet(fmt.Sprintf("Labeling node %s=%s", key, value))
+		err := input.Clientset.LabelNode(ctx, LabelNodeInput{
+			Name:  input.NodeName,
+			Key:   key,
+			Value: value,
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to patch node: %w", err)
+		}
+	}
+
+	err := input.Clientset.AnnotateNode(ctx, AnnotateNodeInput{
+		Name:  input.NodeName,
+		Key:   NodeManagedByAnnotation,
+		Value: NodeManagedByValue,
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to patch node: %w", err)
+	}
+
+	common.LogInfo2Quiet("Installing helm charts")
+	err = installHelmCharts(ctx, input.Clientset, func(chart HelmChart) bool {
+		if chart.ChartPath == "traefik" && input.IngressClass == "nginx" {
+			common.LogVerboseQuiet("Skipping traefik chart, ingress-class is nginx")
+			return false
+		}
+
+		if chart.ChartPath == "ingress-nginx" && input.IngressClass == "traefik" {
+			common.LogVerboseQuiet("Skipping ingress-nginx chart, ingress-class is traefik")
+			return false
+		}
+
+		if chart.ReleaseName == "cert-manager" && !getGlobalInstallCertManager() {
+			common.LogVerboseQuiet("Skipping cert-manager chart, install-cert-manager is false")
+			return false
+		}
+
+		if chart.ReleaseName == "longhorn" && !getGlobalInstallLonghorn() {
+			common.LogVerboseQuiet("Skipping longhorn chart, install-longhorn is false")
+			return false
+		}
+
+		if chart.ReleaseName == "metallb" && getGlobalLoadbalancer() != "metallb" {
+			common.LogVerboseQuiet("Skipping metallb chart, loadbalancer is servicelb")
+			return false
+		}
+
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to install helm charts: %w", err)
+	}
+
+	if getGlobalLoadbalancer() == "metallb" {
+		common.LogInfo2Quiet("Configuring metallb address pool")
+		if err := applyMetalLBAddressPool(ctx, input.Clientset); err != nil {
+			return fmt.Errorf("Unable to configure metallb address pool: %w", err)
+		}
+	}
+
+	common.LogInfo2Quiet("Installing helper commands")
+	err = installHelperCommands(ctx)
+	if err != nil {
+		return fmt.Errorf("Unable to install helper commands: %w", err)
+	}
+
+	return nil
+}
+
+// getAnnotations retrieves annotations for a given app and process type
+func getAnnotations(appName string, processType string) (ProcessAnnotations, error) {
+	annotations := ProcessAnnotations{}
+	certificateAnnotations, err := getAnnotation(appName, processType, "certificate")
+	if err != nil {
+		return annotations, err
+	}
+	annotations.CertificateAnnotations = certificateAnnotations
+
+	cronJobAnnotations, err := getAnnotation(appName, processType, "cronjob")
+	if err != nil {
+		return annotations, err
+	}
+	annotations.CronJobAnnotations = cronJobAnnotations
+
+	deploymentAnnotations, err := getAnnotation(appName, processType, "deployment")
+	if err != nil {
+		return annotations, err
+	}
+	annotations.DeploymentAnnotations = deploymentAnnotations
+
+	ingressAnnotations, err := getIngressAnnotations(appName, processType)
+	if err != nil {
+		return annotations, err
+	}
+	annotations.IngressAnnotations = ingressAnnotations
+
+	jobAnnotations, err := getAnnotation(appName, processType, "job")
+	if err != nil {
+		return annotations, err
+	}
+	annotations.JobAnnotations = jobAnnotations
+
+	kedaScalingObjectAnnotations, err := getAnnotation(appName, processType, "keda_scaled_object")
+	if err != nil {
+		return annotations, err
+	}
+	annotations.KedaScalingObjectAnnotations = kedaScalingObjectAnnotations
+
+	kedaSecretAnnotations, err := getAnnotation(appName, processType, "keda_secret")
+	if err != nil {
+		return annotations, err
+	}
+	annotations.KedaSecretAnnotations = kedaSecretAnnotations
+
+	kedaTriggerAuthenticationAnnotations, err := getAnnotation(appName, processType, "keda_trigger_authentication")
+	if err != nil {
+		return annotations, err
+	}
+	annotations.KedaTriggerAuthenticationAnnotations = kedaTriggerAuthenticationAnnotations
+
+	podAnnotations, err := getAnnotation(appName, processType, "pod")
+	if err != nil {
+		return annotations, err
+	}
+	annotations.PodAnnotations = podAnnotations
+
+	secretAnnotations, err := getAnnotation(appName, processType, "secret")
+	if err != nil {
+		return annotations, err
+	}
+	annotations.SecretAnnotations = secretAnnotations
+
+	serviceAnnotations, err := getAnnotation(appName, processType, "service")
+	if err != nil {
+		return annotations, err
+	}
+	annotations.ServiceAnnotations = serviceAnnotations
+
+	serviceAccountAnnotations, err := getAnnotation(appName, processType, "serviceaccount")
+	if err != nil {
+		return annotations, err
+	}
+	annotations.ServiceAccountAnnotations = serviceAccountAnnotations
+
+	traefikIngressRouteAnnotations, err := getAnnotation(appName, processType, "traefik_ingressroute")
+	if err != nil {
+		return annotations, err
+	}
+	annotations.TraefikIngressRouteAnnotations = traefikIngressRouteAnnotations
+
+	traefikMiddlewareAnnotations, err := getAnnotation(appName, processType, "traefik_middleware")
+	if err != nil {
+		return annotations, err
+	}
+	annotations.TraefikMiddlewareAnnotations = traefikMiddlewareAnnotations
+
+	return annotations, nil
+}
+
+// GetAutoscalingInput contains all the information needed to get autoscaling config
+type GetAutoscalingInput struct {
+	// AppName is the name of the app
+	AppName string
+
+	// ProcessType is the process type
+	ProcessType string
+
+	// Replicas is the number of replicas
+	Replicas int
+
+	// KedaValues is the keda values
+	KedaValues GlobalKedaValues
+}
+
+// getAutoscaling retrieves autoscaling config for a given app and process type
+func getAutoscaling(input GetAutoscalingInput) (ProcessAutoscaling, error) {
+	config, ok, err := appjson.GetAutoscalingConfig(input.AppName, input.ProcessType, input.Replicas)
+	if err != nil {
+		common.LogWarn(fmt.Sprintf("Error getting autoscaling config for %s: %v", input.AppName, err))
+		return ProcessAutoscaling{}, err
+	}
+
+	if !ok {
+		common.LogWarn(fmt.Sprintf("No autoscaling config found for %s", input.AppName))
+		return ProcessAutoscaling{}, nil
+	}
+
+	replacements := map[string]string{
+		"APP_NAME":        input.AppName,
+		"PROCESS_TYPE":    input.ProcessType,
+		"DEPLOYMENT_NAME": fmt.Sprintf("%s-%s", input.AppName, input.ProcessType),
+	}
+
+	triggers := []ProcessAutoscalingTrigger{}
+	for _, trigger := range config.Triggers {
+		metadata := map[string]string{}
+		for key, value := range trigger.Metadata {
+			tmpl, err := template.New("").Delims("[[", "]]").Parse(value)
+			if err != nil {
+				return ProcessAutoscaling{}, fmt.Errorf("Error parsing autoscaling trigger metadata: %w", err)
+			}
+
+			var output bytes.Buffer
+			if err := tmpl.Execute(&output, replacements); err != nil {
+				return ProcessAutoscaling{}, fmt.Errorf("Error executing autoscaling trigger metadata template: %w", err)
+			}
+			metadata[key] = output.String()
+		}
+
+		trigger := ProcessAutoscalingTrigger{
+			Name:     trigger.Name,
+			Type:     trigger.Type,
+			Metadata: metadata,
+		}
+
+		if auth, ok := input.KedaValues.Authentications[trigger.Type]; ok {
+			trigger.AuthenticationRef = &ProcessAutoscalingTriggerAuthenticationRef{
+				Name: auth.Name,
+				Kind: string(auth.Kind),
+			}
+		} else if auth, ok := input.KedaValues.GlobalAuthentications[trigger.Type]; ok {
+			trigger.AuthenticationRef = &ProcessAutoscalingTriggerAuthenticationRef{
+				Name: auth.Name,
+				Kind: string(auth.Kind),
+			}
+		}
+
+		triggers = append(triggers, trigger)
+	}
+
+	autoscaling := ProcessAutoscaling{
+		CooldownPeriodSeconds:  ptr.Deref(config.CooldownPeriodSeconds, 300),
+		Enabled:                len(triggers) > 0,
+		MaxReplicas:            ptr.Deref(config.MaxQuantity, 0),
+		MinReplicas:            ptr.Deref(config.MinQuantity, 0),
+		PollingIntervalSeconds: ptr.Deref(config.PollingIntervalSeconds, 30),
+		Triggers:               triggers,
+		Type:                   "keda",
+	}
+
+	return autoscaling, nil
+}
+
+// getKedaValues retrieves keda values for a given app and process type
+func getKedaValues(ctx context.Context, clientset KubernetesClient, appName string) (GlobalKedaValues, error) {
+	properties, err := common.PropertyGetAllByPrefix("scheduler-k3s", appName, TriggerAuthPropertyPrefix)
+	if err != nil {
+		return GlobalKedaValues{}, fmt.Errorf("Error getting trigger-auth properties: %w", err)
+	}
+
+	auths := map[string]KedaAuthentication{}
+	for key, value := range properties {
+		parts := strings.SplitN(strings.TrimPrefix(key, TriggerAuthPropertyPrefix), ".", 2)
+		if len(parts) != 2 {
+			return GlobalKedaValues{}, fmt.Errorf("Invalid trigger-auth property format: %s", key)
+		}
+
+		authType := parts[0]
+		secretKey := parts[1]
+		if len(secretKey) == 0 {
+			return GlobalKedaValues{}, fmt.Errorf("Invalid trigger-auth property format: %s", key)
+		}
+
+		if _, ok := auths[authType]; !ok {
+			auths[authType] = KedaAuthentication{
+				Name:    fmt.Sprintf("%s-%s", appName, authType),
+				Type:    authType,
+				Kind:    KedaAuthenticationKind_TriggerAuthentication,
+				Secrets: make(map[string]string),
+			}
+		}
+
+		auths[authType].Secrets[secretKey] = base64.StdEncoding.EncodeToString([]byte(value))
+	}
+
+	items, err := clientset.ListClusterTriggerAuthentications(ctx, ListClusterTriggerAuthenticationsInput{})
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return GlobalKedaValues{}, fmt.Errorf("Error listing cluster trigger authentications: %w", err)
+		}
+
+		common.LogWarn(fmt.Sprintf("Error listing cluster trigger authentications: %v", err))
+		common.LogWarn("Continuing with no cluster trigger authentications")
+		common.LogWarn("This may be due to the keda helm chart not being installed")
+		items = []kedav1alpha1.ClusterTriggerAuthentication{}
+	}
+
+	globalAuths := map[string]KedaAuthentication{}
+	for _, item := range items {
+		globalAuths[item.Name] = KedaAuthentication{
+			Name: item.Name,
+			Kind: KedaAuthenticationKind_ClusterTriggerAuthentication,
+			Type: item.Name,
+		}
+	}
+
+	return GlobalKedaValues{
+		Authentications:       auths,
+		GlobalAuthentications: globalAuths,
+	}, nil
+}
+
+// getGlobalAnnotations retrieves global annotations for a given app
+func getGlobalAnnotations(appName string) (ProcessAnnotations, error) {
+	return getAnnotations(appName, GlobalProcessType)
+}
+
+// getAnnotation retrieves an annotation for a given app, process type, and resource type
+func getAnnotation(appName string, processType string, resourceType string) (map[string]string, error) {
+	annotations := map[string]string{}
+	annotationsList, err := common.PropertyListGet("scheduler-k3s", appName, fmt.Sprintf("%s.%s", processType, resourceType))
+	if err != nil {
+		return annotations, err
+	}
+
+	for _, annotation := range annotationsList {
+		parts := strings.SplitN(annotation, ": ", 2)
+		if len(parts) != 2 {
+			return annotations, fmt.Errorf("Invalid annotation format: %s", annotation)
+		}
+
+		annotations[parts[0]] = parts[1]
+	}
+
+	return annotations, nil
+}
+
+func getDependsOn(appName string) string {
+	return common.PropertyGet("scheduler-k3s", appName, "depends-on")
+}
+
+func getDeployTimeout(appName string) string {
+	return common.PropertyGetDefault("scheduler-k3s", appName, "deploy-timeout", "")
+}
+
+func getGlobalDeployTimeout() string {
+	return common.PropertyGetDefault("scheduler-k3s", "--global", "deploy-timeout", "300s")
+}
+
+func getComputedDeployTimeout(appName string) string {
+	deployTimeout := getDeployTimeout(appName)
+	if deployTimeout == "" {
+		deployTimeout = getGlobalDeployTimeout()
+	}
+
+	return deployTimeout
+}
+
+// parseDeployTimeout parses a deploy-timeout value, treating bare integers as seconds
+func parseDeployTimeout(value string) (time.Duration, error) {
+	if _, err := strconv.Atoi(value); err == nil {
+		value = fmt.Sprintf("%ss", value)
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("Invalid deploy-timeout value, expected a positive duration such as 300s or 5m: %s", value)
+	}
+
+	return timeout, nil
+}
+
+// getNotReadyDeployments returns the deployments of an app that do not have their desired number of ready replicas
+func getNotReadyDeployments(ctx context.Context, clientset KubernetesClient, appName string, namespace string) ([]string, error) {
+	deployments, err := clientset.ListDeployments(ctx, ListDeploymentsInput{
+		Namespace:     namespace,
+		LabelSelector: fmt.Sprintf("app.kubernetes.io/part-of=%s", appName),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	notReady := []string{}
+	for _, deployment := range deployments {
+		replicas := int32(1)
+		if deployment.Spec.Replicas != nil {
+			replicas = *deployment.Spec.Replicas
+		}
+
+		if deployment.Status.ReadyReplicas < replicas {
+			notReady = append(notReady, fmt.Sprintf("deployment/%s (%d/%d ready)", deployment.Name, deployment.Status.ReadyReplicas, replicas))
+		}
+	}
+
+	return notReady, nil
+}
+
+// isDeployTimeoutError returns whether a deploy failed because resources did not become ready before the deploy timeout
+func isDeployTimeoutError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || wait.Interrupted(err) {
+		return true
+	}
+
+	return strings.Contains(err.Error(), "timed out waiting for the condition") || strings.Contains(err.Error(), "context deadline exceeded")
+}
+
+// getHelmChartStatus compares the installed release for a helm chart against its declared version
+func getHelmChartStatus(chart HelmChart) (HelmChartStatus, error) {
+	status := HelmChartStatus{
+		ReleaseName:     chart.ReleaseName,
+		Namespace:       chart.Namespace,
+		DeclaredVersion: chart.Version,
+	}
+
+	helmAgent, err := NewHelmAgent(chart.Namespace, DevNullPrinter)
+	if err != nil {
+		return status, fmt.Errorf("Error creating helm agent: %w", err)
+	}
+
+	info, err := helmAgent.GetReleaseInfo(chart.ReleaseName)
+	if err != nil {
+		return status, fmt.Errorf("Unable to get release info for %s: %w", chart.ReleaseName, err)
+	}
+
+	if !info.Installed {
+		status.Status = "not-installed"
+		status.Drift = true
+		return status, nil
+	}
+
+	status.DeployedVersion = info.DeployedChartVersion
+	status.Revision = info.Revision
+	status.Status = info.Status
+	status.Drift = info.Status != "deployed" || strings.TrimPrefix(info.DeployedChartVersion, "v") != strings.TrimPrefix(chart.Version, "v")
+	return status, nil
+}
+
+// getHelmCharts returns the built-in helm charts merged with any charts from the overrides file
+func getHelmCharts() ([]HelmChart, error) {
+	charts := make([]HelmChart, len(HelmCharts))
+	copy(charts, HelmCharts)
+
+	contents, err := os.ReadFile(HelmChartsOverridePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return charts, nil
+	}
+	if err != nil {
+		return charts, fmt.Errorf("Unable to read helm chart overrides file %s: %w", HelmChartsOverridePath, err)
+	}
+
+	var overrides []HelmChart
+	if err := json.Unmarshal(contents, &overrides); err != nil {
+		return charts, fmt.Errorf("Unable to parse helm chart overrides file %s: %w", HelmChartsOverridePath, err)
+	}
+
+	for i, override := range overrides {
+		if override.ReleaseName == "" {
+			return charts, fmt.Errorf("Invalid helm chart override at index %d: missing release_name", i)
+		}
+
+		index := slices.IndexFunc(charts, func(chart HelmChart) bool {
+			return chart.ReleaseName == override.ReleaseName
+		})
+		if index >= 0 {
+			if override.ChartPath != "" {
+				charts[index].ChartPath = override.ChartPath
+			}
+			if override.RepoURL != "" {
+				charts[index].RepoURL = override.RepoURL
+			}
+			if override.Version != "" {
+				charts[index].Version = override.Version
+			}
+			continue
+		}
+
+		missing := []string{}
+		if override.ChartPath == "" {
+			missing = append(missing, "chart_path")
+		}
+		if override.Namespace == "" {
+			missing = append(missing, "namespace")
+		}
+		if override.RepoURL == "" {
+			missing = append(missing, "repo_url")
+		}
+		if override.Version == "" {
+			missing = append(missing, "version")
+		}
+		if len(missing) > 0 {
+			return charts, fmt.Errorf("Invalid helm chart %s at index %d: missing %s", override.ReleaseName, i, strings.Join(missing, ", "))
+		}
+
+		charts = append(charts, override)
+	}
+
+	return charts, nil
+}
+
+func getImagePullSecrets(appName string) string {
+	return common.PropertyGetDefault("scheduler-k3s", appName, "image-pull-secrets", "")
+}
+
+func getGlobalImagePullSecrets() string {
+	return common.PropertyGetDefault("scheduler-k3s", "--global", "image-pull-secrets", "")
+}
+
+func getComputedImagePullSecrets(appName string) string {
+	imagePullSecrets := getImagePullSecrets(appName)
+	if imagePullSecrets == "" {
+		imagePullSecrets = getGlobalImagePullSecrets()
+	}
+
+	return imagePullSecrets
+}
+
+func getGlobalIPFamily() string {
+	return common.PropertyGetDefault("scheduler-k3s", "--global", "ip-family", "ipv4")
+}
+
+// getRegistryMirrors returns the registry mirrors stored for the cluster
+func getRegistryMirrors() ([]RegistryMirror, error) {
+	mirrors := []RegistryMirror{}
+	value := common.PropertyGet("scheduler-k3s", "--global", RegistryMirrorsProperty)
+	if value == "" {
+		return mirrors, nil
+	}
+
+	if err := json.Unmarshal([]byte(value), &mirrors); err != nil {
+		return mirrors, fmt.Errorf("Unable to parse registry mirrors: %w", err)
+	}
+
+	return mirrors, nil
+}
+
+func getDNSUpstreamServers() []string {
+	value := common.PropertyGet("scheduler-k3s", "--global", DNSUpstreamServersProperty)
+	if value == "" {
+		return []string{}
+	}
+
+	return strings.Split(value, ",")
+}
+
+func getDNSStubDomains() ([]DNSStubDomain, error) {
+	stubDomains := []DNSStubDomain{}
+	value := common.PropertyGet("scheduler-k3s", "--global", DNSStubDomainsProperty)
+	if value == "" {
+		return stubDomains, nil
+	}
+
+	if err := json.Unmarshal([]byte(value), &stubDomains); err != nil {
+		return stubDomains, fmt.Errorf("Unable to parse dns stub domains: %w", err)
+	}
+
+	return stubDomains, nil
+}
+
+// parseDNSStubDomain parses a stub domain in the form DOMAIN=SERVER[,SERVER...]
+func parseDNSStubDomain(value string) (DNSStubDomain, error) {
+	domain, servers, ok := strings.Cut(value, "=")
+	if !ok || domain == "" || servers == "" {
+		return DNSStubDomain{}, fmt.Errorf("Invalid stub domain, expected DOMAIN=SERVER[,SERVER...]: %s", value)
+	}
+
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if errs := validation.IsDNS1123Subdomain(domain); len(errs) > 0 {
+		return DNSStubDomain{}, fmt.Errorf("Invalid stub domain %s: %s", domain, strings.Join(errs, ", "))
+	}
+
+	stubDomain := DNSStubDomain{Domain: domain, Servers: []string{}}
+	for _, server := range strings.Split(servers, ",") {
+		if err := validateDNSServer(server); err != nil {
+			return DNSStubDomain{}, err
+		}
+		stubDomain.Servers = append(stubDomain.Servers, server)
+	}
+
+	return stubDomain, nil
+}
+
+// validateDNSServer validates that a dns server is an ip address
+func validateDNSServer(server string) error {
+	if net.ParseIP(server) == nil {
+		return fmt.Errorf("Invalid dns server, expected an ip address: %s", server)
+	}
+
+	return nil
+}
+
+// getCoreDNSCustomManifestPath returns the path in the k3s auto-deploy manifests directory for the CoreDNS custom config
+func getCoreDNSCustomManifestPath() string {
+	return filepath.Join(getGlobalDataDir(), "server", "manifests", CoreDNSCustomManifestFilename)
+}
+
+// renderCoreDNSCustomConfig renders the coredns-custom configmap k3s imports into the CoreDNS config
+func renderCoreDNSCustomConfig(stubDomains []DNSStubDomain) ([]byte, error) {
+	data := map[string]string{}
+	for _, stubDomain := range stubDomains {
+		data[fmt.Sprintf("%s.server", stubDomain.Domain)] = fmt.Sprintf("%s:53 {\n    errors\n    cache 30\n    forward . %s\n}\n", stubDomain.Domain, strings.Join(stubDomain.Servers, " "))
+	}
+
+	return yaml.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]string{
+			"name":      "coredns-custom",
+			"namespace": "kube-system",
+		},
+		"data": data,
+	})
+}
+
+// writeCoreDNSCustomConfig writes the CoreDNS custom config to the k3s auto-deploy manifests directory
+func writeCoreDNSCustomConfig(contents []byte) error {
+	manifestPath := getCoreDNSCustomManifestPath()
+	if err := os.MkdirAll(filepath.Dir(manifestPath), 0755); err != nil {
+		return fmt.Errorf("Unable to create k3s manifests directory: %w", err)
+	}
+
+	if err := os.WriteFile(manifestPath, contents, 0644); err != nil {
+		return fmt.Errorf("Unable to write CoreDNS custom config: %w", err)
+	}
+
+	return nil
+}
+
+// renderDNSResolvConf renders the resolv.conf k3s passes to the kubelet, which CoreDNS uses for upstream queries
+func renderDNSResolvConf(servers []string) []byte {
+	lines := []string{}
+	for _, server := range servers {
+		lines = append(lines, fmt.Sprintf("nameserver %s", server))
+	}
+
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+// writeDNSResolvConf writes the dns resolver config to the local node
+func writeDNSResolvConf(contents []byte) error {
+	if err := os.MkdirAll(filepath.Dir(DNSResolvConfPath), 0755); err != nil {
+		return fmt.Errorf("Unable to create dns resolver config directory: %w", err)
+	}
+
+	if err := os.WriteFile(DNSResolvConfPath, contents, 0644); err != nil {
+		return fmt.Errorf("Unable to write dns resolver config: %w", err)
+	}
+
+	return nil
+}
+
+func getGlobalInstallCertManager() bool {
+	value := common.PropertyGetDefault("scheduler-k3s", "--global", "install-cert-manager", "true")
+	install, err := strconv.ParseBool(value)
+	if err != nil {
+		return true
+	}
+
+	return install
+}
+
+func getGlobalInstallLonghorn() bool {
+	value := common.PropertyGetDefault("scheduler-k3s", "--global", "install-longhorn", "true")
+	install, err := strconv.ParseBool(value)
+	if err != nil {
+		return true
+	}
+
+	return install
+}
+
+func getGlobalIngressClass() string {
+	return common.PropertyGetDefault("scheduler-k3s", "--global", "ingress-class", DefaultIngressClass)
+}
+
+func getIngressAnnotations(appName string, processType string) (map[string]string, error) {
+	type annotation struct {
+		annotation      string
+		getter          func(appName string) string
+		locationSnippet func(value string) string
+		serverSnippet   func(value string) string
+	}
+
+	locationLines := []string{}
+	serverLines := []string{}
+
+	properties := map[string]annotation{
+		"access-log-path": {
+			getter: nginxvhosts.ComputedAccessLogPath,
+			serverSnippet: func(value string) string {
+				if value == "" {
+					return ""
+				}
+				return fmt.Sprintf("access_log %s;", value)
+			},
+		},
+		"bind-address-ipv4": {
+			getter: nginxvhosts.ComputedBindAddressIPv4,
+		},
+		"bind-address-ipv6": {
+			getter: nginxvhosts.ComputedBindAddressIPv6,
+		},
+		"client-max-body-size": {
+			annotation: "nginx.ingress.kubernetes.io/proxy-body-size",
+			getter:     nginxvhosts.ComputedClientMaxBodySize,
+		},
+		"disable-custom-config": {
+			getter: nginxvhosts.ComputedDisableCustomConfig,
+		},
+		"error-log-path": {
+			getter: nginxvhosts.ComputedErrorLogPath,
+			serverSnippet: func(value string) string {
+				if value == "" {
+					return ""
+				}
+				return fmt.Sprintf("error_log %s;", value)
+			},
+		},
+		// todo: handle hsts properly
+		"hsts-include-subdomains": {
+			getter: nginxvhosts.ComputedHSTSIncludeSubdomains,
+		},
+		"hsts-max-age": {
+			getter: nginxvhosts.ComputedHSTSMaxAge,
+		},
+		"hsts-preload": {
+			getter: nginxvhosts.ComputedHSTSPreload,
+		},
+		"hsts": {
+			getter: nginxvhosts.ComputedHSTS,
+		},
+		"nginx-conf-sigil-path": {
+			getter: nginxvhosts.ComputedNginxConfSigilPath,
+		},
+		"proxy-buffer-size": {
+			annotation: "nginx.ingress.kubernetes.io/proxy-buffer-size",
+			getter:     nginxvhosts.ComputedProxyBufferSize,
+		},
+		"proxy-buffering": {
+			annotation: "nginx.ingress.kubernetes.io/proxy-buffering",
+			getter:     nginxvhosts.ComputedProxyBuffering,
+		},
+		"proxy-buffers": {
+			annotation: "nginx.ingress.kubernetes.io/proxy-buffers-number",
+			getter:     nginxvhosts.ComputedProxyBuffers,
+		},
+		"proxy-busy-buffers-size": {
+			getter: nginxvhosts.ComputedProxyBusyBuffersSize,
+			locationSnippet: func(value string) string {
+				if value == "" {
+					return ""
+				}
+				return fmt.Sprintf("proxy_busy_buffers_size %s;", value)
+			},
+		},
+		"proxy-read-timeout": {
+			annotation: "nginx.ingress.kubernetes.io/proxy-read-timeout",
+			getter:     nginxvhosts.ComputedProxyReadTimeout,
+		},
+		"underscore-in-headers": {
+			getter: nginxvhosts.ComputedUnderscoreInHeaders,
+			serverSnippet: func(value string) string {
+				if value == "" {
+					return ""
+				}
+				return fmt.Sprintf("underscores_in_headers %s;", value)
+			},
+		},
+		"x-forwarded-for-value": {
+			getter: nginxvhosts.ComputedXForwardedForValue,
+			locationSnippet: func(value string) string {
+				if value == "" {
+					return ""
+				}
+				return fmt.Sprintf("proxy_set_header X-Forwarded-For %s;", value)
+			},
+		},
+		"x-forwarded-port-value": {
+			getter: nginxvhosts.ComputedXForwardedPortValue,
+			locationSnippet: func(value string) string {
+				if value == "" {
+					return ""
+				}
+				return fmt.Sprintf("proxy_set_header X-Forwarded-Port %s;", value)
+			},
+		},
+		"x-forwarded-proto-value": {
+			getter: nginxvhosts.ComputedXForwardedProtoValue,
+			locationSnippet: func(value string) string {
+				if value == "" {
+					return ""
+				}
+				return fmt.Sprintf("proxy_set_header X-Forwarded-Proto %s;", value)
+			},
+		},
+		"x-forwarded-ssl": {
+			getter: nginxvhosts.ComputedXForwardedSSL,
+			locationSnippet: func(value string) string {
+				if value == "" {
+					return ""
+				}
+				return fmt.Sprintf("proxy_set_header X-Forwarded-SSL %s;", value)
+			},
+		},
+	}
+
+	annotations := map[string]string{}
+	for _, newKey := range properties {
+		if newKey.locationSnippet != nil {
+			locationLines = append(locationLines, newKey.locationSnippet(newKey.getter(appName)))
+		} else if newKey.serverSnippet != nil {
+			serverLines = append(serverLines, newKey.serverSnippet(newKey.getter(appName)))
+		} else if newKey.annotation != "" {
+			annotations[newKey.annotation] = newKey.getter(appName)
+		}
+	}
+
+	var locationSnippet string
+	for _, line := range locationLines {
+		if line != "" {
+			locationSnippet += line + "\n"
+		}
+	}
+	var serverSnippet string
+	for _, line := range serverLines {
+		if line != "" {
+			serverSnippet += line + "\n"
+		}
+	}
+
+	if locationSnippet != "" {
+		annotations["nginx.ingress.kubernetes.io/configuration-snippet"] = locationSnippet
+	}
+	if serverSnippet != "" {
+		annotations["nginx.ingress.kubernetes.io/server-snippet"] = serverSnippet
+	}
+
+	customAnnotations, err := getAnnotation(appName, processType, "deployment")
+	if err != nil {
+		return map[string]string{}, err
+	}
+
+	for key, value := range customAnnotations {
+		if _, ok := annotations[key]; ok {
+			common.LogWarn(fmt.Sprintf("Nginx-based annotation %s will be overwritten by custom annotation", key))
+		}
+
+		annotations[key] = value
+	}
+
+	return annotations, nil
+}
+
+// getLabels retrieves labels for a given app and process type
+func getLabels(appName string, processType string) (ProcessLabels, error) {
+	labels := ProcessLabels{}
+	certificateLabels, err := getLabel(appName, processType, "certificate")
+	if err != nil {
+		return labels, err
+	}
+	labels.CertificateLabels = certificateLabels
+
+	cronJobLabels, err := getLabel(appName, processType, "cronjob")
+	if err != nil {
+		return labels, err
+	}
+	labels.CronJobLabels = cronJobLabels
+
+	deploymentLabels, err := getLabel(appName, processType, "deployment")
+	if err != nil {
+		return labels, err
+	}
+	labels.DeploymentLabels = deploymentLabels
+
+	ingressLabels, err := getLabel(appName, processType, "ingress")
+	if err != nil {
+		return labels, err
+	}
+	labels.IngressLabels = ingressLabels
+
+	jobLabels, err := getLabel(appName, processType, "job")
+	if err != nil {
+		return labels, err
+	}
+	labels.JobLabels = jobLabels
+
+	podLabels, err := getLabel(appName, processType, "pod")
+	if err != nil {
+		return labels, err
+	}
+	labels.PodLabels = podLabels
+
+	secretLabels, err := getLabel(appName, processType, "secret")
+	if err != nil {
+		return labels, err
+	}
+	labels.SecretLabels = secretLabels
+
+	serviceLabels, err := getLabel(appName, processType, "service")
+	if err != nil {
+		return labels, err
+	}
+	labels.ServiceLabels = serviceLabels
+
+	serviceAccountLabels, err := getLabel(appName, processType, "serviceaccount")
+	if err != nil {
+		return labels, err
+	}
+	labels.ServiceAccountLabels = serviceAccountLabels
+
+	traefikIngressRouteLabels, err := getLabel(appName, processType, "traefik_ingressroute")
+	if err != nil {
+		return labels, err
+	}
+	labels.TraefikIngressRouteLabels = traefikIngressRouteLabels
+
+	traefikMiddlewareLabels, err := getLabel(appName, processType, "traefik_middleware")
+	if err != nil {
+		return labels, err
+	}
+	labels.TraefikMiddlewareLabels = traefikMiddlewareLabels
+
+	return labels, nil
+}
+
+// getGlobalLabel retrieves global labels for a given app
+func getGlobalLabel(appName string) (ProcessLabels, error) {
+	return getLabels(appName, GlobalProcessType)
+}
+
+// getLabel retrieves an label for a given app, process type, and resource type
+func getLabel(appName string, processType string, resourceType string) (map[string]string, error) {
+	labels := map[string]string{}
+	labelsList, err := common.PropertyListGet("scheduler-k3s", appName, fmt.Sprintf("labels.%s.%s", processType, resourceType))
+	if err != nil {
+		return labels, err
+	}
+
+	for _, label := range labelsList {
+		parts := strings.SplitN(label, ": ", 2)
+		if len(parts) != 2 {
+			return labels, fmt.Errorf("Invalid label format: %s", label)
+		}
+
+		labels[parts[0]] = parts[1]
+	}
+
+	return labels, nil
+}
+
+func getLetsencryptServer(appName string) string {
+	return common.PropertyGetDefault("scheduler-k3s", appName, "letsencrypt-server", "")
+}
+
+func getGlobalLonghornDefault() bool {
+	value := common.PropertyGetDefault("scheduler-k3s", "--global", "longhorn-default", "true")
+	isDefault, err := strconv.ParseBool(value)
+	if err != nil {
+		return true
+	}
+
+	return isDefault
+}
+
+func getGlobalLonghornReplicaCount() string {
+	return common.PropertyGet("scheduler-k3s", "--global", "longhorn-replica-count")
+}
+
+func getGlobalLetsencryptServer() string {
+	return common.PropertyGetDefault("scheduler-k3s", "--global", "letsencrypt-server", "prod")
+}
+
+func getComputedLetsencryptServer(appName string) string {
+	letsencryptServer := getLetsencryptServer(appName)
+	if letsencryptServer == "" {
+		letsencryptServer = getGlobalLetsencryptServer()
+	}
+
+	return letsencryptServer
+}
+
+// isLetsencryptServerURL returns true if the value is an https acme directory url
+func isLetsencryptServerURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && u.Scheme == "https" && u.Host != ""
+}
+
+// getCustomLetsencryptServer returns the acme url served by the custom cluster issuer, or an empty string if the global letsencrypt-server does not need one
+func getCustomLetsencryptServer(globalServer string) string {
+	if !isLetsencryptServerURL(globalServer) {
+		return ""
+	}
+	if globalServer == LetsencryptProdServer || globalServer == LetsencryptStagServer {
+		return ""
+	}
+
+	return globalServer
+}
+
+// getLetsencryptIssuerName returns the cluster issuer that serves a letsencrypt-server value
+func getLetsencryptIssuerName(server string, globalServer string) (string, error) {
+	switch server {
+	case "prod", "production", LetsencryptProdServer:
+		return "letsencrypt-prod", nil
+	case "stag", "staging", LetsencryptStagServer:
+		return "letsencrypt-stag", nil
+	}
+
+	if !isLetsencryptServerURL(server) {
+		return "", fmt.Errorf("Invalid letsencrypt server config: %s", server)
+	}
+	if server != getCustomLetsencryptServer(globalServer) {
+		return "", fmt.Errorf("Invalid letsencrypt server config, acme urls must match the global letsencrypt-server: %s", server)
+	}
+
+	return LetsencryptCustomIssuerName, nil
+}
+
+func getGlobalLetsencryptEmailProd() string {
+	return common.PropertyGetDefault("scheduler-k3s", "--global", "letsencrypt-email-prod", "")
+}
+
+func getGlobalLetsencryptEmailStag() string {
+	return common.PropertyGetDefault("scheduler-k3s", "--global", "letsencrypt-email-stag", "")
+}
+
+func getGlobalLoadbalancer() string {
+	return common.PropertyGetDefault("scheduler-k3s", "--global", "loadbalancer", DefaultLoadbalancer)
+}
+
+func getGlobalMaxParallelImagePulls() string {
+	return common.PropertyGetDefault("scheduler-k3s", "--global", "max-parallel-image-pulls", "")
+}
+
+func getGlobalMetalLBAddressPool() string {
+	return common.PropertyGet("scheduler-k3s", "--global", "metallb-address-pool")
+}
+
+// getServerLabels returns the labels applied to server nodes, omitting the servicelb label when metallb is in use
+func getServerLabels() map[string]string {
+	if getGlobalLoadbalancer() == "metallb" {
+		return map[string]string{}
+	}
+
+	return ServerLabels
+}
+
+func getNamespace(appName string) string {
+	return common.PropertyGetDefault("scheduler-k3s", appName, "namespace", "")
+}
+
+func getGlobalNamespace() string {
+	return common.PropertyGetDefault("scheduler-k3s", "--global", "namespace", "default")
+}
+
+func getComputedNamespace(appName string) string {
+	namespace := getNamespace(appName)
+	if namespace == "" {
+		namespace = getGlobalNamespace()
+	}
+
+	return namespace
+}
+
+func getGlobalFlannelBackend() string {
+	return common.PropertyGetDefault("scheduler-k3s", "--global", "flannel-backend", "wireguard-native")
+}
+
+// getInterfaceAddresses returns the ipv4 and global unicast ipv6 addresses of a network interface
+func getInterfaceAddresses(iface net.Interface) ([]net.IP, []net.IP, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to get network addresses for interface %s: %w", iface.Name, err)
+	}
+
+	ipv4Addresses := []net.IP{}
+	ipv6Addresses := []net.IP{}
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		if ipnet.IP.To4() != nil {
+			ipv4Addresses = append(ipv4Addresses, ipnet.IP)
+		} else if ipnet.IP.IsGlobalUnicast() {
+			ipv6Addresses = append(ipv6Addresses, ipnet.IP)
+		}
+	}
+
+	return ipv4Addresses, ipv6Addresses, nil
+}
+
+func getGlobalFlannelWireguardPort() string {
+	return common.PropertyGet("scheduler-k3s", "--global", "flannel-wireguard-port")
+}
+
+// getFlannelConfig returns the rendered flannel config when a custom wireguard port is configured, or nil otherwise
+func getFlannelConfig(flannelBackend string, wireguardPort int, clusterCIDR string) ([]byte, error) {
+	if wireguardPort == 0 {
+		return nil, nil
+	}
+
+	if flannelBackend != "wireguard-native" {
+		common.LogWarn(fmt.Sprintf("Ignoring flannel-wireguard-port, the %s flannel backend does not use wireguard", flannelBackend))
+		return nil, nil
+	}
+
+	return renderFlannelConfig(clusterCIDR, wireguardPort)
+}
+
+// renderFlannelConfig renders a flannel net-conf.json for the wireguard-native backend listening on a custom port
+// the port is used for ipv4 traffic, and the next port is used for ipv6 traffic
+func renderFlannelConfig(clusterCIDR string, port int) ([]byte, error) {
+	ipv4Network := ""
+	ipv6Network := ""
+	for _, cidr := range strings.Split(clusterCIDR, ",") {
+		ip, _, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid cluster cidr %s: %w", cidr, err)
+		}
+		if ip.To4() != nil {
+			ipv4Network = cidr
+		} else {
+			ipv6Network = cidr
+		}
+	}
+
+	config := map[string]interface{}{
+		"EnableIPv4": ipv4Network != "",
+		"EnableIPv6": ipv6Network != "",
+		"Backend": map[string]interface{}{
+			"Type":                        "wireguard",
+			"Mode":                        "separate",
+			"PersistentKeepaliveInterval": 25,
+			"ListenPort":                  port,
+			"ListenPortV6":                port + 1,
+		},
+	}
+	if ipv4Network != "" {
+		config["Network"] = ipv4Network
+	}
+	if ipv6Network != "" {
+		config["IPv6Network"] = ipv6Network
+	}
+
+	b, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to marshal flannel config: %w", err)
+	}
+
+	return b, nil
+}
+
+// writeFlannelConfig writes the flannel config to the local host
+func writeFlannelConfig(contents []byte) error {
+	if err := os.MkdirAll(filepath.Dir(FlannelConfigPath), 0755); err != nil {
+		return fmt.Errorf("Unable to create flannel config directory: %w", err)
+	}
+
+	if err := os.WriteFile(FlannelConfigPath, contents, 0644); err != nil {
+		return fmt.Errorf("Unable to write flannel config: %w", err)
+	}
+
+	return nil
+}
+
+func getGlobalClusterCIDR() string {
+	return common.PropertyGet("scheduler-k3s", "--global", "cluster-cidr")
+}
+
+func getGlobalServiceCIDR() string {
+	return common.PropertyGet("scheduler-k3s", "--global", "service-cidr")
+}
+
+// getInitConfigPath returns the path to the init configuration snapshot
+func getInitConfigPath() string {
+	return filepath.Join(common.GetDataDirectory("scheduler-k3s"), InitConfigFilename)
+}
+
+// getInitConfig returns the effective init configuration, reading the disabled components from the k3s installer args
+func getInitConfig(args []string, ingressClass string, serverIP string, taintScheduling bool) InitConfig {
+	config := InitConfig{
+		InitializedAt:   time.Now().UTC(),
+		K3sVersion:      getGlobalK3sVersion(),
+		IngressClass:    ingressClass,
+		ServerIP:        serverIP,
+		TaintScheduling: taintScheduling,
+		Disable:         []string{},
+		FlannelBackend:  getGlobalFlannelBackend(),
+		IPFamily:        getGlobalIPFamily(),
+		DataDir:         getGlobalDataDir(),
+	}
+	config.ClusterCIDR, config.ServiceCIDR = getClusterCIDRs()
+	if config.K3sVersion == "" {
+		config.K3sChannel = getGlobalK3sChannel()
+	}
+	if config.FlannelBackend == "wireguard-native" {
+		config.FlannelWireguardPort, _ = strconv.Atoi(getGlobalFlannelWireguardPort())
+	}
+
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == "--disable" && !slices.Contains(config.Disable, args[i+1]) {
+			config.Disable = append(config.Disable, args[i+1])
+		}
+	}
+
+	return config
+}
+
+// isManagedNodeLabel returns whether a node label is managed by Dokku
+func isManagedNodeLabel(key string) bool {
+	if strings.HasPrefix(key, ProtectedAnnotationPrefix) {
+		return true
+	}
+	if _, ok := ServerLabels[key]; ok {
+		return true
+	}
+	if _, ok := WorkerLabels[key]; ok {
+		return true
+	}
+	return false
+}
+
+// getClusterExportNode returns the Dokku-managed metadata for a node
+func getClusterExportNode(node v1.Node) ClusterExportNode {
+	exportNode := ClusterExportNode{
+		Name:        node.Name,
+		Role:        common.PropertyGet("scheduler-k3s", "--global", fmt.Sprintf("%s%s.role", NodePropertyPrefix, node.Name)),
+		RemoteHost:  common.PropertyGet("scheduler-k3s", "--global", fmt.Sprintf("%s%s.remote-host", NodePropertyPrefix, node.Name)),
+		Annotations: map[string]string{},
+		Labels:      map[string]string{},
+	}
+
+	for key, value := range node.Annotations {
+		if strings.HasPrefix(key, ProtectedAnnotationPrefix) {
+			exportNode.Annotations[key] = value
+		}
+	}
+
+	for key, value := range node.Labels {
+		if isManagedNodeLabel(key) {
+			exportNode.Labels[key] = value
+		}
+	}
+
+	return exportNode
+}
+
+// validateClusterExportNode returns a description of why the exported metadata cannot be applied to a node, if any
+func validateClusterExportNode(exportNode ClusterExportNode, node v1.Node) string {
+	if exportNode.Role != "" && exportNode.Role != "server" && exportNode.Role != "worker" {
+		return fmt.Sprintf("invalid role %s", exportNode.Role)
+	}
+
+	// the k3s role labels reflect the process running on the node, so an exported role must agree with them
+	role := getNodeLabelRole(node)
+
+	if exportNode.Role != "" && exportNode.Role != role {
+		return fmt.Sprintf("exported as a %s but running as a %s", exportNode.Role, role)
+	}
+
+	return ""
+}
+
+// readInitConfig reads the init configuration snapshot, returning false if it has not been recorded
+func readInitConfig() (InitConfig, bool, error) {
+	config := InitConfig{}
+	contents, err := os.ReadFile(getInitConfigPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return config, false, nil
+	}
+	if err != nil {
+		return config, false, fmt.Errorf("Unable to read init config %s: %w", getInitConfigPath(), err)
+	}
+
+	if err := json.Unmarshal(contents, &config); err != nil {
+		return config, false, fmt.Errorf("Unable to parse init config %s: %w", getInitConfigPath(), err)
+	}
+
+	return config, true, nil
+}
+
+// writeInitConfig writes the init configuration snapshot to the plugin data directory
+func writeInitConfig(config InitConfig) error {
+	b, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Unable to marshal init config: %w", err)
+	}
+
+	if err := common.CreateDataDirectory("scheduler-k3s"); err != nil {
+		return fmt.Errorf("Unable to create data directory: %w", err)
+	}
+
+	err = common.WriteBytesToFile(common.WriteBytesToFileInput{
+		Bytes:    append(b, '\n'),
+		Filename: getInitConfigPath(),
+		Mode:     os.FileMode(0644),
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to write init config %s: %w", getInitConfigPath(), err)
+	}
+
+	return nil
+}
+
+// getClusterCIDRs returns the effective pod and service cidrs, falling back to the k3s defaults
+func getClusterCIDRs() (string, string) {
+	clusterCIDR := "10.42.0.0/16"
+	serviceCIDR := "10.43.0.0/16"
+	args := getIPFamilyArgs()
+	for i := 0; i < len(args)-1; i++ {
+		switch args[i] {
+		case "--cluster-cidr":
+			clusterCIDR = args[i+1]
+		case "--service-cidr":
+			serviceCIDR = args[i+1]
+		}
+	}
+
+	return clusterCIDR, serviceCIDR
+}
+
+// getIPFamilyArgs returns the cluster and service cidr flags to pass to the k3s installer on server nodes
+// the cluster-cidr and service-cidr properties take precedence over the defaults for the ip-family
+func getIPFamilyArgs() []string {
+	clusterCIDR := ""
+	serviceCIDR := ""
+	extraArgs := []string{}
+	switch getGlobalIPFamily() {
+	case "ipv6":
+		clusterCIDR = "2001:cafe:42::/56"
+		serviceCIDR = "2001:cafe:43::/112"
+		extraArgs = append(extraArgs, "--flannel-ipv6-masq")
+	case "dual":
+		clusterCIDR = "10.42.0.0/16,2001:cafe:42::/56"
+		serviceCIDR = "10.43.0.0/16,2001:cafe:43::/112"
+		extraArgs = append(extraArgs, "--flannel-ipv6-masq")
+	}
+
+	if value := getGlobalClusterCIDR(); value != "" {
+		clusterCIDR = value
+	}
+	if value := getGlobalServiceCIDR(); value != "" {
+		serviceCIDR = value
+	}
+
+	args := []string{}
+	if clusterCIDR != "" {
+		args = append(args, "--cluster-cidr", clusterCIDR)
+	}
+	if serviceCIDR != "" {
+		args = append(args, "--service-cidr", serviceCIDR)
+	}
+
+	return append(args, extraArgs...)
+}
+
+func getGlobalDataDir() string {
+	return common.PropertyGetDefault("scheduler-k3s", "--global", "data-dir", DefaultK3sDataDir)
+}
+
+// getK3sDataDirArgs returns the --data-dir flag to pass to k3s when a custom data directory is configured
+func getK3sDataDirArgs() []string {
+	dataDir := getGlobalDataDir()
+	if dataDir == DefaultK3sDataDir {
+		return []string{}
+	}
+
+	return []string{"--data-dir", dataDir}
+}
+
+// getK3sDisableArgs returns the --disable flags to pass to the k3s installer
+// local-storage and traefik are always disabled as dokku installs its own storage and ingress
+func getK3sDisableArgs(components []string) ([]string, error) {
+	validComponents := map[string]bool{
+		"coredns":        true,
+		"local-storage":  true,
+		"metrics-server": true,
+		"runtimes":       true,
+		"servicelb":      true,
+		"traefik":        true,
+	}
+	dependentComponents := map[string]string{
+		"coredns":        "cluster dns is required by cert-manager, keda, and app service discovery",
+		"metrics-server": "cpu and memory based autoscaling requires the metrics api",
+		"servicelb":      "the ingress controller relies on a LoadBalancer service to receive traffic",
+	}
+
+	disabled := []string{"local-storage", "traefik"}
+	if getGlobalLoadbalancer() == "metallb" {
+		disabled = append(disabled, "servicelb")
+	}
+	for _, component := range components {
+		component = strings.TrimSpace(component)
+		if component == "" {
+			continue
+		}
+
+		if !validComponents[component] {
+			return []string{}, fmt.Errorf("Invalid k3s component to disable, expected one of coredns, local-storage, metrics-server, runtimes, servicelb, or traefik: %s", component)
+		}
+
+		if slices.Contains(disabled, component) {
+			continue
+		}
+
+		if reason, ok := dependentComponents[component]; ok {
+			common.LogWarn(fmt.Sprintf("Disabling %s may break the cluster unless a replacement is installed: %s", component, reason))
+		}
+		disabled = append(disabled, component)
+	}
+
+	args := []string{}
+	for _, component := range disabled {
+		args = append(args, "--disable", component)
+	}
+
+	return args, nil
+}
+
+// getK3sDependencyBinaries returns the binaries provided by the k3s apt dependencies
+// that must already exist on a host when apt installation is skipped
+func getK3sDependencyBinaries() []string {
+	binaries := []string{
+		// used to download the k3s installer and binary
+		"curl",
+	}
+
+	if getGlobalInstallLonghorn() {
+		// provided by open-iscsi and nfs-common, required by longhorn
+		binaries = append(binaries, "iscsiadm", "mount.nfs")
+	}
+
+	return binaries
+}
+
+// getK3sDependencies returns the apt packages required to run k3s
+func getK3sDependencies() []string {
+	dependencies := []string{
+		"ca-certificates",
+		"curl",
+	}
+
+	if getGlobalInstallLonghorn() {
+		dependencies = append(dependencies, "open-iscsi", "nfs-common")
+	}
+
+	if getGlobalFlannelBackend() == "wireguard-native" {
+		dependencies = append(dependencies, "wireguard")
+	}
+
+	return dependencies
+}
+
+func getGlobalHTTPProxy() string {
+	return common.PropertyGet("scheduler-k3s", "--global", "http-proxy")
+}
+
+func getGlobalHTTPSProxy() string {
+	return common.PropertyGet("scheduler-k3s", "--global", "https-proxy")
+}
+
+func getGlobalNoProxy() string {
+	return common.PropertyGet("scheduler-k3s", "--global", "no-proxy")
+}
+
+// getProxyValue returns the property value if set, falling back to the upper and then lower case environment variable
+func getProxyValue(value string, envName string) string {
+	if value != "" {
+		return value
+	}
+
+	if envValue := os.Getenv(envName); envValue != "" {
+		return envValue
+	}
+
+	return os.Getenv(strings.ToLower(envName))
+}
+
+// getProxyConfig returns the effective proxy configuration, preferring the global properties over the environment
+func getProxyConfig() httpproxy.Config {
+	return httpproxy.Config{
+		HTTPProxy:  getProxyValue(getGlobalHTTPProxy(), "HTTP_PROXY"),
+		HTTPSProxy: getProxyValue(getGlobalHTTPSProxy(), "HTTPS_PROXY"),
+		NoProxy:    getProxyValue(getGlobalNoProxy(), "NO_PROXY"),
+	}
+}
+
+// getProxyEnv returns the proxy environment variables to pass to apt, curl, and the k3s installer
+// both cases are set, as curl only honors the lower case http_proxy and the k3s installer copies either into the k3s service environment
+func getProxyEnv() map[string]string {
+	config := getProxyConfig()
+	values := map[string]string{
+		"HTTP_PROXY":  config.HTTPProxy,
+		"HTTPS_PROXY": config.HTTPSProxy,
+		"NO_PROXY":    config.NoProxy,
+	}
+
+	env := map[string]string{}
+	for key, value := range values {
+		if value == "" {
+			continue
+		}
+
+		env[key] = value
+		env[strings.ToLower(key)] = value
+	}
+
+	return env
+}
+
+// withProxyEnv prefixes a command with env and the proxy environment variables, as sudo resets the environment on remote hosts
+func withProxyEnv(command []string) []string {
+	env := getProxyEnv()
+	if len(env) == 0 {
+		return command
+	}
+
+	args := []string{}
+	for key, value := range env {
+		args = append(args, fmt.Sprintf("%s=%s", key, value))
+	}
+	sort.Strings(args)
+
+	// merge into an existing env invocation rather than nesting env calls
+	if len(command) > 0 && command[0] == "env" {
+		command = command[1:]
+	}
+
+	return append(append([]string{"env"}, args...), command...)
+}
+
+// newRestyClient returns a resty client that sends requests through the configured proxy
+func newRestyClient() *resty.Client {
+	client := resty.New()
+	proxyFunc := getProxyConfig().ProxyFunc()
+	if transport, ok := client.GetClient().Transport.(*http.Transport); ok {
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			return proxyFunc(req.URL)
+		}
+	}
+
+	return client
+}
+
+func getGlobalK3sChannel() string {
+	return common.PropertyGet("scheduler-k3s", "--global", "k3s-channel")
+}
+
+func getGlobalK3sInstallerPath() string {
+	return common.PropertyGet("scheduler-k3s", "--global", "k3s-installer-path")
+}
+
+func getGlobalK3sBinaryPath() string {
+	return common.PropertyGet("scheduler-k3s", "--global", "k3s-binary-path")
+}
+
+func getGlobalK3sAirgapImagesPath() string {
+	return common.PropertyGet("scheduler-k3s", "--global", "k3s-airgap-images-path")
+}
+
+// getK3sAirgapImagesDestination returns the path k3s imports a staged airgap images tarball from
+func getK3sAirgapImagesDestination(imagesPath string) string {
+	return filepath.Join(getGlobalDataDir(), "agent", "images", filepath.Base(imagesPath))
+}
+
+// getAirgapFiles returns the staged airgap files to copy to a node
+func getAirgapFiles() []AirgapFile {
+	files := []AirgapFile{}
+	if binaryPath := getGlobalK3sBinaryPath(); binaryPath != "" {
+		files = append(files, AirgapFile{Source: binaryPath, Destination: K3sBinaryPath})
+	}
+	if imagesPath := getGlobalK3sAirgapImagesPath(); imagesPath != "" {
+		files = append(files, AirgapFile{Source: imagesPath, Destination: getK3sAirgapImagesDestination(imagesPath)})
+	}
+
+	return files
+}
+
+func getGlobalK3sVersion() string {
+	return common.PropertyGet("scheduler-k3s", "--global", "k3s-version")
+}
+
+func getGlobalKubeControllerManagerArgs() string {
+	return common.PropertyGet("scheduler-k3s", "--global", "kube-controller-manager-args")
+}
+
+// getKubeControllerManagerArgs returns the --kube-controller-manager-arg flags to pass to the k3s installer on server nodes
+func getKubeControllerManagerArgs() ([]string, error) {
+	defaultArgs := []string{
+		// bind controller-manager to all interfaces
+		"bind-address=0.0.0.0",
+		// gc terminated pods
+		"terminated-pod-gc-threshold=10",
+	}
+
+	userArgs, err := parseKubeControllerManagerArgs(getGlobalKubeControllerManagerArgs())
+	if err != nil {
+		return []string{}, err
+	}
+
+	overriddenKeys := map[string]bool{}
+	for _, arg := range userArgs {
+		parts := strings.SplitN(arg, "=", 2)
+		overriddenKeys[parts[0]] = true
+	}
+
+	args := []string{}
+	for _, arg := range defaultArgs {
+		parts := strings.SplitN(arg, "=", 2)
+		if overriddenKeys[parts[0]] {
+			continue
+		}
+		args = append(args, "--kube-controller-manager-arg", arg)
+	}
+
+	for _, arg := range userArgs {
+		args = append(args, "--kube-controller-manager-arg", arg)
+	}
+
+	return args, nil
+}
+
+func getGlobalKubeletArgs() string {
+	return common.PropertyGet("scheduler-k3s", "--global", "kubelet-args")
+}
+
+// getKubeletArgs returns the --kubelet-arg flags to pass to the k3s installer
+func getKubeletArgs() ([]string, error) {
+	args := []string{}
+	if value := getGlobalSerializeImagePulls(); value != "" {
+		serializeImagePulls, err := strconv.ParseBool(value)
+		if err != nil {
+			return []string{}, fmt.Errorf("Invalid serialize-image-pulls value: %s", value)
+		}
+		args = append(args, "--kubelet-arg", fmt.Sprintf("serialize-image-pulls=%s", strconv.FormatBool(serializeImagePulls)))
+	}
+
+	if value := getGlobalMaxParallelImagePulls(); value != "" {
+		maxParallelImagePulls, err := strconv.Atoi(value)
+		if err != nil || maxParallelImagePulls < 1 {
+			return []string{}, fmt.Errorf("Invalid max-parallel-image-pulls value: %s", value)
+		}
+		if err := validateImagePulls(getGlobalSerializeImagePulls(), value); err != nil {
+			return []string{}, err
+		}
+		args = append(args, "--kubelet-arg", fmt.Sprintf("max-parallel-image-pulls=%d", maxParallelImagePulls))
+	}
+
+	// user-specified args are passed last so they take precedence over the args above
+	userArgs, err := parseKubeletArgs(getGlobalKubeletArgs())
+	if err != nil {
+		return []string{}, err
+	}
+	for _, arg := range userArgs {
+		args = append(args, "--kubelet-arg", arg)
+	}
+
+	return args, nil
+}
+
+func getGlobalNetworkInterface() string {
+	return common.PropertyGet("scheduler-k3s", "--global", "network-interface")
+}
+
+// getNetworkInterface returns the configured network interface, falling back to eth0
+// the default route interface is only detected - and saved to the property - when initializing a new cluster,
+// so existing installs without the property keep using eth0
+func getNetworkInterface() string {
+	return common.PropertyGetDefault("scheduler-k3s", "--global", "network-interface", DefaultNetworkInterface)
+}
+
+// getDefaultRouteInterface returns the interface backing the default route, preferring the configured ip family
+func getDefaultRouteInterface() (string, error) {
+	families := []string{"ipv4", "ipv6"}
+	if getGlobalIPFamily() == "ipv6" {
+		families = []string{"ipv6", "ipv4"}
+	}
+
+	for _, family := range families {
+		networkInterface, err := readDefaultRouteInterface(family)
+		if err != nil {
+			return "", err
+		}
+		if networkInterface != "" {
+			return networkInterface, nil
+		}
+	}
+
+	return "", fmt.Errorf("No default route found")
+}
+
+// readDefaultRouteInterface returns the interface of the lowest metric default route for an ip family from the kernel routing table
+func readDefaultRouteInterface(family string) (string, error) {
+	path := "/proc/net/route"
+	if family == "ipv6" {
+		path = "/proc/net/ipv6_route"
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("Unable to read %s: %w", path, err)
+	}
+
+	return parseDefaultRouteInterface(string(b), family), nil
+}
+
+// parseDefaultRouteInterface returns the interface of the lowest metric default route in the contents of /proc/net/route or /proc/net/ipv6_route
+func parseDefaultRouteInterface(contents string, family string) string {
+	// RTF_UP and RTF_REJECT from linux/route.h
+	const routeFlagUp = 0x0001
+	const routeFlagReject = 0x0200
+
+	selectedInterface := ""
+	var selectedMetric uint64
+	for _, line := range strings.Split(contents, "\n") {
+		fields := strings.Fields(line)
+
+		// ipv4 metrics are decimal, while every ipv6 field is hex
+		var networkInterface, destination, prefix, flagsValue, metricValue string
+		metricBase := 10
+		switch {
+		case family == "ipv4" && len(fields) >= 8:
+			networkInterface, destination, flagsValue, metricValue, prefix = fields[0], fields[1], fields[3], fields[6], fields[7]
+		case family == "ipv6" && len(fields) >= 10:
+			destination, prefix, metricValue, flagsValue, networkInterface = fields[0], fields[1], fields[5], fields[8], fields[9]
+			metricBase = 16
+		default:
+			continue
+		}
+
+		if strings.Trim(destination, "0") != "" || strings.Trim(prefix, "0") != "" || networkInterface == "lo" {
+			continue
+		}
+
+		// a vpn tunnel carrying the default route does not hold the address other nodes should connect to
+		if strings.HasPrefix(networkInterface, "wg") || strings.HasPrefix(networkInterface, "tun") {
+			continue
+		}
+
+		flags, err := strconv.ParseUint(flagsValue, 16, 32)
+		if err != nil || flags&routeFlagUp == 0 || flags&routeFlagReject != 0 {
+			continue
+		}
+
+		metric, err := strconv.ParseUint(metricValue, metricBase, 32)
+		if err != nil {
+			continue
+		}
+
+		if selectedInterface == "" || metric < selectedMetric {
+			selectedInterface = networkInterface
+			selectedMetric = metric
+		}
+	}
+
+	return selectedInterface
+}
+
+func getGlobalNodeWaitTimeout() string {
+	return common.PropertyGetDefault("scheduler-k3s", "--global", "node-wait-timeout", "120")
+}
+
+// getNodeJoinIssues returns a list of join steps that were not completed for a node
+func getNodeJoinIssues(node v1.Node) []string {
+	role := getNodeRole(node)
+	labels := getServerLabels()
+	if role == "worker" {
+		labels = WorkerLabels
+	}
+
+	issues := []string{}
+	for key, value := range labels {
+		if node.Labels[key] != value {
+			issues = append(issues, fmt.Sprintf("missing label %s=%s", key, value))
+		}
+	}
+
+	if role == "worker" && node.Annotations["dokku.com/remote-host"] == "" {
+		issues = append(issues, "missing annotation dokku.com/remote-host")
+	}
+
+	sort.Strings(issues)
+	return issues
+}
+
+// getExistingRemoteNode returns the name of the cluster node running on a remote host that already has k3s installed.
+// An error is returned if k3s is installed on the host but the host is not a node in this cluster.
+func getExistingRemoteNode(ctx context.Context, clientset KubernetesClient, remoteHost string, allowUknownHosts bool) (string, error) {
+	versionCmd, err := common.CallSshCommandWithContext(ctx, common.SshCommandInput{
+		Command:          "/usr/local/bin/k3s",
+		Args:             []string{"--version"},
+		AllowUknownHosts: allowUknownHosts,
+		RemoteHost:       remoteHost,
+	})
+	// a non-zero exit - such as 127 when the binary is missing - means k3s is not installed,
+	// while an error without an exit code is an ssh transport failure
+	if err != nil && versionCmd.ExitCode <= 0 {
+		return "", fmt.Errorf("Unable to call k3s version command over ssh: %w", err)
+	}
+	if versionCmd.ExitCode != 0 || !strings.HasPrefix(versionCmd.Stdout, "k3s version") {
+		return "", nil
+	}
+
+	u, err := url.Parse(remoteHost)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse remote host: %w", err)
+	}
+
+	nodes, err := clientset.ListNodes(ctx, ListNodesInput{})
+	if err != nil {
+		return "", fmt.Errorf("Unable to list nodes: %w", err)
+	}
+
+	for _, node := range nodes {
+		if nodeRemoteHost := getNodeRemoteHost(node); nodeRemoteHost != "" {
+			nu, err := url.Parse(nodeRemoteHost)
+			if err == nil && nu.Hostname() == u.Hostname() {
+				return node.Name, nil
+			}
+			continue
+		}
+
+		for _, address := range node.Status.Addresses {
+			if address.Address == u.Hostname() {
+				return node.Name, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("k3s is already installed on %s but the host is not a node in this cluster, it may be partially installed or joined to a different cluster. Specify --force-reinstall to reinstall k3s and join this cluster", u.Hostname())
+}
+
+// streamPodLogs prints the logs of a pod, prefixing each line with the pod name
+func streamPodLogs(ctx context.Context, clientset KubernetesClient, namespace string, podName string, lines int64, follow bool) error {
+	logOptions := v1.PodLogOptions{
+		Follow: follow,
+	}
+	if lines > 0 {
+		logOptions.TailLines = ptr.To(lines)
+	}
+
+	podLogs, err := clientset.Client.CoreV1().Pods(namespace).GetLogs(podName, &logOptions).Stream(ctx)
+	if err != nil {
+		return fmt.Errorf("Unable to stream logs for pod %s: %w", podName, err)
+	}
+	defer podLogs.Close()
+
+	scanner := bufio.NewScanner(podLogs)
+	for scanner.Scan() {
+		fmt.Printf("%s: %s\n", podName, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("Unable to read logs for pod %s: %w", podName, err)
+	}
+
+	return nil
+}
+
+// streamServiceJournal streams the journal of a k3s service, over ssh when a remote host is specified
+func streamServiceJournal(ctx context.Context, input StreamServiceJournalInput) error {
+	args := []string{"--unit", input.Service, "--no-pager", "--lines", strconv.FormatInt(input.Lines, 10)}
+	if input.Follow {
+		args = append(args, "--follow")
+	}
+
+	if input.RemoteHost == "" {
+		journalCmd, err := common.CallExecCommandWithContext(ctx, common.ExecCommandInput{
+			Command:     "journalctl",
+			Args:        args,
+			StreamStdio: true,
+		})
+		if err != nil && ctx.Err() == nil {
+			return fmt.Errorf("Unable to call journalctl command: %w", err)
+		}
+		if journalCmd.ExitCode != 0 && ctx.Err() == nil {
+			return exitCodeError("journalctl command", journalCmd.ExitCode, journalCmd.Stdout, journalCmd.Stderr)
+		}
+		return nil
+	}
+
+	journalCmd, err := common.CallSshCommandWithContext(ctx, common.SshCommandInput{
+		Command:          "journalctl",
+		Args:             args,
+		AllowUknownHosts: input.AllowUknownHosts,
+		RemoteHost:       input.RemoteHost,
+		StreamStdio:      true,
+		Sudo:             true,
+	})
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("Unable to call journalctl command over ssh: %w", err)
+	}
+	if journalCmd.ExitCode != 0 && ctx.Err() == nil {
+		return exitCodeError("journalctl command over ssh", journalCmd.ExitCode, journalCmd.Stdout, journalCmd.Stderr)
+	}
+
+	return nil
+}
+
+// getNodeRemoteHost returns the remote host a node was joined from
+func getNodeRemoteHost(node v1.Node) string {
+	if val, ok := node.Annotations["dokku.com/remote-host"]; ok && val != "" {
+		return val
+	}
+
+	return common.PropertyGet("scheduler-k3s", "--global", fmt.Sprintf("%s%s.remote-host", NodePropertyPrefix, node.Name))
+}
+
+// applyRoleLabels sets the Dokku role labels for a role on a node and removes those of the other role, returning whether any label changed
+func applyRoleLabels(ctx context.Context, clientset KubernetesClient, node v1.Node, role string) (bool, error) {
+	roleLabels := getServerLabels()
+	conflictingLabels := WorkerLabels
+	if role == "worker" {
+		roleLabels = WorkerLabels
+		conflictingLabels = ServerLabels
+	}
+
+	changed := false
+	for key := range conflictingLabels {
+		if _, ok := node.Labels[key]; !ok {
+			continue
+		}
+
+		common.LogInfo2Quiet(fmt.Sprintf("Removing label %s from %s node %s", key, role, node.Name))
+		err := clientset.UnlabelNode(ctx, UnlabelNodeInput{
+			Name: node.Name,
+			Key:  key,
+		})
+		if err != nil {
+			return changed, fmt.Errorf("Unable to patch node %s: %w", node.Name, err)
+		}
+		changed = true
+	}
+
+	for key, value := range roleLabels {
+		if node.Labels[key] == value {
+			continue
+		}
+
+		common.LogInfo2Quiet(fmt.Sprintf("Labeling %s node %s with %s=%s", role, node.Name, key, value))
+		err := clientset.LabelNode(ctx, LabelNodeInput{
+			Name:  node.Name,
+			Key:   key,
+			Value: value,
+		})
+		if err != nil {
+			return changed, fmt.Errorf("Unable to patch node %s: %w", node.Name, err)
+		}
+		changed = true
+	}
+
+	return changed, nil
+}
+
+// getNodeAllowUknownHosts returns whether a node was added to the cluster without verifying its host key
+func getNodeAllowUknownHosts(node v1.Node) bool {
+	return common.PropertyGet("scheduler-k3s", "--global", fmt.Sprintf("%s%s.allow-unknown-hosts", NodePropertyPrefix, node.Name)) == "true"
+}
+
+// getNodeRole returns the role a node was joined to the cluster as
+func getNodeRole(node v1.Node) string {
+	role := common.PropertyGet("scheduler-k3s", "--global", fmt.Sprintf("%s%s.role", NodePropertyPrefix, node.Name))
+	if role != "" {
+		return role
+	}
+
+	return getNodeLabelRole(node)
+}
+
+// getNodeLabelRole returns the role of the k3s process running on a node, based only on the role labels k3s applies to it
+func getNodeLabelRole(node v1.Node) string {
+	for _, key := range []string{"node-role.kubernetes.io/control-plane", "node-role.kubernetes.io/master"} {
+		if node.Labels[key] == "true" {
+			return "server"
+		}
+	}
+
+	return "worker"
+}
+
+func getSpreadCheck(appName string) string {
+	return common.PropertyGet("scheduler-k3s", appName, "spread-check")
+}
+
+func getGlobalSpreadCheck() string {
+	return common.PropertyGetDefault("scheduler-k3s", "--global", "spread-check", "warn")
+}
+
+func getComputedSpreadCheck(appName string) string {
+	spreadCheck := getSpreadCheck(appName)
+	if spreadCheck == "" {
+		spreadCheck = getGlobalSpreadCheck()
+	}
+
+	return spreadCheck
+}
+
+func getRollbackOnFailure(appName string) string {
+	return common.PropertyGetDefault("scheduler-k3s", appName, "rollback-on-failure", "")
+}
+
+func getGlobalRollbackOnFailure() string {
+	return common.PropertyGetDefault("scheduler-k3s", "--global", "rollback-on-failure", "false")
+}
+
+func getComputedRollbackOnFailure(appName string) string {
+	rollbackOnFailure := getRollbackOnFailure(appName)
+	if rollbackOnFailure == "" {
+		rollbackOnFailure = getGlobalRollbackOnFailure()
+	}
+
+	return rollbackOnFailure
+}
+
+func getGlobalSerializeImagePulls() string {
+	return common.PropertyGetDefault("scheduler-k3s", "--global", "serialize-image-pulls", "")
+}
+
+// redactToken returns a token with all but its first four characters masked
+func redactToken(token string) string {
+	if len(token) <= 4 {
+		return strings.Repeat("*", len(token))
+	}
+
+	return token[:4] + strings.Repeat("*", len(token)-4)
+}
+
+// generateToken returns a random 32 byte hex-encoded token for joining nodes to the cluster
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("Unable to generate random token: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func getGlobalGlobalToken() string {
+	return common.PropertyGet("scheduler-k3s", "--global", "token")
+}
+
+func getProcessHealtchecks(healthchecks []appjson.Healthcheck, primaryPort int32) ProcessHealthchecks {
+	if len(healthchecks) == 0 {
+		return ProcessHealthchecks{}
+	}
+
+	livenessChecks := []ProcessHealthcheck{}
+	readinessChecks := []ProcessHealthcheck{}
+	startupChecks := []ProcessHealthcheck{}
+	uptimeSeconds := []int32{}
+	for _, healthcheck := range healthchecks {
+		probe := ProcessHealthcheck{
+			InitialDelaySeconds: healthcheck.InitialDelay,
+			PeriodSeconds:       healthcheck.Wait,
+			TimeoutSeconds:      healthcheck.Timeout,
+			FailureThreshold:    healthcheck.Attempts,
+			SuccessThreshold:    int32(1),
+		}
+		if len(healthcheck.Command) > 0 {
+			probe.Exec = &ExecHealthcheck{
+				Command: healthcheck.Command,
+			}
+		} else if healthcheck.Listening {
+			probe.TCPSocket = &TCPHealthcheck{
+				Port: primaryPort,
+			}
+			for _, header := range healthcheck.HTTPHeaders {
+				if header.Name == "Host" {
+					probe.TCPSocket.Host = header.Value
+				}
+			}
+		} else if healthcheck.Path != "" {
+			probe.HTTPGet = &HTTPHealthcheck{
+				Path:        healthcheck.Path,
+				Port:        primaryPort,
+				HTTPHeaders: []HTTPHeader{},
+			}
+
+			if healthcheck.Scheme != "" {
+				probe.HTTPGet.Scheme = URIScheme(strings.ToUpper(healthcheck.Scheme))
+			}
+
+			for _, header := range healthcheck.HTTPHeaders {
+				probe.HTTPGet.HTTPHeaders = append(probe.HTTPGet.HTTPHeaders, HTTPHeader{
+					Name:  header.Name,
+					Value: header.Value,
+				})
+			}
+		} else if healthcheck.Uptime > 0 {
+			uptimeSeconds = append(uptimeSeconds, healthcheck.Uptime)
+		}
+
+		if healthcheck.Type == appjson.HealthcheckType_Liveness {
+			livenessChecks = append(livenessChecks, probe)
+		} else if healthcheck.Type == appjson.HealthcheckType_Readiness {
+			readinessChecks = append(readinessChecks, probe)
+		} else if healthcheck.Type == appjson.HealthcheckType_Startup {
+			startupChecks = append(startupChecks, probe)
+		}
+	}
+	if len(livenessChecks) > 1 {
+		common.LogWarn("Multiple liveness checks are not supported, only the first one will be used")
+	}
+	if len(readinessChecks) > 1 {
+		common.LogWarn("Multiple readiness checks are not supported, only the first one will be used")
+	}
+	if len(startupChecks) > 1 {
+		common.LogWarn("Multiple startup checks are not supported, only the first one will be used")
+	}
+	if len(uptimeSeconds) > 1 {
+		common.LogWarn("Multiple uptime checks are not supported, only the first one will be used")
+	}
+
+	processHealthchecks := ProcessHealthchecks{}
+	if len(livenessChecks) > 0 {
+		processHealthchecks.Liveness = livenessChecks[0]
+	}
+	if len(readinessChecks) > 0 {
+		processHealthchecks.Readiness = readinessChecks[0]
+	}
+	if len(startupChecks) > 0 {
+		processHealthchecks.Startup = startupChecks[0]
+	}
+	if len(uptimeSeconds) > 0 {
+		processHealthchecks.MinReadySeconds = uptimeSeconds[0]
+	}
+
+	return processHealthchecks
+}
+
+// getPodResourceRequest returns the effective request of a resource for a pod
+func getPodResourceRequest(pod v1.Pod, name v1.ResourceName) resource.Quantity {
+	total := resource.Quantity{}
+	for _, container := range pod.Spec.Containers {
+		total.Add(container.Resources.Requests[name])
+	}
+
+	// init containers run sequentially, so only the largest request counts against the node
+	for _, container := range pod.Spec.InitContainers {
+		request := container.Resources.Requests[name]
+		if request.Cmp(total) > 0 {
+			total = request.DeepCopy()
+		}
+	}
+
+	return total
+}
+
+// getResourceProperty returns the app-level value of a default resource property
+func getResourceProperty(appName string, property string) string {
+	return common.PropertyGet("scheduler-k3s", appName, property)
+}
+
+// getGlobalResourceProperty returns the global value of a default resource property
+func getGlobalResourceProperty(property string) string {
+	return common.PropertyGet("scheduler-k3s", "--global", property)
+}
+
+// getComputedResourceProperty returns the app-level value of a default resource property, falling back to the global value
+func getComputedResourceProperty(appName string, property string) string {
+	value := getResourceProperty(appName, property)
+	if value == "" {
+		value = getGlobalResourceProperty(property)
+	}
+
+	return value
+}
+
+// getDefaultProcessResources returns the resource requests and limits used for processes without
+// values set via the resource plugin, taking the cpu and memory properties into account
+func getDefaultProcessResources(appName string) (ProcessResourcesMap, error) {
+	processResources := ProcessResourcesMap{
+		Limits: ProcessResources{},
+		Requests: ProcessResources{
+			CPU:    "100m",
+			Memory: "128Mi",
+		},
+	}
+
+	if value := getComputedResourceProperty(appName, "cpu-limit"); value != "" {
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return ProcessResourcesMap{}, fmt.Errorf("Error parsing cpu-limit property: %w", err)
+		}
+		if !quantity.IsZero() {
+			processResources.Limits.CPU = quantity.String()
+		}
+	}
+	if value := getComputedResourceProperty(appName, "cpu-request"); value != "" {
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return ProcessResourcesMap{}, fmt.Errorf("Error parsing cpu-request property: %w", err)
+		}
+		processResources.Requests.CPU = ""
+		if !quantity.IsZero() {
+			processResources.Requests.CPU = quantity.String()
+		}
+	}
+	if value := getComputedResourceProperty(appName, "memory-limit"); value != "" {
+		quantity, err := parseMemoryQuantity(value)
+		if err != nil {
+			return ProcessResourcesMap{}, fmt.Errorf("Error parsing memory-limit property: %w", err)
+		}
+		if quantity != "0" {
+			processResources.Limits.Memory = quantity
+		}
+	}
+	if value := getComputedResourceProperty(appName, "memory-request"); value != "" {
+		quantity, err := parseMemoryQuantity(value)
+		if err != nil {
+			return ProcessResourcesMap{}, fmt.Errorf("Error parsing memory-request property: %w", err)
+		}
+		processResources.Requests.Memory = ""
+		if quantity != "0" {
+			processResources.Requests.Memory = quantity
+		}
+	}
+
+	// kubernetes rejects a request above its limit, which a low limit would otherwise cause with the default requests
+	cpuRequestSet := getComputedResourceProperty(appName, "cpu-request") != ""
+	cpuRequest, err := clampRequestToLimit("cpu", processResources.Requests.CPU, processResources.Limits.CPU, cpuRequestSet)
+	if err != nil {
+		return ProcessResourcesMap{}, err
+	}
+	processResources.Requests.CPU = cpuRequest
+
+	memoryRequestSet := getComputedResourceProperty(appName, "memory-request") != ""
+	memoryRequest, err := clampRequestToLimit("memory", processResources.Requests.Memory, processResources.Limits.Memory, memoryRequestSet)
+	if err != nil {
+		return ProcessResourcesMap{}, err
+	}
+	processResources.Requests.Memory = memoryRequest
+
+	return processResources, nil
+}
+
+// clampRequestToLimit returns the request lowered to the limit when the limit is below a default request,
+// erroring instead when the request was set explicitly
+func clampRequestToLimit(resourceName string, request string, limit string, explicit bool) (string, error) {
+	if request == "" || limit == "" {
+		return request, nil
+	}
+
+	requestQuantity, err := resource.ParseQuantity(request)
+	if err != nil {
+		return "", fmt.Errorf("Error parsing %s request: %w", resourceName, err)
+	}
+	limitQuantity, err := resource.ParseQuantity(limit)
+	if err != nil {
+		return "", fmt.Errorf("Error parsing %s limit: %w", resourceName, err)
+	}
+
+	if limitQuantity.Cmp(requestQuantity) >= 0 {
+		return request, nil
+	}
+
+	if explicit {
+		return "", fmt.Errorf("Invalid %s-request value %s, it must not exceed the %s-limit value %s", resourceName, request, resourceName, limit)
+	}
+
+	return limit, nil
+}
+
+func getProcessResources(appName string, processType string) (ProcessResourcesMap, error) {
+	processResources, err := getDefaultProcessResources(appName)
+	if err != nil {
+		return ProcessResourcesMap{}, err
+	}
+
+	emptyValues := map[string]bool{
+		"":  true,
+		"0": true,
+	}
+
+	result, err := common.CallPlugnTrigger(common.PlugnTriggerInput{
+		Trigger: "resource-get-property",
+		Args:    []string{appName, processType, "limit", "cpu"},
+	})
+	if err == nil && !emptyValues[result.StdoutContents()] {
+		quantity, err := resource.ParseQuantity(result.StdoutContents())
+		if err != nil {
+			return ProcessResourcesMap{}, fmt.Errorf("Error parsing cpu limit: %w", err)
+		}
+		if quantity.MilliValue() != 0 {
+			processResources.Limits.CPU = quantity.String()
+		} else {
+			processResources.Limits.CPU = ""
+		}
+	}
+	response, err := common.CallPlugnTrigger(common.PlugnTriggerInput{
+		Trigger: "resource-get-property",
+		Args:    []string{appName, processType, "limit", "nvidia-gpu"},
+	})
+	nvidiaGpuLimit := response.StdoutContents()
+	if err == nil && nvidiaGpuLimit != "" && nvidiaGpuLimit != "0" {
+		_, err := resource.ParseQuantity(nvidiaGpuLimit)
+		if err != nil {
+			return ProcessResourcesMap{}, fmt.Errorf("Error parsing nvidia-gpu limit: %w", err)
+		}
+		processResources.Limits.NvidiaGPU = nvidiaGpuLimit
+	}
+	result, err = common.CallPlugnTrigger(common.PlugnTriggerInput{
+		Trigger: "resource-get-property",
+		Args:    []string{appName, processType, "limit", "memory"},
+	})
+	if err == nil && !emptyValues[result.StdoutContents()] {
+		quantity, err := parseMemoryQuantity(result.StdoutContents())
+		if err != nil {
+			return ProcessResourcesMap{}, fmt.Errorf("Error parsing memory limit: %w", err)
+		}
+		if quantity != "0Mi" {
+			processResources.Limits.Memory = quantity
+		} else {
+			processResources.Limits.Memory = ""
+		}
+	}
+
+	result, err = common.CallPlugnTrigger(common.PlugnTriggerInput{
+		Trigger: "resource-get-property",
+		Args:    []string{appName, processType, "reserve", "cpu"},
+	})
+	if err == nil && !emptyValues[result.StdoutContents()] {
+		quantity, err := resource.ParseQuantity(result.StdoutContents())
+		if err != nil {
+			return ProcessResourcesMap{}, fmt.Errorf("Error parsing cpu request: %w", err)
+		}
+		if quantity.MilliValue() != 0 {
+			processResources.Requests.CPU = quantity.String()
+		} else {
+			processResources.Requests.CPU = ""
+		}
+	}
+	result, err = common.CallPlugnTrigger(common.PlugnTriggerInput{
+		Trigger: "resource-get-property",
+		Args:    []string{appName, processType, "reserve", "memory"},
+	})
+	if err == nil && !emptyValues[result.StdoutContents()] {
+		quantity, err := parseMemoryQuantity(result.StdoutContents())
+		if err != nil {
+			return ProcessResourcesMap{}, fmt.Errorf("Error parsing memory request: %w", err)
+		}
+		if quantity != "0Mi" {
+			processResources.Requests.Memory = quantity
+		} else {
+			processResources.Requests.Memory = ""
+		}
+	}
+
+	return processResources, nil
+}
+
+func getServerIP() (string, error) {
+	networkInterface := getNetworkInterface()
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", fmt.Errorf("Unable to get network interfaces: %w", err)
+	}
+
+	var ipv4Addresses, ipv6Addresses []net.IP
+	for _, iface := range ifaces {
+		if iface.Name == networkInterface {
+			ipv4Addresses, ipv6Addresses, err = getInterfaceAddresses(iface)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	// prefer the configured ip family, falling back to the other family when none are found
+	candidates := [][]net.IP{ipv4Addresses, ipv6Addresses}
+	if getGlobalIPFamily() == "ipv6" {
+		candidates = [][]net.IP{ipv6Addresses, ipv4Addresses}
+	}
+
+	for _, addresses := range candidates {
+		if len(addresses) > 0 {
+			return addresses[len(addresses)-1].String(), nil
+		}
+	}
+
+	return "", fmt.Errorf("Unable to determine server ip address from network-interface %s", networkInterface)
+}
+
+func getStartCommand(input StartCommandInput) (StartCommandOutput, error) {
+	command := extractStartCommand(input)
+	fields, err := shell.Fields(command, func(name string) string {
+		if name == "PORT" {
+			return fmt.Sprint(input.Port)
+		}
+
+		return input.Env[name]
+	})
+	if err != nil {
+		return StartCommandOutput{}, err
+	}
+
+	return StartCommandOutput{
+		Command: fields,
+	}, nil
+}
+
+// isRetryableHelmError returns whether a helm error is likely to succeed on retry
+func isRetryableHelmError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var netErr net.Error
+	if isTransientKubernetesError(err) || errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	retryableMessages := []string{
+		"connection refused",
+		"failed calling webhook",
+		"i/o timeout",
+		"no endpoints available for service",
+		"timed out waiting for the condition",
+		"TLS handshake timeout",
+	}
+	for _, message := range retryableMessages {
+		if strings.Contains(err.Error(), message) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// retryHelmCall runs a helm call, retrying retryable errors with exponential backoff
+func retryHelmCall(ctx context.Context, description string, call func() error) error {
+	backoff := 5 * time.Second
+	for attempt := 1; ; attempt++ {
+		err := call()
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= HelmInstallAttempts || !isRetryableHelmError(err) {
+			return fmt.Errorf("Error %s after %d attempt(s): %w", description, attempt, err)
+		}
+
+		common.LogWarn(fmt.Sprintf("Error %s, retrying in %s: %s", description, backoff, err.Error()))
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("Cancelled %s after %d attempt(s): %w", description, attempt, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 60*time.Second)
+	}
+}
+
+func installHelmCharts(ctx context.Context, clientset KubernetesClient, shouldInstall func(HelmChart) bool) error {
+	helmCharts, err := getHelmCharts()
+	if err != nil {
+		return err
+	}
+
+	if err := addHelmRepositories(ctx); err != nil {
+		return err
+	}
+
+	for _, chart := range helmCharts {
+		if !shouldInstall(chart) {
+			continue
+		}
+
+		if err := installHelmChart(ctx, clientset, chart); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addHelmRepositories adds the helm repositories the built-in charts are installed from
+func addHelmRepositories(ctx context.Context) error {
+	for _, repo := range HelmRepositories {
+		helmAgent, err := NewHelmAgent("default", DeployLogPrinter)
+		if err != nil {
+			return fmt.Errorf("Error creating helm agent: %w", err)
+		}
+
+		err = retryHelmCall(ctx, fmt.Sprintf("adding helm repository %s", repo.Name), func() error {
+			return helmAgent.AddRepository(ctx, AddRepositoryInput(repo))
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getHelmChartValues returns the values for a helm chart, merging the global properties into the embedded values file
+func getHelmChartValues(ctx context.Context, clientset KubernetesClient, chart HelmChart) (map[string]interface{}, error) {
+	contents, err := templates.ReadFile(fmt.Sprintf("templates/helm-config/%s.yaml", chart.ReleaseName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("Error reading values file %s: %w", chart.ReleaseName, err)
+	}
+
+	var values map[string]interface{}
+	if len(contents) > 0 {
+		err = yaml.Unmarshal(contents, &values)
+		if err != nil {
+			return nil, fmt.Errorf("Error unmarshalling values file: %w", err)
+		}
+	}
+
+	if chart.ReleaseName == "longhorn" {
+		values, err = getLonghornValues(ctx, clientset, values)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
+
+// installHelmChart installs or upgrades a helm chart with its values, creating the chart namespace if requested
+func installHelmChart(ctx context.Context, clientset KubernetesClient, chart HelmChart) error {
+	if chart.CreateNamespace {
+		namespace := corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: chart.Namespace,
+				Annotations: map[string]string{
+					"dokku.com/managed": "true",
+				},
+				Labels: map[string]string{
+					"dokku.com/managed": "true",
+				},
+			},
+		}
+		_, err := clientset.CreateNamespace(ctx, CreateNamespaceInput{
+			Name: namespace,
+		})
+		if err != nil {
+			return fmt.Errorf("Error creating namespace %s: %w", chart.Namespace, err)
+		}
+	}
+
+	values, err := getHelmChartValues(ctx, clientset, chart)
+	if err != nil {
+		return err
+	}
+
+	helmAgent, err := NewHelmAgent(chart.Namespace, DeployLogPrinter)
+	if err != nil {
+		return fmt.Errorf("Error creating helm agent: %w", err)
+	}
+
+	timeoutDuration, err := time.ParseDuration("300s")
+	if err != nil {
+		return fmt.Errorf("Error parsing deploy timeout duration: %w", err)
+	}
+
+	return retryHelmCall(ctx, fmt.Sprintf("installing chart %s", chart.ChartPath), func() error {
+		return helmAgent.InstallOrUpgradeChart(ctx, ChartInput{
+			ChartPath:   chart.ChartPath,
+			Namespace:   chart.Namespace,
+			ReleaseName: chart.ReleaseName,
+			RepoURL:     chart.RepoURL,
+			Values:      values,
+			Version:     chart.Version,
+			Timeout:     timeoutDuration,
+			Wait:        true,
+		})
+	})
+}
+
+// helmValuesEqual returns whether two sets of helm values are equivalent once serialized, ignoring numeric type differences
+func helmValuesEqual(a map[string]interface{}, b map[string]interface{}) (bool, error) {
+	if len(a) == 0 && len(b) == 0 {
+		return true, nil
+	}
+
+	aJSON, err := json.Marshal(a)
+	if err != nil {
+		return false, fmt.Errorf("Unable to marshal helm values: %w", err)
+	}
+
+	bJSON, err := json.Marshal(b)
+	if err != nil {
+		return false, fmt.Errorf("Unable to marshal helm values: %w", err)
+	}
+
+	return bytes.Equal(aJSON, bJSON), nil
+}
+
+// getK3sUpgradePlans returns the system-upgrade-controller plans that upgrade server and worker nodes to a k3s version in the given order
+func getK3sUpgradePlans(version string, concurrency int, drain bool, order string) []ApplyUpgradePlanInput {
+	serverSpec := map[string]interface{}{
+		// servers are always upgraded one at a time to preserve etcd quorum
+		"concurrency": int64(1),
+		"cordon":      true,
+		"nodeSelector": map[string]interface{}{
+			"matchExpressions": []interface{}{
+				map[string]interface{}{
+					"key":      "node-role.kubernetes.io/control-plane",
+					"operator": "In",
+					"values":   []interface{}{"true"},
+				},
+			},
+		},
+		"serviceAccountName": "system-upgrade",
+		"upgrade": map[string]interface{}{
+			"image": K3sUpgradeImage,
+		},
+		"version": version,
+	}
+
+	workerSpec := map[string]interface{}{
+		"concurrency": int64(concurrency),
+		"cordon":      true,
+		"nodeSelector": map[string]interface{}{
+			"matchExpressions": []interface{}{
+				map[string]interface{}{
+					"key":      "node-role.kubernetes.io/control-plane",
+					"operator": "DoesNotExist",
+				},
+			},
+		},
+		"serviceAccountName": "system-upgrade",
+		"upgrade": map[string]interface{}{
+			"image": K3sUpgradeImage,
+		},
+		"version": version,
+	}
+	if drain {
+		workerSpec["drain"] = map[string]interface{}{
+			"force":                    true,
+			"skipWaitForDeleteTimeout": int64(60),
+		}
+	}
+
+	// the prepare step blocks the second set of nodes from upgrading until the first plan has completed
+	if order == "servers-first" {
+		workerSpec["prepare"] = map[string]interface{}{
+			"args":  []interface{}{"prepare", "k3s-server"},
+			"image": K3sUpgradeImage,
+		}
+	} else {
+		serverSpec["prepare"] = map[string]interface{}{
+			"args":  []interface{}{"prepare", "k3s-worker"},
+			"image": K3sUpgradeImage,
+		}
+	}
+
+	return []ApplyUpgradePlanInput{
+		{
+			Name:      "k3s-server",
+			Namespace: SystemUpgradeNamespace,
+			Spec:      serverSpec,
+		},
+		{
+			Name:      "k3s-worker",
+			Namespace: SystemUpgradeNamespace,
+			Spec:      workerSpec,
+		},
+	}
+}
+
+// getLonghornValues merges the longhorn replica count and default storage class settings into the longhorn chart values
+func getLonghornValues(ctx context.Context, clientset KubernetesClient, values map[string]interface{}) (map[string]interface{}, error) {
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+
+	replicaCount := 0
+	if value := getGlobalLonghornReplicaCount(); value != "" {
+		count, err := strconv.Atoi(value)
+		if err != nil || count < 1 {
+			return values, fmt.Errorf("Invalid longhorn-replica-count value, expected a positive integer: %s", value)
+		}
+		replicaCount = count
+	} else {
+		nodes, err := clientset.ListNodes(ctx, ListNodesInput{})
+		if err != nil {
+			return values, fmt.Errorf("Unable to list nodes: %w", err)
+		}
+
+		// a cluster initialized with --expect-nodes keeps the replica count it will eventually be able to schedule
+		expectedNodes := len(nodes)
+		if config, ok, err := readInitConfig(); err == nil && ok && config.ExpectNodes > expectedNodes {
+			expectedNodes = config.ExpectNodes
+		}
+
+		// the chart default of 3 replicas cannot be scheduled on fewer than 3 nodes
+		if expectedNodes == 1 {
+			common.LogVerboseQuiet("Single node cluster detected, defaulting longhorn replica count to 1")
+			replicaCount = 1
+		} else if expectedNodes < 3 {
+			common.LogVerboseQuiet(fmt.Sprintf("Cluster of %d nodes expected, defaulting longhorn replica count to %d", expectedNodes, expectedNodes))
+			replicaCount = expectedNodes
+		}
+	}
+
+	persistence, ok := values["persistence"].(map[string]interface{})
+	if !ok {
+		persistence = map[string]interface{}{}
+	}
+	persistence["defaultClass"] = getGlobalLonghornDefault()
+
+	if replicaCount > 0 {
+		persistence["defaultClassReplicaCount"] = replicaCount
+
+		defaultSettings, ok := values["defaultSettings"].(map[string]interface{})
+		if !ok {
+			defaultSettings = map[string]interface{}{}
+		}
+		defaultSettings["defaultReplicaCount"] = replicaCount
+		values["defaultSettings"] = defaultSettings
+	}
+	values["persistence"] = persistence
+
+	return values, nil
+}
+
+func installHelperCommands(ctx context.Context) error {
+	urls := map[string]string{
+		"kubectx": "https://github.com/ahmetb/kubectx/releases/latest/download/kubectx",
+		"kubens":  "https://github.com/ahmetb/kubectx/releases/latest/download/kubens",
+	}
+
+	client := newRestyClient()
+	for binaryName, url := range urls {
+		resp, err := client.R().
+			SetContext(ctx).
+			Get(url)
+		if err != nil {
+			return fmt.Errorf("Unable to download %s: %w", binaryName, err)
+		}
+		if resp == nil {
+			return fmt.Errorf("Missing response from %s download: %w", binaryName, err)
+		}
+
+		if resp.StatusCode() != 200 {
+			return fmt.Errorf("Invalid status code for %s: %d", binaryName, resp.StatusCode())
+		}
+
+		f, err := os.Create(filepath.Join("/usr/local/bin", binaryName))
+		if err != nil {
+			return fmt.Errorf("Unable to create %s: %w", binaryName, err)
+		}
+
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("Unable to close %s file: %w", binaryName, err)
+		}
+
+		err = common.WriteStringToFile(common.WriteStringToFileInput{
+			Content:   resp.String(),
+			Filename:  f.Name(),
+			GroupName: "root",
+			Mode:      os.FileMode(0755),
+			Username:  "root",
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to write %s to file: %w", binaryName, err)
+		}
+
+		fi, err := os.Stat(f.Name())
+		if err != nil {
+			return fmt.Errorf("Unable to get %s file size: %w", binaryName, err)
+		}
+
+		if fi.Size() == 0 {
+			return fmt.Errorf("Invalid %s filesize", binaryName)
+		}
+	}
+
+	return installHelm(ctx)
+}
+
+func installHelm(ctx context.Context) error {
+	client := newRestyClient()
+	resp, err := client.R().
+		SetContext(ctx).
+		Get("https://raw.githubusercontent.com/helm/helm/main/scripts/get-helm-3")
+	if err != nil {
+		return fmt.Errorf("Unable to download helm installer: %w", err)
+	}
+	if resp == nil {
+		return fmt.Errorf("Missing response from helm installer download: %w", err)
+	}
+
+	if resp.StatusCode() != 200 {
+		return fmt.Errorf("Invalid status code for helm installer script: %d", resp.StatusCode())
+	}
+
+	f, err := os.CreateTemp("", "sample")
+	if err != nil {
+		return fmt.Errorf("Unable to create temporary file for helm installer: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Unable to close helm installer file: %w", err)
+	}
+
+	err = common.WriteStringToFile(common.WriteStringToFileInput{
+		Content:  resp.String(),
+		Filename: f.Name(),
+		Mode:     os.FileMode(0755),
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to write helm installer to file: %w", err)
+	}
+
+	fi, err := os.Stat(f.Name())
+	if err != nil {
+		return fmt.Errorf("Unable to get helm installer file size: %w", err)
+	}
+
+	if fi.Size() == 0 {
+		return fmt.Errorf("Invalid helm installer filesize")
+	}
+
+	common.LogInfo2Quiet("Running helm installer")
+	installerCmd, err := common.CallExecCommand(common.ExecCommandInput{
+		Command:     f.Name(),
+		Env:         getProxyEnv(),
+		StreamStdio: shouldStreamStdio(),
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to call helm installer command: %w", err)
+	}
+	if installerCmd.ExitCode != 0 {
+		return exitCodeError("helm installer command", installerCmd.ExitCode, installerCmd.Stdout, installerCmd.Stderr)
+	}
+
+	return nil
+}
+
+// getAppRuntimeStatus returns the pod status counts and deployed revision for an app
+// zero values are returned when the app has no scheduled workload
+func getAppRuntimeStatus(clientset KubernetesClient, appName string) AppRuntimeStatus {
+	status := AppRuntimeStatus{}
+	namespace := getComputedNamespace(appName)
+	pods, err := clientset.ListPods(context.Background(), ListPodsInput{
+		Namespace:     namespace,
+		LabelSelector: fmt.Sprintf("app.kubernetes.io/part-of=%s", appName),
+	})
+	if err == nil {
+		for _, pod := range pods {
+			switch pod.Status.Phase {
+			case v1.PodRunning:
+				status.RunningPods++
+			case v1.PodPending:
+				status.PendingPods++
+			case v1.PodFailed:
+				status.FailedPods++
+			}
+		}
+	}
+
+	helmAgent, err := NewHelmAgent(namespace, DevNullPrinter)
+	if err != nil {
+		return status
+	}
+
+	info, err := helmAgent.GetReleaseInfo(appName)
+	if err == nil && info.Installed {
+		status.Revision = strconv.Itoa(info.Revision)
+	}
+
+	return status
+}
+
+// isKubernetesAvailable returns an error if kubernetes api is not available
+func isKubernetesAvailable() error {
+	client, err := NewKubernetesClient()
+	if err != nil {
+		return fmt.Errorf("Error creating kubernetes client: %w", err)
+	}
+
+	if err := client.Ping(); err != nil {
+		return fmt.Errorf("Error pinging kubernetes: %w", err)
+	}
+
+	return nil
+}
+
+// isK3sInstalled returns an error if k3s is not installed
+func isK3sInstalled() error {
+	if !common.FileExists("/usr/local/bin/k3s") {
+		return newKindError(ErrK3sNotInstalled, nil, "k3s binary is not available")
+	}
+
+	if !common.FileExists(getKubeconfigPath()) {
+		return newKindError(ErrK3sNotInstalled, nil, "k3s kubeconfig is not available")
+	}
+
+	return nil
+}
+
+// isLocalNode returns whether a node is running on the current server
+func isLocalNode(node v1.Node) (bool, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false, fmt.Errorf("Unable to get interface addresses: %w", err)
+	}
+
+	localIPs := map[string]bool{}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			localIPs[ipNet.IP.String()] = true
+		}
+	}
+
+	for _, address := range node.Status.Addresses {
+		if address.Type != v1.NodeInternalIP && address.Type != v1.NodeExternalIP {
+			continue
+		}
+
+		if ip := net.ParseIP(address.Address); ip != nil && localIPs[ip.String()] {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// isK3sKubernetes returns true if the current kubernetes cluster is configured to be k3s
+func isK3sKubernetes() bool {
+	return getKubeconfigPath() == KubeConfigPath
+}
+
+func isPodReady(ctx context.Context, clientset KubernetesClient, podName, namespace string) wait.ConditionWithContextFunc {
+	return func(ctx context.Context) (bool, error) {
+		fmt.Printf(".")
+
+		pod, err := clientset.GetPod(ctx, GetPodInput{
+			Name:      podName,
+			Namespace: namespace,
+		})
+		if err != nil {
+			return false, err
+		}
+
+		switch pod.Status.Phase {
+		case v1.PodRunning:
+			return true, nil
+		case v1.PodFailed, v1.PodSucceeded:
+			return false, conditions.ErrPodCompleted
+		}
+		return false, nil
+	}
+}
+
+// getNodeRoles returns the sorted roles of a node, falling back to the role recorded when the node was added
+func getNodeRoles(node v1.Node) []string {
+	roles := []string{}
+	if role := node.Labels["kubernetes.io/role"]; role != "" {
+		roles = append(roles, role)
+	}
+
+	for key, value := range node.Labels {
+		if !strings.HasPrefix(key, "node-role.kubernetes.io/") || value == "false" {
+			continue
+		}
+
+		role := strings.TrimPrefix(key, "node-role.kubernetes.io/")
+		if role != "" && !slices.Contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+
+	if len(roles) == 0 {
+		switch common.PropertyGet("scheduler-k3s", "--global", fmt.Sprintf("%s%s.role", NodePropertyPrefix, node.Name)) {
+		case "server":
+			roles = append(roles, "control-plane")
+		case "worker":
+			roles = append(roles, "worker")
+		default:
+			roles = append(roles, "unknown")
+		}
+	}
+
+	sort.Strings(roles)
+	return roles
+}
+
+// kubernetesNodeToNode converts a kubernetes node to a Node
+func kubernetesNodeToNode(node v1.Node) Node {
+	roles := getNodeRoles(node)
+
+	ready := false
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == "Ready" {
+			ready = condition.Status == "True"
+			break
+		}
+	}
+
+	remoteHost := ""
+	if val, ok := node.Annotations["dokku.com/remote-host"]; ok {
+		remoteHost = val
+	}
+
+	internalIP := ""
+	externalIP := ""
+	for _, address := range node.Status.Addresses {
+		if address.Type == v1.NodeInternalIP && internalIP == "" {
+			internalIP = address.Address
+		}
+		if address.Type == v1.NodeExternalIP && externalIP == "" {
+			externalIP = address.Address
+		}
+	}
+
+	joinIssues := getNodeJoinIssues(node)
+	joinStatus := "complete"
+	if len(joinIssues) > 0 {
+		joinStatus = "incomplete"
+	}
+
+	return Node{
+		Name:         node.Name,
+		Age:          duration.HumanDuration(time.Since(node.CreationTimestamp.Time)),
+		JoinStatus:   joinStatus,
+		JoinIssues:   joinIssues,
+		Roles:        roles,
+		Ready:        ready,
+		RemoteHost:   remoteHost,
+		Version:      node.Status.NodeInfo.KubeletVersion,
+		InternalIP:   internalIP,
+		ExternalIP:   externalIP,
+		Schedulable:  !node.Spec.Unschedulable,
+		Architecture: node.Status.NodeInfo.Architecture,
+		Pool:         node.Labels[NodePoolLabel],
+		JoinedAt:     node.Annotations[NodeJoinedAtAnnotation],
+		ManagedBy:    node.Annotations[NodeManagedByAnnotation],
+
+		CPUCapacity:       formatCPUQuantity(node.Status.Capacity[v1.ResourceCPU]),
+		CPUAllocatable:    formatCPUQuantity(node.Status.Allocatable[v1.ResourceCPU]),
+		MemoryCapacity:    formatMemoryQuantity(node.Status.Capacity[v1.ResourceMemory]),
+		MemoryAllocatable: formatMemoryQuantity(node.Status.Allocatable[v1.ResourceMemory]),
+	}
+}
+
+// formatCPUQuantity formats a cpu quantity as a number of cores, regardless of whether it was reported in cores or millicores
+func formatCPUQuantity(quantity resource.Quantity) string {
+	return strconv.FormatFloat(float64(quantity.MilliValue())/1000, 'f', -1, 64)
+}
+
+// formatMemoryQuantity formats a memory quantity in gibibytes, regardless of the suffix it was reported with
+func formatMemoryQuantity(quantity resource.Quantity) string {
+	return fmt.Sprintf("%.1fGi", float64(quantity.Value())/(1024*1024*1024))
+}
+
+// sortNodes sorts nodes by name, and then stably by the specified field so nodes with equal values stay ordered by name
+func sortNodes(nodes []Node, sortBy string) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].Name < nodes[j].Name
+	})
+
+	switch sortBy {
+	case "ready":
+		sort.SliceStable(nodes, func(i, j int) bool {
+			return nodes[i].Ready && !nodes[j].Ready
+		})
+	case "role":
+		sort.SliceStable(nodes, func(i, j int) bool {
+			return strings.Join(nodes[i].Roles, ",") < strings.Join(nodes[j].Roles, ",")
+		})
+	case "version":
+		sort.SliceStable(nodes, func(i, j int) bool {
+			result, err := compareK3sVersions(nodes[i].Version, nodes[j].Version)
+			if err != nil {
+				return nodes[i].Version < nodes[j].Version
+			}
+			return result < 0
+		})
+	}
+}
+
+// getJoinServerIP returns the ip address of a ready server node for new nodes to join the cluster through
+// the local host is preferred when it is a ready server, otherwise another ready server is used
+func getJoinServerIP(ctx context.Context, clientset KubernetesClient) (string, error) {
+	nodes, err := clientset.ListNodes(ctx, ListNodesInput{})
+	if err != nil {
+		return "", fmt.Errorf("Unable to list nodes: %w", err)
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Name < nodes[j].Name
+	})
+
+	localRole := ""
+	readyServers := []v1.Node{}
+	for _, node := range nodes {
+		isLocal, err := isLocalNode(node)
+		if err != nil {
+			return "", err
+		}
+
+		role := getNodeRole(node)
+		ready := kubernetesNodeToNode(node).Ready
+		if isLocal {
+			localRole = role
+			if role == "server" && ready {
+				serverIP, err := getServerIP()
+				if err != nil {
+					return "", fmt.Errorf("Unable to get server ip address: %w", err)
+				}
+
+				if err := validateRoutableServerIP(serverIP); err != nil {
+					return "", err
+				}
+
+				common.LogVerboseQuiet(fmt.Sprintf("Using server ip address from %s interface: %s", getNetworkInterface(), serverIP))
+				return serverIP, nil
+			}
+		}
+
+		if role == "server" && ready {
+			readyServers = append(readyServers, node)
+		}
+	}
+
+	switch localRole {
+	case "":
+		common.LogVerboseQuiet("This host is not a node in the cluster, selecting a ready server node to join through")
+	case "server":
+		common.LogWarn("This host is a server node but is not ready, selecting another ready server node to join through")
+	default:
+		common.LogVerboseQuiet(fmt.Sprintf("This host is a %s node and cannot be joined through, selecting a ready server node instead", localRole))
+	}
+
+	for _, node := range readyServers {
+		for _, address := range node.Status.Addresses {
+			if address.Type == v1.NodeInternalIP {
+				common.LogVerboseQuiet(fmt.Sprintf("Using server ip address of server node %s: %s", node.Name, address.Address))
+				return address.Address, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("Unable to find a ready server node to join through, this host is not a ready server node. Run cluster-add from a ready server node, or specify the server to join through via --join-server or --server-ip")
+}
+
+// getServerK3sVersion returns the oldest k3s version reported by the ready server nodes in the cluster
+func getServerK3sVersion(ctx context.Context, clientset KubernetesClient) (string, error) {
+	nodes, err := clientset.ListNodes(ctx, ListNodesInput{})
+	if err != nil {
+		return "", fmt.Errorf("Unable to list nodes: %w", err)
+	}
+
+	version := ""
+	for _, node := range nodes {
+		if getNodeRole(node) != "server" || !kubernetesNodeToNode(node).Ready {
+			continue
+		}
+
+		nodeVersion := node.Status.NodeInfo.KubeletVersion
+		if version == "" {
+			version = nodeVersion
+			continue
+		}
+
+		result, err := compareK3sVersions(nodeVersion, version)
+		if err != nil {
+			return "", err
+		}
+		if result < 0 {
+			version = nodeVersion
+		}
+	}
+
+	if version == "" {
+		return "", fmt.Errorf("Unable to find a ready server node to read the cluster k3s version from")
+	}
+
+	return version, nil
+}
+
+// getRemainingReadyServers returns the ready server nodes left after removing a server node, erroring if etcd quorum would be lost
+func getRemainingReadyServers(ctx context.Context, clientset KubernetesClient, nodeName string) ([]v1.Node, error) {
+	nodes, err := clientset.ListNodes(ctx, ListNodesInput{})
+	if err != nil {
+		return []v1.Node{}, fmt.Errorf("Unable to list nodes: %w", err)
+	}
+
+	servers := 0
+	readyServers := []v1.Node{}
+	for _, n := range nodes {
+		if getNodeRole(n) != "server" {
+			continue
+		}
+
+		servers++
+		if n.Name != nodeName && kubernetesNodeToNode(n).Ready {
+			readyServers = append(readyServers, n)
+		}
+	}
+
+	if len(readyServers) == 0 {
+		return []v1.Node{}, fmt.Errorf("Unable to remove %s, at least one other ready server node is required to preserve the cluster", nodeName)
+	}
+
+	quorum := (servers-1)/2 + 1
+	if len(readyServers) < quorum {
+		return []v1.Node{}, fmt.Errorf("Unable to remove %s, the remaining %d ready server nodes are below the etcd quorum of %d", nodeName, len(readyServers), quorum)
+	}
+
+	return readyServers, nil
+}
+
+// getNodesForRemoval resolves node names and a label selector into the nodes to remove, ordered so the local node is removed last
+func getNodesForRemoval(ctx context.Context, clientset KubernetesClient, nodeNames []string, selector string) ([]v1.Node, error) {
+	nodes, err := clientset.ListNodes(ctx, ListNodesInput{})
+	if err != nil {
+		return []v1.Node{}, fmt.Errorf("Unable to list nodes: %w", err)
+	}
+
+	nodesByName := map[string]v1.Node{}
+	for _, node := range nodes {
+		nodesByName[node.Name] = node
+	}
+
+	toRemove := []v1.Node{}
+	seen := map[string]bool{}
+	for _, nodeName := range nodeNames {
+		node, ok := nodesByName[nodeName]
+		if !ok {
+			return []v1.Node{}, newKindError(ErrNodeNotFound, nil, fmt.Sprintf("Node %s not found in the cluster", nodeName))
+		}
+		if seen[nodeName] {
+			continue
+		}
+
+		seen[nodeName] = true
+		toRemove = append(toRemove, node)
+	}
+
+	if selector != "" {
+		selected, err := clientset.ListNodes(ctx, ListNodesInput{
+			LabelSelector: selector,
+		})
+		if err != nil {
+			return []v1.Node{}, fmt.Errorf("Unable to list nodes matching selector: %w", err)
+		}
+		if len(selected) == 0 {
+			return []v1.Node{}, fmt.Errorf("No nodes match selector %s", selector)
+		}
+
+		for _, node := range selected {
+			if seen[node.Name] {
+				continue
+			}
+
+			seen[node.Name] = true
+			toRemove = append(toRemove, node)
+		}
+	}
+
+	// workers go first, then remote servers, and the local node last as its removal tears down the local api server
+	rank := map[string]int{}
+	for _, node := range toRemove {
+		isLocal, err := isLocalNode(node)
+		if err != nil {
+			return []v1.Node{}, fmt.Errorf("Unable to check if node is the local node: %w", err)
+		}
+
+		switch {
+		case isLocal:
+			rank[node.Name] = 2
+		case getNodeRole(node) == "server":
+			rank[node.Name] = 1
+		default:
+			rank[node.Name] = 0
+		}
+	}
+	slices.SortStableFunc(toRemove, func(a, b v1.Node) int {
+		return rank[a.Name] - rank[b.Name]
+	})
+
+	return toRemove, nil
+}
+
+// getNodeRemovalSummary lists the pods running on a node and the longhorn volumes whose data only exists on it
+func getNodeRemovalSummary(ctx context.Context, clientset KubernetesClient, nodeName string) (NodeRemovalSummary, error) {
+	summary := NodeRemovalSummary{
+		Node:      nodeName,
+		Volumes:   []NodeVolume{},
+		Workloads: []NodeWorkload{},
+	}
+
+	pods, err := clientset.ListPods(ctx, ListPodsInput{
+		FieldSelector: fmt.Sprintf("spec.nodeName=%s", nodeName),
+	})
+	if err != nil {
+		return summary, fmt.Errorf("Unable to list pods on node %s: %w", nodeName, err)
+	}
+
+	budgets := map[string][]policyv1.PodDisruptionBudget{}
+	for _, pod := range pods {
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		if _, ok := pod.Annotations[v1.MirrorPodAnnotationKey]; ok {
+			continue
+		}
+
+		owner := ""
+		isDaemonSetPod := false
+		for _, reference := range pod.OwnerReferences {
+			if reference.Kind == "DaemonSet" {
+				isDaemonSetPod = true
+			}
+			if reference.Controller != nil && *reference.Controller {
+				owner = fmt.Sprintf("%s/%s", reference.Kind, reference.Name)
+			}
+		}
+		if isDaemonSetPod {
+			continue
+		}
+
+		workload := NodeWorkload{
+			Namespace:              pod.Namespace,
+			Pod:                    pod.Name,
+			Owner:                  owner,
+			PersistentVolumeClaims: []string{},
+			Risks:                  []string{},
+		}
+
+		if _, ok := budgets[pod.Namespace]; !ok {
+			namespaceBudgets, err := clientset.ListPodDisruptionBudgets(ctx, ListPodDisruptionBudgetsInput{
+				Namespace: pod.Namespace,
+			})
+			if err != nil {
+				return summary, fmt.Errorf("Unable to list pod disruption budgets in namespace %s: %w", pod.Namespace, err)
+			}
+			budgets[pod.Namespace] = namespaceBudgets
+		}
+		for _, budget := range budgets[pod.Namespace] {
+			selector, err := metav1.LabelSelectorAsSelector(budget.Spec.Selector)
+			if err != nil {
+				continue
+			}
+			if selector.Matches(k8slabels.Set(pod.Labels)) {
+				workload.PodDisruptionBudget = budget.Name
+				break
+			}
+		}
+
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim == nil {
+				continue
+			}
+
+			workload.PersistentVolumeClaims = append(workload.PersistentVolumeClaims, volume.PersistentVolumeClaim.ClaimName)
+		}
+
+		if len(workload.PersistentVolumeClaims) > 0 && workload.PodDisruptionBudget == "" {
+			workload.Risks = append(workload.Risks, "stateful pod without a pod disruption budget")
+		}
+		if workload.Risky() {
+			summary.Risky = true
+		}
+
+		summary.Workloads = append(summary.Workloads, workload)
+	}
+
+	volumes, err := getNodeOnlyLonghornVolumes(ctx, clientset, nodeName)
+	if err != nil {
+		return summary, err
+	}
+	if len(volumes) > 0 {
+		summary.Risky = true
+		summary.Volumes = volumes
+	}
+
+	return summary, nil
+}
+
+// getNodeOnlyLonghornVolumes returns the longhorn volumes that have a replica on a node and no healthy replica on any other node
+func getNodeOnlyLonghornVolumes(ctx context.Context, clientset KubernetesClient, nodeName string) ([]NodeVolume, error) {
+	volumes := []NodeVolume{}
+	replicas, err := clientset.ListLonghornReplicas(ctx, ListLonghornReplicasInput{
+		Namespace: "longhorn-system",
+	})
+	if err != nil {
+		return volumes, fmt.Errorf("Unable to list longhorn replicas: %w", err)
+	}
+
+	onNode := []string{}
+	healthyReplicas := map[string]int{}
+	healthyElsewhere := map[string]int{}
+	for _, replica := range replicas {
+		if replica.NodeID == nodeName && !slices.Contains(onNode, replica.VolumeName) {
+			onNode = append(onNode, replica.VolumeName)
+		}
+		if !replica.Healthy {
+			continue
+		}
+
+		healthyReplicas[replica.VolumeName]++
+		if replica.NodeID != nodeName {
+			healthyElsewhere[replica.VolumeName]++
+		}
+	}
+	sort.Strings(onNode)
+
+	namespacePods := map[string][]v1.Pod{}
+	for _, volumeName := range onNode {
+		if healthyElsewhere[volumeName] > 0 {
+			continue
+		}
+
+		nodeVolume := NodeVolume{
+			Volume:          volumeName,
+			HealthyReplicas: healthyReplicas[volumeName],
+			Pods:            []string{},
+		}
+
+		// longhorn names the persistent volume it provisions after the longhorn volume
+		volume, err := clientset.GetPersistentVolume(ctx, GetPersistentVolumeInput{
+			Name: volumeName,
+		})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return volumes, fmt.Errorf("Unable to get persistent volume %s: %w", volumeName, err)
+		}
+
+		if err == nil && volume.Spec.ClaimRef != nil {
+			claim := volume.Spec.ClaimRef
+			nodeVolume.PersistentVolumeClaim = fmt.Sprintf("%s/%s", claim.Namespace, claim.Name)
+
+			if _, ok := namespacePods[claim.Namespace]; !ok {
+				pods, err := clientset.ListPods(ctx, ListPodsInput{
+					Namespace: claim.Namespace,
+				})
+				if err != nil {
+					return volumes, fmt.Errorf("Unable to list pods in namespace %s: %w", claim.Namespace, err)
 				}
-				return fmt.Sprintf("proxy_set_header X-Forwarded-Proto %s;", value)
-			},
-		},
-		"x-forwarded-ssl": {
-			getter: nginxvhosts.ComputedXForwardedSSL,
-			locationSnippet: func(value string) string {
-				if value == "" {
-					return ""
+				namespacePods[claim.Namespace] = pods
+			}
+
+			for _, pod := range namespacePods[claim.Namespace] {
+				for _, podVolume := range pod.Spec.Volumes {
+					if podVolume.PersistentVolumeClaim != nil && podVolume.PersistentVolumeClaim.ClaimName == claim.Name {
+						nodeVolume.Pods = append(nodeVolume.Pods, pod.Name)
+						break
+					}
 				}
-				return fmt.Sprintf("proxy_set_header X-Forwarded-SSL %s;", value)
-			},
-		},
+			}
+		}
+
+		volumes = append(volumes, nodeVolume)
 	}
 
-	annotations := map[string]string{}
-	for _, newKey := range properties {
-		if newKey.locationSnippet != nil {
-			locationLines = append(locationLines, newKey.locationSnippet(newKey.getter(appName)))
-		} else if newKey.serverSnippet != nil {
-			serverLines = append(serverLines, newKey.serverSnippet(newKey.getter(appName)))
-		} else if newKey.annotation != "" {
-			annotations[newKey.annotation] = newKey.getter(appName)
+	return volumes, nil
+}
+
+// removeClusterNode drains, uninstalls k3s from, and deletes a single node from the cluster
+func removeClusterNode(ctx context.Context, input RemoveClusterNodeInput) error {
+	clientset := input.Clientset
+	nodeName := input.NodeName
+
+	common.LogVerboseQuiet("Getting node remote connection information")
+	nodes, err := clientset.ListNodes(ctx, ListNodesInput{})
+	if err != nil {
+		return fmt.Errorf("Unable to list nodes: %w", err)
+	}
+
+	index := slices.IndexFunc(nodes, func(n v1.Node) bool {
+		return n.Name == nodeName
+	})
+	if index == -1 {
+		return newKindError(ErrNodeNotFound, nil, fmt.Sprintf("Node %s not found in the cluster", nodeName))
+	}
+	kubeNode := nodes[index]
+	node := kubernetesNodeToNode(kubeNode)
+
+	common.LogVerboseQuiet("Checking if node is a remote node managed by Dokku")
+	if node.RemoteHost == "" {
+		isLocal, err := isLocalNode(kubeNode)
+		if err != nil {
+			return fmt.Errorf("Unable to check if node is the local node: %w", err)
+		}
+		if !isLocal {
+			return fmt.Errorf("Node %s is not a remote node managed by Dokku", nodeName)
 		}
+
+		common.LogVerboseQuiet("Node is the local node, checking server quorum")
+		if err := removeLocalNode(ctx, clientset, kubeNode); err != nil {
+			return err
+		}
+
+		if err := common.PropertyDelete("scheduler-k3s", "--global", fmt.Sprintf("%s%s.role", NodePropertyPrefix, nodeName)); err != nil {
+			return fmt.Errorf("Unable to delete node role: %w", err)
+		}
+
+		return nil
 	}
 
-	var locationSnippet string
-	for _, line := range locationLines {
-		if line != "" {
-			locationSnippet += line + "\n"
+	remoteHost, err := overrideSshConnection(node.RemoteHost, input.SshUser, input.SshPort)
+	if err != nil {
+		return err
+	}
+
+	if getNodeRole(kubeNode) == "server" {
+		common.LogVerboseQuiet("Node is a server node, checking server quorum")
+		if _, err := getRemainingReadyServers(ctx, clientset, nodeName); err != nil {
+			return err
 		}
 	}
-	var serverSnippet string
-	for _, line := range serverLines {
-		if line != "" {
-			serverSnippet += line + "\n"
+
+	if input.NoDrain {
+		common.LogWarn("Skipping node drain, pods running on the node will be terminated without rescheduling")
+	} else {
+		common.LogVerboseQuiet("Draining node")
+		drainOutput, err := clientset.DrainNode(ctx, DrainNodeInput{
+			Name:        nodeName,
+			GracePeriod: input.DrainGracePeriod,
+			Timeout:     input.DrainTimeout,
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to drain node, specify --no-drain to remove the node without draining: %w", err)
 		}
+		common.LogVerboseQuiet(fmt.Sprintf("Evicted %d pod(s) from node", drainOutput.EvictedPods))
 	}
 
-	if locationSnippet != "" {
-		annotations["nginx.ingress.kubernetes.io/configuration-snippet"] = locationSnippet
+	return removeRemoteNode(ctx, clientset, nodeName, remoteHost)
+}
+
+// removeLocalNode uninstalls k3s from the current server and removes its node from the cluster
+func removeLocalNode(ctx context.Context, clientset KubernetesClient, node v1.Node) error {
+	readyServers, err := getRemainingReadyServers(ctx, clientset, node.Name)
+	if err != nil {
+		return err
 	}
-	if serverSnippet != "" {
-		annotations["nginx.ingress.kubernetes.io/server-snippet"] = serverSnippet
+
+	serverAddress := ""
+	for _, address := range readyServers[0].Status.Addresses {
+		if address.Type == v1.NodeInternalIP {
+			serverAddress = address.Address
+			break
+		}
+	}
+	if serverAddress == "" {
+		return fmt.Errorf("Unable to find an internal ip address for server node %s", readyServers[0].Name)
 	}
 
-	customAnnotations, err := getAnnotation(appName, processType, "deployment")
+	// the local api server and kubeconfig are removed by the uninstall, so use another server to delete the node
+	remoteClientset, err := clientset.ForHost(fmt.Sprintf("https://%s", net.JoinHostPort(serverAddress, "6443")))
 	if err != nil {
-		return map[string]string{}, err
+		return fmt.Errorf("Unable to create kubernetes client for server node %s: %w", readyServers[0].Name, err)
 	}
 
-	for key, value := range customAnnotations {
-		if _, ok := annotations[key]; ok {
-			common.LogWarn(fmt.Sprintf("Nginx-based annotation %s will be overwritten by custom annotation", key))
+	common.LogVerboseQuiet("Uninstalling k3s on local host")
+	removeCmd, err := common.CallExecCommand(common.ExecCommandInput{
+		Command:     "/usr/local/bin/k3s-uninstall.sh",
+		StreamStdio: shouldStreamStdio(),
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to call k3s uninstall command: %w", err)
+	}
+	if removeCmd.ExitCode != 0 {
+		return exitCodeError("k3s uninstall command", removeCmd.ExitCode, removeCmd.Stdout, removeCmd.Stderr)
+	}
+
+	common.LogVerboseQuiet(fmt.Sprintf("Deleting node from k3s cluster via %s", readyServers[0].Name))
+	err = remoteClientset.DeleteNode(ctx, DeleteNodeInput{
+		Name: node.Name,
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to delete node: %w", err)
+	}
+
+	return nil
+}
+
+// grantRegistryConfigAccess creates the registry config if it does not exist and grants the dokku user write access to it via an acl
+func grantRegistryConfigAccess(ctx context.Context) error {
+	if err := os.MkdirAll(filepath.Dir(RegistryConfigPath), 0755); err != nil {
+		return fmt.Errorf("Unable to create registry config directory: %w", err)
+	}
+
+	if _, err := os.Stat(RegistryConfigPath); errors.Is(err, os.ErrNotExist) {
+		if err := common.TouchFile(RegistryConfigPath); err != nil {
+			return fmt.Errorf("Unable to create registry config: %w", err)
 		}
+	} else if err != nil {
+		return fmt.Errorf("Unable to stat registry config: %w", err)
+	}
 
-		annotations[key] = value
+	fi, err := os.Stat(RegistryConfigPath)
+	if err != nil {
+		return fmt.Errorf("Unable to stat registry config after creating it: %w", err)
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("Registry config at %s is not a regular file", RegistryConfigPath)
 	}
 
-	return annotations, nil
+	systemUser := common.GetenvWithDefault("DOKKU_SYSTEM_USER", "dokku")
+	attempts := 3
+	for attempt := 1; attempt <= attempts; attempt++ {
+		setfaclCmd, err := common.CallExecCommand(common.ExecCommandInput{
+			Command: "setfacl",
+			Args:    []string{"-m", fmt.Sprintf("user:%s:rw", systemUser), RegistryConfigPath},
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to call setfacl command: %w", err)
+		}
+		if setfaclCmd.ExitCode != 0 {
+			return fmt.Errorf("Invalid exit code from setfacl command: %d (%s)", setfaclCmd.ExitCode, setfaclCmd.StderrContents())
+		}
+
+		// read the acl back, as setfacl silently succeeds on filesystems that ignore acls
+		err = verifyRegistryConfigAccess(systemUser)
+		if err == nil {
+			return nil
+		}
+		if attempt == attempts {
+			return err
+		}
+
+		common.LogVerboseQuiet(fmt.Sprintf("Registry config acl not applied, retrying (%d/%d)", attempt, attempts))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+
+	return nil
+}
+
+// verifyRegistryConfigAccess returns an error if the acl of the registry config does not grant a user read and write access
+func verifyRegistryConfigAccess(systemUser string) error {
+	getfaclCmd, err := common.CallExecCommand(common.ExecCommandInput{
+		Command: "getfacl",
+		Args:    []string{"--omit-header", RegistryConfigPath},
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to call getfacl command: %w", err)
+	}
+	if getfaclCmd.ExitCode != 0 {
+		return fmt.Errorf("Invalid exit code from getfacl command: %d (%s)", getfaclCmd.ExitCode, getfaclCmd.StderrContents())
+	}
+
+	prefix := fmt.Sprintf("user:%s:", systemUser)
+	for _, line := range strings.Split(getfaclCmd.StdoutContents(), "\n") {
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+
+		// the mask may restrict the permissions granted, in which case getfacl reports the effective permissions
+		permissions := ""
+		if fields := strings.Fields(strings.TrimPrefix(line, prefix)); len(fields) > 0 {
+			permissions = fields[0]
+		}
+		if _, effective, ok := strings.Cut(line, "#effective:"); ok {
+			permissions = strings.TrimSpace(effective)
+		}
+		if strings.HasPrefix(permissions, "rw") {
+			return nil
+		}
+
+		return fmt.Errorf("Registry config acl grants %s %s access, expected rw", systemUser, permissions)
+	}
+
+	return fmt.Errorf("Registry config acl does not grant %s access", systemUser)
+}
+
+// renderRegistryConfig renders the k3s registries.yaml for a set of registry mirrors
+func renderRegistryConfig(mirrors []RegistryMirror) ([]byte, error) {
+	registryMirrors := map[string]interface{}{}
+	registryConfigs := map[string]interface{}{}
+	for _, mirror := range mirrors {
+		registryMirrors[mirror.Registry] = map[string][]string{
+			"endpoint": mirror.GetEndpoints(),
+		}
+
+		if mirror.Username == "" {
+			continue
+		}
+
+		u, err := url.Parse(mirror.Endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse registry mirror endpoint %s: %w", mirror.Endpoint, err)
+		}
+
+		registryConfigs[u.Host] = map[string]interface{}{
+			"auth": map[string]string{
+				"username": mirror.Username,
+				"password": mirror.Password,
+			},
+		}
+	}
+
+	config := map[string]interface{}{
+		"mirrors": registryMirrors,
+	}
+	if len(registryConfigs) > 0 {
+		config["configs"] = registryConfigs
+	}
+
+	return yaml.Marshal(config)
+}
+
+// normalizeRegistryHost maps the docker hub registry aliases to docker.io, the key containerd matches docker hub images against
+func normalizeRegistryHost(registry string) string {
+	switch registry {
+	case "", "docker.io", "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return "docker.io"
+	}
+
+	return registry
+}
+
+// validateRegistryMirrorEndpoint returns an error if a registry mirror endpoint is not an http or https url with a host
+func validateRegistryMirrorEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("Invalid registry mirror endpoint: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("Invalid registry mirror endpoint scheme, must be http or https: %s", endpoint)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("Invalid registry mirror endpoint, missing host: %s", endpoint)
+	}
+
+	return nil
 }
 
-// getLabels retrieves labels for a given app and process type
-func getLabels(appName string, processType string) (ProcessLabels, error) {
-	labels := ProcessLabels{}
-	certificateLabels, err := getLabel(appName, processType, "certificate")
-	if err != nil {
-		return labels, err
+// applyRegistryMirrors stores the registry mirrors and, if the cluster is initialized, writes them to every node
+func applyRegistryMirrors(ctx context.Context, mirrors []RegistryMirror) error {
+	slices.SortFunc(mirrors, func(a, b RegistryMirror) int {
+		return strings.Compare(a.Registry, b.Registry)
+	})
+
+	data, err := json.Marshal(mirrors)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal registry mirrors: %w", err)
+	}
+	if err := common.PropertyWrite("scheduler-k3s", "--global", RegistryMirrorsProperty, string(data)); err != nil {
+		return fmt.Errorf("Unable to store registry mirrors: %w", err)
+	}
+
+	if err := isK3sInstalled(); err != nil {
+		common.LogVerboseQuiet("k3s not installed, registry mirrors will be applied on initialize")
+		return nil
+	}
+
+	contents, err := renderRegistryConfig(mirrors)
+	if err != nil {
+		return fmt.Errorf("Unable to render registry config: %w", err)
+	}
+
+	clientset, err := NewKubernetesClient()
+	if err != nil {
+		return fmt.Errorf("Unable to create kubernetes client: %w", err)
+	}
+
+	if err := clientset.Ping(); err != nil {
+		return fmt.Errorf("kubernetes api not available, cannot apply registry mirrors: %w", err)
+	}
+
+	nodes, err := clientset.ListNodes(ctx, ListNodesInput{})
+	if err != nil {
+		return fmt.Errorf("Unable to list nodes: %w", err)
+	}
+
+	remoteErrs := []error{}
+	for _, node := range nodes {
+		isLocal, err := isLocalNode(node)
+		if err != nil {
+			return fmt.Errorf("Unable to determine if node is local: %w", err)
+		}
+		if isLocal {
+			continue
+		}
+
+		remoteHost := getNodeRemoteHost(node)
+		if remoteHost == "" {
+			common.LogWarn(fmt.Sprintf("Unable to find remote host for %s, skipping", node.Name))
+			continue
+		}
+
+		service := "k3s-agent"
+		if getNodeRole(node) == "server" {
+			service = "k3s"
+		}
+
+		common.LogInfo2Quiet(fmt.Sprintf("Copying registry mirrors to %s", node.Name))
+		err = copyFileToNode(ctx, CopyFileToNodeInput{
+			AllowUknownHosts: getNodeAllowUknownHosts(node),
+			Contents:         contents,
+			Path:             RegistryConfigPath,
+			RemoteHost:       remoteHost,
+			Restart:          true,
+			Service:          service,
+		})
+		if err != nil {
+			common.LogWarn(fmt.Sprintf("Unable to copy registry mirrors to %s: %s", node.Name, err.Error()))
+			remoteErrs = append(remoteErrs, fmt.Errorf("%s: %w", node.Name, err))
+		}
 	}
-	labels.CertificateLabels = certificateLabels
 
-	cronJobLabels, err := getLabel(appName, processType, "cronjob")
-	if err != nil {
-		return labels, err
+	common.LogInfo2Quiet("Writing registry mirrors to local node")
+	if err := writeRegistryConfig(contents); err != nil {
+		return err
 	}
-	labels.CronJobLabels = cronJobLabels
 
-	deploymentLabels, err := getLabel(appName, processType, "deployment")
+	restartCmd, err := common.CallExecCommand(common.ExecCommandInput{
+		Command:     "systemctl",
+		Args:        []string{"restart", "k3s"},
+		StreamStdio: shouldStreamStdio(),
+	})
 	if err != nil {
-		return labels, err
+		return fmt.Errorf("Unable to call systemctl restart command: %w", err)
+	}
+	if restartCmd.ExitCode != 0 {
+		return exitCodeError("systemctl restart command", restartCmd.ExitCode, restartCmd.Stdout, restartCmd.Stderr)
 	}
-	labels.DeploymentLabels = deploymentLabels
 
-	ingressLabels, err := getLabel(appName, processType, "ingress")
-	if err != nil {
-		return labels, err
+	if len(remoteErrs) > 0 {
+		return fmt.Errorf("Unable to apply registry mirrors to %d remote node(s): %w", len(remoteErrs), errors.Join(remoteErrs...))
 	}
-	labels.IngressLabels = ingressLabels
 
-	jobLabels, err := getLabel(appName, processType, "job")
-	if err != nil {
-		return labels, err
+	return nil
+}
+
+// writeRegistryConfig writes the registry config to the local node
+func writeRegistryConfig(contents []byte) error {
+	if err := os.MkdirAll(filepath.Dir(RegistryConfigPath), 0755); err != nil {
+		return fmt.Errorf("Unable to create registry config directory: %w", err)
 	}
-	labels.JobLabels = jobLabels
 
-	podLabels, err := getLabel(appName, processType, "pod")
-	if err != nil {
-		return labels, err
+	if err := os.WriteFile(RegistryConfigPath, contents, 0600); err != nil {
+		return fmt.Errorf("Unable to write registry config: %w", err)
 	}
-	labels.PodLabels = podLabels
 
-	secretLabels, err := getLabel(appName, processType, "secret")
+	return nil
+}
+
+// removeRemoteNode uninstalls k3s from a remote node over ssh and removes it from the cluster
+func removeRemoteNode(ctx context.Context, clientset KubernetesClient, nodeName string, remoteHost string) error {
+	common.LogVerboseQuiet("Uninstalling k3s on remote host")
+	removeCmd, err := common.CallSshCommand(common.SshCommandInput{
+		Command:          "/usr/local/bin/k3s-uninstall.sh",
+		Args:             []string{},
+		AllowUknownHosts: true,
+		RemoteHost:       remoteHost,
+		StreamStdio:      shouldStreamStdio(),
+		Sudo:             true,
+	})
 	if err != nil {
-		return labels, err
+		return fmt.Errorf("Unable to call k3s uninstall command over ssh: %w", err)
 	}
-	labels.SecretLabels = secretLabels
 
-	serviceLabels, err := getLabel(appName, processType, "service")
-	if err != nil {
-		return labels, err
+	if removeCmd.ExitCode != 0 {
+		return exitCodeError("k3s uninstall command over ssh", removeCmd.ExitCode, removeCmd.Stdout, removeCmd.Stderr)
 	}
-	labels.ServiceLabels = serviceLabels
 
-	serviceAccountLabels, err := getLabel(appName, processType, "serviceaccount")
+	common.LogVerboseQuiet("Deleting node from k3s cluster")
+	err = clientset.DeleteNode(ctx, DeleteNodeInput{
+		Name: nodeName,
+	})
 	if err != nil {
-		return labels, err
+		return fmt.Errorf("Unable to delete node: %w", err)
 	}
-	labels.ServiceAccountLabels = serviceAccountLabels
 
-	traefikIngressRouteLabels, err := getLabel(appName, processType, "traefik_ingressroute")
-	if err != nil {
-		return labels, err
+	if err := common.PropertyDelete("scheduler-k3s", "--global", fmt.Sprintf("%s%s.role", NodePropertyPrefix, nodeName)); err != nil {
+		return fmt.Errorf("Unable to delete node role: %w", err)
+	}
+	if err := common.PropertyDelete("scheduler-k3s", "--global", fmt.Sprintf("%s%s.remote-host", NodePropertyPrefix, nodeName)); err != nil {
+		return fmt.Errorf("Unable to delete node remote host: %w", err)
+	}
+	if err := common.PropertyDelete("scheduler-k3s", "--global", fmt.Sprintf("%s%s.allow-unknown-hosts", NodePropertyPrefix, nodeName)); err != nil {
+		return fmt.Errorf("Unable to delete node allow-unknown-hosts setting: %w", err)
 	}
-	labels.TraefikIngressRouteLabels = traefikIngressRouteLabels
 
-	traefikMiddlewareLabels, err := getLabel(appName, processType, "traefik_middleware")
+	return nil
+}
+
+// parseMemoryQuantity parses a string into a valid memory quantity
+func parseMemoryQuantity(input string) (string, error) {
+	if _, err := strconv.ParseInt(input, 10, 64); err == nil {
+		input = fmt.Sprintf("%sMi", input)
+	}
+	quantity, err := resource.ParseQuantity(input)
 	if err != nil {
-		return labels, err
+		return "", err
 	}
-	labels.TraefikMiddlewareLabels = traefikMiddlewareLabels
 
-	return labels, nil
+	return quantity.String(), nil
 }
 
-// getGlobalLabel retrieves global labels for a given app
-func getGlobalLabel(appName string) (ProcessLabels, error) {
-	return getLabels(appName, GlobalProcessType)
+func uninstallHelperCommands(ctx context.Context) error {
+	errs, _ := errgroup.WithContext(ctx)
+	errs.Go(func() error {
+		return os.RemoveAll("/usr/local/bin/kubectx")
+	})
+	errs.Go(func() error {
+		return os.RemoveAll("/usr/local/bin/kubens")
+	})
+	return errs.Wait()
 }
 
-// getLabel retrieves an label for a given app, process type, and resource type
-func getLabel(appName string, processType string, resourceType string) (map[string]string, error) {
-	labels := map[string]string{}
-	labelsList, err := common.PropertyListGet("scheduler-k3s", appName, fmt.Sprintf("labels.%s.%s", processType, resourceType))
+// overrideSshConnection returns the remote host url with the ssh user and port replaced when specified
+func overrideSshConnection(remoteHost string, sshUser string, sshPort int) (string, error) {
+	u, err := url.Parse(remoteHost)
 	if err != nil {
-		return labels, err
+		return "", fmt.Errorf("failed to parse remote host: %w", err)
+	}
+	if u.Scheme != "ssh" || u.Hostname() == "" {
+		return "", fmt.Errorf("Invalid remote host, expected a url such as ssh://user@host:port: %s", remoteHost)
 	}
 
-	for _, label := range labelsList {
-		parts := strings.SplitN(label, ": ", 2)
-		if len(parts) != 2 {
-			return labels, fmt.Errorf("Invalid label format: %s", label)
+	if sshPort < 0 || sshPort > 65535 {
+		return "", fmt.Errorf("Invalid ssh-port value, expected a port between 1 and 65535: %d", sshPort)
+	}
+
+	if sshUser != "" {
+		if password, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(sshUser, password)
+		} else {
+			u.User = url.User(sshUser)
 		}
+	}
 
-		labels[parts[0]] = parts[1]
+	if sshPort > 0 {
+		u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(sshPort))
 	}
 
-	return labels, nil
+	return u.String(), nil
 }
 
-func getLetsencryptServer(appName string) string {
-	return common.PropertyGetDefault("scheduler-k3s", appName, "letsencrypt-server", "")
+// parseKubeControllerManagerArgs splits a comma-separated list of key=value pairs
+func parseKubeControllerManagerArgs(value string) ([]string, error) {
+	return parseComponentArgs("kube-controller-manager-args", value)
 }
 
-func getGlobalLetsencryptServer() string {
-	return common.PropertyGetDefault("scheduler-k3s", "--global", "letsencrypt-server", "prod")
+// parseKubeletArgs parses a comma-separated list of key=value kubelet flags
+func parseKubeletArgs(value string) ([]string, error) {
+	return parseComponentArgs("kubelet-args", value)
 }
 
-func getComputedLetsencryptServer(appName string) string {
-	letsencryptServer := getLetsencryptServer(appName)
-	if letsencryptServer == "" {
-		letsencryptServer = getGlobalLetsencryptServer()
+// parseMetalLBAddressPool parses a comma-separated list of cidrs or start-end ip ranges into metallb pool addresses
+func parseMetalLBAddressPool(value string) ([]string, error) {
+	addresses := []string{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if strings.Contains(entry, "/") {
+			if _, _, err := net.ParseCIDR(entry); err != nil {
+				return []string{}, fmt.Errorf("Invalid metallb-address-pool entry, expected cidr notation such as 192.168.1.240/28: %s", entry)
+			}
+			addresses = append(addresses, entry)
+			continue
+		}
+
+		start, end, ok := strings.Cut(entry, "-")
+		if !ok {
+			return []string{}, fmt.Errorf("Invalid metallb-address-pool entry, expected a cidr or a range such as 192.168.1.240-192.168.1.250: %s", entry)
+		}
+
+		startIP := net.ParseIP(strings.TrimSpace(start))
+		endIP := net.ParseIP(strings.TrimSpace(end))
+		if startIP == nil || endIP == nil {
+			return []string{}, fmt.Errorf("Invalid metallb-address-pool entry, expected a range of ip addresses: %s", entry)
+		}
+		if (startIP.To4() == nil) != (endIP.To4() == nil) {
+			return []string{}, fmt.Errorf("Invalid metallb-address-pool entry, the start and end of a range must be the same ip family: %s", entry)
+		}
+		if bytes.Compare(startIP.To16(), endIP.To16()) > 0 {
+			return []string{}, fmt.Errorf("Invalid metallb-address-pool entry, the start of a range must not be after its end: %s", entry)
+		}
+
+		addresses = append(addresses, fmt.Sprintf("%s-%s", startIP.String(), endIP.String()))
 	}
 
-	return letsencryptServer
-}
+	if len(addresses) == 0 {
+		return []string{}, fmt.Errorf("Invalid metallb-address-pool value, expected at least one cidr or ip range")
+	}
 
-func getGlobalLetsencryptEmailProd() string {
-	return common.PropertyGetDefault("scheduler-k3s", "--global", "letsencrypt-email-prod", "")
+	return addresses, nil
 }
 
-func getGlobalLetsencryptEmailStag() string {
-	return common.PropertyGetDefault("scheduler-k3s", "--global", "letsencrypt-email-stag", "")
-}
+// parseComponentArgs parses a comma-separated list of key=value flags for a kubernetes component
+func parseComponentArgs(property string, value string) ([]string, error) {
+	args := []string{}
+	if value == "" {
+		return args, nil
+	}
 
-func getNamespace(appName string) string {
-	return common.PropertyGetDefault("scheduler-k3s", appName, "namespace", "")
-}
+	for _, arg := range strings.Split(value, ",") {
+		arg = strings.TrimSpace(arg)
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return []string{}, fmt.Errorf("Invalid %s entry, expected key=value: %s", property, arg)
+		}
+		args = append(args, arg)
+	}
 
-func getGlobalNamespace() string {
-	return common.PropertyGetDefault("scheduler-k3s", "--global", "namespace", "default")
+	return args, nil
 }
 
-func getComputedNamespace(appName string) string {
-	namespace := getNamespace(appName)
-	if namespace == "" {
-		namespace = getGlobalNamespace()
+// parseNodeLabels parses a list of key=value node labels, validating each against kubernetes label rules
+func parseNodeLabels(values []string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, value := range values {
+		key, labelValue, ok := strings.Cut(value, "=")
+		if !ok {
+			return labels, fmt.Errorf("Invalid label, expected key=value: %s", value)
+		}
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return labels, fmt.Errorf("Invalid label key %s: %s", key, strings.Join(errs, ", "))
+		}
+		if errs := validation.IsValidLabelValue(labelValue); len(errs) > 0 {
+			return labels, fmt.Errorf("Invalid label value %s: %s", labelValue, strings.Join(errs, ", "))
+		}
+
+		labels[key] = labelValue
 	}
 
-	return namespace
+	return labels, nil
 }
 
-func getGlobalNetworkInterface() string {
-	return common.PropertyGetDefault("scheduler-k3s", "--global", "network-interface", "eth0")
-}
+// parseTaint parses a taint in the form key[=value]:effect
+// when requireEffect is false, the effect may be omitted
+func parseTaint(value string, requireEffect bool) (v1.Taint, error) {
+	taint := v1.Taint{}
+	keyValue, effect, hasEffect := strings.Cut(value, ":")
+	if !hasEffect && requireEffect {
+		return taint, fmt.Errorf("Invalid taint, expected key[=value]:effect: %s", value)
+	}
 
-func getRollbackOnFailure(appName string) string {
-	return common.PropertyGetDefault("scheduler-k3s", appName, "rollback-on-failure", "")
-}
+	if hasEffect {
+		validEffects := map[v1.TaintEffect]bool{
+			v1.TaintEffectNoExecute:        true,
+			v1.TaintEffectNoSchedule:       true,
+			v1.TaintEffectPreferNoSchedule: true,
+		}
+		if !validEffects[v1.TaintEffect(effect)] {
+			return taint, fmt.Errorf("Invalid taint effect, expected NoSchedule, PreferNoSchedule, or NoExecute: %s", effect)
+		}
+		taint.Effect = v1.TaintEffect(effect)
+	}
 
-func getGlobalRollbackOnFailure() string {
-	return common.PropertyGetDefault("scheduler-k3s", "--global", "rollback-on-failure", "false")
+	key, taintValue, _ := strings.Cut(keyValue, "=")
+	if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+		return taint, fmt.Errorf("Invalid taint key %s: %s", key, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsValidLabelValue(taintValue); len(errs) > 0 {
+		return taint, fmt.Errorf("Invalid taint value %s: %s", taintValue, strings.Join(errs, ", "))
+	}
+
+	taint.Key = key
+	taint.Value = taintValue
+	return taint, nil
 }
 
-func getComputedRollbackOnFailure(appName string) string {
-	rollbackOnFailure := getRollbackOnFailure(appName)
-	if rollbackOnFailure == "" {
-		rollbackOnFailure = getGlobalRollbackOnFailure()
+// summarizeKubeconfig returns a structured view of the selected kubeconfig context, redacting credentials unless requested
+func summarizeKubeconfig(contents []byte, kubeContext string, includeSecrets bool) (KubeconfigSummary, error) {
+	config, kubeContext, kubeconfigContext, err := loadKubeconfigContext(contents, kubeContext)
+	if err != nil {
+		return KubeconfigSummary{}, err
 	}
 
-	return rollbackOnFailure
+	summary := KubeconfigSummary{
+		CurrentContext: config.CurrentContext,
+		Context:        kubeContext,
+		Cluster:        kubeconfigContext.Cluster,
+		User:           kubeconfigContext.AuthInfo,
+	}
+
+	if cluster, ok := config.Clusters[kubeconfigContext.Cluster]; ok {
+		summary.Server = cluster.Server
+		summary.HasCertificateAuthorityData = len(cluster.CertificateAuthorityData) > 0
+		if includeSecrets && summary.HasCertificateAuthorityData {
+			summary.CertificateAuthorityData = base64.StdEncoding.EncodeToString(cluster.CertificateAuthorityData)
+		}
+	}
+
+	if authInfo, ok := config.AuthInfos[kubeconfigContext.AuthInfo]; ok {
+		summary.HasClientCertificateData = len(authInfo.ClientCertificateData) > 0
+		summary.HasClientKeyData = len(authInfo.ClientKeyData) > 0
+		summary.HasToken = authInfo.Token != ""
+		if includeSecrets {
+			if summary.HasClientCertificateData {
+				summary.ClientCertificateData = base64.StdEncoding.EncodeToString(authInfo.ClientCertificateData)
+			}
+			if summary.HasClientKeyData {
+				summary.ClientKeyData = base64.StdEncoding.EncodeToString(authInfo.ClientKeyData)
+			}
+			summary.Token = authInfo.Token
+		}
+	}
+
+	return summary, nil
 }
 
-func getGlobalGlobalToken() string {
-	return common.PropertyGet("scheduler-k3s", "--global", "token")
+// loadKubeconfigContext parses a kubeconfig and returns it along with the selected context name and context
+func loadKubeconfigContext(contents []byte, kubeContext string) (*clientcmdapi.Config, string, *clientcmdapi.Context, error) {
+	config, err := clientcmd.Load(contents)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("Unable to parse kubeconfig file: %w", err)
+	}
+
+	if kubeContext == "" {
+		kubeContext = config.CurrentContext
+	}
+
+	kubeconfigContext, ok := config.Contexts[kubeContext]
+	if !ok {
+		return nil, "", nil, fmt.Errorf("Unable to find context %s in kubeconfig file", kubeContext)
+	}
+
+	return config, kubeContext, kubeconfigContext, nil
 }
 
-func getProcessHealtchecks(healthchecks []appjson.Healthcheck, primaryPort int32) ProcessHealthchecks {
-	if len(healthchecks) == 0 {
-		return ProcessHealthchecks{}
+// rewriteKubeconfigServer replaces the server url of the cluster used by the selected kubeconfig context
+func rewriteKubeconfigServer(contents []byte, serverURL string, kubeContext string) ([]byte, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("Invalid server-url value, expected a url such as https://203.0.113.10:6443: %s", serverURL)
 	}
 
-	livenessChecks := []ProcessHealthcheck{}
-	readinessChecks := []ProcessHealthcheck{}
-	startupChecks := []ProcessHealthcheck{}
-	uptimeSeconds := []int32{}
-	for _, healthcheck := range healthchecks {
-		probe := ProcessHealthcheck{
-			InitialDelaySeconds: healthcheck.InitialDelay,
-			PeriodSeconds:       healthcheck.Wait,
-			TimeoutSeconds:      healthcheck.Timeout,
-			FailureThreshold:    healthcheck.Attempts,
-			SuccessThreshold:    int32(1),
+	config, _, kubeconfigContext, err := loadKubeconfigContext(contents, kubeContext)
+	if err != nil {
+		return nil, err
+	}
+
+	cluster, ok := config.Clusters[kubeconfigContext.Cluster]
+	if !ok {
+		return nil, fmt.Errorf("Unable to find cluster %s in kubeconfig file", kubeconfigContext.Cluster)
+	}
+	cluster.Server = serverURL
+
+	b, err := clientcmd.Write(*config)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to write kubeconfig: %w", err)
+	}
+
+	return b, nil
+}
+
+// validateIPFamilyCIDRs returns an error if the cluster-cidr or service-cidr properties do not match the ip-family
+func validateIPFamilyCIDRs() error {
+	ipFamily := getGlobalIPFamily()
+	properties := map[string]string{
+		"cluster-cidr": getGlobalClusterCIDR(),
+		"service-cidr": getGlobalServiceCIDR(),
+	}
+	for _, property := range []string{"cluster-cidr", "service-cidr"} {
+		value := properties[property]
+		if value == "" {
+			continue
 		}
-		if len(healthcheck.Command) > 0 {
-			probe.Exec = &ExecHealthcheck{
-				Command: healthcheck.Command,
-			}
-		} else if healthcheck.Listening {
-			probe.TCPSocket = &TCPHealthcheck{
-				Port: primaryPort,
-			}
-			for _, header := range healthcheck.HTTPHeaders {
-				if header.Name == "Host" {
-					probe.TCPSocket.Host = header.Value
-				}
+
+		hasIPv4 := false
+		hasIPv6 := false
+		for _, cidr := range strings.Split(value, ",") {
+			ip, _, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return fmt.Errorf("Invalid %s value: %w", property, err)
 			}
-		} else if healthcheck.Path != "" {
-			probe.HTTPGet = &HTTPHealthcheck{
-				Path:        healthcheck.Path,
-				Port:        primaryPort,
-				HTTPHeaders: []HTTPHeader{},
+			if ip.To4() != nil {
+				hasIPv4 = true
+			} else {
+				hasIPv6 = true
 			}
+		}
 
-			if healthcheck.Scheme != "" {
-				probe.HTTPGet.Scheme = URIScheme(strings.ToUpper(healthcheck.Scheme))
-			}
+		switch {
+		case ipFamily == "ipv4" && hasIPv6:
+			return fmt.Errorf("Invalid %s value for the ipv4 ip-family, expected only ipv4 networks: %s", property, value)
+		case ipFamily == "ipv6" && hasIPv4:
+			return fmt.Errorf("Invalid %s value for the ipv6 ip-family, expected only ipv6 networks: %s", property, value)
+		case ipFamily == "dual" && (!hasIPv4 || !hasIPv6):
+			return fmt.Errorf("Invalid %s value for the dual ip-family, expected an ipv4 and an ipv6 network: %s", property, value)
+		}
+	}
+
+	return nil
+}
+
+// validateDataDir returns an error if the k3s data directory is not an absolute path or cannot be written to
+func validateDataDir(dataDir string) error {
+	if !filepath.IsAbs(dataDir) {
+		return fmt.Errorf("Invalid data-dir, expected an absolute path: %s", dataDir)
+	}
+
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return fmt.Errorf("Unable to create data-dir %s: %w", dataDir, err)
+	}
+
+	f, err := os.CreateTemp(dataDir, ".dokku-write-check")
+	if err != nil {
+		return fmt.Errorf("Invalid data-dir, %s is not writable: %w", dataDir, err)
+	}
+	defer os.Remove(f.Name())
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Unable to close data-dir write check file: %w", err)
+	}
+
+	return nil
+}
 
-			for _, header := range healthcheck.HTTPHeaders {
-				probe.HTTPGet.HTTPHeaders = append(probe.HTTPGet.HTTPHeaders, HTTPHeader{
-					Name:  header.Name,
-					Value: header.Value,
-				})
-			}
-		} else if healthcheck.Uptime > 0 {
-			uptimeSeconds = append(uptimeSeconds, healthcheck.Uptime)
-		}
+// validateK3sInstaller returns an error if a staged k3s installer is missing, empty, or not executable
+func validateK3sInstaller(installerPath string) error {
+	fi, err := os.Stat(installerPath)
+	if err != nil {
+		return fmt.Errorf("Unable to find k3s installer at %s: %w", installerPath, err)
+	}
 
-		if healthcheck.Type == appjson.HealthcheckType_Liveness {
-			livenessChecks = append(livenessChecks, probe)
-		} else if healthcheck.Type == appjson.HealthcheckType_Readiness {
-			readinessChecks = append(readinessChecks, probe)
-		} else if healthcheck.Type == appjson.HealthcheckType_Startup {
-			startupChecks = append(startupChecks, probe)
-		}
+	if fi.IsDir() {
+		return fmt.Errorf("Invalid k3s installer, %s is a directory", installerPath)
 	}
-	if len(livenessChecks) > 1 {
-		common.LogWarn("Multiple liveness checks are not supported, only the first one will be used")
+
+	if fi.Size() == 0 {
+		return fmt.Errorf("Invalid k3s installer filesize: %s", installerPath)
 	}
-	if len(readinessChecks) > 1 {
-		common.LogWarn("Multiple readiness checks are not supported, only the first one will be used")
+
+	if fi.Mode().Perm()&0111 == 0 {
+		return fmt.Errorf("Invalid k3s installer, %s is not executable", installerPath)
 	}
-	if len(startupChecks) > 1 {
-		common.LogWarn("Multiple startup checks are not supported, only the first one will be used")
+
+	contents, err := os.ReadFile(installerPath)
+	if err != nil {
+		return fmt.Errorf("Unable to read k3s installer at %s: %w", installerPath, err)
 	}
-	if len(uptimeSeconds) > 1 {
-		common.LogWarn("Multiple uptime checks are not supported, only the first one will be used")
+
+	if err := validateK3sInstallerContents(contents); err != nil {
+		return fmt.Errorf("%w: %s", err, installerPath)
 	}
 
-	processHealthchecks := ProcessHealthchecks{}
-	if len(livenessChecks) > 0 {
-		processHealthchecks.Liveness = livenessChecks[0]
+	return nil
+}
+
+// validateAirgapFile returns an error if a staged airgap file is missing or empty
+func validateAirgapFile(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("Unable to find staged file at %s: %w", path, err)
 	}
-	if len(readinessChecks) > 0 {
-		processHealthchecks.Readiness = readinessChecks[0]
+
+	if fi.IsDir() {
+		return fmt.Errorf("Invalid staged file, %s is a directory", path)
 	}
-	if len(startupChecks) > 0 {
-		processHealthchecks.Startup = startupChecks[0]
+
+	if fi.Size() == 0 {
+		return fmt.Errorf("Invalid staged filesize: %s", path)
 	}
-	if len(uptimeSeconds) > 0 {
-		processHealthchecks.MinReadySeconds = uptimeSeconds[0]
+
+	return nil
+}
+
+// stageAirgapFiles copies the staged k3s binary and airgap images into place on the local host
+func stageAirgapFiles() error {
+	for _, file := range getAirgapFiles() {
+		if err := validateAirgapFile(file.Source); err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(file.Destination), 0755); err != nil {
+			return fmt.Errorf("Unable to create directory for %s: %w", file.Destination, err)
+		}
+
+		if err := copyLocalFile(file.Source, file.Destination, getAirgapFileMode(file.Destination)); err != nil {
+			return err
+		}
 	}
 
-	return processHealthchecks
+	return nil
 }
 
-func getProcessResources(appName string, processType string) (ProcessResourcesMap, error) {
-	processResources := ProcessResourcesMap{
-		Limits: ProcessResources{},
-		Requests: ProcessResources{
-			CPU:    "100m",
-			Memory: "128Mi",
-		},
+// getAirgapFileMode returns the file mode a staged airgap file is written with
+func getAirgapFileMode(destination string) os.FileMode {
+	if destination == K3sBinaryPath {
+		return 0755
 	}
 
-	emptyValues := map[string]bool{
-		"":  true,
-		"0": true,
+	return 0644
+}
+
+// copyLocalFile copies a file to a destination path on the local host
+func copyLocalFile(source string, destination string, mode os.FileMode) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return fmt.Errorf("Unable to read %s: %w", source, err)
 	}
+	defer in.Close()
 
-	result, err := common.CallPlugnTrigger(common.PlugnTriggerInput{
-		Trigger: "resource-get-property",
-		Args:    []string{appName, processType, "limit", "cpu"},
-	})
-	if err == nil && !emptyValues[result.StdoutContents()] {
-		quantity, err := resource.ParseQuantity(result.StdoutContents())
-		if err != nil {
-			return ProcessResourcesMap{}, fmt.Errorf("Error parsing cpu limit: %w", err)
+	out, err := os.OpenFile(destination, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return fmt.Errorf("Unable to create %s: %w", destination, err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return fmt.Errorf("Unable to copy %s to %s: %w", source, destination, err)
+	}
+
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("Unable to write %s: %w", destination, err)
+	}
+
+	return os.Chmod(destination, mode)
+}
+
+// stageRemoteAirgapFiles copies the staged k3s binary and airgap images into place on a remote host over ssh
+func stageRemoteAirgapFiles(ctx context.Context, remoteHost string, allowUknownHosts bool) error {
+	for _, file := range getAirgapFiles() {
+		if err := validateAirgapFile(file.Source); err != nil {
+			return err
 		}
-		if quantity.MilliValue() != 0 {
-			processResources.Limits.CPU = quantity.String()
-		} else {
-			processResources.Limits.CPU = ""
+
+		if err := stageRemoteAirgapFile(ctx, remoteHost, allowUknownHosts, file); err != nil {
+			return err
 		}
 	}
-	response, err := common.CallPlugnTrigger(common.PlugnTriggerInput{
-		Trigger: "resource-get-property",
-		Args:    []string{appName, processType, "limit", "nvidia-gpu"},
+
+	return nil
+}
+
+// stageRemoteAirgapFile streams a single staged airgap file to a remote host, verifying its checksum once copied
+func stageRemoteAirgapFile(ctx context.Context, remoteHost string, allowUknownHosts bool, file AirgapFile) error {
+	_, err := callRemoteStep(ctx, RemoteCommandTimeout, "mkdir command over ssh", common.SshCommandInput{
+		Command:          "mkdir",
+		Args:             []string{"-p", filepath.Dir(file.Destination)},
+		AllowUknownHosts: allowUknownHosts,
+		RemoteHost:       remoteHost,
+		Sudo:             true,
 	})
-	nvidiaGpuLimit := response.StdoutContents()
-	if err == nil && nvidiaGpuLimit != "" && nvidiaGpuLimit != "0" {
-		_, err := resource.ParseQuantity(nvidiaGpuLimit)
-		if err != nil {
-			return ProcessResourcesMap{}, fmt.Errorf("Error parsing nvidia-gpu limit: %w", err)
-		}
-		processResources.Limits.NvidiaGPU = nvidiaGpuLimit
+	if err != nil {
+		return err
 	}
-	result, err = common.CallPlugnTrigger(common.PlugnTriggerInput{
-		Trigger: "resource-get-property",
-		Args:    []string{appName, processType, "limit", "memory"},
+
+	contents, err := os.Open(file.Source)
+	if err != nil {
+		return fmt.Errorf("Unable to read staged file %s: %w", file.Source, err)
+	}
+	defer contents.Close()
+
+	// the images tarball may be hundreds of megabytes, so stream it through dd rather than echoing it back via tee
+	hash := sha256.New()
+	_, err = callRemoteStep(ctx, RemoteInstallTimeout, "dd command over ssh", common.SshCommandInput{
+		Command:          "dd",
+		Args:             []string{fmt.Sprintf("of=%s", file.Destination), "bs=1M", "status=none"},
+		AllowUknownHosts: allowUknownHosts,
+		RemoteHost:       remoteHost,
+		Stdin:            io.TeeReader(contents, hash),
+		Sudo:             true,
 	})
-	if err == nil && !emptyValues[result.StdoutContents()] {
-		quantity, err := parseMemoryQuantity(result.StdoutContents())
-		if err != nil {
-			return ProcessResourcesMap{}, fmt.Errorf("Error parsing memory limit: %w", err)
-		}
-		if quantity != "0Mi" {
-			processResources.Limits.Memory = quantity
-		} else {
-			processResources.Limits.Memory = ""
-		}
+	if err != nil {
+		return err
 	}
 
-	result, err = common.CallPlugnTrigger(common.PlugnTriggerInput{
-		Trigger: "resource-get-property",
-		Args:    []string{appName, processType, "reserve", "cpu"},
+	sumCmd, err := callRemoteStep(ctx, RemoteCommandTimeout, "sha256sum command over ssh", common.SshCommandInput{
+		Command:          "sha256sum",
+		Args:             []string{file.Destination},
+		AllowUknownHosts: allowUknownHosts,
+		RemoteHost:       remoteHost,
+		Sudo:             true,
 	})
-	if err == nil && !emptyValues[result.StdoutContents()] {
-		quantity, err := resource.ParseQuantity(result.StdoutContents())
-		if err != nil {
-			return ProcessResourcesMap{}, fmt.Errorf("Error parsing cpu request: %w", err)
-		}
-		if quantity.MilliValue() != 0 {
-			processResources.Requests.CPU = quantity.String()
-		} else {
-			processResources.Requests.CPU = ""
-		}
+	if err != nil {
+		return err
 	}
-	result, err = common.CallPlugnTrigger(common.PlugnTriggerInput{
-		Trigger: "resource-get-property",
-		Args:    []string{appName, processType, "reserve", "memory"},
+
+	expected := hex.EncodeToString(hash.Sum(nil))
+	fields := strings.Fields(sumCmd.Stdout)
+	if len(fields) == 0 || fields[0] != expected {
+		return fmt.Errorf("Invalid checksum for %s on remote host, expected %s", file.Destination, expected)
+	}
+
+	_, err = callRemoteStep(ctx, RemoteCommandTimeout, "chmod command over ssh", common.SshCommandInput{
+		Command:          "chmod",
+		Args:             []string{fmt.Sprintf("%04o", getAirgapFileMode(file.Destination)), file.Destination},
+		AllowUknownHosts: allowUknownHosts,
+		RemoteHost:       remoteHost,
+		Sudo:             true,
 	})
-	if err == nil && !emptyValues[result.StdoutContents()] {
-		quantity, err := parseMemoryQuantity(result.StdoutContents())
-		if err != nil {
-			return ProcessResourcesMap{}, fmt.Errorf("Error parsing memory request: %w", err)
-		}
-		if quantity != "0Mi" {
-			processResources.Requests.Memory = quantity
-		} else {
-			processResources.Requests.Memory = ""
-		}
+	if err != nil {
+		return err
 	}
 
-	return processResources, nil
+	return nil
 }
 
-func getServerIP() (string, error) {
-	serverIP := ""
-	networkInterface := getGlobalNetworkInterface()
-	ifaces, err := net.Interfaces()
-	if err != nil {
-		return "", fmt.Errorf("Unable to get network interfaces: %w", err)
+// validateK3sInstallerContents returns an error if the contents are not a shell script that can be run as-is
+func validateK3sInstallerContents(contents []byte) error {
+	if len(contents) == 0 {
+		return fmt.Errorf("Invalid k3s installer filesize")
 	}
 
-	for _, iface := range ifaces {
-		if iface.Name == networkInterface {
-			addr, err := iface.Addrs()
-			if err != nil {
-				return "", fmt.Errorf("Unable to get network addresses for interface %s: %w", networkInterface, err)
-			}
-			for _, a := range addr {
-				if ipnet, ok := a.(*net.IPNet); ok {
-					if ipnet.IP.To4() != nil {
-						serverIP = ipnet.IP.String()
-					}
-				}
-			}
-		}
+	if !bytes.HasPrefix(contents, []byte("#!")) {
+		return fmt.Errorf("Invalid k3s installer, missing shebang line")
 	}
 
-	if len(serverIP) == 0 {
-		return "", fmt.Errorf(fmt.Sprintf("Unable to determine server ip address from network-interface %s", networkInterface))
+	// a proxy or editor that rewrites line endings leaves a script that fails with confusing errors midway through
+	if bytes.Contains(contents, []byte("\r\n")) {
+		return fmt.Errorf("Invalid k3s installer, contains windows line endings")
 	}
-	return serverIP, nil
+
+	return nil
 }
 
-func getStartCommand(input StartCommandInput) (StartCommandOutput, error) {
-	command := extractStartCommand(input)
-	fields, err := shell.Fields(command, func(name string) string {
-		if name == "PORT" {
-			return fmt.Sprint(input.Port)
-		}
+// writeK3sInstallerTempFile writes the k3s installer to an executable temporary file, verifying the written contents
+// the file is removed if any step fails, otherwise the caller is responsible for removing it
+func writeK3sInstallerTempFile(installer []byte) (string, error) {
+	f, err := os.CreateTemp("", "k3s-installer-*.sh")
+	if err != nil {
+		return "", fmt.Errorf("Unable to create temporary file for k3s installer: %w", err)
+	}
 
-		return input.Env[name]
-	})
+	path := f.Name()
+	cleanup := func(err error) (string, error) {
+		f.Close()
+		os.Remove(path)
+		return "", err
+	}
+
+	if _, err := f.Write(installer); err != nil {
+		return cleanup(fmt.Errorf("Unable to write k3s installer to file: %w", err))
+	}
+
+	if err := f.Chmod(os.FileMode(0755)); err != nil {
+		return cleanup(fmt.Errorf("Unable to set k3s installer permissions: %w", err))
+	}
+
+	if err := f.Close(); err != nil {
+		return cleanup(fmt.Errorf("Unable to close k3s installer file: %w", err))
+	}
+
+	expected := sha256.Sum256(installer)
+	actual, err := getFileChecksum(path)
 	if err != nil {
-		return StartCommandOutput{}, err
+		return cleanup(fmt.Errorf("Unable to verify k3s installer file: %w", err))
+	}
+	if actual != hex.EncodeToString(expected[:]) {
+		return cleanup(fmt.Errorf("Invalid k3s installer, written file does not match the downloaded contents"))
 	}
 
-	return StartCommandOutput{
-		Command: fields,
-	}, nil
+	return path, nil
 }
 
-func installHelmCharts(ctx context.Context, clientset KubernetesClient, shouldInstall func(HelmChart) bool) error {
-	for _, repo := range HelmRepositories {
-		helmAgent, err := NewHelmAgent("default", DeployLogPrinter)
-		if err != nil {
-			return fmt.Errorf("Error creating helm agent: %w", err)
+// validateNetworkInterface returns an error if the interface does not exist or has no usable address
+func validateNetworkInterface(networkInterface string) error {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return fmt.Errorf("Unable to get network interfaces: %w", err)
+	}
+
+	names := []string{}
+	for _, iface := range ifaces {
+		names = append(names, iface.Name)
+		if iface.Name != networkInterface {
+			continue
 		}
 
-		err = helmAgent.AddRepository(ctx, AddRepositoryInput(repo))
+		ipv4Addresses, ipv6Addresses, err := getInterfaceAddresses(iface)
 		if err != nil {
-			return fmt.Errorf("Error adding helm repository %s: %w", repo.Name, err)
+			return err
 		}
+		if len(ipv4Addresses) > 0 || len(ipv6Addresses) > 0 {
+			return nil
+		}
+
+		return fmt.Errorf("Invalid network-interface value, interface %s has no ipv4 or ipv6 address", networkInterface)
 	}
 
-	for _, chart := range HelmCharts {
-		if !shouldInstall(chart) {
-			continue
-		}
+	return fmt.Errorf("Invalid network-interface value, interface %s not found, available interfaces: %s", networkInterface, strings.Join(names, ", "))
+}
 
-		if chart.CreateNamespace {
-			namespace := corev1.Namespace{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: chart.Namespace,
-					Annotations: map[string]string{
-						"dokku.com/managed": "true",
-					},
-					Labels: map[string]string{
-						"dokku.com/managed": "true",
-					},
-				},
-			}
-			_, err := clientset.CreateNamespace(ctx, CreateNamespaceInput{
-				Name: namespace,
-			})
-			if err != nil {
-				return fmt.Errorf("Error creating namespace %s: %w", chart.Namespace, err)
-			}
+// validateProperty returns an error if the value is not valid for the given property
+func validateProperty(appName string, property string, value string) error {
+	if value == "" {
+		// clearing serialize-image-pulls restores the default of serializing pulls
+		if property == "serialize-image-pulls" {
+			return validateImagePulls("", getGlobalMaxParallelImagePulls())
 		}
+		return nil
+	}
 
-		contents, err := templates.ReadFile(fmt.Sprintf("templates/helm-config/%s.yaml", chart.ReleaseName))
-		if err != nil && !errors.Is(err, os.ErrNotExist) {
-			return fmt.Errorf("Error reading values file %s: %w", chart.ReleaseName, err)
+	switch property {
+	case "api-retry-timeout":
+		apiRetryTimeout, err := strconv.Atoi(value)
+		if err != nil || apiRetryTimeout < 0 {
+			return fmt.Errorf("Invalid api-retry-timeout value, expected a non-negative integer: %s", value)
 		}
-
-		var values map[string]interface{}
-		if len(contents) > 0 {
-			err = yaml.Unmarshal(contents, &values)
-			if err != nil {
-				return fmt.Errorf("Error unmarshalling values file: %w", err)
+	case "cpu-limit", "cpu-request":
+		if _, err := resource.ParseQuantity(value); err != nil {
+			return fmt.Errorf("Invalid %s value, expected a kubernetes quantity such as 500m or 2: %s", property, value)
+		}
+	case "deploy-timeout":
+		if _, err := parseDeployTimeout(value); err != nil {
+			return err
+		}
+	case "cluster-cidr", "service-cidr":
+		cidrs := strings.Split(value, ",")
+		if len(cidrs) > 2 {
+			return fmt.Errorf("Invalid %s value, expected at most one ipv4 and one ipv6 network: %s", property, value)
+		}
+		for _, cidr := range cidrs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("Invalid %s value, expected cidr notation such as 10.42.0.0/16: %s", property, value)
 			}
 		}
-
-		helmAgent, err := NewHelmAgent(chart.Namespace, DeployLogPrinter)
-		if err != nil {
-			return fmt.Errorf("Error creating helm agent: %w", err)
+	case "data-dir":
+		if !filepath.IsAbs(value) {
+			return fmt.Errorf("Invalid data-dir value, expected an absolute path: %s", value)
+		}
+	case "depends-on":
+		if value == appName {
+			return fmt.Errorf("Invalid depends-on value, an app cannot depend on itself: %s", value)
+		}
+		if err := common.VerifyAppName(value); err != nil {
+			return fmt.Errorf("Invalid depends-on value: %w", err)
+		}
+	case "flannel-backend":
+		validBackends := map[string]bool{
+			"host-gw":          true,
+			"none":             true,
+			"vxlan":            true,
+			"wireguard-native": true,
+		}
+		if !validBackends[value] {
+			return fmt.Errorf("Invalid flannel-backend value, expected wireguard-native, vxlan, host-gw, or none: %s", value)
+		}
+	case "flannel-wireguard-port":
+		port, err := strconv.Atoi(value)
+		if err != nil || port < 1 || port > 65534 {
+			return fmt.Errorf("Invalid flannel-wireguard-port value, expected a port between 1 and 65534: %s", value)
+		}
+	case "install-cert-manager", "install-longhorn":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("Invalid %s value, expected true or false: %s", property, value)
+		}
+	case "ingress-class":
+		if value != "nginx" && value != "traefik" {
+			return fmt.Errorf("Invalid ingress-class value, expected nginx or traefik: %s", value)
+		}
+	case "ip-family":
+		if value != "ipv4" && value != "ipv6" && value != "dual" {
+			return fmt.Errorf("Invalid ip-family value, expected ipv4, ipv6, or dual: %s", value)
+		}
+	case "http-proxy", "https-proxy":
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("Invalid %s value, expected a url such as http://proxy.example.com:3128: %s", property, value)
+		}
+	case "k3s-channel":
+		if value != "stable" && value != "latest" && value != "testing" {
+			return fmt.Errorf("Invalid k3s-channel value, expected stable, latest, or testing: %s", value)
+		}
+		if getGlobalK3sVersion() != "" {
+			return fmt.Errorf("Unable to set k3s-channel while k3s-version is set, clear k3s-version first")
+		}
+	case "k3s-airgap-images-path", "k3s-binary-path":
+		if appName == "--global" {
+			return validateAirgapFile(value)
+		}
+	case "k3s-installer-path":
+		if appName == "--global" {
+			return validateK3sInstaller(value)
+		}
+	case "k3s-version":
+		if !k3sVersionRegex.MatchString(value) {
+			return fmt.Errorf("Invalid k3s-version value, expected a version such as v1.30.2+k3s1: %s", value)
+		}
+		if getGlobalK3sChannel() != "" {
+			return fmt.Errorf("Unable to set k3s-version while k3s-channel is set, clear k3s-channel first")
+		}
+	case "kube-controller-manager-args":
+		if _, err := parseKubeControllerManagerArgs(value); err != nil {
+			return err
+		}
+	case "kubelet-args":
+		if _, err := parseKubeletArgs(value); err != nil {
+			return err
+		}
+	case "namespace":
+		if errs := validation.IsDNS1123Label(value); len(errs) > 0 {
+			return fmt.Errorf("Invalid namespace value %s: %s", value, strings.Join(errs, ", "))
+		}
+	case "network-interface":
+		if appName == "--global" {
+			return validateNetworkInterface(value)
+		}
+	case "node-wait-timeout":
+		nodeWaitTimeout, err := strconv.Atoi(value)
+		if err != nil || nodeWaitTimeout < 1 {
+			return fmt.Errorf("Invalid node-wait-timeout value, expected a positive integer: %s", value)
+		}
+	case "letsencrypt-email-prod", "letsencrypt-email-stag":
+		address, err := mail.ParseAddress(value)
+		if err != nil || address.Address != value {
+			return fmt.Errorf("Invalid %s value, expected an email address such as admin@example.com: %s", property, value)
+		}
+	case "letsencrypt-server":
+		validServers := map[string]bool{
+			"prod":       true,
+			"production": true,
+			"stag":       true,
+			"staging":    true,
+		}
+		if !validServers[value] && !isLetsencryptServerURL(value) {
+			return fmt.Errorf("Invalid letsencrypt-server value, expected production, staging, or an https url: %s", value)
+		}
+	case "longhorn-default":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("Invalid longhorn-default value, expected true or false: %s", value)
 		}
-
-		timeoutDuration, err := time.ParseDuration("300s")
-		if err != nil {
-			return fmt.Errorf("Error parsing deploy timeout duration: %w", err)
+	case "longhorn-replica-count":
+		replicaCount, err := strconv.Atoi(value)
+		if err != nil || replicaCount < 1 {
+			return fmt.Errorf("Invalid longhorn-replica-count value, expected a positive integer: %s", value)
 		}
-
-		err = helmAgent.InstallOrUpgradeChart(ctx, ChartInput{
-			ChartPath:   chart.ChartPath,
-			Namespace:   chart.Namespace,
-			ReleaseName: chart.ReleaseName,
-			RepoURL:     chart.RepoURL,
-			Values:      values,
-			Version:     chart.Version,
-			Timeout:     timeoutDuration,
-			Wait:        true,
-		})
-		if err != nil {
-			return fmt.Errorf("Error installing chart %s: %w", chart.ChartPath, err)
+	case "loadbalancer":
+		if value != "servicelb" && value != "metallb" {
+			return fmt.Errorf("Invalid loadbalancer value, expected servicelb or metallb: %s", value)
 		}
-	}
-	return nil
-}
-
-func installHelperCommands(ctx context.Context) error {
-	urls := map[string]string{
-		"kubectx": "https://github.com/ahmetb/kubectx/releases/latest/download/kubectx",
-		"kubens":  "https://github.com/ahmetb/kubectx/releases/latest/download/kubens",
-	}
-
-	client := resty.New()
-	for binaryName, url := range urls {
-		resp, err := client.R().
-			SetContext(ctx).
-			Get(url)
-		if err != nil {
-			return fmt.Errorf("Unable to download %s: %w", binaryName, err)
+	case "max-parallel-image-pulls":
+		maxParallelImagePulls, err := strconv.Atoi(value)
+		if err != nil || maxParallelImagePulls < 1 {
+			return fmt.Errorf("Invalid max-parallel-image-pulls value, expected a positive integer: %s", value)
 		}
-		if resp == nil {
-			return fmt.Errorf("Missing response from %s download: %w", binaryName, err)
+		if err := validateImagePulls(getGlobalSerializeImagePulls(), value); err != nil {
+			return err
 		}
-
-		if resp.StatusCode() != 200 {
-			return fmt.Errorf("Invalid status code for %s: %d", binaryName, resp.StatusCode())
+	case "metallb-address-pool":
+		if _, err := parseMetalLBAddressPool(value); err != nil {
+			return err
 		}
-
-		f, err := os.Create(filepath.Join("/usr/local/bin", binaryName))
-		if err != nil {
-			return fmt.Errorf("Unable to create %s: %w", binaryName, err)
+	case "memory-limit", "memory-request":
+		if _, err := parseMemoryQuantity(value); err != nil {
+			return fmt.Errorf("Invalid %s value, expected a kubernetes quantity such as 512Mi or 1Gi: %s", property, value)
 		}
-
-		if err := f.Close(); err != nil {
-			return fmt.Errorf("Unable to close %s file: %w", binaryName, err)
+	case "token":
+		if len(value) < MinimumTokenLength {
+			return fmt.Errorf("Invalid token value, expected at least %d characters", MinimumTokenLength)
 		}
 
-		err = common.WriteStringToFile(common.WriteStringToFileInput{
-			Content:   resp.String(),
-			Filename:  f.Name(),
-			GroupName: "root",
-			Mode:      os.FileMode(0755),
-			Username:  "root",
-		})
-		if err != nil {
-			return fmt.Errorf("Unable to write %s to file: %w", binaryName, err)
+		uniqueCharacters := map[rune]bool{}
+		for _, character := range value {
+			uniqueCharacters[character] = true
 		}
-
-		fi, err := os.Stat(f.Name())
-		if err != nil {
-			return fmt.Errorf("Unable to get %s file size: %w", binaryName, err)
+		if len(uniqueCharacters) < 8 {
+			return fmt.Errorf("Invalid token value, expected at least 8 distinct characters")
 		}
-
-		if fi.Size() == 0 {
-			return fmt.Errorf("Invalid %s filesize", binaryName)
+	case "spread-check":
+		if value != "warn" && value != "fail" && value != "off" {
+			return fmt.Errorf("Invalid spread-check value, expected warn, fail, or off: %s", value)
+		}
+	case "serialize-image-pulls":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("Invalid serialize-image-pulls value, expected true or false: %s", value)
+		}
+		if err := validateImagePulls(value, getGlobalMaxParallelImagePulls()); err != nil {
+			return err
 		}
 	}
 
-	return installHelm(ctx)
+	return nil
 }
 
-func installHelm(ctx context.Context) error {
-	client := resty.New()
-	resp, err := client.R().
-		SetContext(ctx).
-		Get("https://raw.githubusercontent.com/helm/helm/main/scripts/get-helm-3")
-	if err != nil {
-		return fmt.Errorf("Unable to download helm installer: %w", err)
-	}
-	if resp == nil {
-		return fmt.Errorf("Missing response from helm installer download: %w", err)
+// validateImagePulls returns an error if more than one parallel image pull is allowed while the kubelet serializes image pulls
+func validateImagePulls(serializeImagePulls string, maxParallelImagePulls string) error {
+	maxPulls, err := strconv.Atoi(maxParallelImagePulls)
+	if err != nil || maxPulls <= 1 {
+		return nil
 	}
 
-	if resp.StatusCode() != 200 {
-		return fmt.Errorf("Invalid status code for helm installer script: %d", resp.StatusCode())
+	// the kubelet serializes image pulls unless explicitly told not to
+	if serialize, err := strconv.ParseBool(serializeImagePulls); err == nil && !serialize {
+		return nil
 	}
 
-	f, err := os.CreateTemp("", "sample")
+	return fmt.Errorf("A max-parallel-image-pulls value above 1 requires serialize-image-pulls to be set to false, set it via 'dokku scheduler-k3s:set --global serialize-image-pulls false' or lower max-parallel-image-pulls first")
+}
+
+// validateJoinServer validates that a join server override is an https url with a host and no path
+func validateJoinServer(joinServer string) error {
+	u, err := url.Parse(joinServer)
 	if err != nil {
-		return fmt.Errorf("Unable to create temporary file for helm installer: %w", err)
+		return fmt.Errorf("Invalid join-server value, expected a url such as https://k3s.example.com:6443: %w", err)
 	}
-	defer os.Remove(f.Name())
 
-	if err := f.Close(); err != nil {
-		return fmt.Errorf("Unable to close helm installer file: %w", err)
+	if u.Scheme != "https" || u.Hostname() == "" {
+		return fmt.Errorf("Invalid join-server value, expected a url such as https://k3s.example.com:6443: %s", joinServer)
 	}
 
-	err = common.WriteStringToFile(common.WriteStringToFileInput{
-		Content:  resp.String(),
-		Filename: f.Name(),
-		Mode:     os.FileMode(0755),
-	})
-	if err != nil {
-		return fmt.Errorf("Unable to write helm installer to file: %w", err)
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("Invalid join-server value, expected a url without a path or query: %s", joinServer)
 	}
 
-	fi, err := os.Stat(f.Name())
-	if err != nil {
-		return fmt.Errorf("Unable to get helm installer file size: %w", err)
+	if port := u.Port(); port != "" {
+		if _, err := strconv.Atoi(port); err != nil {
+			return fmt.Errorf("Invalid join-server value, expected a numeric port: %s", joinServer)
+		}
 	}
 
-	if fi.Size() == 0 {
-		return fmt.Errorf("Invalid helm installer filesize")
-	}
+	return nil
+}
 
-	common.LogInfo2Quiet("Running helm installer")
-	installerCmd, err := common.CallExecCommand(common.ExecCommandInput{
-		Command:     f.Name(),
-		StreamStdio: true,
-	})
-	if err != nil {
-		return fmt.Errorf("Unable to call helm installer command: %w", err)
+// validateServerIP validates that a server ip override is either a valid ip address or a resolvable host
+func validateServerIP(serverIP string) error {
+	if net.ParseIP(serverIP) != nil {
+		return nil
 	}
-	if installerCmd.ExitCode != 0 {
-		return fmt.Errorf("Invalid exit code from helm installer command: %d", installerCmd.ExitCode)
+
+	if _, err := net.LookupHost(serverIP); err != nil {
+		return fmt.Errorf("Invalid server-ip value, expected a valid ip address or resolvable host: %s", serverIP)
 	}
 
 	return nil
 }
 
-// isKubernetesAvailable returns an error if kubernetes api is not available
-func isKubernetesAvailable() error {
-	client, err := NewKubernetesClient()
-	if err != nil {
-		return fmt.Errorf("Error creating kubernetes client: %w", err)
+// validateRoutableServerIP returns an error if a server ip address cannot be reached by other nodes
+func validateRoutableServerIP(serverIP string) error {
+	ip := net.ParseIP(serverIP)
+	if ip == nil {
+		return nil
 	}
 
-	if err := client.Ping(); err != nil {
-		return fmt.Errorf("Error pinging kubernetes: %w", err)
+	reason := ""
+	switch {
+	case ip.IsLoopback():
+		reason = "a loopback address"
+	case ip.IsLinkLocalUnicast():
+		reason = "a link-local address"
+	case ip.IsUnspecified():
+		reason = "an unspecified address"
+	case ip.IsMulticast():
+		reason = "a multicast address"
+	}
+	if reason != "" {
+		return fmt.Errorf("Server ip address %s is %s that other nodes cannot reach, specify --server-ip or set the network-interface property to an interface with a routable address", serverIP, reason)
 	}
 
 	return nil
 }
 
-// isK3sInstalled returns an error if k3s is not installed
-func isK3sInstalled() error {
-	if !common.FileExists("/usr/local/bin/k3s") {
-		return fmt.Errorf("k3s binary is not available")
+// checkRemoteServerReachable checks that a remote host can connect to the kubernetes api of the server it will join
+func checkRemoteServerReachable(ctx context.Context, remoteHost string, allowUknownHosts bool, serverURL string) error {
+	curlCmd, err := callRemoteStep(ctx, RemoteCommandTimeout, "curl command over ssh", common.SshCommandInput{
+		Command:          "curl",
+		Args:             []string{"--silent", "--insecure", "--max-time", "10", "--output", "/dev/null", serverURL},
+		AllowUknownHosts: allowUknownHosts,
+		RemoteHost:       remoteHost,
+	})
+	// a non-zero exit means curl ran but could not connect, which is reported separately from a failed remote step
+	if curlCmd.ExitCode > 0 {
+		return fmt.Errorf("Remote host is unable to reach the kubernetes api at %s (curl exit code %d), specify --server-ip or --join-server with an address reachable from the remote host, or set the network-interface property", serverURL, curlCmd.ExitCode)
 	}
-
-	if !common.FileExists(getKubeconfigPath()) {
-		return fmt.Errorf("k3s kubeconfig is not available")
+	if err != nil {
+		return err
 	}
 
 	return nil
 }
 
-// isK3sKubernetes returns true if the current kubernetes cluster is configured to be k3s
-func isK3sKubernetes() bool {
-	return getKubeconfigPath() == KubeConfigPath
-}
+// getCoreComponents returns the core components installed during initialization, skipping those that are disabled
+func getCoreComponents() []CoreComponent {
+	components := []CoreComponent{}
+	if getGlobalInstallCertManager() {
+		components = append(components, CoreComponent{
+			Name:         "cert-manager webhook",
+			Kind:         "deployment",
+			Namespace:    "cert-manager",
+			ResourceName: "cert-manager-webhook",
+		})
+	}
 
-func isPodReady(ctx context.Context, clientset KubernetesClient, podName, namespace string) wait.ConditionWithContextFunc {
-	return func(ctx context.Context) (bool, error) {
-		fmt.Printf(".")
+	if getGlobalInstallLonghorn() {
+		components = append(components, CoreComponent{
+			Name:         "longhorn manager",
+			Kind:         "daemonset",
+			Namespace:    "longhorn-system",
+			ResourceName: "longhorn-manager",
+		})
+	}
 
-		pod, err := clientset.GetPod(ctx, GetPodInput{
-			Name:      podName,
-			Namespace: namespace,
+	components = append(components, CoreComponent{
+		Name:         "system-upgrade-controller",
+		Kind:         "deployment",
+		Namespace:    SystemUpgradeNamespace,
+		ResourceName: "system-upgrade-controller",
+	})
+
+	return components
+}
+
+// isCoreComponentReady returns whether all replicas of a core component are ready, treating a missing workload as not yet ready
+func isCoreComponentReady(ctx context.Context, clientset KubernetesClient, component CoreComponent) (bool, error) {
+	if component.Kind == "daemonset" {
+		daemonSet, err := clientset.GetDaemonSet(ctx, GetDaemonSetInput{
+			Name:      component.ResourceName,
+			Namespace: component.Namespace,
 		})
+		if k8serrors.IsNotFound(err) {
+			return false, nil
+		}
 		if err != nil {
 			return false, err
 		}
 
-		switch pod.Status.Phase {
-		case v1.PodRunning:
-			return true, nil
-		case v1.PodFailed, v1.PodSucceeded:
-			return false, conditions.ErrPodCompleted
-		}
+		common.LogDebug(fmt.Sprintf("Daemonset %s has %d/%d ready pods", component.ResourceName, daemonSet.Status.NumberReady, daemonSet.Status.DesiredNumberScheduled))
+		return daemonSet.Status.DesiredNumberScheduled > 0 && daemonSet.Status.NumberReady >= daemonSet.Status.DesiredNumberScheduled, nil
+	}
+
+	deployment, err := clientset.GetDeployment(ctx, GetDeploymentInput{
+		Name:      component.ResourceName,
+		Namespace: component.Namespace,
+	})
+	if k8serrors.IsNotFound(err) {
 		return false, nil
 	}
+	if err != nil {
+		return false, err
+	}
+
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+
+	common.LogDebug(fmt.Sprintf("Deployment %s has %d/%d ready replicas", component.ResourceName, deployment.Status.ReadyReplicas, replicas))
+	return deployment.Status.ReadyReplicas >= replicas, nil
 }
 
-// kubernetesNodeToNode converts a kubernetes node to a Node
-func kubernetesNodeToNode(node v1.Node) Node {
-	roles := []string{}
-	if len(node.Labels["kubernetes.io/role"]) > 0 {
-		roles = append(roles, node.Labels["kubernetes.io/role"])
-	} else {
-		for k, v := range node.Labels {
-			if strings.HasPrefix(k, "node-role.kubernetes.io/") && v == "true" {
-				roles = append(roles, strings.TrimPrefix(k, "node-role.kubernetes.io/"))
+// waitForCoreComponentsReady waits for the core components to be ready, reporting the components that are still not ready on timeout
+func waitForCoreComponentsReady(ctx context.Context, input WaitForCoreComponentsReadyInput) error {
+	notReady := []string{}
+	err := wait.PollUntilContextTimeout(ctx, 2*time.Second, input.Timeout, true, func(ctx context.Context) (bool, error) {
+		notReady = []string{}
+		for _, component := range input.Components {
+			ready, err := isCoreComponentReady(ctx, input.Clientset, component)
+			if err != nil {
+				return false, fmt.Errorf("Unable to check %s readiness: %w", component.Name, err)
+			}
+			if !ready {
+				notReady = append(notReady, component.Name)
 			}
 		}
+
+		return len(notReady) == 0, nil
+	})
+	if err != nil {
+		if wait.Interrupted(err) && len(notReady) > 0 {
+			return fmt.Errorf("Timed out after %s waiting for core components to become ready, not ready: %s", input.Timeout, strings.Join(notReady, ", "))
+		}
+		return fmt.Errorf("Error waiting for core components to become ready: %w", err)
 	}
 
-	sort.Strings(roles)
+	return nil
+}
 
-	ready := false
-	for _, condition := range node.Status.Conditions {
-		if condition.Type == "Ready" {
-			ready = condition.Status == "True"
-			break
+// waitForAppDeploymentsReady waits for all deployments of an app to have their desired number of ready replicas
+func waitForAppDeploymentsReady(ctx context.Context, input WaitForAppDeploymentsReadyInput) error {
+	err := wait.PollUntilContextTimeout(ctx, time.Second, input.Timeout, true, func(ctx context.Context) (bool, error) {
+		deployments, err := input.Clientset.ListDeployments(ctx, ListDeploymentsInput{
+			Namespace:     input.Namespace,
+			LabelSelector: fmt.Sprintf("app.kubernetes.io/part-of=%s", input.AppName),
+		})
+		if err != nil {
+			return false, err
 		}
-	}
 
-	remoteHost := ""
-	if val, ok := node.Annotations["dokku.com/remote-host"]; ok {
-		remoteHost = val
-	}
+		if len(deployments) == 0 {
+			return false, fmt.Errorf("No deployments found for %s in namespace %s", input.AppName, input.Namespace)
+		}
 
-	return Node{
-		Name:       node.Name,
-		Roles:      roles,
-		Ready:      ready,
-		RemoteHost: remoteHost,
-		Version:    node.Status.NodeInfo.KubeletVersion,
-	}
-}
+		for _, deployment := range deployments {
+			replicas := int32(1)
+			if deployment.Spec.Replicas != nil {
+				replicas = *deployment.Spec.Replicas
+			}
 
-// parseMemoryQuantity parses a string into a valid memory quantity
-func parseMemoryQuantity(input string) (string, error) {
-	if _, err := strconv.ParseInt(input, 10, 64); err == nil {
-		input = fmt.Sprintf("%sMi", input)
-	}
-	quantity, err := resource.ParseQuantity(input)
+			if deployment.Status.ReadyReplicas < replicas {
+				common.LogDebug(fmt.Sprintf("Deployment %s has %d/%d ready replicas", deployment.Name, deployment.Status.ReadyReplicas, replicas))
+				return false, nil
+			}
+		}
+
+		return true, nil
+	})
 	if err != nil {
-		return "", err
+		return fmt.Errorf("Error waiting for %s to be ready: %w", input.AppName, err)
 	}
 
-	return quantity.String(), nil
-}
-
-func uninstallHelperCommands(ctx context.Context) error {
-	errs, _ := errgroup.WithContext(ctx)
-	errs.Go(func() error {
-		return os.RemoveAll("/usr/local/bin/kubectx")
-	})
-	errs.Go(func() error {
-		return os.RemoveAll("/usr/local/bin/kubens")
-	})
-	return errs.Wait()
+	return nil
 }
 
 func waitForPodBySelectorRunning(ctx context.Context, input WaitForPodBySelectorRunningInput) error {
@@ -1687,35 +7310,132 @@ func waitForPodBySelectorRunning(ctx context.Context, input WaitForPodBySelector
 	return nil
 }
 
+// waitForNodeToExist polls the cluster with exponential backoff until the node exists
 func waitForNodeToExist(ctx context.Context, input WaitForNodeToExistInput) ([]v1.Node, error) {
-	var matchingNodes []v1.Node
-	var err error
-	for i := 0; i < input.RetryCount; i++ {
+	backoff := input.Backoff
+	if backoff <= 0 {
+		backoff = 1 * time.Second
+	}
+
+	maxBackoff := input.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = 10 * time.Second
+	}
+
+	retryCount := input.RetryCount
+	if retryCount <= 0 && input.Timeout <= 0 {
+		retryCount = 20
+	}
+
+	if input.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, input.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	lastNodeCount := 0
+	var lastErr error
+	timeoutError := func() error {
+		waited := time.Since(start).Round(time.Second)
+		if lastErr != nil {
+			return fmt.Errorf("Timed out after %s waiting for node %s to exist, last observed %d nodes: %w", waited, input.NodeName, lastNodeCount, lastErr)
+		}
+		return fmt.Errorf("Timed out after %s waiting for node %s to exist, last observed %d nodes", waited, input.NodeName, lastNodeCount)
+	}
+
+	for attempt := 0; retryCount <= 0 || attempt < retryCount; attempt++ {
 		nodes, err := input.Clientset.ListNodes(ctx, ListNodesInput{})
-		if err != nil {
-			time.Sleep(1 * time.Second)
+		lastErr = err
+		if err == nil {
+			lastNodeCount = len(nodes)
+			if input.NodeName == "" {
+				return nodes, nil
+			}
+
+			for _, node := range nodes {
+				if node.Name == input.NodeName {
+					return []v1.Node{node}, nil
+				}
+			}
 		}
 
-		if input.NodeName == "" {
-			matchingNodes = nodes
-			break
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return []v1.Node{}, fmt.Errorf("Cancelled waiting for node to exist: %w", ctx.Err())
+			}
+			return []v1.Node{}, timeoutError()
+		case <-time.After(backoff):
 		}
 
-		for _, node := range nodes {
-			if node.Name == input.NodeName {
-				matchingNodes = append(matchingNodes, node)
-				break
+		backoff = min(backoff*2, maxBackoff)
+	}
+
+	return []v1.Node{}, timeoutError()
+}
+
+// waitForNodeReady waits for a node to report the Ready condition, returning the node's condition messages on timeout
+func waitForNodeReady(ctx context.Context, input WaitForNodeReadyInput) error {
+	backoff := input.Backoff
+	if backoff <= 0 {
+		backoff = 1 * time.Second
+	}
+
+	maxBackoff := input.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = 10 * time.Second
+	}
+
+	if input.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, input.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	conditions := []string{}
+	var lastErr error
+	for {
+		node, err := input.Clientset.Client.CoreV1().Nodes().Get(ctx, input.NodeName, metav1.GetOptions{})
+		lastErr = err
+		if err == nil {
+			conditions = []string{}
+			for _, condition := range node.Status.Conditions {
+				if condition.Type == v1.NodeReady && condition.Status == v1.ConditionTrue {
+					return nil
+				}
+
+				// only the Ready condition is healthy when true
+				unhealthy := condition.Status == v1.ConditionTrue
+				if condition.Type == v1.NodeReady {
+					unhealthy = true
+				}
+				if unhealthy {
+					conditions = append(conditions, fmt.Sprintf("%s=%s (%s: %s)", condition.Type, condition.Status, condition.Reason, condition.Message))
+				}
 			}
 		}
-		if len(matchingNodes) > 0 {
-			break
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return fmt.Errorf("Cancelled waiting for node to become ready: %w", ctx.Err())
+			}
+
+			waited := time.Since(start).Round(time.Second)
+			if len(conditions) > 0 {
+				return fmt.Errorf("Timed out after %s waiting for node %s to become ready, node conditions: %s", waited, input.NodeName, strings.Join(conditions, "; "))
+			}
+			if lastErr != nil {
+				return fmt.Errorf("Timed out after %s waiting for node %s to become ready: %w", waited, input.NodeName, lastErr)
+			}
+			return fmt.Errorf("Timed out after %s waiting for node %s to become ready", waited, input.NodeName)
+		case <-time.After(backoff):
 		}
-		time.Sleep(1 * time.Second)
-	}
-	if err != nil {
-		return matchingNodes, fmt.Errorf("Error listing nodes: %w", err)
+
+		backoff = min(backoff*2, maxBackoff)
 	}
-	return matchingNodes, nil
 }
 
 func waitForPodToExist(ctx context.Context, input WaitForPodToExistInput) ([]v1.Pod, error) {
@@ -0,0 +1,919 @@
+package scheduler_k3s
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dokku/dokku/plugins/common"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v3"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// setupTestProperties points the property store at a temporary directory, writing the given global properties into it
+func setupTestProperties(t *testing.T, properties map[string]string) {
+	root := t.TempDir()
+	t.Setenv("DOKKU_LIB_ROOT", root)
+
+	propertyPath := filepath.Join(root, "config", "scheduler-k3s", "--global")
+	Expect(os.MkdirAll(propertyPath, 0755)).To(Succeed())
+	for property, value := range properties {
+		Expect(os.WriteFile(filepath.Join(propertyPath, property), []byte(value), 0644)).To(Succeed())
+	}
+}
+
+func testNode(name string, labels map[string]string) v1.Node {
+	return v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+	}
+}
+
+func TestGetNodeRoles(t *testing.T) {
+	RegisterTestingT(t)
+	setupTestProperties(t, map[string]string{
+		"node.recorded-server.role": "server",
+		"node.recorded-worker.role": "worker",
+	})
+
+	tests := []struct {
+		name     string
+		node     v1.Node
+		expected []string
+	}{
+		{
+			name: "k3s server labels",
+			node: testNode("server-1", map[string]string{
+				"node-role.kubernetes.io/control-plane": "true",
+				"node-role.kubernetes.io/etcd":          "true",
+				"node-role.kubernetes.io/master":        "true",
+			}),
+			expected: []string{"control-plane", "etcd", "master"},
+		},
+		{
+			name: "kubernetes.io/role merged with node-role labels",
+			node: testNode("worker-1", map[string]string{
+				"kubernetes.io/role":           "worker",
+				"node-role.kubernetes.io/gpu":  "true",
+				"node-role.kubernetes.io/edge": "",
+			}),
+			expected: []string{"edge", "gpu", "worker"},
+		},
+		{
+			name: "duplicate roles are listed once",
+			node: testNode("worker-2", map[string]string{
+				"kubernetes.io/role":             "worker",
+				"node-role.kubernetes.io/worker": "true",
+			}),
+			expected: []string{"worker"},
+		},
+		{
+			name: "false role labels are ignored",
+			node: testNode("worker-3", map[string]string{
+				"node-role.kubernetes.io/control-plane": "false",
+			}),
+			expected: []string{"unknown"},
+		},
+		{
+			name:     "unlabeled node recorded as a server",
+			node:     testNode("recorded-server", nil),
+			expected: []string{"control-plane"},
+		},
+		{
+			name:     "unlabeled node recorded as a worker",
+			node:     testNode("recorded-worker", nil),
+			expected: []string{"worker"},
+		},
+		{
+			name:     "unlabeled node without a recorded role",
+			node:     testNode("unrecorded", nil),
+			expected: []string{"unknown"},
+		},
+	}
+
+	for _, test := range tests {
+		Expect(getNodeRoles(test.node)).To(Equal(test.expected), test.name)
+	}
+}
+
+func TestGetNodeRole(t *testing.T) {
+	RegisterTestingT(t)
+	setupTestProperties(t, map[string]string{
+		"node.recorded-server.role": "server",
+		"node.recorded-worker.role": "worker",
+	})
+
+	tests := []struct {
+		name     string
+		node     v1.Node
+		expected string
+	}{
+		{
+			name: "control-plane label",
+			node: testNode("server-1", map[string]string{
+				"node-role.kubernetes.io/control-plane": "true",
+			}),
+			expected: "server",
+		},
+		{
+			name: "legacy master label",
+			node: testNode("server-2", map[string]string{
+				"node-role.kubernetes.io/master": "true",
+			}),
+			expected: "server",
+		},
+		{
+			name: "control-plane label without a true value",
+			node: testNode("worker-1", map[string]string{
+				"node-role.kubernetes.io/control-plane": "",
+			}),
+			expected: "worker",
+		},
+		{
+			name:     "unlabeled node",
+			node:     testNode("worker-2", nil),
+			expected: "worker",
+		},
+		{
+			name:     "recorded server role",
+			node:     testNode("recorded-server", nil),
+			expected: "server",
+		},
+		{
+			name: "recorded role takes precedence over labels",
+			node: testNode("recorded-worker", map[string]string{
+				"node-role.kubernetes.io/control-plane": "true",
+			}),
+			expected: "worker",
+		},
+	}
+
+	for _, test := range tests {
+		Expect(getNodeRole(test.node)).To(Equal(test.expected), test.name)
+	}
+}
+
+func TestParseMetalLBAddressPool(t *testing.T) {
+	RegisterTestingT(t)
+
+	tests := []struct {
+		name     string
+		value    string
+		expected []string
+		err      bool
+	}{
+		{
+			name:     "cidr",
+			value:    "192.168.1.240/28",
+			expected: []string{"192.168.1.240/28"},
+		},
+		{
+			name:     "range with whitespace",
+			value:    " 192.168.1.240 - 192.168.1.250 ",
+			expected: []string{"192.168.1.240-192.168.1.250"},
+		},
+		{
+			name:     "mixed entries skipping empty ones",
+			value:    "10.0.0.0/30,,fd00::10-fd00::20",
+			expected: []string{"10.0.0.0/30", "fd00::10-fd00::20"},
+		},
+		{
+			name:  "empty value",
+			value: " , ",
+			err:   true,
+		},
+		{
+			name:  "invalid cidr",
+			value: "192.168.1.300/28",
+			err:   true,
+		},
+		{
+			name:  "single address",
+			value: "192.168.1.240",
+			err:   true,
+		},
+		{
+			name:  "invalid range address",
+			value: "192.168.1.240-example.com",
+			err:   true,
+		},
+		{
+			name:  "mixed ip families",
+			value: "192.168.1.240-fd00::20",
+			err:   true,
+		},
+		{
+			name:  "reversed range",
+			value: "192.168.1.250-192.168.1.240",
+			err:   true,
+		},
+	}
+
+	for _, test := range tests {
+		addresses, err := parseMetalLBAddressPool(test.value)
+		if test.err {
+			Expect(err).To(HaveOccurred(), test.name)
+			continue
+		}
+
+		Expect(err).NotTo(HaveOccurred(), test.name)
+		Expect(addresses).To(Equal(test.expected), test.name)
+	}
+}
+
+func TestParseDefaultRouteInterface(t *testing.T) {
+	RegisterTestingT(t)
+
+	ipv4Routes := strings.Join([]string{
+		"Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT",
+		"wg0\t00000000\t00000000\t0001\t0\t0\t0\t00000000\t0\t0\t0",
+		"eth0\t00000000\t0101A8C0\t0003\t0\t0\t100\t00000000\t0\t0\t0",
+		"eth1\t00000000\t01010A0A\t0003\t0\t0\t50\t00000000\t0\t0\t0",
+		"eth2\t00000000\t01020A0A\t0002\t0\t0\t10\t00000000\t0\t0\t0",
+		"eth0\t0001A8C0\t00000000\t0001\t0\t0\t0\t00FFFFFF\t0\t0\t0",
+	}, "\n")
+
+	ipv6Routes := strings.Join([]string{
+		"00000000000000000000000000000000 00 00000000000000000000000000000000 00 fe800000000000000000000000000001 00000400 00000001 00000000 00000003 eth1",
+		"00000000000000000000000000000000 00 00000000000000000000000000000000 00 fe800000000000000000000000000002 00000100 00000001 00000000 00000003 eth2",
+		"00000000000000000000000000000000 00 00000000000000000000000000000000 00 00000000000000000000000000000000 00000001 00000001 00000000 00000201 eth3",
+		"00000000000000000000000000000000 00 00000000000000000000000000000000 00 fe800000000000000000000000000003 00000000 00000001 00000000 00000003 tun0",
+		"fd000000000000000000000000000000 40 00000000000000000000000000000000 00 00000000000000000000000000000000 00000000 00000001 00000000 00000001 eth4",
+		"00000000000000000000000000000000 00 00000000000000000000000000000000 00 00000000000000000000000000000000 ffffffff 00000001 00000000 00200200 lo",
+	}, "\n")
+
+	tests := []struct {
+		name     string
+		contents string
+		family   string
+		expected string
+	}{
+		{
+			name:     "lowest metric ipv4 default route, skipping vpn and down routes",
+			contents: ipv4Routes,
+			family:   "ipv4",
+			expected: "eth1",
+		},
+		{
+			name:     "lowest metric ipv6 default route, skipping vpn and reject routes",
+			contents: ipv6Routes,
+			family:   "ipv6",
+			expected: "eth2",
+		},
+		{
+			name:     "ipv6 routes are not parsed as ipv4",
+			contents: ipv6Routes,
+			family:   "ipv4",
+			expected: "",
+		},
+		{
+			name:     "no default route",
+			contents: "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\neth0\t0001A8C0\t00000000\t0001\t0\t0\t0\t00FFFFFF\t0\t0\t0",
+			family:   "ipv4",
+			expected: "",
+		},
+	}
+
+	for _, test := range tests {
+		Expect(parseDefaultRouteInterface(test.contents, test.family)).To(Equal(test.expected), test.name)
+	}
+}
+
+func TestGetAirgapFiles(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupTestProperties(t, map[string]string{})
+	Expect(getAirgapFiles()).To(BeEmpty(), "no staged files")
+
+	setupTestProperties(t, map[string]string{
+		"k3s-binary-path":        "/opt/k3s/k3s",
+		"k3s-airgap-images-path": "/opt/k3s/k3s-airgap-images-amd64.tar.zst",
+		"data-dir":               "/mnt/k3s",
+	})
+	Expect(getAirgapFiles()).To(Equal([]AirgapFile{
+		{Source: "/opt/k3s/k3s", Destination: K3sBinaryPath},
+		{Source: "/opt/k3s/k3s-airgap-images-amd64.tar.zst", Destination: "/mnt/k3s/agent/images/k3s-airgap-images-amd64.tar.zst"},
+	}), "staged binary and images")
+}
+
+func TestValidateK3sInstallerContents(t *testing.T) {
+	RegisterTestingT(t)
+
+	tests := []struct {
+		name     string
+		contents string
+		err      bool
+	}{
+		{
+			name:     "shell script",
+			contents: "#!/bin/sh\nset -e\necho installing\n",
+		},
+		{
+			name:     "empty file",
+			contents: "",
+			err:      true,
+		},
+		{
+			name:     "html error page",
+			contents: "<html><body>502 Bad Gateway</body></html>\n",
+			err:      true,
+		},
+		{
+			name:     "windows line endings",
+			contents: "#!/bin/sh\r\nset -e\r\n",
+			err:      true,
+		},
+	}
+
+	for _, test := range tests {
+		err := validateK3sInstallerContents([]byte(test.contents))
+		if test.err {
+			Expect(err).To(HaveOccurred(), test.name)
+		} else {
+			Expect(err).NotTo(HaveOccurred(), test.name)
+		}
+	}
+}
+
+func TestValidateImagePulls(t *testing.T) {
+	RegisterTestingT(t)
+
+	tests := []struct {
+		name                  string
+		serializeImagePulls   string
+		maxParallelImagePulls string
+		err                   bool
+	}{
+		{
+			name:                  "defaults",
+			serializeImagePulls:   "",
+			maxParallelImagePulls: "",
+		},
+		{
+			name:                  "single pull with default serialization",
+			serializeImagePulls:   "",
+			maxParallelImagePulls: "1",
+		},
+		{
+			name:                  "parallel pulls with default serialization",
+			serializeImagePulls:   "",
+			maxParallelImagePulls: "3",
+			err:                   true,
+		},
+		{
+			name:                  "parallel pulls while serializing",
+			serializeImagePulls:   "true",
+			maxParallelImagePulls: "3",
+			err:                   true,
+		},
+		{
+			name:                  "parallel pulls without serialization",
+			serializeImagePulls:   "false",
+			maxParallelImagePulls: "3",
+		},
+	}
+
+	for _, test := range tests {
+		err := validateImagePulls(test.serializeImagePulls, test.maxParallelImagePulls)
+		if test.err {
+			Expect(err).To(HaveOccurred(), test.name)
+		} else {
+			Expect(err).NotTo(HaveOccurred(), test.name)
+		}
+	}
+}
+
+func TestValidateRoutableServerIP(t *testing.T) {
+	RegisterTestingT(t)
+
+	tests := []struct {
+		name     string
+		serverIP string
+		err      bool
+	}{
+		{
+			name:     "private ipv4 address",
+			serverIP: "10.0.0.5",
+		},
+		{
+			name:     "global ipv6 address",
+			serverIP: "2001:db8::5",
+		},
+		{
+			name:     "hostname is left to other checks",
+			serverIP: "server-1.example.com",
+		},
+		{
+			name:     "ipv4 loopback",
+			serverIP: "127.0.0.1",
+			err:      true,
+		},
+		{
+			name:     "ipv6 loopback",
+			serverIP: "::1",
+			err:      true,
+		},
+		{
+			name:     "link-local",
+			serverIP: "169.254.10.20",
+			err:      true,
+		},
+		{
+			name:     "ipv6 link-local",
+			serverIP: "fe80::1",
+			err:      true,
+		},
+		{
+			name:     "unspecified",
+			serverIP: "0.0.0.0",
+			err:      true,
+		},
+		{
+			name:     "multicast",
+			serverIP: "224.0.0.1",
+			err:      true,
+		},
+	}
+
+	for _, test := range tests {
+		err := validateRoutableServerIP(test.serverIP)
+		if test.err {
+			Expect(err).To(HaveOccurred(), test.name)
+		} else {
+			Expect(err).NotTo(HaveOccurred(), test.name)
+		}
+	}
+}
+
+func TestClampRequestToLimit(t *testing.T) {
+	RegisterTestingT(t)
+
+	tests := map[string]struct {
+		request  string
+		limit    string
+		explicit bool
+		expected string
+		err      bool
+	}{
+		"no limit":                   {request: "100m", limit: "", expected: "100m"},
+		"no request":                 {request: "", limit: "50m", expected: ""},
+		"limit above request":        {request: "100m", limit: "1", expected: "100m"},
+		"limit equal to request":     {request: "128Mi", limit: "128Mi", expected: "128Mi"},
+		"limit below default":        {request: "100m", limit: "50m", expected: "50m"},
+		"limit below explicit":       {request: "250m", limit: "50m", explicit: true, err: true},
+		"memory limit below default": {request: "128Mi", limit: "64Mi", expected: "64Mi"},
+	}
+
+	for name, test := range tests {
+		request, err := clampRequestToLimit("cpu", test.request, test.limit, test.explicit)
+		if test.err {
+			Expect(err).To(HaveOccurred(), name)
+			continue
+		}
+
+		Expect(err).NotTo(HaveOccurred(), name)
+		Expect(request).To(Equal(test.expected), name)
+	}
+}
+
+func TestGetLetsencryptIssuerName(t *testing.T) {
+	RegisterTestingT(t)
+
+	customServer := "https://acme.example.com/directory"
+	tests := map[string]struct {
+		server       string
+		globalServer string
+		expected     string
+		err          bool
+	}{
+		"production name":     {server: "production", globalServer: "prod", expected: "letsencrypt-prod"},
+		"staging name":        {server: "stag", globalServer: "prod", expected: "letsencrypt-stag"},
+		"production url":      {server: LetsencryptProdServer, globalServer: "prod", expected: "letsencrypt-prod"},
+		"staging url":         {server: LetsencryptStagServer, globalServer: "prod", expected: "letsencrypt-stag"},
+		"global custom url":   {server: customServer, globalServer: customServer, expected: LetsencryptCustomIssuerName},
+		"unknown custom url":  {server: customServer, globalServer: "prod", err: true},
+		"http url":            {server: "http://acme.example.com/directory", globalServer: "http://acme.example.com/directory", err: true},
+		"unknown server name": {server: "development", globalServer: "prod", err: true},
+	}
+
+	for name, test := range tests {
+		issuerName, err := getLetsencryptIssuerName(test.server, test.globalServer)
+		if test.err {
+			Expect(err).To(HaveOccurred(), name)
+			continue
+		}
+
+		Expect(err).NotTo(HaveOccurred(), name)
+		Expect(issuerName).To(Equal(test.expected), name)
+	}
+}
+
+func TestFormatMemoryQuantity(t *testing.T) {
+	RegisterTestingT(t)
+
+	tests := map[string]string{
+		"4Gi":        "4.0Gi",
+		"512Mi":      "0.5Gi",
+		"16302872Ki": "15.5Gi",
+		"1G":         "0.9Gi",
+	}
+
+	for quantity, expected := range tests {
+		Expect(formatMemoryQuantity(resource.MustParse(quantity))).To(Equal(expected), quantity)
+	}
+}
+
+func TestGetHostnameMaxSkew(t *testing.T) {
+	RegisterTestingT(t)
+
+	tests := map[string]struct {
+		spec     v1.PodSpec
+		expected int
+	}{
+		"default": {
+			spec:     v1.PodSpec{},
+			expected: DefaultHostnameMaxSkew,
+		},
+		"hostname spread constraint": {
+			spec: v1.PodSpec{
+				TopologySpreadConstraints: []v1.TopologySpreadConstraint{
+					{MaxSkew: 1, TopologyKey: v1.LabelTopologyZone},
+					{MaxSkew: 2, TopologyKey: v1.LabelHostname},
+				},
+			},
+			expected: 2,
+		},
+		"zone spread constraint only": {
+			spec: v1.PodSpec{
+				TopologySpreadConstraints: []v1.TopologySpreadConstraint{
+					{MaxSkew: 1, TopologyKey: v1.LabelTopologyZone},
+				},
+			},
+			expected: DefaultHostnameMaxSkew,
+		},
+		"preferred anti-affinity": {
+			spec: v1.PodSpec{
+				Affinity: &v1.Affinity{
+					PodAntiAffinity: &v1.PodAntiAffinity{
+						PreferredDuringSchedulingIgnoredDuringExecution: []v1.WeightedPodAffinityTerm{
+							{Weight: 100, PodAffinityTerm: v1.PodAffinityTerm{TopologyKey: v1.LabelHostname}},
+						},
+					},
+				},
+			},
+			expected: 1,
+		},
+	}
+
+	for name, test := range tests {
+		Expect(getHostnameMaxSkew(test.spec)).To(Equal(test.expected), name)
+	}
+}
+
+func TestGetNodeSkew(t *testing.T) {
+	RegisterTestingT(t)
+
+	tests := map[string]struct {
+		distribution map[string]int
+		expected     int
+	}{
+		"empty":    {distribution: map[string]int{}, expected: 0},
+		"even":     {distribution: map[string]int{"a": 2, "b": 2}, expected: 0},
+		"uneven":   {distribution: map[string]int{"a": 3, "b": 1}, expected: 2},
+		"unused":   {distribution: map[string]int{"a": 4, "b": 0, "c": 0}, expected: 4},
+		"one node": {distribution: map[string]int{"a": 5}, expected: 0},
+	}
+
+	for name, test := range tests {
+		Expect(getNodeSkew(test.distribution)).To(Equal(test.expected), name)
+	}
+}
+
+// clearProxyEnv unsets the proxy environment variables for the duration of a test
+func clearProxyEnv(t *testing.T) {
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY"} {
+		t.Setenv(name, "")
+		t.Setenv(strings.ToLower(name), "")
+	}
+}
+
+func TestGetProxyValue(t *testing.T) {
+	RegisterTestingT(t)
+	clearProxyEnv(t)
+
+	Expect(getProxyValue("", "HTTP_PROXY")).To(Equal(""))
+
+	t.Setenv("http_proxy", "http://lower.example.com:3128")
+	Expect(getProxyValue("", "HTTP_PROXY")).To(Equal("http://lower.example.com:3128"))
+
+	t.Setenv("HTTP_PROXY", "http://upper.example.com:3128")
+	Expect(getProxyValue("", "HTTP_PROXY")).To(Equal("http://upper.example.com:3128"))
+
+	Expect(getProxyValue("http://property.example.com:3128", "HTTP_PROXY")).To(Equal("http://property.example.com:3128"))
+}
+
+func TestWithProxyEnv(t *testing.T) {
+	RegisterTestingT(t)
+	clearProxyEnv(t)
+	setupTestProperties(t, map[string]string{})
+
+	command := []string{"curl", "-o", "/tmp/k3s-installer.sh", "https://get.k3s.io"}
+	Expect(withProxyEnv(command)).To(Equal(command), "no proxy configured")
+
+	setupTestProperties(t, map[string]string{
+		"http-proxy": "http://proxy.example.com:3128",
+		"no-proxy":   "localhost,10.0.0.0/8",
+	})
+	t.Setenv("HTTPS_PROXY", "http://env.example.com:3128")
+
+	proxyArgs := []string{
+		"HTTPS_PROXY=http://env.example.com:3128",
+		"HTTP_PROXY=http://proxy.example.com:3128",
+		"NO_PROXY=localhost,10.0.0.0/8",
+		"http_proxy=http://proxy.example.com:3128",
+		"https_proxy=http://env.example.com:3128",
+		"no_proxy=localhost,10.0.0.0/8",
+	}
+
+	Expect(withProxyEnv(command)).To(Equal(append(append([]string{"env"}, proxyArgs...), command...)), "plain command")
+
+	installCommand := []string{"env", "INSTALL_K3S_VERSION=v1.30.2+k3s1", "/tmp/k3s-installer.sh"}
+	Expect(withProxyEnv(installCommand)).To(Equal(append(append([]string{"env"}, proxyArgs...), installCommand[1:]...)), "existing env invocation")
+}
+
+func TestRenderRegistryConfig(t *testing.T) {
+	RegisterTestingT(t)
+
+	contents, err := renderRegistryConfig([]RegistryMirror{
+		{
+			Registry:       "docker.io",
+			Endpoint:       "https://mirror.example.com",
+			ExtraEndpoints: []string{"https://dockerhub-cache.example.com"},
+		},
+		{
+			Registry: "123456789012.dkr.ecr.us-east-1.amazonaws.com",
+			Endpoint: "https://ecr-cache.example.com:8443/v2",
+			Username: "AWS",
+			Password: "secret",
+		},
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	config := map[string]interface{}{}
+	Expect(yaml.Unmarshal(contents, &config)).To(Succeed())
+	Expect(config).To(Equal(map[string]interface{}{
+		"mirrors": map[string]interface{}{
+			"docker.io": map[string]interface{}{
+				"endpoint": []interface{}{"https://mirror.example.com", "https://dockerhub-cache.example.com"},
+			},
+			"123456789012.dkr.ecr.us-east-1.amazonaws.com": map[string]interface{}{
+				"endpoint": []interface{}{"https://ecr-cache.example.com:8443/v2"},
+			},
+		},
+		"configs": map[string]interface{}{
+			"ecr-cache.example.com:8443": map[string]interface{}{
+				"auth": map[string]interface{}{
+					"username": "AWS",
+					"password": "secret",
+				},
+			},
+		},
+	}))
+
+	contents, err = renderRegistryConfig([]RegistryMirror{
+		{
+			Registry: "docker.io",
+			Endpoint: "https://mirror.example.com",
+		},
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	config = map[string]interface{}{}
+	Expect(yaml.Unmarshal(contents, &config)).To(Succeed())
+	Expect(config).NotTo(HaveKey("configs"), "mirrors without credentials")
+}
+
+func TestRegistryMirrorString(t *testing.T) {
+	RegisterTestingT(t)
+
+	tests := map[string]struct {
+		mirror   RegistryMirror
+		expected string
+	}{
+		"no credentials": {
+			mirror:   RegistryMirror{Registry: "docker.io", Endpoint: "https://mirror.example.com", ExtraEndpoints: []string{"https://cache.example.com"}},
+			expected: "docker.io=https://mirror.example.com,https://cache.example.com",
+		},
+		"credentials": {
+			mirror:   RegistryMirror{Registry: "registry.example.com", Endpoint: "https://cache.example.com", Username: "AWS", Password: "secret"},
+			expected: "registry.example.com=https://cache.example.com(AWS:********)",
+		},
+	}
+
+	for name, test := range tests {
+		Expect(test.mirror.String()).To(Equal(test.expected), name)
+		Expect(test.mirror.String()).NotTo(ContainSubstring("secret"), name)
+	}
+}
+
+func TestCompareK3sVersions(t *testing.T) {
+	RegisterTestingT(t)
+
+	tests := []struct {
+		a        string
+		b        string
+		expected int
+	}{
+		{a: "v1.30.2+k3s1", b: "v1.30.2+k3s1", expected: 0},
+		{a: "v1.30.2+k3s1", b: "v1.30.2+k3s2", expected: -1},
+		{a: "v1.30.3+k3s1", b: "v1.30.2+k3s2", expected: 1},
+		{a: "v1.29.10+k3s1", b: "v1.30.1+k3s1", expected: -1},
+		{a: "v1.30.10+k3s1", b: "v1.30.9+k3s1", expected: 1},
+		{a: "v2.0.0+k3s1", b: "v1.99.99+k3s9", expected: 1},
+		{a: "v1.30.2-rc1+k3s1", b: "v1.30.2+k3s1", expected: -1},
+		{a: "v1.30.2-rc2+k3s1", b: "v1.30.2-rc1+k3s1", expected: 1},
+		{a: "v1.30.2-rc1+k3s1", b: "v1.30.1+k3s1", expected: 1},
+	}
+
+	for _, test := range tests {
+		result, err := compareK3sVersions(test.a, test.b)
+		Expect(err).NotTo(HaveOccurred(), test.a)
+		Expect(result).To(Equal(test.expected), fmt.Sprintf("%s compared to %s", test.a, test.b))
+	}
+
+	for _, version := range []string{"", "1.30.2+k3s1", "v1.30.2", "v1.30+k3s1", "latest"} {
+		_, err := compareK3sVersions(version, "v1.30.2+k3s1")
+		Expect(err).To(HaveOccurred(), version)
+
+		_, err = compareK3sVersions("v1.30.2+k3s1", version)
+		Expect(err).To(HaveOccurred(), version)
+	}
+}
+
+func TestGetK3sUpgradePlans(t *testing.T) {
+	RegisterTestingT(t)
+
+	tests := map[string]struct {
+		preparePlan string
+		waitsFor    string
+	}{
+		"workers-first": {preparePlan: "k3s-server", waitsFor: "k3s-worker"},
+		"servers-first": {preparePlan: "k3s-worker", waitsFor: "k3s-server"},
+	}
+
+	for order, test := range tests {
+		plans := getK3sUpgradePlans("v1.30.2+k3s1", 3, true, order)
+		Expect(plans).To(HaveLen(2), order)
+
+		for _, plan := range plans {
+			if plan.Name == "k3s-server" {
+				Expect(plan.Spec["concurrency"]).To(Equal(int64(1)), order)
+			}
+
+			if plan.Name != test.preparePlan {
+				Expect(plan.Spec).NotTo(HaveKey("prepare"), order)
+				continue
+			}
+
+			Expect(plan.Spec["prepare"]).To(HaveKeyWithValue("args", []interface{}{"prepare", test.waitsFor}), order)
+		}
+	}
+}
+
+func TestParseComponentArgs(t *testing.T) {
+	RegisterTestingT(t)
+
+	tests := []struct {
+		name     string
+		value    string
+		expected []string
+		err      bool
+	}{
+		{
+			name:     "empty value",
+			value:    "",
+			expected: []string{},
+		},
+		{
+			name:     "single flag",
+			value:    "max-pods=250",
+			expected: []string{"max-pods=250"},
+		},
+		{
+			name:     "multiple flags with whitespace",
+			value:    "max-pods=250, eviction-hard=memory.available<5%",
+			expected: []string{"max-pods=250", "eviction-hard=memory.available<5%"},
+		},
+		{
+			name:     "value containing an equals sign",
+			value:    "feature-gates=GracefulNodeShutdown=true",
+			expected: []string{"feature-gates=GracefulNodeShutdown=true"},
+		},
+		{
+			name:     "empty value is allowed",
+			value:    "node-ip=",
+			expected: []string{"node-ip="},
+		},
+		{
+			name:  "missing value",
+			value: "max-pods",
+			err:   true,
+		},
+		{
+			name:  "missing key",
+			value: "=250",
+			err:   true,
+		},
+		{
+			name:  "trailing comma",
+			value: "max-pods=250,",
+			err:   true,
+		},
+	}
+
+	for _, test := range tests {
+		args, err := parseComponentArgs("kubelet-args", test.value)
+		if test.err {
+			Expect(err).To(HaveOccurred(), test.name)
+			continue
+		}
+
+		Expect(err).NotTo(HaveOccurred(), test.name)
+		Expect(args).To(Equal(test.expected), test.name)
+	}
+}
+
+func TestSortNodes(t *testing.T) {
+	RegisterTestingT(t)
+
+	tests := map[string][]string{
+		"":        {"node-a", "node-b", "node-c"},
+		"name":    {"node-a", "node-b", "node-c"},
+		"ready":   {"node-b", "node-c", "node-a"},
+		"role":    {"node-b", "node-c", "node-a"},
+		"version": {"node-b", "node-c", "node-a"},
+	}
+
+	for sortBy, expected := range tests {
+		nodes := []Node{
+			{Name: "node-c", Ready: true, Roles: []string{"control-plane", "etcd", "master"}, Version: "v1.30.2+k3s1"},
+			{Name: "node-a", Ready: false, Roles: []string{"worker"}, Version: "v1.30.10+k3s1"},
+			{Name: "node-b", Ready: true, Roles: []string{"control-plane", "etcd", "master"}, Version: "v1.30.2+k3s1"},
+		}
+		sortNodes(nodes, sortBy)
+
+		names := []string{}
+		for _, node := range nodes {
+			names = append(names, node.Name)
+		}
+		Expect(names).To(Equal(expected), sortBy)
+	}
+}
+
+func TestRemoteStepError(t *testing.T) {
+	RegisterTestingT(t)
+
+	tests := []struct {
+		name          string
+		result        common.SshResult
+		err           error
+		commandFailed bool
+		sshFailed     bool
+	}{
+		{
+			name:          "remote non-zero exit",
+			result:        common.SshResult{ExitCode: 100, Stderr: "E: Unable to locate package"},
+			err:           errors.New("E: Unable to locate package"),
+			commandFailed: true,
+		},
+		{
+			name:      "ssh failure",
+			result:    common.SshResult{},
+			err:       errors.New("ssh: handshake failed"),
+			sshFailed: true,
+		},
+		{
+			name:   "success",
+			result: common.SshResult{Stdout: "ok"},
+		},
+	}
+
+	for _, test := range tests {
+		err := remoteStepError("apt-get install command over ssh", test.result, test.err)
+		if !test.commandFailed && !test.sshFailed {
+			Expect(err).NotTo(HaveOccurred(), test.name)
+			continue
+		}
+
+		Expect(errors.Is(err, ErrCommandFailed)).To(Equal(test.commandFailed), test.name)
+		Expect(errors.Is(err, ErrSshFailed)).To(Equal(test.sshFailed), test.name)
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"helm.sh/helm/v3/pkg/cli"
 	"helm.sh/helm/v3/pkg/getter"
 	"helm.sh/helm/v3/pkg/kube"
+	helmrelease "helm.sh/helm/v3/pkg/release"
 	"helm.sh/helm/v3/pkg/repo"
 	"helm.sh/helm/v3/pkg/storage/driver"
 )
@@ -63,6 +64,24 @@ type Release struct {
 	Version   int
 }
 
+// ReleaseInfo contains the state of an installed helm release
+type ReleaseInfo struct {
+	// ChartVersion is the chart version of the latest revision
+	ChartVersion string
+
+	// DeployedChartVersion is the chart version of the latest successfully deployed revision
+	DeployedChartVersion string
+
+	// Installed is whether the release exists
+	Installed bool
+
+	// Revision is the latest revision of the release
+	Revision int
+
+	// Status is the status of the latest revision
+	Status string
+}
+
 type HelmAgent struct {
 	Configuration *action.Configuration
 	Namespace     string
@@ -193,6 +212,41 @@ func (h *HelmAgent) DeleteRevision(ctx context.Context, releaseName string, revi
 	return nil
 }
 
+func (h *HelmAgent) GetReleaseInfo(releaseName string) (ReleaseInfo, error) {
+	client := action.NewHistory(h.Configuration)
+	releases, err := client.Run(releaseName)
+	if err != nil {
+		if errors.Is(err, driver.ErrReleaseNotFound) {
+			return ReleaseInfo{}, nil
+		}
+		return ReleaseInfo{}, fmt.Errorf("Error getting release history: %w", err)
+	}
+
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i].Version < releases[j].Version
+	})
+
+	info := ReleaseInfo{Installed: len(releases) > 0}
+	for _, rel := range releases {
+		chartVersion := ""
+		if rel.Chart != nil && rel.Chart.Metadata != nil {
+			chartVersion = rel.Chart.Metadata.Version
+		}
+
+		info.ChartVersion = chartVersion
+		info.Revision = rel.Version
+		info.Status = ""
+		if rel.Info != nil {
+			info.Status = rel.Info.Status.String()
+			if rel.Info.Status == helmrelease.StatusDeployed {
+				info.DeployedChartVersion = chartVersion
+			}
+		}
+	}
+
+	return info, nil
+}
+
 func (h *HelmAgent) GetValues(releaseName string) (map[string]interface{}, error) {
 	client := action.NewGetValues(h.Configuration)
 	client.AllValues = true
@@ -204,6 +258,16 @@ func (h *HelmAgent) GetValues(releaseName string) (map[string]interface{}, error
 	return values, nil
 }
 
+func (h *HelmAgent) GetUserValues(releaseName string) (map[string]interface{}, error) {
+	client := action.NewGetValues(h.Configuration)
+	values, err := client.Run(releaseName)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting values: %w", err)
+	}
+
+	return values, nil
+}
+
 func (h *HelmAgent) InstallOrUpgradeChart(ctx context.Context, input ChartInput) error {
 	chartExists, err := h.ChartExists(input.ReleaseName)
 	if err != nil {
@@ -2,9 +2,14 @@ package scheduler_k3s
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
+	"strconv"
+	"time"
 
+	certmanagerv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
 	"github.com/dokku/dokku/plugins/common"
 	"github.com/go-openapi/jsonpointer"
 	kedav1alpha1 "github.com/kedacore/keda/v2/apis/keda/v1alpha1"
@@ -13,10 +18,14 @@ import (
 	batchv1 "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -33,6 +42,56 @@ func getKubeContext() string {
 	return common.PropertyGetDefault("scheduler-k3s", "--global", "kube-context", DefaultKubeContext)
 }
 
+func getApiRetryTimeout() string {
+	return common.PropertyGetDefault("scheduler-k3s", "--global", "api-retry-timeout", "0")
+}
+
+// isTransientKubernetesError returns whether an error is likely caused by the kubernetes api being briefly unavailable
+func isTransientKubernetesError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	return utilnet.IsConnectionRefused(err) ||
+		utilnet.IsConnectionReset(err) ||
+		utilnet.IsProbableEOF(err) ||
+		k8serrors.IsServiceUnavailable(err) ||
+		k8serrors.IsServerTimeout(err) ||
+		k8serrors.IsTimeout(err) ||
+		k8serrors.IsTooManyRequests(err)
+}
+
+// retryKubernetesCall retries a kubernetes api call with exponential backoff while the api is unavailable, up to the api-retry-timeout
+func retryKubernetesCall[T any](ctx context.Context, call func(ctx context.Context) (T, error)) (T, error) {
+	result, err := call(ctx)
+	if !isTransientKubernetesError(err) {
+		return result, err
+	}
+
+	timeout, convErr := strconv.Atoi(getApiRetryTimeout())
+	if convErr != nil || timeout <= 0 {
+		return result, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	delay := 500 * time.Millisecond
+	for isTransientKubernetesError(err) {
+		common.LogDebug(fmt.Sprintf("Kubernetes api unavailable, retrying in %s: %s", delay, err))
+		select {
+		case <-ctx.Done():
+			return result, err
+		case <-time.After(delay):
+		}
+
+		result, err = call(ctx)
+		delay = min(delay*2, 10*time.Second)
+	}
+
+	return result, err
+}
+
 // KubernetesClient is a wrapper around the Kubernetes client
 type KubernetesClient struct {
 	// Client is the Kubernetes client
@@ -54,6 +113,10 @@ type KubernetesClient struct {
 // NewKubernetesClient creates a new Kubernetes client
 func NewKubernetesClient() (KubernetesClient, error) {
 	kubeconfigPath := getKubeconfigPath()
+	if err := validateKubeconfigPath(kubeconfigPath); err != nil {
+		return KubernetesClient{}, err
+	}
+
 	kubeContext := getKubeContext()
 	clientConfig := KubernetesClientConfig(kubeconfigPath, kubeContext)
 	restConf, err := clientConfig.ClientConfig()
@@ -61,6 +124,39 @@ func NewKubernetesClient() (KubernetesClient, error) {
 		return KubernetesClient{}, err
 	}
 
+	return newKubernetesClientForConfig(restConf, kubeconfigPath)
+}
+
+// validateKubeconfigPath returns an actionable error if the kubeconfig is missing or cannot be read by the current user
+func validateKubeconfigPath(kubeconfigPath string) error {
+	f, err := os.Open(kubeconfigPath)
+	if err == nil {
+		return f.Close()
+	}
+
+	if errors.Is(err, os.ErrNotExist) {
+		if kubeconfigPath == KubeConfigPath {
+			return newKindError(ErrK3sNotInstalled, err, fmt.Sprintf("Kubeconfig %s does not exist, k3s is not installed on this host. Run scheduler-k3s:initialize to create a cluster, or set the kubeconfig-path property to use an existing cluster", kubeconfigPath))
+		}
+		return fmt.Errorf("Kubeconfig %s does not exist, check the kubeconfig-path property", kubeconfigPath)
+	}
+
+	if errors.Is(err, os.ErrPermission) {
+		systemUser := common.GetenvWithDefault("DOKKU_SYSTEM_USER", "dokku")
+		return fmt.Errorf("Kubeconfig %s exists but is not readable by the %s user, grant read access as root via 'setfacl -m user:%s:r %s'", kubeconfigPath, systemUser, systemUser, kubeconfigPath)
+	}
+
+	return fmt.Errorf("Unable to read kubeconfig %s: %w", kubeconfigPath, err)
+}
+
+// ForHost returns a new KubernetesClient that connects to the specified api server host using the same credentials
+func (k KubernetesClient) ForHost(host string) (KubernetesClient, error) {
+	restConf := rest.CopyConfig(&k.RestConfig)
+	restConf.Host = host
+	return newKubernetesClientForConfig(restConf, k.KubeConfigPath)
+}
+
+func newKubernetesClientForConfig(restConf *rest.Config, kubeconfigPath string) (KubernetesClient, error) {
 	restConf.GroupVersion = &schema.GroupVersion{
 		Group:   "api",
 		Version: "v1",
@@ -106,8 +202,15 @@ func KubernetesClientConfig(kubeconfigPath string, kubecontext string) clientcmd
 }
 
 func (k KubernetesClient) Ping() error {
-	_, err := k.Client.Discovery().ServerVersion()
-	return err
+	_, err := retryKubernetesCall(context.Background(), func(ctx context.Context) (bool, error) {
+		_, err := k.Client.Discovery().ServerVersion()
+		return err == nil, err
+	})
+	if err != nil {
+		return newKindError(ErrKubernetesUnreachable, err, "")
+	}
+
+	return nil
 }
 
 // AnnotateNodeInput contains all the information needed to annotates a Kubernetes node
@@ -141,17 +244,67 @@ func (k KubernetesClient) AnnotateNode(ctx context.Context, input AnnotateNodeIn
 	return nil
 }
 
+// AnnotateNodeValuesInput contains all the information needed to set several annotations on a Kubernetes node
+type AnnotateNodeValuesInput struct {
+	// Name is the Kubernetes node name
+	Name string
+	// Annotations is a map of annotation keys to values
+	Annotations map[string]string
+}
+
+// AnnotateNodeValues sets several annotations on a Kubernetes node in a single patch
+func (k KubernetesClient) AnnotateNodeValues(ctx context.Context, input AnnotateNodeValuesInput) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": input.Annotations,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal annotations: %w", err)
+	}
+
+	_, err = k.Client.CoreV1().Nodes().Patch(ctx, input.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to annotate node: %w", err)
+	}
+
+	return nil
+}
+
+// ApplyKubernetesManifestInput contains all the information needed to apply a Kubernetes manifest
 type ApplyKubernetesManifestInput struct {
+	// DryRun previews the changes via a server-side dry-run apply without changing the cluster
+	DryRun bool
+
 	// Manifest is the path to the Kubernetes manifest
 	Manifest string
 }
 
-func (k KubernetesClient) ApplyKubernetesManifest(ctx context.Context, input ApplyKubernetesManifestInput) error {
+// ApplyKubernetesManifestOutput contains the result of applying a Kubernetes manifest
+type ApplyKubernetesManifestOutput struct {
+	// Changed is whether the manifest differs from the cluster state, only set for dry-runs
+	Changed bool
+
+	// Diff is the diff between the manifest and the cluster state, only set for dry-runs
+	Diff string
+}
+
+// ApplyKubernetesManifest applies a Kubernetes manifest via kubectl, or diffs it against the cluster when DryRun is set
+func (k KubernetesClient) ApplyKubernetesManifest(ctx context.Context, input ApplyKubernetesManifestInput) (ApplyKubernetesManifestOutput, error) {
 	args := []string{
 		"apply",
 		"-f",
 		input.Manifest,
 	}
+	if input.DryRun {
+		// kubectl diff performs a server-side dry-run apply and compares the result against the live objects
+		args = []string{
+			"diff",
+			"--server-side",
+			"-f",
+			input.Manifest,
+		}
+	}
 
 	if kubeContext := getKubeContext(); kubeContext != "" {
 		args = append([]string{"--context", kubeContext}, args...)
@@ -164,18 +317,158 @@ func (k KubernetesClient) ApplyKubernetesManifest(ctx context.Context, input App
 	upgradeCmd, err := common.CallExecCommand(common.ExecCommandInput{
 		Command:     "kubectl",
 		Args:        args,
-		StreamStdio: true,
+		StreamStdio: !input.DryRun && shouldStreamStdio(),
 	})
 	if err != nil {
-		return fmt.Errorf("Unable to call kubectl command: %w", err)
+		return ApplyKubernetesManifestOutput{}, fmt.Errorf("Unable to call kubectl command: %w", err)
 	}
+
+	if input.DryRun {
+		// kubectl diff exits 0 when there are no changes, 1 when there are changes, and above 1 on failure
+		if upgradeCmd.ExitCode > 1 {
+			return ApplyKubernetesManifestOutput{}, fmt.Errorf("Invalid exit code from kubectl diff command: %d (%s)", upgradeCmd.ExitCode, upgradeCmd.StderrContents())
+		}
+
+		return ApplyKubernetesManifestOutput{
+			Changed: upgradeCmd.ExitCode == 1,
+			Diff:    upgradeCmd.StdoutContents(),
+		}, nil
+	}
+
 	if upgradeCmd.ExitCode != 0 {
-		return fmt.Errorf("Invalid exit code from kubectl command: %d", upgradeCmd.ExitCode)
+		return ApplyKubernetesManifestOutput{}, exitCodeError("kubectl command", upgradeCmd.ExitCode, upgradeCmd.Stdout, upgradeCmd.Stderr)
+	}
+
+	return ApplyKubernetesManifestOutput{}, nil
+}
+
+// ApplyMetalLBAddressPoolInput contains all the information needed to configure a metallb address pool
+type ApplyMetalLBAddressPoolInput struct {
+	// Addresses is a list of cidrs or start-end ip ranges
+	Addresses []string
+
+	// Name is the name of the address pool and its l2 advertisement
+	Name string
+
+	// Namespace is the Kubernetes namespace metallb is installed in
+	Namespace string
+}
+
+// ApplyMetalLBAddressPool creates or updates a metallb address pool and a matching l2 advertisement via server-side apply
+func (k KubernetesClient) ApplyMetalLBAddressPool(ctx context.Context, input ApplyMetalLBAddressPoolInput) error {
+	addresses := []interface{}{}
+	for _, address := range input.Addresses {
+		addresses = append(addresses, address)
+	}
+
+	resources := []struct {
+		resource string
+		kind     string
+		spec     map[string]interface{}
+	}{
+		{
+			resource: "ipaddresspools",
+			kind:     "IPAddressPool",
+			spec: map[string]interface{}{
+				"addresses": addresses,
+			},
+		},
+		{
+			resource: "l2advertisements",
+			kind:     "L2Advertisement",
+			spec: map[string]interface{}{
+				"ipAddressPools": []interface{}{input.Name},
+			},
+		},
+	}
+
+	for _, r := range resources {
+		gvr := schema.GroupVersionResource{
+			Group:    "metallb.io",
+			Version:  "v1beta1",
+			Resource: r.resource,
+		}
+
+		object := &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"apiVersion": "metallb.io/v1beta1",
+				"kind":       r.kind,
+				"metadata": map[string]interface{}{
+					"name":      input.Name,
+					"namespace": input.Namespace,
+					"labels": map[string]interface{}{
+						"dokku.com/managed": "true",
+					},
+				},
+				"spec": r.spec,
+			},
+		}
+
+		_, err := k.DynamicClient.Resource(gvr).Namespace(input.Namespace).Apply(ctx, input.Name, object, metav1.ApplyOptions{
+			FieldManager: "dokku",
+			Force:        true,
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to apply metallb %s: %w", r.kind, err)
+		}
 	}
 
 	return nil
 }
 
+// ApplyUpgradePlanInput contains all the information needed to apply a system-upgrade-controller plan
+type ApplyUpgradePlanInput struct {
+	// Name is the name of the plan
+	Name string
+
+	// Namespace is the Kubernetes namespace
+	Namespace string
+
+	// Spec is the plan spec
+	Spec map[string]interface{}
+}
+
+// ApplyUpgradePlan creates or updates a system-upgrade-controller plan via server-side apply
+func (k KubernetesClient) ApplyUpgradePlan(ctx context.Context, input ApplyUpgradePlanInput) error {
+	gvr := schema.GroupVersionResource{
+		Group:    "upgrade.cattle.io",
+		Version:  "v1",
+		Resource: "plans",
+	}
+
+	plan := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "upgrade.cattle.io/v1",
+			"kind":       "Plan",
+			"metadata": map[string]interface{}{
+				"name":      input.Name,
+				"namespace": input.Namespace,
+				"labels": map[string]interface{}{
+					"dokku.com/managed": "true",
+				},
+			},
+			"spec": input.Spec,
+		},
+	}
+
+	_, err := k.DynamicClient.Resource(gvr).Namespace(input.Namespace).Apply(ctx, input.Name, plan, metav1.ApplyOptions{
+		FieldManager: "dokku",
+		Force:        true,
+	})
+	return err
+}
+
+// CordonNodeInput contains all the information needed to cordon a Kubernetes node
+type CordonNodeInput struct {
+	// Name is the Kubernetes node name
+	Name string
+}
+
+// CordonNode marks a Kubernetes node as unschedulable
+func (k KubernetesClient) CordonNode(ctx context.Context, input CordonNodeInput) error {
+	return k.setNodeUnschedulable(ctx, input.Name, true)
+}
+
 // CreateJobInput contains all the information needed to create a Kubernetes job
 type CreateJobInput struct {
 	// Job is the Kubernetes job
@@ -205,7 +498,7 @@ type CreateNamespaceInput struct {
 	Name v1.Namespace
 }
 
-// CreateNamespace creates a Kubernetes namespace
+// CreateNamespace creates a Kubernetes namespace, returning the existing namespace if it already exists
 func (k KubernetesClient) CreateNamespace(ctx context.Context, input CreateNamespaceInput) (v1.Namespace, error) {
 	namespaces, err := k.ListNamespaces(ctx)
 	if err != nil {
@@ -230,6 +523,45 @@ func (k KubernetesClient) CreateNamespace(ctx context.Context, input CreateNames
 	return *namespace, err
 }
 
+// CreateOrUpdateSecretInput contains all the information needed to create or update a Kubernetes secret
+type CreateOrUpdateSecretInput struct {
+	// Secret is the Kubernetes secret
+	Secret v1.Secret
+}
+
+// CreateOrUpdateSecret creates a Kubernetes secret, replacing the type and data of an existing secret with the same name
+func (k KubernetesClient) CreateOrUpdateSecret(ctx context.Context, input CreateOrUpdateSecretInput) (v1.Secret, error) {
+	secrets := k.Client.CoreV1().Secrets(input.Secret.Namespace)
+	existing, err := secrets.Get(ctx, input.Secret.Name, metav1.GetOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return v1.Secret{}, err
+	}
+
+	var secret *v1.Secret
+	if k8serrors.IsNotFound(err) {
+		secret, err = secrets.Create(ctx, &input.Secret, metav1.CreateOptions{})
+	} else {
+		existing.Type = input.Secret.Type
+		existing.Data = input.Secret.Data
+		if existing.Labels == nil {
+			existing.Labels = map[string]string{}
+		}
+		for key, value := range input.Secret.Labels {
+			existing.Labels[key] = value
+		}
+		secret, err = secrets.Update(ctx, existing, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return v1.Secret{}, err
+	}
+
+	if secret == nil {
+		return v1.Secret{}, errors.New("secret is nil")
+	}
+
+	return *secret, nil
+}
+
 // DeleteIngressInput contains all the information needed to delete a Kubernetes ingress
 type DeleteIngressInput struct {
 	// Name is the Kubernetes ingress name
@@ -285,6 +617,184 @@ func (k KubernetesClient) DeleteSecret(ctx context.Context, input DeleteSecretIn
 	return k.Client.CoreV1().Secrets(input.Namespace).Delete(ctx, input.Name, metav1.DeleteOptions{})
 }
 
+// DrainNodeInput contains all the information needed to drain a Kubernetes node
+type DrainNodeInput struct {
+	// Name is the Kubernetes node name
+	Name string
+
+	// GracePeriod is the amount of time evicted pods are given to terminate
+	GracePeriod time.Duration
+
+	// Timeout is the maximum amount of time to wait for all pods to be evicted
+	Timeout time.Duration
+}
+
+// DrainNodeOutput contains the result of draining a Kubernetes node
+type DrainNodeOutput struct {
+	// EvictedPods is the number of pods evicted from the node
+	EvictedPods int
+}
+
+// DrainNode cordons a Kubernetes node and evicts all pods not managed by a daemonset, respecting pod disruption budgets
+func (k KubernetesClient) DrainNode(ctx context.Context, input DrainNodeInput) (DrainNodeOutput, error) {
+	node, err := k.Client.CoreV1().Nodes().Get(ctx, input.Name, metav1.GetOptions{})
+	if err != nil {
+		return DrainNodeOutput{}, err
+	}
+
+	if node == nil {
+		return DrainNodeOutput{}, errors.New("node is nil")
+	}
+
+	if !node.Spec.Unschedulable {
+		if err := k.CordonNode(ctx, CordonNodeInput{Name: input.Name}); err != nil {
+			return DrainNodeOutput{}, fmt.Errorf("failed to cordon node: %w", err)
+		}
+	}
+
+	podList, err := k.Client.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector: fmt.Sprintf("spec.nodeName=%s", input.Name),
+	})
+	if err != nil {
+		return DrainNodeOutput{}, fmt.Errorf("failed to list pods on node: %w", err)
+	}
+
+	pods := []v1.Pod{}
+	for _, pod := range podList.Items {
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		if _, ok := pod.Annotations[v1.MirrorPodAnnotationKey]; ok {
+			continue
+		}
+
+		isDaemonSetPod := false
+		for _, owner := range pod.OwnerReferences {
+			if owner.Kind == "DaemonSet" {
+				isDaemonSetPod = true
+				break
+			}
+		}
+		if !isDaemonSetPod {
+			pods = append(pods, pod)
+		}
+	}
+
+	gracePeriodSeconds := int64(input.GracePeriod.Seconds())
+	deadline := time.Now().Add(input.Timeout)
+	output := DrainNodeOutput{}
+	for _, pod := range pods {
+		for {
+			err := k.Client.PolicyV1().Evictions(pod.Namespace).Evict(ctx, &policyv1.Eviction{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      pod.Name,
+					Namespace: pod.Namespace,
+				},
+				DeleteOptions: &metav1.DeleteOptions{
+					GracePeriodSeconds: &gracePeriodSeconds,
+				},
+			})
+			if err == nil || k8serrors.IsNotFound(err) {
+				output.EvictedPods++
+				break
+			}
+
+			// a pod disruption budget is blocking the eviction
+			if !k8serrors.IsTooManyRequests(err) {
+				return output, fmt.Errorf("failed to evict pod %s/%s: %w", pod.Namespace, pod.Name, err)
+			}
+			if time.Now().After(deadline) {
+				return output, fmt.Errorf("timed out waiting for pod disruption budget to allow eviction of pod %s/%s", pod.Namespace, pod.Name)
+			}
+
+			select {
+			case <-ctx.Done():
+				return output, ctx.Err()
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}
+
+	for {
+		remaining := 0
+		for _, pod := range pods {
+			current, err := k.Client.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+			if k8serrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return output, fmt.Errorf("failed to get pod %s/%s: %w", pod.Namespace, pod.Name, err)
+			}
+			if current.UID == pod.UID {
+				remaining++
+			}
+		}
+
+		if remaining == 0 {
+			return output, nil
+		}
+		if time.Now().After(deadline) {
+			return output, fmt.Errorf("timed out waiting for %d pod(s) to terminate", remaining)
+		}
+
+		select {
+		case <-ctx.Done():
+			return output, ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// GetDaemonSetInput contains all the information needed to get a Kubernetes daemonset
+type GetDaemonSetInput struct {
+	// Name is the Kubernetes daemonset name
+	Name string
+
+	// Namespace is the Kubernetes namespace
+	Namespace string
+}
+
+// GetDaemonSet gets a Kubernetes daemonset
+func (k KubernetesClient) GetDaemonSet(ctx context.Context, input GetDaemonSetInput) (appsv1.DaemonSet, error) {
+	daemonSet, err := retryKubernetesCall(ctx, func(ctx context.Context) (*appsv1.DaemonSet, error) {
+		return k.Client.AppsV1().DaemonSets(input.Namespace).Get(ctx, input.Name, metav1.GetOptions{})
+	})
+	if err != nil {
+		return appsv1.DaemonSet{}, err
+	}
+
+	if daemonSet == nil {
+		return appsv1.DaemonSet{}, errors.New("daemonset is nil")
+	}
+
+	return *daemonSet, err
+}
+
+// GetDeploymentInput contains all the information needed to get a Kubernetes deployment
+type GetDeploymentInput struct {
+	// Name is the Kubernetes deployment name
+	Name string
+
+	// Namespace is the Kubernetes namespace
+	Namespace string
+}
+
+// GetDeployment gets a Kubernetes deployment
+func (k KubernetesClient) GetDeployment(ctx context.Context, input GetDeploymentInput) (appsv1.Deployment, error) {
+	deployment, err := retryKubernetesCall(ctx, func(ctx context.Context) (*appsv1.Deployment, error) {
+		return k.Client.AppsV1().Deployments(input.Namespace).Get(ctx, input.Name, metav1.GetOptions{})
+	})
+	if err != nil {
+		return appsv1.Deployment{}, err
+	}
+
+	if deployment == nil {
+		return appsv1.Deployment{}, errors.New("deployment is nil")
+	}
+
+	return *deployment, err
+}
+
 // GetNodeInput contains all the information needed to get a Kubernetes node
 type GetNodeInput struct {
 	// Name is the Kubernetes node name
@@ -297,7 +807,12 @@ func (k KubernetesClient) GetNode(ctx context.Context, input GetNodeInput) (Node
 		return Node{}, errors.New("node name is required")
 	}
 
-	node, err := k.Client.CoreV1().Nodes().Get(ctx, input.Name, metav1.GetOptions{})
+	node, err := retryKubernetesCall(ctx, func(ctx context.Context) (*v1.Node, error) {
+		return k.Client.CoreV1().Nodes().Get(ctx, input.Name, metav1.GetOptions{})
+	})
+	if k8serrors.IsNotFound(err) {
+		return Node{}, newKindError(ErrNodeNotFound, err, "")
+	}
 	if err != nil {
 		return Node{}, err
 	}
@@ -309,6 +824,28 @@ func (k KubernetesClient) GetNode(ctx context.Context, input GetNodeInput) (Node
 	return kubernetesNodeToNode(*node), err
 }
 
+// GetPersistentVolumeInput contains all the information needed to get a Kubernetes persistent volume
+type GetPersistentVolumeInput struct {
+	// Name is the Kubernetes persistent volume name
+	Name string
+}
+
+// GetPersistentVolume gets a Kubernetes persistent volume
+func (k KubernetesClient) GetPersistentVolume(ctx context.Context, input GetPersistentVolumeInput) (v1.PersistentVolume, error) {
+	volume, err := retryKubernetesCall(ctx, func(ctx context.Context) (*v1.PersistentVolume, error) {
+		return k.Client.CoreV1().PersistentVolumes().Get(ctx, input.Name, metav1.GetOptions{})
+	})
+	if err != nil {
+		return v1.PersistentVolume{}, err
+	}
+
+	if volume == nil {
+		return v1.PersistentVolume{}, errors.New("persistent volume is nil")
+	}
+
+	return *volume, err
+}
+
 // GetJobInput contains all the information needed to get a Kubernetes job
 type GetPodInput struct {
 	// Name is the Kubernetes pod name
@@ -320,7 +857,9 @@ type GetPodInput struct {
 
 // GetJob gets a Kubernetes job
 func (k KubernetesClient) GetPod(ctx context.Context, input GetPodInput) (v1.Pod, error) {
-	pod, err := k.Client.CoreV1().Pods(input.Namespace).Get(ctx, input.Name, metav1.GetOptions{})
+	pod, err := retryKubernetesCall(ctx, func(ctx context.Context) (*v1.Pod, error) {
+		return k.Client.CoreV1().Pods(input.Namespace).Get(ctx, input.Name, metav1.GetOptions{})
+	})
 	if err != nil {
 		return v1.Pod{}, err
 	}
@@ -363,6 +902,44 @@ func (k KubernetesClient) LabelNode(ctx context.Context, input LabelNodeInput) e
 	return nil
 }
 
+// ListCertificatesInput contains all the information needed to list cert-manager certificates
+type ListCertificatesInput struct {
+	// Namespace is the Kubernetes namespace
+	Namespace string
+
+	// LabelSelector is the Kubernetes label selector
+	LabelSelector string
+}
+
+// ListCertificates lists cert-manager certificates
+func (k KubernetesClient) ListCertificates(ctx context.Context, input ListCertificatesInput) ([]certmanagerv1.Certificate, error) {
+	listOptions := metav1.ListOptions{LabelSelector: input.LabelSelector}
+
+	gvr := schema.GroupVersionResource{
+		Group:    "cert-manager.io",
+		Version:  "v1",
+		Resource: "certificates",
+	}
+
+	response, err := k.DynamicClient.Resource(gvr).Namespace(input.Namespace).List(ctx, listOptions)
+	if err != nil {
+		return []certmanagerv1.Certificate{}, err
+	}
+
+	certificates := []certmanagerv1.Certificate{}
+	for _, certificate := range response.Items {
+		var c certmanagerv1.Certificate
+		err := runtime.DefaultUnstructuredConverter.FromUnstructured(certificate.Object, &c)
+		if err != nil {
+			return []certmanagerv1.Certificate{}, err
+		}
+
+		certificates = append(certificates, c)
+	}
+
+	return certificates, nil
+}
+
 // ListClusterTriggerAuthenticationsInput contains all the information needed to list Kubernetes trigger authentications
 type ListClusterTriggerAuthenticationsInput struct {
 	// Namespace is the Kubernetes namespace
@@ -441,7 +1018,9 @@ type ListDeploymentsInput struct {
 // ListDeployments lists Kubernetes deployments
 func (k KubernetesClient) ListDeployments(ctx context.Context, input ListDeploymentsInput) ([]appsv1.Deployment, error) {
 	listOptions := metav1.ListOptions{LabelSelector: input.LabelSelector}
-	deployments, err := k.Client.AppsV1().Deployments(input.Namespace).List(ctx, listOptions)
+	deployments, err := retryKubernetesCall(ctx, func(ctx context.Context) (*appsv1.DeploymentList, error) {
+		return k.Client.AppsV1().Deployments(input.Namespace).List(ctx, listOptions)
+	})
 	if err != nil {
 		return []appsv1.Deployment{}, err
 	}
@@ -453,6 +1032,30 @@ func (k KubernetesClient) ListDeployments(ctx context.Context, input ListDeploym
 	return deployments.Items, nil
 }
 
+// ListEventsInput contains all the information needed to list Kubernetes events
+type ListEventsInput struct {
+	// Namespace is the Kubernetes namespace
+	Namespace string
+
+	// FieldSelector is the Kubernetes field selector
+	FieldSelector string
+}
+
+// ListEvents lists Kubernetes events
+func (k KubernetesClient) ListEvents(ctx context.Context, input ListEventsInput) ([]v1.Event, error) {
+	listOptions := metav1.ListOptions{FieldSelector: input.FieldSelector}
+	events, err := k.Client.CoreV1().Events(input.Namespace).List(ctx, listOptions)
+	if err != nil {
+		return []v1.Event{}, err
+	}
+
+	if events == nil {
+		return []v1.Event{}, errors.New("events is nil")
+	}
+
+	return events.Items, nil
+}
+
 // ListIngressesInput contains all the information needed to list Kubernetes ingresses
 type ListIngressesInput struct {
 	// Namespace is the Kubernetes namespace
@@ -479,7 +1082,9 @@ func (k KubernetesClient) ListIngresses(ctx context.Context, input ListIngresses
 
 // ListNamespaces lists Kubernetes namespaces
 func (k KubernetesClient) ListNamespaces(ctx context.Context) ([]v1.Namespace, error) {
-	namespaces, err := k.Client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	namespaces, err := retryKubernetesCall(ctx, func(ctx context.Context) (*v1.NamespaceList, error) {
+		return k.Client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	})
 	if err != nil {
 		return []v1.Namespace{}, err
 	}
@@ -490,6 +1095,62 @@ func (k KubernetesClient) ListNamespaces(ctx context.Context) ([]v1.Namespace, e
 	return namespaces.Items, nil
 }
 
+// LonghornReplica is a replica of a longhorn volume stored on a node
+type LonghornReplica struct {
+	// Name is the name of the replica
+	Name string
+
+	// VolumeName is the name of the longhorn volume the replica belongs to
+	VolumeName string
+
+	// NodeID is the name of the node storing the replica
+	NodeID string
+
+	// Healthy is whether the replica is running and has been rebuilt without failing since
+	Healthy bool
+}
+
+// ListLonghornReplicasInput contains all the information needed to list longhorn replicas
+type ListLonghornReplicasInput struct {
+	// Namespace is the Kubernetes namespace
+	Namespace string
+}
+
+// ListLonghornReplicas lists longhorn replicas, returning an empty list when longhorn is not installed
+func (k KubernetesClient) ListLonghornReplicas(ctx context.Context, input ListLonghornReplicasInput) ([]LonghornReplica, error) {
+	gvr := schema.GroupVersionResource{
+		Group:    "longhorn.io",
+		Version:  "v1beta2",
+		Resource: "replicas",
+	}
+
+	response, err := k.DynamicClient.Resource(gvr).Namespace(input.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return []LonghornReplica{}, nil
+		}
+		return []LonghornReplica{}, err
+	}
+
+	replicas := []LonghornReplica{}
+	for _, item := range response.Items {
+		volumeName, _, _ := unstructured.NestedString(item.Object, "spec", "volumeName")
+		nodeID, _, _ := unstructured.NestedString(item.Object, "spec", "nodeID")
+		healthyAt, _, _ := unstructured.NestedString(item.Object, "spec", "healthyAt")
+		failedAt, _, _ := unstructured.NestedString(item.Object, "spec", "failedAt")
+		currentState, _, _ := unstructured.NestedString(item.Object, "status", "currentState")
+
+		replicas = append(replicas, LonghornReplica{
+			Name:       item.GetName(),
+			VolumeName: volumeName,
+			NodeID:     nodeID,
+			Healthy:    currentState == "running" && healthyAt != "" && failedAt == "",
+		})
+	}
+
+	return replicas, nil
+}
+
 // ListNodesInput contains all the information needed to list Kubernetes nodes
 type ListNodesInput struct {
 	// LabelSelector is the Kubernetes label selector
@@ -503,7 +1164,9 @@ func (k KubernetesClient) ListNodes(ctx context.Context, input ListNodesInput) (
 		common.LogDebug(fmt.Sprintf("Using label selector: %s", input.LabelSelector))
 		listOptions.LabelSelector = input.LabelSelector
 	}
-	nodeList, err := k.Client.CoreV1().Nodes().List(ctx, listOptions)
+	nodeList, err := retryKubernetesCall(ctx, func(ctx context.Context) (*v1.NodeList, error) {
+		return k.Client.CoreV1().Nodes().List(ctx, listOptions)
+	})
 	if err != nil {
 		return []v1.Node{}, err
 	}
@@ -515,6 +1178,28 @@ func (k KubernetesClient) ListNodes(ctx context.Context, input ListNodesInput) (
 	return nodeList.Items, err
 }
 
+// ListPodDisruptionBudgetsInput contains all the information needed to list Kubernetes pod disruption budgets
+type ListPodDisruptionBudgetsInput struct {
+	// Namespace is the Kubernetes namespace
+	Namespace string
+}
+
+// ListPodDisruptionBudgets lists Kubernetes pod disruption budgets
+func (k KubernetesClient) ListPodDisruptionBudgets(ctx context.Context, input ListPodDisruptionBudgetsInput) ([]policyv1.PodDisruptionBudget, error) {
+	budgetList, err := retryKubernetesCall(ctx, func(ctx context.Context) (*policyv1.PodDisruptionBudgetList, error) {
+		return k.Client.PolicyV1().PodDisruptionBudgets(input.Namespace).List(ctx, metav1.ListOptions{})
+	})
+	if err != nil {
+		return []policyv1.PodDisruptionBudget{}, err
+	}
+
+	if budgetList == nil {
+		return []policyv1.PodDisruptionBudget{}, errors.New("pod disruption budget list is nil")
+	}
+
+	return budgetList.Items, err
+}
+
 // ListPodsInput contains all the information needed to list Kubernetes pods
 type ListPodsInput struct {
 	// Namespace is the Kubernetes namespace
@@ -522,12 +1207,17 @@ type ListPodsInput struct {
 
 	// LabelSelector is the Kubernetes label selector
 	LabelSelector string
+
+	// FieldSelector is the Kubernetes field selector
+	FieldSelector string
 }
 
 // ListPods lists Kubernetes pods
 func (k KubernetesClient) ListPods(ctx context.Context, input ListPodsInput) ([]v1.Pod, error) {
-	listOptions := metav1.ListOptions{LabelSelector: input.LabelSelector}
-	podList, err := k.Client.CoreV1().Pods(input.Namespace).List(ctx, listOptions)
+	listOptions := metav1.ListOptions{LabelSelector: input.LabelSelector, FieldSelector: input.FieldSelector}
+	podList, err := retryKubernetesCall(ctx, func(ctx context.Context) (*v1.PodList, error) {
+		return k.Client.CoreV1().Pods(input.Namespace).List(ctx, listOptions)
+	})
 	if err != nil {
 		return []v1.Pod{}, err
 	}
@@ -577,6 +1267,39 @@ func (k KubernetesClient) ListTriggerAuthentications(ctx context.Context, input
 	return triggerAuthentications, nil
 }
 
+// RemoveNodeAnnotationInput contains all the information needed to remove an annotation from a Kubernetes node
+type RemoveNodeAnnotationInput struct {
+	// Name is the Kubernetes node name
+	Name string
+	// Key is the annotation key
+	Key string
+}
+
+// RemoveNodeAnnotation removes an annotation from a Kubernetes node
+func (k KubernetesClient) RemoveNodeAnnotation(ctx context.Context, input RemoveNodeAnnotationInput) error {
+	node, err := k.Client.CoreV1().Nodes().Get(ctx, input.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	if node == nil {
+		return errors.New("node is nil")
+	}
+
+	if _, ok := node.Annotations[input.Key]; !ok {
+		return nil
+	}
+
+	keyPath := fmt.Sprintf("/metadata/annotations/%s", jsonpointer.Escape(input.Key))
+	patch := fmt.Sprintf(`[{"op":"remove", "path":"%s" }]`, keyPath)
+	_, err = k.Client.CoreV1().Nodes().Patch(ctx, node.Name, types.JSONPatchType, []byte(patch), metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to remove node annotation: %w", err)
+	}
+
+	return nil
+}
+
 // ScaleDeploymentInput contains all the information needed to scale a Kubernetes deployment
 type ScaleDeploymentInput struct {
 	// Name is the Kubernetes deployment name
@@ -606,3 +1329,136 @@ func (k KubernetesClient) ScaleDeployment(ctx context.Context, input ScaleDeploy
 
 	return nil
 }
+
+// TaintNodeInput contains all the information needed to taint a Kubernetes node
+type TaintNodeInput struct {
+	// Name is the Kubernetes node name
+	Name string
+	// Taint is the taint to add to the node
+	Taint v1.Taint
+}
+
+// TaintNode adds a taint to a Kubernetes node, replacing any existing taint with the same key and effect
+func (k KubernetesClient) TaintNode(ctx context.Context, input TaintNodeInput) error {
+	node, err := k.Client.CoreV1().Nodes().Get(ctx, input.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	if node == nil {
+		return errors.New("node is nil")
+	}
+
+	taints := []v1.Taint{}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == input.Taint.Key && taint.Effect == input.Taint.Effect {
+			continue
+		}
+		taints = append(taints, taint)
+	}
+	node.Spec.Taints = append(taints, input.Taint)
+
+	_, err = k.Client.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to taint node: %w", err)
+	}
+
+	return nil
+}
+
+// UncordonNodeInput contains all the information needed to uncordon a Kubernetes node
+type UncordonNodeInput struct {
+	// Name is the Kubernetes node name
+	Name string
+}
+
+// UncordonNode marks a Kubernetes node as schedulable
+func (k KubernetesClient) UncordonNode(ctx context.Context, input UncordonNodeInput) error {
+	return k.setNodeUnschedulable(ctx, input.Name, false)
+}
+
+// UnlabelNodeInput contains all the information needed to remove a label from a Kubernetes node
+type UnlabelNodeInput struct {
+	// Name is the Kubernetes node name
+	Name string
+	// Key is the label key
+	Key string
+}
+
+// UnlabelNode removes a label from a Kubernetes node
+func (k KubernetesClient) UnlabelNode(ctx context.Context, input UnlabelNodeInput) error {
+	node, err := k.Client.CoreV1().Nodes().Get(ctx, input.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	if node == nil {
+		return errors.New("node is nil")
+	}
+
+	if _, ok := node.Labels[input.Key]; !ok {
+		return nil
+	}
+
+	keyPath := fmt.Sprintf("/metadata/labels/%s", jsonpointer.Escape(input.Key))
+	patch := fmt.Sprintf(`[{"op":"remove", "path":"%s" }]`, keyPath)
+	_, err = k.Client.CoreV1().Nodes().Patch(ctx, node.Name, types.JSONPatchType, []byte(patch), metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to unlabel node: %w", err)
+	}
+
+	return nil
+}
+
+// UntaintNodeInput contains all the information needed to remove a taint from a Kubernetes node
+type UntaintNodeInput struct {
+	// Name is the Kubernetes node name
+	Name string
+	// Key is the taint key
+	Key string
+	// Effect is the taint effect, or empty to remove the key for all effects
+	Effect v1.TaintEffect
+}
+
+// UntaintNode removes a taint from a Kubernetes node
+func (k KubernetesClient) UntaintNode(ctx context.Context, input UntaintNodeInput) error {
+	node, err := k.Client.CoreV1().Nodes().Get(ctx, input.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	if node == nil {
+		return errors.New("node is nil")
+	}
+
+	taints := []v1.Taint{}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == input.Key && (input.Effect == "" || taint.Effect == input.Effect) {
+			continue
+		}
+		taints = append(taints, taint)
+	}
+
+	if len(taints) == len(node.Spec.Taints) {
+		return fmt.Errorf("taint %s not found on node %s", input.Key, input.Name)
+	}
+
+	node.Spec.Taints = taints
+	_, err = k.Client.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to untaint node: %w", err)
+	}
+
+	return nil
+}
+
+// setNodeUnschedulable patches the unschedulable field of a Kubernetes node
+func (k KubernetesClient) setNodeUnschedulable(ctx context.Context, name string, unschedulable bool) error {
+	if name == "" {
+		return errors.New("node name is required")
+	}
+
+	patch := fmt.Sprintf(`{"spec":{"unschedulable":%t}}`, unschedulable)
+	_, err := k.Client.CoreV1().Nodes().Patch(ctx, name, types.MergePatchType, []byte(patch), metav1.PatchOptions{})
+	return err
+}
@@ -0,0 +1,147 @@
+package scheduler_k3s
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dokku/dokku/plugins/common"
+)
+
+// StepEvent is a structured log event emitted for a step of a multi-step command
+type StepEvent struct {
+	// Command is the name of the command running the step
+	Command string `json:"command"`
+
+	// Step is the name of the step
+	Step string `json:"step"`
+
+	// Status is one of started, succeeded, or failed
+	Status string `json:"status"`
+
+	// DurationMs is the duration of the step in milliseconds, set once the step has completed
+	DurationMs int64 `json:"duration_ms,omitempty"`
+
+	// Error is the error the step failed with
+	Error string `json:"error,omitempty"`
+
+	// Time is the time the event was emitted
+	Time time.Time `json:"time"`
+}
+
+// StepLogger logs the steps of a multi-step command either as human-readable text or as json events
+type StepLogger struct {
+	command   string
+	format    string
+	step      string
+	stepStart time.Time
+}
+
+// NewStepLogger returns a StepLogger for a command in the given log format
+func NewStepLogger(command string, format string) (*StepLogger, error) {
+	if format == "" {
+		format = "text"
+	}
+
+	if format != "text" && format != "json" {
+		return nil, fmt.Errorf("Invalid log-format specified, supported formats: json, text")
+	}
+
+	return &StepLogger{
+		command: command,
+		format:  format,
+	}, nil
+}
+
+// Step completes the current step, if any, and starts a new one
+func (l *StepLogger) Step(name string) {
+	l.completeStep(nil)
+
+	l.step = name
+	l.stepStart = time.Now()
+	if l.format == "text" {
+		common.LogInfo2Quiet(name)
+		return
+	}
+
+	l.emit(StepEvent{
+		Step:   name,
+		Status: "started",
+	})
+}
+
+// Finish completes the current step with the result of the command
+func (l *StepLogger) Finish(err error) {
+	if err != nil && l.step == "" {
+		l.step = "validate"
+		l.stepStart = time.Now()
+	}
+
+	l.completeStep(err)
+}
+
+func (l *StepLogger) completeStep(err error) {
+	if l.step == "" {
+		return
+	}
+
+	event := StepEvent{
+		Step:       l.step,
+		Status:     "succeeded",
+		DurationMs: time.Since(l.stepStart).Milliseconds(),
+	}
+	if err != nil {
+		event.Status = "failed"
+		event.Error = err.Error()
+	}
+
+	l.step = ""
+	if l.format == "text" {
+		return
+	}
+
+	l.emit(event)
+}
+
+func (l *StepLogger) emit(event StepEvent) {
+	event.Command = l.command
+	event.Time = time.Now().UTC()
+	b, err := json.Marshal(event)
+	if err != nil {
+		common.LogWarn(fmt.Sprintf("Unable to marshal log event: %s", err.Error()))
+		return
+	}
+
+	fmt.Println(string(b))
+}
+
+// ParseQuietFlag removes a --quiet flag from the arguments, returning whether it was specified
+func ParseQuietFlag(args []string) ([]string, bool) {
+	quiet := false
+	filtered := []string{}
+	for i, arg := range args {
+		if arg == "--" {
+			filtered = append(filtered, args[i:]...)
+			break
+		}
+		if arg == "--quiet" {
+			quiet = true
+			continue
+		}
+
+		filtered = append(filtered, arg)
+	}
+
+	return filtered, quiet
+}
+
+// SetQuietOutput suppresses progress output and streamed command output so that only errors are displayed
+func SetQuietOutput() {
+	os.Setenv("DOKKU_QUIET_OUTPUT", "1")
+}
+
+// shouldStreamStdio returns whether the output of executed commands should be streamed to the terminal
+func shouldStreamStdio() bool {
+	return os.Getenv("DOKKU_QUIET_OUTPUT") == ""
+}
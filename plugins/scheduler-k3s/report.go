@@ -2,39 +2,112 @@ package scheduler_k3s
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/dokku/dokku/plugins/common"
 )
 
+// reportKubernetesClient returns the kubernetes client shared by every app in a report, connecting at most once
+var reportKubernetesClient = sync.OnceValues(func() (KubernetesClient, error) {
+	clientset, err := NewKubernetesClient()
+	if err != nil {
+		return clientset, err
+	}
+
+	return clientset, clientset.Ping()
+})
+
+// reportRuntimeStatuses holds the runtime status of each app in a report, fetched at most once per app
+var reportRuntimeStatuses sync.Map
+
 // ReportSingleApp is an internal function that displays the scheduler-k3s report for one or more apps
 func ReportSingleApp(appName string, format string, infoFlag string) error {
-	if err := common.VerifyAppName(appName); err != nil {
+	infoFlags, flagKeys, err := collectAppReport(appName, infoFlag)
+	if err != nil {
 		return err
 	}
 
+	return displayAppReport(appName, format, infoFlag, infoFlags, flagKeys)
+}
+
+// collectAppReport collects the scheduler-k3s report values for an app
+func collectAppReport(appName string, infoFlag string) (map[string]string, []string, error) {
+	if err := common.VerifyAppName(appName); err != nil {
+		return nil, nil, err
+	}
+
 	flags := map[string]common.ReportFunc{
-		"--scheduler-k3s-computed-deploy-timeout":       reportComputedDeployTimeout,
-		"--scheduler-k3s-deploy-timeout":                reportDeployTimeout,
-		"--scheduler-k3s-global-deploy-timeout":         reportGlobalDeployTimeout,
-		"--scheduler-k3s-computed-image-pull-secrets":   reportComputedImagePullSecrets,
-		"--scheduler-k3s-image-pull-secrets":            reportImagePullSecrets,
-		"--scheduler-k3s-global-image-pull-secrets":     reportGlobalImagePullSecrets,
-		"--scheduler-k3s-global-kubeconfig-path":        reportGlobalKubeconfigPath,
-		"--scheduler-k3s-global-kube-context":           reportGlobalKubeContext,
-		"--scheduler-k3s-computed-letsencrypt-server":   reportComputedLetsencryptServer,
-		"--scheduler-k3s-letsencrypt-server":            reportLetsencryptServer,
-		"--scheduler-k3s-global-letsencrypt-server":     reportGlobalLetsencryptServer,
-		"--scheduler-k3s-global-ingress-class":          reportGlobalIngressClass,
-		"--scheduler-k3s-global-letsencrypt-email-prod": reportGlobalLetsencryptEmailProd,
-		"--scheduler-k3s-global-letsencrypt-email-stag": reportGlobalLetsencryptEmailStag,
-		"--scheduler-k3s-computed-namespace":            reportComputedNamespace,
-		"--scheduler-k3s-namespace":                     reportNamespace,
-		"--scheduler-k3s-global-namespace":              reportGlobalNamespace,
-		"--scheduler-k3s-global-network-interface":      reportGlobalNetworkInterface,
-		"--scheduler-k3s-computed-rollback-on-failure":  reportComputedRollbackOnFailure,
-		"--scheduler-k3s-rollback-on-failure":           reportRollbackOnFailure,
-		"--scheduler-k3s-global-rollback-on-failure":    reportGlobalRollbackOnFailure,
+		"--scheduler-k3s-global-api-retry-timeout":            reportGlobalApiRetryTimeout,
+		"--scheduler-k3s-global-cluster-cidr":                 reportGlobalClusterCIDR,
+		"--scheduler-k3s-computed-cpu-limit":                  reportComputedCPULimit,
+		"--scheduler-k3s-cpu-limit":                           reportCPULimit,
+		"--scheduler-k3s-global-cpu-limit":                    reportGlobalCPULimit,
+		"--scheduler-k3s-computed-cpu-request":                reportComputedCPURequest,
+		"--scheduler-k3s-cpu-request":                         reportCPURequest,
+		"--scheduler-k3s-global-cpu-request":                  reportGlobalCPURequest,
+		"--scheduler-k3s-global-data-dir":                     reportGlobalDataDir,
+		"--scheduler-k3s-depends-on":                          reportDependsOn,
+		"--scheduler-k3s-computed-deploy-timeout":             reportComputedDeployTimeout,
+		"--scheduler-k3s-deploy-timeout":                      reportDeployTimeout,
+		"--scheduler-k3s-global-deploy-timeout":               reportGlobalDeployTimeout,
+		"--scheduler-k3s-global-flannel-backend":              reportGlobalFlannelBackend,
+		"--scheduler-k3s-global-flannel-wireguard-port":       reportGlobalFlannelWireguardPort,
+		"--scheduler-k3s-computed-image-pull-secrets":         reportComputedImagePullSecrets,
+		"--scheduler-k3s-image-pull-secrets":                  reportImagePullSecrets,
+		"--scheduler-k3s-global-image-pull-secrets":           reportGlobalImagePullSecrets,
+		"--scheduler-k3s-global-http-proxy":                   reportGlobalHTTPProxy,
+		"--scheduler-k3s-global-https-proxy":                  reportGlobalHTTPSProxy,
+		"--scheduler-k3s-global-install-cert-manager":         reportGlobalInstallCertManager,
+		"--scheduler-k3s-global-install-longhorn":             reportGlobalInstallLonghorn,
+		"--scheduler-k3s-global-k3s-airgap-images-path":       reportGlobalK3sAirgapImagesPath,
+		"--scheduler-k3s-global-k3s-binary-path":              reportGlobalK3sBinaryPath,
+		"--scheduler-k3s-global-k3s-channel":                  reportGlobalK3sChannel,
+		"--scheduler-k3s-global-k3s-installer-path":           reportGlobalK3sInstallerPath,
+		"--scheduler-k3s-global-k3s-version":                  reportGlobalK3sVersion,
+		"--scheduler-k3s-global-kubeconfig-path":              reportGlobalKubeconfigPath,
+		"--scheduler-k3s-global-kube-context":                 reportGlobalKubeContext,
+		"--scheduler-k3s-global-kube-controller-manager-args": reportGlobalKubeControllerManagerArgs,
+		"--scheduler-k3s-global-kubelet-args":                 reportGlobalKubeletArgs,
+		"--scheduler-k3s-computed-letsencrypt-server":         reportComputedLetsencryptServer,
+		"--scheduler-k3s-letsencrypt-server":                  reportLetsencryptServer,
+		"--scheduler-k3s-global-letsencrypt-server":           reportGlobalLetsencryptServer,
+		"--scheduler-k3s-global-ingress-class":                reportGlobalIngressClass,
+		"--scheduler-k3s-global-ip-family":                    reportGlobalIPFamily,
+		"--scheduler-k3s-global-letsencrypt-email-prod":       reportGlobalLetsencryptEmailProd,
+		"--scheduler-k3s-global-letsencrypt-email-stag":       reportGlobalLetsencryptEmailStag,
+		"--scheduler-k3s-global-loadbalancer":                 reportGlobalLoadbalancer,
+		"--scheduler-k3s-global-longhorn-default":             reportGlobalLonghornDefault,
+		"--scheduler-k3s-global-longhorn-replica-count":       reportGlobalLonghornReplicaCount,
+		"--scheduler-k3s-global-max-parallel-image-pulls":     reportGlobalMaxParallelImagePulls,
+		"--scheduler-k3s-global-metallb-address-pool":         reportGlobalMetalLBAddressPool,
+		"--scheduler-k3s-computed-memory-limit":               reportComputedMemoryLimit,
+		"--scheduler-k3s-memory-limit":                        reportMemoryLimit,
+		"--scheduler-k3s-global-memory-limit":                 reportGlobalMemoryLimit,
+		"--scheduler-k3s-computed-memory-request":             reportComputedMemoryRequest,
+		"--scheduler-k3s-memory-request":                      reportMemoryRequest,
+		"--scheduler-k3s-global-memory-request":               reportGlobalMemoryRequest,
+		"--scheduler-k3s-computed-namespace":                  reportComputedNamespace,
+		"--scheduler-k3s-namespace":                           reportNamespace,
+		"--scheduler-k3s-global-namespace":                    reportGlobalNamespace,
+		"--scheduler-k3s-computed-network-interface":          reportComputedNetworkInterface,
+		"--scheduler-k3s-global-network-interface":            reportGlobalNetworkInterface,
+		"--scheduler-k3s-global-no-proxy":                     reportGlobalNoProxy,
+		"--scheduler-k3s-global-node-wait-timeout":            reportGlobalNodeWaitTimeout,
+		"--scheduler-k3s-global-registry-mirrors":             reportGlobalRegistryMirrors,
+		"--scheduler-k3s-computed-rollback-on-failure":        reportComputedRollbackOnFailure,
+		"--scheduler-k3s-rollback-on-failure":                 reportRollbackOnFailure,
+		"--scheduler-k3s-global-rollback-on-failure":          reportGlobalRollbackOnFailure,
+		"--scheduler-k3s-global-serialize-image-pulls":        reportGlobalSerializeImagePulls,
+		"--scheduler-k3s-global-service-cidr":                 reportGlobalServiceCIDR,
+		"--scheduler-k3s-computed-spread-check":               reportComputedSpreadCheck,
+		"--scheduler-k3s-spread-check":                        reportSpreadCheck,
+		"--scheduler-k3s-global-spread-check":                 reportGlobalSpreadCheck,
+		"--scheduler-k3s-running-pods":                        reportRunningPods,
+		"--scheduler-k3s-pending-pods":                        reportPendingPods,
+		"--scheduler-k3s-failed-pods":                         reportFailedPods,
+		"--scheduler-k3s-deployed-revision":                   reportDeployedRevision,
 	}
 
 	flagKeys := []string{}
@@ -42,9 +115,14 @@ func ReportSingleApp(appName string, format string, infoFlag string) error {
 		flagKeys = append(flagKeys, flagKey)
 	}
 
+	infoFlags := common.CollectReport(appName, infoFlag, flags)
+	return infoFlags, flagKeys, nil
+}
+
+// displayAppReport displays collected scheduler-k3s report values for an app
+func displayAppReport(appName string, format string, infoFlag string, infoFlags map[string]string, flagKeys []string) error {
 	trimPrefix := false
 	uppercaseFirstCharacter := true
-	infoFlags := common.CollectReport(appName, infoFlag, flags)
 	return common.ReportSingleApp("scheduler-k3s", appName, infoFlag, infoFlags, flagKeys, format, trimPrefix, uppercaseFirstCharacter)
 }
 
@@ -79,6 +157,46 @@ func ReportAutoscalingAuthSingleApp(appName string, format string, includeMetada
 	return common.ReportSingleApp("scheduler-k3s", appName, "", infoFlags, flagKeys, format, trimPrefix, uppercaseFirstCharacter)
 }
 
+func reportGlobalApiRetryTimeout(appName string) string {
+	return getApiRetryTimeout()
+}
+
+func reportComputedCPULimit(appName string) string {
+	processResources, err := getDefaultProcessResources(appName)
+	if err != nil {
+		return ""
+	}
+	return processResources.Limits.CPU
+}
+
+func reportCPULimit(appName string) string {
+	return getResourceProperty(appName, "cpu-limit")
+}
+
+func reportGlobalCPULimit(appName string) string {
+	return getGlobalResourceProperty("cpu-limit")
+}
+
+func reportComputedCPURequest(appName string) string {
+	processResources, err := getDefaultProcessResources(appName)
+	if err != nil {
+		return ""
+	}
+	return processResources.Requests.CPU
+}
+
+func reportCPURequest(appName string) string {
+	return getResourceProperty(appName, "cpu-request")
+}
+
+func reportGlobalCPURequest(appName string) string {
+	return getGlobalResourceProperty("cpu-request")
+}
+
+func reportDependsOn(appName string) string {
+	return getDependsOn(appName)
+}
+
 func reportComputedDeployTimeout(appName string) string {
 	return getComputedDeployTimeout(appName)
 }
@@ -91,6 +209,14 @@ func reportGlobalDeployTimeout(appName string) string {
 	return getGlobalDeployTimeout()
 }
 
+func reportGlobalFlannelBackend(appName string) string {
+	return getGlobalFlannelBackend()
+}
+
+func reportGlobalFlannelWireguardPort(appName string) string {
+	return getGlobalFlannelWireguardPort()
+}
+
 func reportComputedImagePullSecrets(appName string) string {
 	return getComputedImagePullSecrets(appName)
 }
@@ -103,10 +229,58 @@ func reportGlobalImagePullSecrets(appName string) string {
 	return getGlobalImagePullSecrets()
 }
 
+func reportGlobalHTTPProxy(appName string) string {
+	return getGlobalHTTPProxy()
+}
+
+func reportGlobalHTTPSProxy(appName string) string {
+	return getGlobalHTTPSProxy()
+}
+
+func reportGlobalIPFamily(appName string) string {
+	return getGlobalIPFamily()
+}
+
 func reportGlobalIngressClass(appName string) string {
 	return getGlobalIngressClass()
 }
 
+func reportGlobalClusterCIDR(appName string) string {
+	return getGlobalClusterCIDR()
+}
+
+func reportGlobalDataDir(appName string) string {
+	return getGlobalDataDir()
+}
+
+func reportGlobalInstallCertManager(appName string) string {
+	return strconv.FormatBool(getGlobalInstallCertManager())
+}
+
+func reportGlobalInstallLonghorn(appName string) string {
+	return strconv.FormatBool(getGlobalInstallLonghorn())
+}
+
+func reportGlobalK3sAirgapImagesPath(appName string) string {
+	return getGlobalK3sAirgapImagesPath()
+}
+
+func reportGlobalK3sBinaryPath(appName string) string {
+	return getGlobalK3sBinaryPath()
+}
+
+func reportGlobalK3sChannel(appName string) string {
+	return getGlobalK3sChannel()
+}
+
+func reportGlobalK3sInstallerPath(appName string) string {
+	return getGlobalK3sInstallerPath()
+}
+
+func reportGlobalK3sVersion(appName string) string {
+	return getGlobalK3sVersion()
+}
+
 func reportGlobalKubeconfigPath(appName string) string {
 	return getKubeconfigPath()
 }
@@ -114,6 +288,15 @@ func reportGlobalKubeconfigPath(appName string) string {
 func reportGlobalKubeContext(appName string) string {
 	return getKubeContext()
 }
+
+func reportGlobalKubeControllerManagerArgs(appName string) string {
+	return getGlobalKubeControllerManagerArgs()
+}
+
+func reportGlobalKubeletArgs(appName string) string {
+	return getGlobalKubeletArgs()
+}
+
 func reportComputedLetsencryptServer(appName string) string {
 	return getComputedLetsencryptServer(appName)
 }
@@ -134,6 +317,58 @@ func reportGlobalLetsencryptEmailStag(appName string) string {
 	return getGlobalLetsencryptEmailStag()
 }
 
+func reportGlobalLoadbalancer(appName string) string {
+	return getGlobalLoadbalancer()
+}
+
+func reportGlobalLonghornDefault(appName string) string {
+	return strconv.FormatBool(getGlobalLonghornDefault())
+}
+
+func reportGlobalLonghornReplicaCount(appName string) string {
+	return getGlobalLonghornReplicaCount()
+}
+
+func reportGlobalMaxParallelImagePulls(appName string) string {
+	return getGlobalMaxParallelImagePulls()
+}
+
+func reportGlobalMetalLBAddressPool(appName string) string {
+	return getGlobalMetalLBAddressPool()
+}
+
+func reportComputedMemoryLimit(appName string) string {
+	processResources, err := getDefaultProcessResources(appName)
+	if err != nil {
+		return ""
+	}
+	return processResources.Limits.Memory
+}
+
+func reportMemoryLimit(appName string) string {
+	return getResourceProperty(appName, "memory-limit")
+}
+
+func reportGlobalMemoryLimit(appName string) string {
+	return getGlobalResourceProperty("memory-limit")
+}
+
+func reportComputedMemoryRequest(appName string) string {
+	processResources, err := getDefaultProcessResources(appName)
+	if err != nil {
+		return ""
+	}
+	return processResources.Requests.Memory
+}
+
+func reportMemoryRequest(appName string) string {
+	return getResourceProperty(appName, "memory-request")
+}
+
+func reportGlobalMemoryRequest(appName string) string {
+	return getGlobalResourceProperty("memory-request")
+}
+
 func reportComputedNamespace(appName string) string {
 	return getComputedNamespace(appName)
 }
@@ -146,6 +381,32 @@ func reportGlobalNamespace(appName string) string {
 	return getGlobalNamespace()
 }
 
+func reportGlobalNoProxy(appName string) string {
+	return getGlobalNoProxy()
+}
+
+func reportGlobalNodeWaitTimeout(appName string) string {
+	return getGlobalNodeWaitTimeout()
+}
+
+func reportGlobalRegistryMirrors(appName string) string {
+	mirrors, err := getRegistryMirrors()
+	if err != nil {
+		return ""
+	}
+
+	values := []string{}
+	for _, mirror := range mirrors {
+		values = append(values, mirror.String())
+	}
+
+	return strings.Join(values, " ")
+}
+
+func reportComputedNetworkInterface(appName string) string {
+	return getNetworkInterface()
+}
+
 func reportGlobalNetworkInterface(appName string) string {
 	return getGlobalNetworkInterface()
 }
@@ -161,3 +422,58 @@ func reportRollbackOnFailure(appName string) string {
 func reportGlobalRollbackOnFailure(appName string) string {
 	return getGlobalRollbackOnFailure()
 }
+
+func reportGlobalServiceCIDR(appName string) string {
+	return getGlobalServiceCIDR()
+}
+
+func reportGlobalSerializeImagePulls(appName string) string {
+	return getGlobalSerializeImagePulls()
+}
+
+func reportComputedSpreadCheck(appName string) string {
+	return getComputedSpreadCheck(appName)
+}
+
+func reportSpreadCheck(appName string) string {
+	return getSpreadCheck(appName)
+}
+
+func reportGlobalSpreadCheck(appName string) string {
+	return getGlobalSpreadCheck()
+}
+
+// getReportRuntimeStatus returns the runtime status of an app for the report
+// apps not using the k3s scheduler, and reports collected while the cluster is unreachable, return zero values
+func getReportRuntimeStatus(appName string) AppRuntimeStatus {
+	status, _ := reportRuntimeStatuses.LoadOrStore(appName, sync.OnceValue(func() AppRuntimeStatus {
+		if common.GetAppScheduler(appName) != "k3s" {
+			return AppRuntimeStatus{}
+		}
+
+		clientset, err := reportKubernetesClient()
+		if err != nil {
+			return AppRuntimeStatus{}
+		}
+
+		return getAppRuntimeStatus(clientset, appName)
+	}))
+
+	return status.(func() AppRuntimeStatus)()
+}
+
+func reportRunningPods(appName string) string {
+	return strconv.Itoa(getReportRuntimeStatus(appName).RunningPods)
+}
+
+func reportPendingPods(appName string) string {
+	return strconv.Itoa(getReportRuntimeStatus(appName).PendingPods)
+}
+
+func reportFailedPods(appName string) string {
+	return strconv.Itoa(getReportRuntimeStatus(appName).FailedPods)
+}
+
+func reportDeployedRevision(appName string) string {
+	return getReportRuntimeStatus(appName).Revision
+}
@@ -2,7 +2,11 @@ package scheduler_k3s
 
 import (
 	"embed"
+	"fmt"
+	"regexp"
+	"strings"
 	"sync"
+	"time"
 
 	certmanagerv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
 	kedav1alpha1 "github.com/kedacore/keda/v2/apis/keda/v1alpha1"
@@ -18,35 +22,116 @@ import (
 var (
 	// DefaultProperties is a map of all valid k3s properties with corresponding default property values
 	DefaultProperties = map[string]string{
+		"cpu-limit":           "",
+		"cpu-request":         "",
+		"depends-on":          "",
 		"deploy-timeout":      "",
 		"letsencrypt-server":  "",
 		"image-pull-secrets":  "",
+		"memory-limit":        "",
+		"memory-request":      "",
 		"namespace":           "",
 		"rollback-on-failure": "",
+		"spread-check":        "",
 	}
 
 	// GlobalProperties is a map of all valid global k3s properties
 	GlobalProperties = map[string]bool{
-		"deploy-timeout":         true,
-		"image-pull-secrets":     true,
-		"ingress-class":          true,
-		"kube-context":           true,
-		"kubeconfig-path":        true,
-		"letsencrypt-server":     true,
-		"letsencrypt-email-prod": true,
-		"letsencrypt-email-stag": true,
-		"namespace":              true,
-		"network-interface":      true,
-		"rollback-on-failure":    true,
-		"token":                  true,
+		"api-retry-timeout":            true,
+		"cluster-cidr":                 true,
+		"cpu-limit":                    true,
+		"cpu-request":                  true,
+		"data-dir":                     true,
+		"deploy-timeout":               true,
+		"flannel-backend":              true,
+		"flannel-wireguard-port":       true,
+		"http-proxy":                   true,
+		"https-proxy":                  true,
+		"image-pull-secrets":           true,
+		"ingress-class":                true,
+		"install-cert-manager":         true,
+		"install-longhorn":             true,
+		"ip-family":                    true,
+		"k3s-airgap-images-path":       true,
+		"k3s-binary-path":              true,
+		"k3s-channel":                  true,
+		"k3s-installer-path":           true,
+		"k3s-version":                  true,
+		"kube-context":                 true,
+		"kube-controller-manager-args": true,
+		"kubelet-args":                 true,
+		"kubeconfig-path":              true,
+		"letsencrypt-server":           true,
+		"letsencrypt-email-prod":       true,
+		"letsencrypt-email-stag":       true,
+		"loadbalancer":                 true,
+		"longhorn-default":             true,
+		"longhorn-replica-count":       true,
+		"max-parallel-image-pulls":     true,
+		"memory-limit":                 true,
+		"memory-request":               true,
+		"metallb-address-pool":         true,
+		"namespace":                    true,
+		"network-interface":            true,
+		"no-proxy":                     true,
+		"node-wait-timeout":            true,
+		"rollback-on-failure":          true,
+		"serialize-image-pulls":        true,
+		"service-cidr":                 true,
+		"spread-check":                 true,
+		"token":                        true,
 	}
 )
 
 const DefaultIngressClass = "nginx"
+const DefaultLoadbalancer = "servicelb"
+const DefaultNetworkInterface = "eth0"
 const GlobalProcessType = "--global"
 const KubeConfigPath = "/etc/rancher/k3s/k3s.yaml"
+const DefaultK3sDataDir = "/var/lib/rancher/k3s"
 const DefaultKubeContext = ""
 const TriggerAuthPropertyPrefix = "trigger-auth."
+const NodePropertyPrefix = "node."
+const HelmChartsOverridePath = "/etc/rancher/dokku/helm-charts.json"
+const InitConfigFilename = "init-config.json"
+const K3sInstallerCacheFilename = "k3s-installer.sh"
+const K3sBinaryPath = "/usr/local/bin/k3s"
+const HelmInstallAttempts = 5
+const K3sInstallerDownloadAttempts = 3
+const MinimumTokenLength = 32
+const K3sInstallerDownloadTimeout = 60 * time.Second
+const RemoteAptTimeout = 10 * time.Minute
+const RemoteDownloadTimeout = 2 * time.Minute
+const RemoteInstallTimeout = 10 * time.Minute
+const RemoteCommandTimeout = 30 * time.Second
+const ProtectedAnnotationPrefix = "dokku.com/"
+const CriticalAddonsOnlyTaint = "CriticalAddonsOnly=true:NoSchedule"
+const SystemUpgradeNamespace = "system-upgrade"
+const MetalLBNamespace = "metallb-system"
+const MetalLBAddressPoolName = "dokku"
+const K3sUpgradeImage = "rancher/k3s-upgrade"
+const RegistryConfigPath = "/etc/rancher/k3s/registries.yaml"
+const FlannelConfigPath = "/etc/rancher/dokku/flannel-net-conf.json"
+const RegistryMirrorsProperty = "registry-mirrors"
+const DNSUpstreamServersProperty = "dns-upstream-servers"
+const DNSStubDomainsProperty = "dns-stub-domains"
+const DNSResolvConfPath = "/etc/rancher/dokku/resolv.conf"
+const CoreDNSCustomManifestFilename = "dokku-coredns-custom.yaml"
+const NodePoolLabel = "dokku.com/pool"
+const NodeJoinedAtAnnotation = "dokku.com/joined-at"
+const NodeManagedByAnnotation = "dokku.com/managed-by"
+const NodeManagedByValue = "dokku-scheduler-k3s"
+
+// DefaultHostnameMaxSkew is the per-node skew the kube-scheduler's default topology spread constraints allow
+const DefaultHostnameMaxSkew = 3
+
+const LetsencryptProdServer = "https://acme-v02.api.letsencrypt.org/directory"
+const LetsencryptStagServer = "https://acme-staging-v02.api.letsencrypt.org/directory"
+
+// LetsencryptCustomIssuerName is the cluster issuer created when the global letsencrypt-server is an acme url
+const LetsencryptCustomIssuerName = "letsencrypt-custom"
+
+var k3sVersionRegex = regexp.MustCompile(`^v([0-9]+)\.([0-9]+)\.([0-9]+)(-rc([0-9]+))?\+k3s([0-9]+)$`)
 
 var (
 	runtimeScheme  = runtime.NewScheme()
@@ -71,14 +156,44 @@ var KubernetesManifests = []Manifest{
 	},
 }
 
+// RegistryMirror is a registry mirror rendered into the k3s registries.yaml
+type RegistryMirror struct {
+	Registry       string   `json:"registry"`
+	Endpoint       string   `json:"endpoint"`
+	ExtraEndpoints []string `json:"extra_endpoints,omitempty"`
+	Username       string   `json:"username,omitempty"`
+	Password       string   `json:"password,omitempty"`
+}
+
+// String returns a string representation of the mirror with its password redacted
+func (m RegistryMirror) String() string {
+	value := fmt.Sprintf("%s=%s", m.Registry, strings.Join(m.GetEndpoints(), ","))
+	if m.Username != "" {
+		value += fmt.Sprintf("(%s:********)", m.Username)
+	}
+
+	return value
+}
+
+// GetEndpoints returns the endpoints of the mirror in the order containerd tries them
+func (m RegistryMirror) GetEndpoints() []string {
+	return append([]string{m.Endpoint}, m.ExtraEndpoints...)
+}
+
+// DNSStubDomain is a domain whose queries CoreDNS forwards to specific dns servers
+type DNSStubDomain struct {
+	Domain  string   `json:"domain"`
+	Servers []string `json:"servers"`
+}
+
 type HelmChart struct {
-	ChartPath       string
-	CreateNamespace bool
-	Namespace       string
-	Path            string
-	ReleaseName     string
-	RepoURL         string
-	Version         string
+	ChartPath       string `json:"chart_path"`
+	CreateNamespace bool   `json:"create_namespace"`
+	Namespace       string `json:"namespace"`
+	Path            string `json:"path"`
+	ReleaseName     string `json:"release_name"`
+	RepoURL         string `json:"repo_url"`
+	Version         string `json:"version"`
 }
 
 var HelmCharts = []HelmChart{
@@ -122,6 +237,14 @@ var HelmCharts = []HelmChart{
 		RepoURL:         "https://kedacore.github.io/charts",
 		Version:         "2.13.1",
 	},
+	{
+		ChartPath:       "metallb",
+		CreateNamespace: true,
+		Namespace:       "metallb-system",
+		ReleaseName:     "metallb",
+		RepoURL:         "https://metallb.github.io/metallb",
+		Version:         "0.14.5",
+	},
 }
 
 type HelmRepository struct {
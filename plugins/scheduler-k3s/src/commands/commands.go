@@ -19,15 +19,45 @@ Additional commands:`
 	helpContent = `
     scheduler-k3s:autoscaling-auth:set <app|--global> <trigger> [<--metadata key=value>...], Set or clear a scheduler-k3s autoscaling keda trigger authentication resource for an app
     scheduler-k3s:annotations:set <app|--global> <property> (<value>) [--process-type PROCESS_TYPE] <--resource-type RESOURCE_TYPE>, Set or clear an annotation for a given app/process-type/resource-type combination
-    scheduler-k3s:cluster-add [--insecure-allow-unknown-hosts] [--server-ip SERVER_IP] [--taint-scheduling] <ssh://user@host:port>, Adds a server node to a Dokku-managed cluster
-    scheduler-k3s:cluster-list [--format json|stdout], Lists all nodes in a Dokku-managed cluster
-    scheduler-k3s:cluster-remove [node-id], Removes client node to a Dokku-managed cluster
-    scheduler-k3s:initialize [--server-ip SERVER_IP] [--taint-scheduling], Initializes a cluster
+    scheduler-k3s:certificates:expiring [--format json|stdout] [--warn-days DAYS] [--fail-on-expiring], Lists app certificates that have expired or are expiring soon
+    scheduler-k3s:charts:list [--format json|stdout], Lists the helm charts installed on the cluster and whether their versions have drifted
+    scheduler-k3s:charts:upgrade <release>, Upgrades an installed helm chart with values from the current properties
+    scheduler-k3s:cluster-add [--arch ARCH] [--cache-installer] [--refresh-installer] [--dry-run] [--force-reinstall] [--insecure-allow-unknown-hosts] [--join-server URL] [--log-format text|json] [--no-wait-ready] [--label KEY=VALUE...] [--pool NAME] [--registry-test-image IMAGE] [--ssh-user USER] [--ssh-port PORT] [--server-ip SERVER_IP] [--skip-connectivity-check] [--skip-dependencies] [--taint-scheduling] <ssh://user@host:port>, Adds a server node to a Dokku-managed cluster
+    scheduler-k3s:cluster-config:show [--format json|stdout], Displays the configuration the cluster was initialized with
+    scheduler-k3s:cluster-export, Outputs the Dokku-managed node metadata and init config for the cluster as json
+    scheduler-k3s:cluster-import [--dry-run] [<file>], Reapplies the Dokku-managed node metadata from a cluster export
+    scheduler-k3s:cluster-info [--format json|stdout], Displays the control-plane and etcd quorum health of the cluster
+    scheduler-k3s:cluster-label <node-id> <server|worker>, Applies the role labels for a server or worker to an existing node
+    scheduler-k3s:cluster-list [--format json|stdout] [--complete] [--capacity] [--role server|worker] [--ready true|false] [--extended] [--sort name|role|ready|version], Lists all nodes in a Dokku-managed cluster
+    scheduler-k3s:cluster-relabel-defaults, Reapplies the role labels Dokku manages to every node in the cluster
+    scheduler-k3s:cluster-remove [--no-drain] [--drain-grace-period SECONDS] [--drain-timeout SECONDS] [--ssh-user USER] [--ssh-port PORT] [--selector SELECTOR] [--force] [--allow-data-loss] [--format stdout|json] [node-id...], Removes client node to a Dokku-managed cluster
+    scheduler-k3s:cluster-token [--show] [--node-token] [--regenerate --force], Displays the token used to join nodes to the cluster
+    scheduler-k3s:cluster-upgrade [--concurrency COUNT] [--no-drain] [--order workers-first|servers-first] [--timeout SECONDS] <version>, Upgrades k3s on all nodes in the cluster
+    scheduler-k3s:cordon <node-id> [--drain] [--drain-grace-period SECONDS] [--drain-timeout SECONDS], Marks a node as unschedulable, optionally evicting its pods
+    scheduler-k3s:dns:configure [--upstream IP...] [--stub-domain DOMAIN=IP[,IP...]...] [--clear], Sets the upstream dns servers and stub domains used by CoreDNS
+    scheduler-k3s:doctor [--format json|stdout], Diagnoses common problems with the k3s installation and cluster
+    scheduler-k3s:image-pull-secret:create <app|--global> --server SERVER --username USER --password PASSWORD [--name NAME], Creates or updates a registry credential secret and uses it as the image pull secret
+    scheduler-k3s:image-pull-secret:delete <app|--global> [<name>], Deletes a registry credential secret and clears the image pull secret
+    scheduler-k3s:initialize [--server-ip SERVER_IP] [--taint-scheduling] [--finalize] [--disable COMPONENT...] [--log-format text|json] [--skip-dependencies] [--expect-nodes COUNT] [--wait] [--wait-timeout SECONDS], Initializes a cluster
     scheduler-k3s:labels:set <app|--global> <property> (<value>) [--process-type PROCESS_TYPE] <--resource-type RESOURCE_TYPE>, Set or clear a label for a given app/process-type/resource-type combination
-    scheduler-k3s:report [<app>] [<flag>], Displays a scheduler-k3s report for one or more apps
-    scheduler-k3s:set <app> <property> (<value>), Set or clear a scheduler-k3s property for an app
-    scheduler-k3s:show-kubeconfig, Displays the kubeconfig for remote usage
-    scheduler-k3s:uninstall, Uninstalls k3s from the Dokku server`
+    scheduler-k3s:logs [--lines N] [--follow] [--component COMPONENT] [node-id], Displays the logs of the k3s service on a node or of a core component
+    scheduler-k3s:manifest:diff [<manifest>], Displays the changes applying the bundled kubernetes manifests would make to the cluster
+    scheduler-k3s:node-annotations:list <node-id> [--format json|stdout], Lists the annotations set on a node
+    scheduler-k3s:node-annotations:set <node-id> <key> <value>, Sets an annotation on a node
+    scheduler-k3s:node-annotations:unset <node-id> <key>, Removes an annotation from a node
+    scheduler-k3s:pending-pods [--format json|stdout], Lists all pending pods in the cluster and why they have not been scheduled
+    scheduler-k3s:pool:list [--format json|stdout], Lists the node pools in the cluster and the nodes in each pool
+    scheduler-k3s:registry:mirror [--upstream REGISTRY] <endpoint>, Add a mirror endpoint for docker.io or another registry on all nodes in the cluster
+    scheduler-k3s:registry:set <registry> (<endpoint>) [--username USER --password PASSWORD], Set or clear a registry mirror for all nodes in the cluster
+    scheduler-k3s:report [<app>] [<flag>] [--parallel COUNT], Displays a scheduler-k3s report for one or more apps
+    scheduler-k3s:resources:audit [--format json|stdout], Lists app process types whose deployments are missing cpu or memory limits
+    scheduler-k3s:set <app> <property> (<value>) [--force], Set or clear a scheduler-k3s property for an app
+    scheduler-k3s:show-kubeconfig [--server-url URL] [--detect-server-url] [--format json|stdout] [--include-secrets], Displays the kubeconfig for remote usage
+    scheduler-k3s:taint:add <node-id> <key[=value]:effect>, Adds a taint to a node
+    scheduler-k3s:taint:remove <node-id> <key[:effect]>, Removes a taint from a node
+    scheduler-k3s:token:rotate [--force], Rotates the k3s token used to join nodes to the cluster
+    scheduler-k3s:uncordon <node-id>, Marks a node as schedulable
+    scheduler-k3s:uninstall [--all --force], Uninstalls k3s from the Dokku server`
 )
 
 func main() {
@@ -16,6 +16,12 @@ func main() {
 	parts := strings.Split(os.Args[0], "/")
 	subcommand := parts[len(parts)-1]
 
+	osArgs, quiet := scheduler_k3s.ParseQuietFlag(os.Args)
+	os.Args = osArgs
+	if quiet {
+		scheduler_k3s.SetQuietOutput()
+	}
+
 	var err error
 	switch subcommand {
 	case "annotations:set":
@@ -54,32 +60,200 @@ func main() {
 		args.Parse(os.Args[2:])
 		appName := args.Arg(0)
 		err = scheduler_k3s.CommandAutoscalingAuthReport(appName, *format, *global, *includeMetadata)
+	case "certificates:expiring":
+		args := flag.NewFlagSet("scheduler-k3s:certificates:expiring", flag.ExitOnError)
+		format := args.String("format", "stdout", "format: [ stdout | json ]")
+		warnDays := args.Int("warn-days", 14, "warn-days: number of days before expiry to report a certificate")
+		failOnExpiring := args.Bool("fail-on-expiring", false, "fail-on-expiring: exit non-zero if any certificate is expired or expiring")
+		args.Parse(os.Args[2:])
+		err = scheduler_k3s.CommandCertificatesExpiring(*format, *warnDays, *failOnExpiring)
+	case "charts:list":
+		args := flag.NewFlagSet("scheduler-k3s:charts:list", flag.ExitOnError)
+		format := args.String("format", "stdout", "format: [ stdout | json ]")
+		args.Parse(os.Args[2:])
+		err = scheduler_k3s.CommandChartsList(*format)
+	case "charts:upgrade":
+		args := flag.NewFlagSet("scheduler-k3s:charts:upgrade", flag.ExitOnError)
+		args.Parse(os.Args[2:])
+		releaseName := args.Arg(0)
+		err = scheduler_k3s.CommandChartsUpgrade(releaseName)
 	case "cluster-add":
 		args := flag.NewFlagSet("scheduler-k3s:cluster-add", flag.ExitOnError)
 		allowUknownHosts := args.Bool("insecure-allow-unknown-hosts", false, "insecure-allow-unknown-hosts: allow unknown hosts")
 		taintScheduling := args.Bool("taint-scheduling", false, "taint-scheduling: add a taint against scheduling app workloads")
 		serverIP := args.String("server-ip", "", "server-ip: IP address of the dokku server node")
 		role := args.String("role", "worker", "role: [ server | worker ]")
+		dryRun := args.Bool("dry-run", false, "dry-run: print the commands that would be run without joining the node")
+		logFormat := args.String("log-format", os.Getenv("DOKKU_SCHEDULER_K3S_LOG_FORMAT"), "log-format: [ text | json ]")
+		noWaitReady := args.Bool("no-wait-ready", false, "no-wait-ready: do not wait for the node to become ready after joining")
+		forceReinstall := args.Bool("force-reinstall", false, "force-reinstall: run the k3s installer even if k3s is already installed on the remote host")
+		labels := args.StringArray("label", []string{}, "label: a key=value label to apply to the node, may be repeated")
+		sshUser := args.String("ssh-user", "", "ssh-user: user to connect to the remote host as, overriding the user in the url")
+		sshPort := args.Int("ssh-port", 0, "ssh-port: port to connect to the remote host on, overriding the port in the url")
+		skipDependencies := args.Bool("skip-dependencies", false, "skip-dependencies: do not install apt dependencies, assuming they are already present")
+		arch := args.String("arch", "", "arch: expected cpu architecture of the remote host, warning if it differs")
+		registryTestImage := args.String("registry-test-image", "", "registry-test-image: image to pull on the node after joining to verify registry access")
+		pool := args.String("pool", "", "pool: name of the node pool to add the node to")
+		joinServer := args.String("join-server", "", "join-server: url of the kubernetes api the node should join, overriding the detected server ip")
+		cacheInstaller := args.Bool("cache-installer", false, "cache-installer: download the k3s installer once on the dokku server and copy it to the remote host")
+		refreshInstaller := args.Bool("refresh-installer", false, "refresh-installer: download the k3s installer again even if a valid cached copy exists")
+		skipConnectivityCheck := args.Bool("skip-connectivity-check", false, "skip-connectivity-check: do not check that the remote host can reach the kubernetes api before installing k3s")
 		args.Parse(os.Args[2:])
 		remoteHost := args.Arg(0)
-		err = scheduler_k3s.CommandClusterAdd(*role, remoteHost, *serverIP, *allowUknownHosts, *taintScheduling)
+		err = scheduler_k3s.CommandClusterAdd(scheduler_k3s.AddClusterNodeInput{
+			Role:                  *role,
+			RemoteHost:            remoteHost,
+			ServerIP:              *serverIP,
+			AllowUknownHosts:      *allowUknownHosts,
+			TaintScheduling:       *taintScheduling,
+			DryRun:                *dryRun,
+			ForceReinstall:        *forceReinstall,
+			NoWaitReady:           *noWaitReady,
+			SkipDependencies:      *skipDependencies,
+			Arch:                  *arch,
+			RegistryTestImage:     *registryTestImage,
+			Pool:                  *pool,
+			JoinServer:            *joinServer,
+			Labels:                *labels,
+			SshUser:               *sshUser,
+			SshPort:               *sshPort,
+			CacheInstaller:        *cacheInstaller,
+			RefreshInstaller:      *refreshInstaller,
+			SkipConnectivityCheck: *skipConnectivityCheck,
+		}, *logFormat)
+	case "cluster-config:show":
+		args := flag.NewFlagSet("scheduler-k3s:cluster-config:show", flag.ExitOnError)
+		format := args.String("format", "stdout", "format: [ stdout | json ]")
+		args.Parse(os.Args[2:])
+		err = scheduler_k3s.CommandClusterConfigShow(*format)
+	case "cluster-export":
+		args := flag.NewFlagSet("scheduler-k3s:cluster-export", flag.ExitOnError)
+		args.Parse(os.Args[2:])
+		err = scheduler_k3s.CommandClusterExport()
+	case "cluster-import":
+		args := flag.NewFlagSet("scheduler-k3s:cluster-import", flag.ExitOnError)
+		dryRun := args.Bool("dry-run", false, "dry-run: display the changes that would be made without applying them")
+		args.Parse(os.Args[2:])
+		filename := args.Arg(0)
+		err = scheduler_k3s.CommandClusterImport(filename, *dryRun)
+	case "cluster-info":
+		args := flag.NewFlagSet("scheduler-k3s:cluster-info", flag.ExitOnError)
+		format := args.String("format", "stdout", "format: [ stdout | json ]")
+		args.Parse(os.Args[2:])
+		err = scheduler_k3s.CommandClusterInfo(*format)
+	case "cluster-label":
+		args := flag.NewFlagSet("scheduler-k3s:cluster-label", flag.ExitOnError)
+		args.Parse(os.Args[2:])
+		nodeName := args.Arg(0)
+		role := args.Arg(1)
+		err = scheduler_k3s.CommandClusterLabel(nodeName, role)
 	case "cluster-list":
 		args := flag.NewFlagSet("scheduler-k3s:cluster-list", flag.ExitOnError)
 		format := args.String("format", "stdout", "format: [ stdout | json ]")
+		complete := args.Bool("complete", false, "complete: re-run the labeling and annotation steps for nodes with an incomplete join")
+		capacity := args.Bool("capacity", false, "capacity: show the total capacity and headroom of all ready nodes")
+		role := args.String("role", "", "role: only show nodes with the specified role [ server | worker ]")
+		ready := args.String("ready", "", "ready: only show nodes with the specified ready status [ true | false ]")
+		extended := args.Bool("extended", false, "extended: include node addresses and schedulability in stdout output")
+		sortBy := args.String("sort", "name", "sort: field to sort nodes by [ name | role | ready | version ]")
+		args.Parse(os.Args[2:])
+		err = scheduler_k3s.CommandClusterList(*format, *complete, *capacity, *role, *ready, *extended, *sortBy)
+	case "cluster-relabel-defaults":
+		args := flag.NewFlagSet("scheduler-k3s:cluster-relabel-defaults", flag.ExitOnError)
 		args.Parse(os.Args[2:])
-		err = scheduler_k3s.CommandClusterList(*format)
+		err = scheduler_k3s.CommandClusterRelabelDefaults()
 	case "cluster-remove":
 		args := flag.NewFlagSet("scheduler-k3s:cluster-remove", flag.ExitOnError)
+		sshUser := args.String("ssh-user", "", "ssh-user: user to connect to the remote host as, overriding the stored user")
+		sshPort := args.Int("ssh-port", 0, "ssh-port: port to connect to the remote host on, overriding the stored port")
+		noDrain := args.Bool("no-drain", false, "no-drain: skip draining the node before removing it")
+		drainGracePeriod := args.Int("drain-grace-period", 30, "drain-grace-period: seconds evicted pods are given to terminate")
+		drainTimeout := args.Int("drain-timeout", 300, "drain-timeout: seconds to wait for all pods to be evicted")
+		selector := args.String("selector", "", "selector: remove all nodes matching a label selector")
+		force := args.Bool("force", false, "force: allow removing server nodes in batch mode")
+		allowDataLoss := args.Bool("allow-data-loss", false, "allow-data-loss: remove nodes storing the only healthy replica of a volume or running workloads at risk of data loss")
+		format := args.String("format", "stdout", "format: [ stdout | json ]")
+		args.Parse(os.Args[2:])
+		err = scheduler_k3s.CommandClusterRemove(args.Args(), *selector, *force, *allowDataLoss, *format, *sshUser, *sshPort, *noDrain, *drainGracePeriod, *drainTimeout)
+	case "cluster-token":
+		args := flag.NewFlagSet("scheduler-k3s:cluster-token", flag.ExitOnError)
+		show := args.Bool("show", false, "show: display the token instead of a redacted value")
+		nodeToken := args.Bool("node-token", false, "node-token: display the k3s server node token instead of the cluster token")
+		regenerate := args.Bool("regenerate", false, "regenerate: rotate the token before displaying it")
+		force := args.Bool("force", false, "force: confirm rotating the k3s token")
+		args.Parse(os.Args[2:])
+		err = scheduler_k3s.CommandClusterToken(*show, *nodeToken, *regenerate, *force)
+	case "cluster-upgrade":
+		args := flag.NewFlagSet("scheduler-k3s:cluster-upgrade", flag.ExitOnError)
+		concurrency := args.Int("concurrency", 1, "concurrency: number of worker nodes to upgrade at the same time")
+		noDrain := args.Bool("no-drain", false, "no-drain: cordon worker nodes without draining them before upgrading")
+		timeout := args.Int("timeout", 1800, "timeout: seconds to wait for all nodes to be upgraded")
+		order := args.String("order", "workers-first", "order: [ workers-first | servers-first ]")
+		args.Parse(os.Args[2:])
+		version := args.Arg(0)
+		err = scheduler_k3s.CommandClusterUpgrade(version, *concurrency, *noDrain, *timeout, *order)
+	case "cordon":
+		args := flag.NewFlagSet("scheduler-k3s:cordon", flag.ExitOnError)
+		drain := args.Bool("drain", false, "drain: evict pods running on the node after cordoning it")
+		drainGracePeriod := args.Int("drain-grace-period", 30, "drain-grace-period: seconds evicted pods are given to terminate")
+		drainTimeout := args.Int("drain-timeout", 300, "drain-timeout: seconds to wait for all pods to be evicted")
 		args.Parse(os.Args[2:])
 		nodeName := args.Arg(0)
-		err = scheduler_k3s.CommandClusterRemove(nodeName)
+		err = scheduler_k3s.CommandCordon(nodeName, *drain, *drainGracePeriod, *drainTimeout)
+	case "dns:configure":
+		args := flag.NewFlagSet("scheduler-k3s:dns:configure", flag.ExitOnError)
+		upstreamServers := args.StringArray("upstream", []string{}, "upstream: ip address of an upstream dns server, may be repeated")
+		stubDomains := args.StringArray("stub-domain", []string{}, "stub-domain: a DOMAIN=SERVER[,SERVER...] stub domain, may be repeated")
+		clearConfig := args.Bool("clear", false, "clear: remove the upstream servers and stub domains")
+		args.Parse(os.Args[2:])
+		err = scheduler_k3s.CommandDNSConfigure(*upstreamServers, *stubDomains, *clearConfig)
+	case "doctor":
+		args := flag.NewFlagSet("scheduler-k3s:doctor", flag.ExitOnError)
+		format := args.String("format", "stdout", "format: [ stdout | json ]")
+		args.Parse(os.Args[2:])
+		err = scheduler_k3s.CommandDoctor(*format)
+	case "image-pull-secret:create":
+		args := flag.NewFlagSet("scheduler-k3s:image-pull-secret:create", flag.ExitOnError)
+		global := args.Bool("global", false, "--global: create the secret for all apps")
+		server := args.String("server", "", "server: registry server the credentials are for")
+		username := args.String("username", "", "username: username to authenticate against the registry with")
+		password := args.String("password", "", "password: password or token to authenticate against the registry with")
+		name := args.String("name", "", "name: name of the secret, defaulting to APP-image-pull-secret")
+		args.Parse(os.Args[2:])
+		appName := args.Arg(0)
+		if *global {
+			appName = "--global"
+		}
+		err = scheduler_k3s.CommandImagePullSecretCreate(appName, *server, *username, *password, *name)
+	case "image-pull-secret:delete":
+		args := flag.NewFlagSet("scheduler-k3s:image-pull-secret:delete", flag.ExitOnError)
+		global := args.Bool("global", false, "--global: delete the secret used by all apps")
+		args.Parse(os.Args[2:])
+		appName := args.Arg(0)
+		name := args.Arg(1)
+		if *global {
+			appName = "--global"
+			name = args.Arg(0)
+		}
+		err = scheduler_k3s.CommandImagePullSecretDelete(appName, name)
 	case "initialize":
 		args := flag.NewFlagSet("scheduler-k3s:initialize", flag.ExitOnError)
+		finalize := args.Bool("finalize", false, "finalize: resume a partially failed initialization when k3s is already installed")
+		disable := args.StringSlice("disable", []string{}, "disable: k3s components to disable, may be repeated or comma-separated")
 		taintScheduling := args.Bool("taint-scheduling", false, "taint-scheduling: add a taint against scheduling app workloads")
 		serverIP := args.String("server-ip", "", "server-ip: IP address of the dokku server node")
 		ingressClass := args.String("ingress-class", "traefik", "ingress-class: ingress-class to use for all outbound traffic")
+		logFormat := args.String("log-format", os.Getenv("DOKKU_SCHEDULER_K3S_LOG_FORMAT"), "log-format: [ text | json ]")
+		skipDependencies := args.Bool("skip-dependencies", false, "skip-dependencies: do not install apt dependencies, assuming they are already present")
+		expectNodes := args.Int("expect-nodes", 1, "expect-nodes: number of nodes the cluster is expected to grow to")
+		waitReady := args.Bool("wait", false, "wait: wait for the cert-manager, longhorn, and system-upgrade-controller workloads to become ready")
+		waitTimeout := args.Int("wait-timeout", 600, "wait-timeout: number of seconds to wait for the core components to become ready")
 		args.Parse(os.Args[2:])
-		err = scheduler_k3s.CommandInitialize(*ingressClass, *serverIP, *taintScheduling)
+		if *finalize && !args.Changed("ingress-class") {
+			// resume with the ingress class recorded by the original initialization
+			*ingressClass = ""
+		}
+		err = scheduler_k3s.CommandInitialize(*ingressClass, *serverIP, *taintScheduling, *finalize, *disable, *skipDependencies, *expectNodes, *waitReady, *waitTimeout, *logFormat)
 	case "labels:set":
 		args := flag.NewFlagSet("scheduler-k3s:labels:set", flag.ExitOnError)
 		global := args.Bool("global", false, "--global: set a global property")
@@ -96,18 +270,97 @@ func main() {
 		}
 
 		err = scheduler_k3s.CommandLabelsSet(appName, *processType, *resourceType, property, value)
+	case "logs":
+		args := flag.NewFlagSet("scheduler-k3s:logs", flag.ExitOnError)
+		component := args.String("component", "", "component: show the logs of the pods of a core component instead of the k3s service [ cert-manager | longhorn | traefik | ingress-nginx | keda ]")
+		lines := args.Int64("lines", 100, "lines: number of log lines to show")
+		follow := args.Bool("follow", false, "follow: continue streaming new log lines")
+		args.Parse(os.Args[2:])
+		nodeName := args.Arg(0)
+		err = scheduler_k3s.CommandLogs(nodeName, *component, *lines, *follow)
+	case "manifest:diff":
+		args := flag.NewFlagSet("scheduler-k3s:manifest:diff", flag.ExitOnError)
+		args.Parse(os.Args[2:])
+		manifestName := args.Arg(0)
+		err = scheduler_k3s.CommandManifestDiff(manifestName)
+	case "node-annotations:list":
+		args := flag.NewFlagSet("scheduler-k3s:node-annotations:list", flag.ExitOnError)
+		format := args.String("format", "stdout", "format: [ stdout | json ]")
+		args.Parse(os.Args[2:])
+		nodeName := args.Arg(0)
+		err = scheduler_k3s.CommandNodeAnnotationsList(nodeName, *format)
+	case "node-annotations:set":
+		args := flag.NewFlagSet("scheduler-k3s:node-annotations:set", flag.ExitOnError)
+		args.Parse(os.Args[2:])
+		nodeName := args.Arg(0)
+		key := args.Arg(1)
+		value := args.Arg(2)
+		err = scheduler_k3s.CommandNodeAnnotationsSet(nodeName, key, value)
+	case "node-annotations:unset":
+		args := flag.NewFlagSet("scheduler-k3s:node-annotations:unset", flag.ExitOnError)
+		args.Parse(os.Args[2:])
+		nodeName := args.Arg(0)
+		key := args.Arg(1)
+		err = scheduler_k3s.CommandNodeAnnotationsUnset(nodeName, key)
+	case "pending-pods":
+		args := flag.NewFlagSet("scheduler-k3s:pending-pods", flag.ExitOnError)
+		format := args.String("format", "stdout", "format: [ stdout | json ]")
+		args.Parse(os.Args[2:])
+		err = scheduler_k3s.CommandPendingPods(*format)
+	case "pool:list":
+		args := flag.NewFlagSet("scheduler-k3s:pool:list", flag.ExitOnError)
+		format := args.String("format", "stdout", "format: [ stdout | json ]")
+		args.Parse(os.Args[2:])
+		err = scheduler_k3s.CommandPoolList(*format)
+	case "registry:mirror":
+		args := flag.NewFlagSet("scheduler-k3s:registry:mirror", flag.ExitOnError)
+		upstream := args.String("upstream", "docker.io", "upstream: registry to add the mirror endpoint for")
+		args.Parse(os.Args[2:])
+		endpoint := args.Arg(0)
+		err = scheduler_k3s.CommandRegistryMirror(*upstream, endpoint)
+	case "registry:set":
+		args := flag.NewFlagSet("scheduler-k3s:registry:set", flag.ExitOnError)
+		username := args.String("username", "", "username: username to authenticate against the registry mirror with")
+		password := args.String("password", "", "password: password to authenticate against the registry mirror with")
+		args.Parse(os.Args[2:])
+		registry := args.Arg(0)
+		endpoint := args.Arg(1)
+		err = scheduler_k3s.CommandRegistrySet(registry, endpoint, *username, *password)
 	case "report":
 		args := flag.NewFlagSet("scheduler-k3s:report", flag.ExitOnError)
 		format := args.String("format", "stdout", "format: [ stdout | json ]")
-		osArgs, infoFlag, flagErr := common.ParseReportArgs("scheduler-k3s", os.Args[2:])
+		parallel := args.Int("parallel", 5, "parallel: number of app reports to collect in parallel, -1 to match cpu count")
+		// ParseReportArgs only understands --format, so --parallel is parsed separately
+		reportArgs := []string{}
+		parallelArgs := []string{}
+		for i := 2; i < len(os.Args); i++ {
+			if os.Args[i] == "--parallel" && i+1 < len(os.Args) {
+				parallelArgs = append(parallelArgs, os.Args[i], os.Args[i+1])
+				i++
+				continue
+			}
+			if strings.HasPrefix(os.Args[i], "--parallel=") {
+				parallelArgs = append(parallelArgs, os.Args[i])
+				continue
+			}
+			reportArgs = append(reportArgs, os.Args[i])
+		}
+
+		osArgs, infoFlag, flagErr := common.ParseReportArgs("scheduler-k3s", reportArgs)
 		if flagErr == nil {
-			args.Parse(osArgs)
+			args.Parse(append(parallelArgs, osArgs...))
 			appName := args.Arg(0)
-			err = scheduler_k3s.CommandReport(appName, *format, infoFlag)
+			err = scheduler_k3s.CommandReport(appName, *format, infoFlag, *parallel)
 		}
+	case "resources:audit":
+		args := flag.NewFlagSet("scheduler-k3s:resources:audit", flag.ExitOnError)
+		format := args.String("format", "stdout", "format: [ stdout | json ]")
+		args.Parse(os.Args[2:])
+		err = scheduler_k3s.CommandResourcesAudit(*format)
 	case "set":
 		args := flag.NewFlagSet("scheduler-k3s:set", flag.ExitOnError)
 		global := args.Bool("global", false, "--global: set a global property")
+		force := args.Bool("force", false, "--force: change the ingress-class or loadbalancer of an initialized cluster")
 		args.Parse(os.Args[2:])
 		appName := args.Arg(0)
 		property := args.Arg(1)
@@ -117,15 +370,43 @@ func main() {
 			property = args.Arg(0)
 			value = args.Arg(1)
 		}
-		err = scheduler_k3s.CommandSet(appName, property, value)
+		err = scheduler_k3s.CommandSet(appName, property, value, *force)
 	case "show-kubeconfig":
 		args := flag.NewFlagSet("scheduler-k3s:show-kubeconfig", flag.ExitOnError)
+		serverURL := args.String("server-url", "", "server-url: external url of the kubernetes api server to use in the kubeconfig")
+		detectServerURL := args.Bool("detect-server-url", false, "detect-server-url: use the server ip address of the dokku server in the kubeconfig")
+		format := args.String("format", "stdout", "format: [ stdout | json ]")
+		includeSecrets := args.Bool("include-secrets", false, "include-secrets: include credentials in json output")
 		args.Parse(os.Args[2:])
-		err = scheduler_k3s.CommandShowKubeconfig()
+		err = scheduler_k3s.CommandShowKubeconfig(*serverURL, *detectServerURL, *format, *includeSecrets)
+	case "taint:add":
+		args := flag.NewFlagSet("scheduler-k3s:taint:add", flag.ExitOnError)
+		args.Parse(os.Args[2:])
+		nodeName := args.Arg(0)
+		taint := args.Arg(1)
+		err = scheduler_k3s.CommandTaintAdd(nodeName, taint)
+	case "taint:remove":
+		args := flag.NewFlagSet("scheduler-k3s:taint:remove", flag.ExitOnError)
+		args.Parse(os.Args[2:])
+		nodeName := args.Arg(0)
+		taint := args.Arg(1)
+		err = scheduler_k3s.CommandTaintRemove(nodeName, taint)
+	case "token:rotate":
+		args := flag.NewFlagSet("scheduler-k3s:token:rotate", flag.ExitOnError)
+		force := args.Bool("force", false, "force: confirm rotating the k3s token")
+		args.Parse(os.Args[2:])
+		err = scheduler_k3s.CommandTokenRotate(*force)
+	case "uncordon":
+		args := flag.NewFlagSet("scheduler-k3s:uncordon", flag.ExitOnError)
+		args.Parse(os.Args[2:])
+		nodeName := args.Arg(0)
+		err = scheduler_k3s.CommandUncordon(nodeName)
 	case "uninstall":
 		args := flag.NewFlagSet("scheduler-k3s:uninstall", flag.ExitOnError)
+		all := args.Bool("all", false, "all: uninstall k3s from all remote nodes before uninstalling locally")
+		force := args.Bool("force", false, "force: confirm uninstalling k3s from all nodes")
 		args.Parse(os.Args[2:])
-		err = scheduler_k3s.CommandUninstall()
+		err = scheduler_k3s.CommandUninstall(*all, *force)
 	default:
 		err = fmt.Errorf("Invalid plugin subcommand call: %s", subcommand)
 	}
@@ -1,20 +1,38 @@
 package scheduler_k3s
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
+	certmanagerv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
 	"github.com/dokku/dokku/plugins/common"
-	resty "github.com/go-resty/resty/v2"
 	"github.com/ryanuber/columnize"
+	"golang.org/x/sync/errgroup"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 // CommandAnnotationsSet set or clear a scheduler-k3s annotation for an app
@@ -144,13 +162,38 @@ func CommandAutoscalingAuthReport(appName string, format string, global bool, in
 }
 
 // CommandInitialize initializes a k3s cluster on the local server
-func CommandInitialize(ingressClass string, serverIP string, taintScheduling bool) error {
-	if ingressClass != "nginx" && ingressClass != "traefik" {
+func CommandInitialize(ingressClass string, serverIP string, taintScheduling bool, finalize bool, disable []string, skipDependencies bool, expectNodes int, waitReady bool, waitTimeout int, logFormat string) error {
+	logger, err := NewStepLogger("initialize", logFormat)
+	if err != nil {
+		return err
+	}
+
+	err = initializeCluster(logger, ingressClass, serverIP, taintScheduling, finalize, disable, skipDependencies, expectNodes, waitReady, waitTimeout)
+	logger.Finish(err)
+	return err
+}
+
+// initializeCluster runs the steps to initialize a k3s cluster, logging each step via the logger
+func initializeCluster(logger *StepLogger, ingressClass string, serverIP string, taintScheduling bool, finalize bool, disable []string, skipDependencies bool, expectNodes int, waitReady bool, waitTimeout int) error {
+	if ingressClass != "" && ingressClass != "nginx" && ingressClass != "traefik" {
 		return fmt.Errorf("Invalid ingress-class: %s", ingressClass)
 	}
 
-	if err := isK3sInstalled(); err == nil {
-		return fmt.Errorf("k3s already installed, cannot re-initialize k3s")
+	if expectNodes < 1 {
+		return fmt.Errorf("Invalid expect-nodes value, expected a positive integer: %d", expectNodes)
+	}
+	if expectNodes == 1 && taintScheduling {
+		common.LogWarn("Tainting the only node in a single-node cluster prevents app workloads from being scheduled until worker nodes are added")
+	}
+
+	if waitReady && waitTimeout <= 0 {
+		return fmt.Errorf("Invalid wait-timeout value, expected a positive integer: %d", waitTimeout)
+	}
+
+	if getGlobalLoadbalancer() == "metallb" {
+		if _, err := parseMetalLBAddressPool(getGlobalMetalLBAddressPool()); err != nil {
+			return fmt.Errorf("The metallb loadbalancer requires an address pool, set one with scheduler-k3s:set --global metallb-address-pool: %w", err)
+		}
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -164,108 +207,212 @@ func CommandInitialize(ingressClass string, serverIP string, taintScheduling boo
 		cancel()
 	}()
 
-	if serverIP == "" {
-		var err error
-		serverIP, err = getServerIP()
+	if err := isK3sInstalled(); err == nil {
+		if !finalize {
+			return fmt.Errorf("k3s already installed, cannot re-initialize k3s, use --finalize to resume a partial initialization")
+		}
+
+		if ingressClass == "" {
+			ingressClass = getGlobalIngressClass()
+		} else if ingressClass != getGlobalIngressClass() {
+			return fmt.Errorf("The --ingress-class flag (%s) differs from the ingress class the cluster was initialized with (%s), omit the flag to finalize with the recorded ingress class", ingressClass, getGlobalIngressClass())
+		}
+
+		common.LogInfo1Quiet("Finalizing k3s initialization")
+		common.LogVerboseQuiet("Skipping apt dependencies and k3s installer, k3s is already installed")
+		clientset, err := NewKubernetesClient()
 		if err != nil {
-			return fmt.Errorf("Unable to get server ip address: %w", err)
+			return fmt.Errorf("Unable to create kubernetes client: %w", err)
+		}
+
+		if err := clientset.Ping(); err != nil {
+			return fmt.Errorf("kubernetes api not available, cannot finalize initialization: %w", err)
+		}
+
+		nodes, err := clientset.ListNodes(ctx, ListNodesInput{})
+		if err != nil {
+			return fmt.Errorf("Unable to list nodes: %w", err)
+		}
+
+		nodeName := ""
+		for _, node := range nodes {
+			isLocal, err := isLocalNode(node)
+			if err != nil {
+				return fmt.Errorf("Unable to check if node is the local node: %w", err)
+			}
+			if isLocal {
+				nodeName = node.Name
+				break
+			}
+		}
+		if nodeName == "" {
+			return fmt.Errorf("Unable to find the local node in the cluster")
+		}
+
+		err = finalizeInitialize(ctx, FinalizeInitializeInput{
+			Clientset:    clientset,
+			IngressClass: ingressClass,
+			NodeName:     nodeName,
+		})
+		if err != nil {
+			return err
+		}
+
+		if waitReady {
+			logger.Step("Waiting for core components to become ready")
+			err = waitForCoreComponentsReady(ctx, WaitForCoreComponentsReadyInput{
+				Clientset:  clientset,
+				Components: getCoreComponents(),
+				Timeout:    time.Duration(waitTimeout) * time.Second,
+			})
+			if err != nil {
+				return err
+			}
 		}
 
-		common.LogVerboseQuiet(fmt.Sprintf("Using server ip address: %s", serverIP))
+		common.LogVerboseQuiet("Done")
+		return nil
 	}
 
-	common.LogInfo1Quiet("Initializing k3s")
+	if ingressClass == "" {
+		ingressClass = "traefik"
+	}
 
-	common.LogInfo2Quiet("Updating apt")
-	aptUpdateCmd, err := common.CallExecCommand(common.ExecCommandInput{
-		Command: "apt-get",
-		Args: []string{
-			"update",
-		},
-		StreamStdio: true,
-	})
+	kubeletArgs, err := getKubeletArgs()
 	if err != nil {
-		return fmt.Errorf("Unable to call apt-get update command: %w", err)
-	}
-	if aptUpdateCmd.ExitCode != 0 {
-		return fmt.Errorf("Invalid exit code from apt-get update command: %d", aptUpdateCmd.ExitCode)
-	}
-
-	common.LogInfo2Quiet("Installing k3s dependencies")
-	aptInstallCmd, err := common.CallExecCommand(common.ExecCommandInput{
-		Command: "apt-get",
-		Args: []string{
-			"-y",
-			"install",
-			"ca-certificates",
-			"curl",
-			"open-iscsi",
-			"nfs-common",
-			"wireguard",
-		},
-		StreamStdio: true,
-	})
+		return fmt.Errorf("Unable to get kubelet args: %w", err)
+	}
+
+	kubeControllerManagerArgs, err := getKubeControllerManagerArgs()
 	if err != nil {
-		return fmt.Errorf("Unable to call apt-get install command: %w", err)
+		return fmt.Errorf("Unable to get kube-controller-manager args: %w", err)
 	}
-	if aptInstallCmd.ExitCode != 0 {
-		return fmt.Errorf("Invalid exit code from apt-get install command: %d", aptInstallCmd.ExitCode)
+
+	nodeWaitTimeout, err := strconv.Atoi(getGlobalNodeWaitTimeout())
+	if err != nil {
+		return fmt.Errorf("Invalid node-wait-timeout value: %w", err)
 	}
 
-	common.LogInfo2Quiet("Downloading k3s installer")
-	client := resty.New()
-	resp, err := client.R().
-		SetContext(ctx).
-		Get("https://get.k3s.io")
+	disableArgs, err := getK3sDisableArgs(disable)
 	if err != nil {
-		return fmt.Errorf("Unable to download k3s installer: %w", err)
+		return err
 	}
-	if resp == nil {
-		return fmt.Errorf("Missing response from k3s installer download: %w", err)
+
+	if err := validateDataDir(getGlobalDataDir()); err != nil {
+		return err
 	}
 
-	if resp.StatusCode() != 200 {
-		return fmt.Errorf("Invalid status code for k3s installer script: %d", resp.StatusCode())
+	if err := validateIPFamilyCIDRs(); err != nil {
+		return err
 	}
 
-	f, err := os.CreateTemp("", "sample")
-	if err != nil {
-		return fmt.Errorf("Unable to create temporary file for k3s installer: %w", err)
+	if getGlobalNetworkInterface() == "" {
+		networkInterface, err := getDefaultRouteInterface()
+		if err != nil {
+			common.LogWarn(fmt.Sprintf("Unable to detect the default route interface, using %s: %s", DefaultNetworkInterface, err.Error()))
+		} else {
+			common.LogInfo1Quiet(fmt.Sprintf("Using network interface %s from the default route, change it with scheduler-k3s:set --global network-interface", networkInterface))
+			if err := common.PropertyWrite("scheduler-k3s", "--global", "network-interface", networkInterface); err != nil {
+				return fmt.Errorf("Unable to save network-interface property: %w", err)
+			}
+		}
 	}
-	defer os.Remove(f.Name())
 
-	if err := f.Close(); err != nil {
-		return fmt.Errorf("Unable to close k3s installer file: %w", err)
+	if serverIP == "" {
+		var err error
+		serverIP, err = getServerIP()
+		if err != nil {
+			return fmt.Errorf("Unable to get server ip address: %w", err)
+		}
+		if err := validateRoutableServerIP(serverIP); err != nil {
+			return err
+		}
+
+		common.LogVerboseQuiet(fmt.Sprintf("Using server ip address from %s interface: %s", getNetworkInterface(), serverIP))
 	}
 
-	err = common.WriteStringToFile(common.WriteStringToFileInput{
-		Content:  resp.String(),
-		Filename: f.Name(),
-		Mode:     os.FileMode(0755),
-	})
-	if err != nil {
-		return fmt.Errorf("Unable to write k3s installer to file: %w", err)
+	common.LogInfo1Quiet("Initializing k3s")
+
+	if skipDependencies {
+		logger.Step("Checking k3s dependencies")
+		if err := checkLocalDependencies(); err != nil {
+			return err
+		}
+		if err := checkLocalAclDependencies(); err != nil {
+			return err
+		}
+	} else {
+		logger.Step("Updating apt")
+		aptUpdateCmd, err := common.CallExecCommand(common.ExecCommandInput{
+			Command: "apt-get",
+			Args: []string{
+				"update",
+			},
+			Env:         getProxyEnv(),
+			StreamStdio: shouldStreamStdio(),
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to call apt-get update command: %w", err)
+		}
+		if aptUpdateCmd.ExitCode != 0 {
+			return exitCodeError("apt-get update command", aptUpdateCmd.ExitCode, aptUpdateCmd.Stdout, aptUpdateCmd.Stderr)
+		}
+
+		logger.Step("Installing k3s dependencies")
+		aptInstallCmd, err := common.CallExecCommand(common.ExecCommandInput{
+			Command:     "apt-get",
+			Args:        append([]string{"-y", "install", "acl"}, getK3sDependencies()...),
+			Env:         getProxyEnv(),
+			StreamStdio: shouldStreamStdio(),
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to call apt-get install command: %w", err)
+		}
+		if aptInstallCmd.ExitCode != 0 {
+			return exitCodeError("apt-get install command", aptInstallCmd.ExitCode, aptInstallCmd.Stdout, aptInstallCmd.Stderr)
+		}
+		if err := checkLocalAclDependencies(); err != nil {
+			return err
+		}
 	}
 
-	fi, err := os.Stat(f.Name())
-	if err != nil {
-		return fmt.Errorf("Unable to get k3s installer file size: %w", err)
+	if getGlobalFlannelBackend() == "wireguard-native" {
+		logger.Step("Checking wireguard support")
+		if err := checkLocalWireguardSupport(); err != nil {
+			return err
+		}
 	}
 
-	if fi.Size() == 0 {
-		return fmt.Errorf("Invalid k3s installer filesize")
+	installerPath := getGlobalK3sInstallerPath()
+	if installerPath != "" {
+		logger.Step("Using staged k3s installer")
+		common.LogVerboseQuiet(fmt.Sprintf("Installer path: %s", installerPath))
+		if err := validateK3sInstaller(installerPath); err != nil {
+			return err
+		}
+	} else {
+		logger.Step("Downloading k3s installer")
+		installer, err := downloadK3sInstaller(ctx)
+		if err != nil {
+			return err
+		}
+
+		installerPath, err = writeK3sInstallerTempFile(installer)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(installerPath)
 	}
 
 	token := getGlobalGlobalToken()
 	if len(token) == 0 {
-		n := 5
-		b := make([]byte, n)
-		if _, err := rand.Read(b); err != nil {
-			return fmt.Errorf("Unable to generate random node name: %w", err)
+		var err error
+		token, err = generateToken()
+		if err != nil {
+			return err
 		}
 
-		token = strings.ToLower(fmt.Sprintf("%X", b))
-		if err := CommandSet("--global", "token", token); err != nil {
+		if err := CommandSet("--global", "token", token, false); err != nil {
 			return fmt.Errorf("Unable to set k3s token: %w", err)
 		}
 	}
@@ -276,27 +423,19 @@ func CommandInitialize(ingressClass string, serverIP string, taintScheduling boo
 	if _, err := rand.Read(b); err != nil {
 		return fmt.Errorf("Unable to generate random node name: %w", err)
 	}
-	nodeName = strings.ReplaceAll(strings.ToLower(fmt.Sprintf("ip-%s-%s", nodeName, fmt.Sprintf("%X", b))), ".", "-")
+	nodeName = strings.NewReplacer(".", "-", ":", "-").Replace(strings.ToLower(fmt.Sprintf("ip-%s-%s", nodeName, fmt.Sprintf("%X", b))))
 
 	args := []string{
 		// initialize the cluster
 		"--cluster-init",
-		// disable local-storage
-		"--disable", "local-storage",
-		// disable traefik so it can be installed separately
-		"--disable", "traefik",
 		// expose etcd metrics
 		"--etcd-expose-metrics",
-		// use wireguard for flannel
-		"--flannel-backend=wireguard-native",
-		// bind controller-manager to all interfaces
-		"--kube-controller-manager-arg", "bind-address=0.0.0.0",
+		// set the flannel backend
+		fmt.Sprintf("--flannel-backend=%s", getGlobalFlannelBackend()),
 		// bind proxy metrics to all interfaces
 		"--kube-proxy-arg", "metrics-bind-address=0.0.0.0",
 		// bind scheduler to all interfaces
 		"--kube-scheduler-arg", "bind-address=0.0.0.0",
-		// gc terminated pods
-		"--kube-controller-manager-arg", "terminated-pod-gc-threshold=10",
 		// specify the node name
 		"--node-name", nodeName,
 		// allow access for the dokku user
@@ -304,8 +443,16 @@ func CommandInitialize(ingressClass string, serverIP string, taintScheduling boo
 		// specify a token
 		"--token", token,
 	}
+	// disable local-storage, traefik, and any user-specified components
+	args = append(args, disableArgs...)
+	// store k3s state in a custom data directory
+	args = append(args, getK3sDataDirArgs()...)
+	// configure pod and service cidrs for the ip-family
+	args = append(args, getIPFamilyArgs()...)
+	args = append(args, kubeControllerManagerArgs...)
+	args = append(args, kubeletArgs...)
 	if taintScheduling {
-		args = append(args, "--node-taint", "CriticalAddonsOnly=true:NoSchedule")
+		args = append(args, "--node-taint", CriticalAddonsOnlyTaint)
 	}
 
 	common.CommandPropertySet("scheduler-k3s", "--global", "ingress-class", ingressClass, DefaultProperties, GlobalProperties)
@@ -313,94 +460,102 @@ func CommandInitialize(ingressClass string, serverIP string, taintScheduling boo
 		args = append(args, "--disable", "traefik")
 	}
 
-	common.LogInfo2Quiet("Running k3s installer")
-	installerCmd, err := common.CallExecCommand(common.ExecCommandInput{
-		Command:     f.Name(),
-		Args:        args,
-		StreamStdio: true,
-	})
+	initConfig := getInitConfig(args, ingressClass, serverIP, taintScheduling)
+	flannelConfig, err := getFlannelConfig(initConfig.FlannelBackend, initConfig.FlannelWireguardPort, initConfig.ClusterCIDR)
 	if err != nil {
-		return fmt.Errorf("Unable to call k3s installer command: %w", err)
+		return err
 	}
-	if installerCmd.ExitCode != 0 {
-		return fmt.Errorf("Invalid exit code from k3s installer command: %d", installerCmd.ExitCode)
+	if len(flannelConfig) > 0 {
+		args = append(args, "--flannel-conf", FlannelConfigPath)
 	}
 
-	clientset, err := NewKubernetesClient()
-	if err != nil {
-		return fmt.Errorf("Unable to create kubernetes client: %w", err)
+	dnsUpstreamServers := getDNSUpstreamServers()
+	if len(dnsUpstreamServers) > 0 {
+		args = append(args, "--resolv-conf", DNSResolvConfPath)
 	}
-
-	common.LogInfo2Quiet("Waiting for node to exist")
-	nodes, err := waitForNodeToExist(ctx, WaitForNodeToExistInput{
-		Clientset:  clientset,
-		NodeName:   nodeName,
-		RetryCount: 20,
-	})
+	dnsStubDomains, err := getDNSStubDomains()
 	if err != nil {
-		return fmt.Errorf("Error waiting for pod to exist: %w", err)
+		return err
 	}
-	if len(nodes) == 0 {
-		return fmt.Errorf("Unable to find node after initializing cluster, node will not be annotated/labeled appropriately access registry secrets")
+
+	// the k3s installer copies the proxy environment into the k3s service so containerd image pulls use it
+	env := getProxyEnv()
+	if k3sVersion := getGlobalK3sVersion(); k3sVersion != "" {
+		common.LogVerboseQuiet(fmt.Sprintf("Installing k3s version: %s", k3sVersion))
+		env["INSTALL_K3S_VERSION"] = k3sVersion
+	} else if k3sChannel := getGlobalK3sChannel(); k3sChannel != "" {
+		common.LogVerboseQuiet(fmt.Sprintf("Installing k3s from channel: %s", k3sChannel))
+		env["INSTALL_K3S_CHANNEL"] = k3sChannel
 	}
 
-	for _, manifest := range KubernetesManifests {
-		common.LogInfo2Quiet(fmt.Sprintf("Installing %s@%s", manifest.Name, manifest.Version))
-		err = clientset.ApplyKubernetesManifest(ctx, ApplyKubernetesManifestInput{
-			Manifest: manifest.Path,
-		})
-		if err != nil {
-			return fmt.Errorf("Unable to apply kubernetes manifest: %w", err)
+	if len(getAirgapFiles()) > 0 {
+		logger.Step("Staging k3s airgap files")
+		if err := stageAirgapFiles(); err != nil {
+			return err
 		}
 	}
+	if getGlobalK3sBinaryPath() != "" {
+		// the installer would otherwise download k3s, which fails in air-gapped environments
+		env["INSTALL_K3S_SKIP_DOWNLOAD"] = "true"
+	}
 
-	for key, value := range ServerLabels {
-		common.LogInfo2Quiet(fmt.Sprintf("Labeling node %s=%s", key, value))
+	registryMirrors, err := getRegistryMirrors()
+	if err != nil {
+		return err
+	}
+	if len(registryMirrors) > 0 {
+		logger.Step("Writing registry mirrors")
+		contents, err := renderRegistryConfig(registryMirrors)
 		if err != nil {
-			return fmt.Errorf("Unable to create kubernetes client: %w", err)
+			return fmt.Errorf("Unable to render registry config: %w", err)
+		}
+		if err := writeRegistryConfig(contents); err != nil {
+			return err
 		}
+	}
 
-		err = clientset.LabelNode(ctx, LabelNodeInput{
-			Name:  nodeName,
-			Key:   key,
-			Value: value,
-		})
-		if err != nil {
-			return fmt.Errorf("Unable to patch node: %w", err)
+	if len(flannelConfig) > 0 {
+		logger.Step("Writing flannel config")
+		if err := writeFlannelConfig(flannelConfig); err != nil {
+			return err
 		}
 	}
 
-	common.LogInfo2Quiet("Installing helm charts")
-	err = installHelmCharts(ctx, clientset, func(chart HelmChart) bool {
-		if chart.ChartPath == "traefik" && ingressClass == "nginx" {
-			return false
+	if len(dnsUpstreamServers) > 0 {
+		logger.Step("Writing dns resolver config")
+		if err := writeDNSResolvConf(renderDNSResolvConf(dnsUpstreamServers)); err != nil {
+			return err
 		}
+	}
 
-		if chart.ChartPath == "ingress-nginx" && ingressClass == "traefik" {
-			return false
+	if len(dnsStubDomains) > 0 {
+		logger.Step("Writing CoreDNS custom config")
+		contents, err := renderCoreDNSCustomConfig(dnsStubDomains)
+		if err != nil {
+			return fmt.Errorf("Unable to render CoreDNS custom config: %w", err)
 		}
+		if err := writeCoreDNSCustomConfig(contents); err != nil {
+			return err
+		}
+	}
 
-		return true
-	})
-	if err != nil {
-		return fmt.Errorf("Unable to install helm charts: %w", err)
+	logger.Step("Granting registry config access")
+	if err := grantRegistryConfigAccess(ctx); err != nil {
+		return err
 	}
 
-	common.LogInfo2Quiet("Installing helper commands")
-	err = installHelperCommands(ctx)
+	logger.Step("Running k3s installer")
+	installerCmd, err := common.CallExecCommand(common.ExecCommandInput{
+		Command:     installerPath,
+		Args:        args,
+		Env:         env,
+		StreamStdio: shouldStreamStdio(),
+	})
 	if err != nil {
-		return fmt.Errorf("Unable to install helper commands: %w", err)
+		return fmt.Errorf("Unable to call k3s installer command: %w", err)
 	}
-
-	common.LogVerboseQuiet("Done")
-
-	return nil
-}
-
-// CommandClusterAdd adds a server to the k3s cluster
-func CommandClusterAdd(role string, remoteHost string, serverIP string, allowUknownHosts bool, taintScheduling bool) error {
-	if err := isK3sInstalled(); err != nil {
-		return fmt.Errorf("k3s not installed, cannot add node to cluster: %w", err)
+	if installerCmd.ExitCode != 0 {
+		return exitCodeError("k3s installer command", installerCmd.ExitCode, installerCmd.Stdout, installerCmd.Stderr)
 	}
 
 	clientset, err := NewKubernetesClient()
@@ -408,31 +563,62 @@ func CommandClusterAdd(role string, remoteHost string, serverIP string, allowUkn
 		return fmt.Errorf("Unable to create kubernetes client: %w", err)
 	}
 
-	if err := clientset.Ping(); err != nil {
-		return fmt.Errorf("kubernetes api not available, cannot add node to cluster: %w", err)
+	logger.Step("Waiting for node to exist")
+	nodes, err := waitForNodeToExist(ctx, WaitForNodeToExistInput{
+		Clientset: clientset,
+		NodeName:  nodeName,
+		Backoff:   1 * time.Second,
+		Timeout:   time.Duration(nodeWaitTimeout) * time.Second,
+	})
+	if err != nil {
+		return fmt.Errorf("Error waiting for node to exist: %w", err)
 	}
-
-	if role != "server" && role != "worker" {
-		return fmt.Errorf("Invalid server-type: %s", role)
+	if len(nodes) == 0 {
+		return fmt.Errorf("Unable to find node after initializing cluster, node will not be annotated/labeled appropriately access registry secrets")
 	}
 
-	token := getGlobalGlobalToken()
-	if len(token) == 0 {
-		return fmt.Errorf("Missing k3s token")
+	logger.Step("Recording init config")
+	initConfig.InitializedAt = time.Now().UTC()
+	initConfig.ExpectNodes = expectNodes
+	initConfig.K3sVersion = nodes[0].Status.NodeInfo.KubeletVersion
+	if err := writeInitConfig(initConfig); err != nil {
+		common.LogWarn(err.Error())
 	}
 
-	if taintScheduling && role == "worker" {
-		return fmt.Errorf("Taint scheduling can only be used on the server role")
+	err = finalizeInitialize(ctx, FinalizeInitializeInput{
+		Clientset:    clientset,
+		IngressClass: ingressClass,
+		NodeName:     nodeName,
+	})
+	if err != nil {
+		return fmt.Errorf("%w, run scheduler-k3s:initialize --finalize to resume the initialization", err)
 	}
 
-	if serverIP == "" {
-		var err error
-		serverIP, err = getServerIP()
+	if waitReady {
+		logger.Step("Waiting for core components to become ready")
+		err = waitForCoreComponentsReady(ctx, WaitForCoreComponentsReadyInput{
+			Clientset:  clientset,
+			Components: getCoreComponents(),
+			Timeout:    time.Duration(waitTimeout) * time.Second,
+		})
 		if err != nil {
-			return fmt.Errorf("Unable to get server ip address: %w", err)
+			return fmt.Errorf("%w, run scheduler-k3s:initialize --finalize --wait to wait again", err)
 		}
+	}
+
+	common.LogVerboseQuiet("Done")
+
+	return nil
+}
+
+// CommandCertificatesExpiring lists app certificates that have expired or will expire within the warning window
+func CommandCertificatesExpiring(format string, warnDays int, failOnExpiring bool) error {
+	if format != "stdout" && format != "json" {
+		return fmt.Errorf("Invalid format: %s", format)
+	}
 
-		common.LogVerboseQuiet(fmt.Sprintf("Using server ip address: %s", serverIP))
+	if warnDays < 0 {
+		return fmt.Errorf("Invalid warn-days value, expected a non-negative integer: %d", warnDays)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -446,151 +632,473 @@ func CommandClusterAdd(role string, remoteHost string, serverIP string, allowUkn
 		cancel()
 	}()
 
-	// todo: check if k3s is installed on the remote host
-
-	k3sVersionCmd, err := common.CallExecCommand(common.ExecCommandInput{
-		Command: "k3s",
-		Args:    []string{"--version"},
-	})
+	clientset, err := NewKubernetesClient()
 	if err != nil {
-		return fmt.Errorf("Unable to call k3s version command: %w", err)
-	}
-	if k3sVersionCmd.ExitCode != 0 {
-		return fmt.Errorf("Invalid exit code from k3s --version command: %d", k3sVersionCmd.ExitCode)
+		return fmt.Errorf("Unable to create kubernetes client: %w", err)
 	}
 
-	k3sVersion := ""
-	k3sVersionLines := strings.Split(string(k3sVersionCmd.Stdout), "\n")
-	if len(k3sVersionLines) > 0 {
-		k3sVersionParts := strings.Split(k3sVersionLines[0], " ")
-		if len(k3sVersionParts) != 4 {
-			return fmt.Errorf("Unable to get k3s version from k3s --version: %s", k3sVersionCmd.Stdout)
-		}
-		k3sVersion = k3sVersionParts[2]
+	if err := clientset.Ping(); err != nil {
+		return fmt.Errorf("kubernetes api not available, cannot list certificates: %w", err)
 	}
-	common.LogDebug(fmt.Sprintf("k3s version: %s", k3sVersion))
 
-	common.LogInfo1(fmt.Sprintf("Joining %s to k3s cluster as %s", remoteHost, role))
-	common.LogInfo2Quiet("Updating apt")
-	aptUpdateCmd, err := common.CallSshCommand(common.SshCommandInput{
-		Command: "apt-get",
-		Args: []string{
-			"update",
-		},
-		AllowUknownHosts: allowUknownHosts,
-		RemoteHost:       remoteHost,
-		StreamStdio:      true,
-		Sudo:             true,
+	certificates, err := clientset.ListCertificates(ctx, ListCertificatesInput{
+		LabelSelector: "app.kubernetes.io/part-of",
 	})
 	if err != nil {
-		return fmt.Errorf("Unable to call apt-get update command over ssh: %w", err)
-	}
-	if aptUpdateCmd.ExitCode != 0 {
-		return fmt.Errorf("Invalid exit code from apt-get update command over ssh: %d", aptUpdateCmd.ExitCode)
-	}
-
-	common.LogInfo2Quiet("Installing k3s dependencies")
-	aptInstallCmd, err := common.CallSshCommand(common.SshCommandInput{
-		Command: "apt-get",
-		Args: []string{
-			"-y",
-			"install",
-			"ca-certificates",
-			"curl",
-			"open-iscsi",
-			"nfs-common",
-			"wireguard",
-		},
-		AllowUknownHosts: allowUknownHosts,
-		RemoteHost:       remoteHost,
-		StreamStdio:      true,
-		Sudo:             true,
-	})
-	if err != nil {
-		return fmt.Errorf("Unable to call apt-get install command over ssh: %w", err)
+		return fmt.Errorf("Unable to list certificates: %w", err)
 	}
-	if aptInstallCmd.ExitCode != 0 {
-		return fmt.Errorf("Invalid exit code from apt-get install command over ssh: %d", aptInstallCmd.ExitCode)
+
+	now := time.Now()
+	threshold := now.Add(time.Duration(warnDays) * 24 * time.Hour)
+	output := []CertificateExpiry{}
+	for _, certificate := range certificates {
+		expiry := CertificateExpiry{
+			AppName:   certificate.Labels["app.kubernetes.io/part-of"],
+			Name:      certificate.Name,
+			Namespace: certificate.Namespace,
+		}
+
+		for _, condition := range certificate.Status.Conditions {
+			if condition.Type != certmanagerv1.CertificateConditionReady {
+				continue
+			}
+
+			expiry.Ready = string(condition.Status) == "True"
+			expiry.RenewalStatus = condition.Reason
+			if !expiry.Ready && condition.Message != "" {
+				expiry.RenewalStatus = condition.Message
+			}
+		}
+
+		// certificates that have not been issued yet are always reported
+		if certificate.Status.NotAfter != nil {
+			notAfter := certificate.Status.NotAfter.Time
+			if notAfter.After(threshold) {
+				continue
+			}
+
+			expiry.NotAfter = notAfter.Format(time.RFC3339)
+			expiry.DaysRemaining = int(notAfter.Sub(now).Hours() / 24)
+		}
+
+		output = append(output, expiry)
 	}
 
-	common.LogInfo2Quiet("Downloading k3s installer")
-	curlTask, err := common.CallSshCommand(common.SshCommandInput{
-		Command: "curl",
-		Args: []string{
-			"-o /tmp/k3s-installer.sh",
-			"https://get.k3s.io",
-		},
-		AllowUknownHosts: allowUknownHosts,
-		RemoteHost:       remoteHost,
-		StreamStdio:      true,
+	sort.Slice(output, func(i, j int) bool {
+		return output[i].DaysRemaining < output[j].DaysRemaining
 	})
-	if err != nil {
-		return fmt.Errorf("Unable to call curl command over ssh: %w", err)
-	}
-	if curlTask.ExitCode != 0 {
-		return fmt.Errorf("Invalid exit code from curl command over ssh: %d", curlTask.ExitCode)
+
+	if format == "stdout" {
+		lines := []string{"app|name|namespace|not-after|days-remaining|ready|renewal-status"}
+		for _, expiry := range output {
+			lines = append(lines, expiry.String())
+		}
+
+		columnized := columnize.SimpleFormat(lines)
+		fmt.Println(columnized)
+	} else {
+		b, err := json.Marshal(output)
+		if err != nil {
+			return fmt.Errorf("Unable to marshal json: %w", err)
+		}
+
+		fmt.Println(string(b))
 	}
 
-	common.LogInfo2Quiet("Setting k3s installer permissions")
-	chmodCmd, err := common.CallSshCommand(common.SshCommandInput{
-		Command: "chmod",
-		Args: []string{
-			"0755",
-			"/tmp/k3s-installer.sh",
-		},
-		AllowUknownHosts: allowUknownHosts,
-		RemoteHost:       remoteHost,
-		StreamStdio:      true,
-	})
-	if err != nil {
-		return fmt.Errorf("Unable to call chmod command over ssh: %w", err)
+	if failOnExpiring && len(output) > 0 {
+		return fmt.Errorf("%d certificates expired or expiring within %d days", len(output), warnDays)
 	}
-	if chmodCmd.ExitCode != 0 {
-		return fmt.Errorf("Invalid exit code from chmod command over ssh: %d", chmodCmd.ExitCode)
+
+	return nil
+}
+
+// CommandChartsList lists the helm charts installed by dokku and whether their deployed versions have drifted
+func CommandChartsList(format string) error {
+	if format != "stdout" && format != "json" {
+		return fmt.Errorf("Invalid format: %s", format)
 	}
 
-	u, err := url.Parse(remoteHost)
+	clientset, err := NewKubernetesClient()
 	if err != nil {
-		return fmt.Errorf("failed to parse remote host: %w", err)
+		return fmt.Errorf("Unable to create kubernetes client: %w", err)
 	}
 
-	nodeName := u.Hostname()
-	n := 5
-	b := make([]byte, n)
-	if _, err := rand.Read(b); err != nil {
-		return fmt.Errorf("Unable to generate random node name: %w", err)
+	if err := clientset.Ping(); err != nil {
+		return fmt.Errorf("kubernetes api not available, cannot list helm charts: %w", err)
 	}
-	nodeName = strings.ReplaceAll(strings.ToLower(fmt.Sprintf("ip-%s-%s", nodeName, fmt.Sprintf("%X", b))), ".", "-")
 
-	args := []string{
-		// disable local-storage
-		"--disable", "local-storage",
-		// use wireguard for flannel
-		"--flannel-backend=wireguard-native",
-		// specify the node name
-		"--node-name", nodeName,
-		// server to connect to as the main
-		"--server",
-		fmt.Sprintf("https://%s:6443", serverIP),
-		// specify a token
-		"--token",
-		token,
+	charts, err := getHelmCharts()
+	if err != nil {
+		return err
 	}
 
-	if role == "server" {
-		args = append([]string{"server"}, args...)
-		// expose etcd metrics
-		args = append(args, "--etcd-expose-metrics")
-		// bind controller-manager to all interfaces
-		args = append(args, "--kube-controller-manager-arg", "bind-address=0.0.0.0")
-		// bind proxy metrics to all interfaces
-		args = append(args, "--kube-proxy-arg", "metrics-bind-address=0.0.0.0")
-		// bind scheduler to all interfaces
-		args = append(args, "--kube-scheduler-arg", "bind-address=0.0.0.0")
-		// gc terminated pods
-		args = append(args, "--kube-controller-manager-arg", "terminated-pod-gc-threshold=10")
-		// allow access for the dokku user
-		args = append(args, "--write-kubeconfig-mode", "0644")
+	ingressClass := getGlobalIngressClass()
+	output := []HelmChartStatus{}
+	for _, chart := range charts {
+		if chart.ChartPath == "traefik" && ingressClass == "nginx" {
+			continue
+		}
+		if chart.ChartPath == "ingress-nginx" && ingressClass == "traefik" {
+			continue
+		}
+		if chart.ReleaseName == "cert-manager" && !getGlobalInstallCertManager() {
+			continue
+		}
+		if chart.ReleaseName == "longhorn" && !getGlobalInstallLonghorn() {
+			continue
+		}
+		if chart.ReleaseName == "metallb" && getGlobalLoadbalancer() != "metallb" {
+			continue
+		}
+
+		status, err := getHelmChartStatus(chart)
+		if err != nil {
+			return err
+		}
+		output = append(output, status)
+	}
+
+	if format == "stdout" {
+		lines := []string{"namespace|release|declared|deployed|revision|status|drift"}
+		for _, status := range output {
+			lines = append(lines, status.String())
+		}
+
+		columnized := columnize.SimpleFormat(lines)
+		fmt.Println(columnized)
+		return nil
+	}
+
+	b, err := json.Marshal(output)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal json: %w", err)
+	}
+
+	fmt.Println(string(b))
+	return nil
+}
+
+// CommandChartsUpgrade upgrades an installed helm chart managed by Dokku with values computed from the current global properties
+func CommandChartsUpgrade(releaseName string) error {
+	if releaseName == "" {
+		return fmt.Errorf("Missing release name")
+	}
+
+	charts, err := getHelmCharts()
+	if err != nil {
+		return err
+	}
+
+	index := slices.IndexFunc(charts, func(chart HelmChart) bool {
+		return chart.ReleaseName == releaseName
+	})
+	if index == -1 {
+		releaseNames := []string{}
+		for _, chart := range charts {
+			releaseNames = append(releaseNames, chart.ReleaseName)
+		}
+		return fmt.Errorf("Invalid release name, expected one of %s: %s", strings.Join(releaseNames, ", "), releaseName)
+	}
+	chart := charts[index]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	clientset, err := NewKubernetesClient()
+	if err != nil {
+		return fmt.Errorf("Unable to create kubernetes client: %w", err)
+	}
+
+	if err := clientset.Ping(); err != nil {
+		return fmt.Errorf("kubernetes api not available, cannot upgrade helm chart: %w", err)
+	}
+
+	status, err := getHelmChartStatus(chart)
+	if err != nil {
+		return err
+	}
+	if status.Status == "not-installed" {
+		return fmt.Errorf("Helm chart %s is not installed, run scheduler-k3s:initialize --finalize to install it", releaseName)
+	}
+
+	values, err := getHelmChartValues(ctx, clientset, chart)
+	if err != nil {
+		return err
+	}
+
+	helmAgent, err := NewHelmAgent(chart.Namespace, DevNullPrinter)
+	if err != nil {
+		return fmt.Errorf("Error creating helm agent: %w", err)
+	}
+
+	currentValues, err := helmAgent.GetUserValues(releaseName)
+	if err != nil {
+		return fmt.Errorf("Unable to get values for %s: %w", releaseName, err)
+	}
+
+	equal, err := helmValuesEqual(currentValues, values)
+	if err != nil {
+		return err
+	}
+	if equal && !status.Drift {
+		common.LogInfo1Quiet(fmt.Sprintf("Helm chart %s is up to date", releaseName))
+		return nil
+	}
+
+	if err := addHelmRepositories(ctx); err != nil {
+		return err
+	}
+
+	common.LogInfo1Quiet(fmt.Sprintf("Upgrading helm chart %s to %s", releaseName, chart.Version))
+	if err := installHelmChart(ctx, clientset, chart); err != nil {
+		return fmt.Errorf("Unable to upgrade helm chart %s: %w", releaseName, err)
+	}
+
+	common.LogVerboseQuiet("Done")
+	return nil
+}
+
+// CommandClusterAdd adds a server to the k3s cluster
+func CommandClusterAdd(input AddClusterNodeInput, logFormat string) error {
+	logger, err := NewStepLogger("cluster-add", logFormat)
+	if err != nil {
+		return err
+	}
+
+	err = addClusterNode(logger, input)
+	logger.Finish(err)
+	return err
+}
+
+// addClusterNode runs the steps to join a remote host to the k3s cluster, logging each step via the logger
+func addClusterNode(logger *StepLogger, input AddClusterNodeInput) error {
+	if err := isK3sInstalled(); err != nil {
+		return fmt.Errorf("k3s not installed, cannot add node to cluster: %w", err)
+	}
+
+	clientset, err := NewKubernetesClient()
+	if err != nil {
+		return fmt.Errorf("Unable to create kubernetes client: %w", err)
+	}
+
+	if err := clientset.Ping(); err != nil {
+		return fmt.Errorf("kubernetes api not available, cannot add node to cluster: %w", err)
+	}
+
+	if input.Role != "server" && input.Role != "worker" {
+		return fmt.Errorf("Invalid server-type: %s", input.Role)
+	}
+
+	token := getGlobalGlobalToken()
+	if len(token) == 0 {
+		return fmt.Errorf("Missing k3s token")
+	}
+
+	// worker nodes always run app workloads, so the critical-addons taint only applies to servers
+	if input.TaintScheduling && input.Role == "worker" {
+		return fmt.Errorf("Taint scheduling can only be used on the server role, worker nodes always run app workloads")
+	}
+
+	input.RemoteHost, err = overrideSshConnection(input.RemoteHost, input.SshUser, input.SshPort)
+	if err != nil {
+		return err
+	}
+
+	nodeLabels, err := parseNodeLabels(input.Labels)
+	if err != nil {
+		return err
+	}
+
+	if input.Pool != "" {
+		if errs := validation.IsValidLabelValue(input.Pool); len(errs) > 0 {
+			return fmt.Errorf("Invalid pool name %s: %s", input.Pool, strings.Join(errs, ", "))
+		}
+		nodeLabels[NodePoolLabel] = input.Pool
+	}
+
+	kubeletArgs, err := getKubeletArgs()
+	if err != nil {
+		return fmt.Errorf("Unable to get kubelet args: %w", err)
+	}
+
+	kubeControllerManagerArgs, err := getKubeControllerManagerArgs()
+	if err != nil {
+		return fmt.Errorf("Unable to get kube-controller-manager args: %w", err)
+	}
+
+	// prefer the configuration recorded at init time so joined nodes match the cluster
+	flannelBackend := getGlobalFlannelBackend()
+	wireguardPort, _ := strconv.Atoi(getGlobalFlannelWireguardPort())
+	clusterCIDR, _ := getClusterCIDRs()
+	disableArgs := []string{"--disable", "local-storage"}
+	initConfig, ok, err := readInitConfig()
+	if err != nil {
+		return err
+	}
+	if ok {
+		if initConfig.FlannelBackend != "" && initConfig.FlannelBackend != flannelBackend {
+			common.LogWarn(fmt.Sprintf("The flannel-backend property (%s) differs from the backend the cluster was initialized with, using %s", flannelBackend, initConfig.FlannelBackend))
+			flannelBackend = initConfig.FlannelBackend
+		}
+		if initConfig.FlannelBackend == "wireguard-native" && initConfig.FlannelWireguardPort != wireguardPort {
+			common.LogWarn("The flannel-wireguard-port property differs from the port the cluster was initialized with, using the recorded port")
+			wireguardPort = initConfig.FlannelWireguardPort
+		}
+		clusterCIDR = initConfig.ClusterCIDR
+		if input.Role == "server" {
+			for _, component := range initConfig.Disable {
+				if component != "local-storage" {
+					disableArgs = append(disableArgs, "--disable", component)
+				}
+			}
+		}
+	}
+	if input.Role == "server" && getGlobalLoadbalancer() == "metallb" && !slices.Contains(disableArgs, "servicelb") {
+		disableArgs = append(disableArgs, "--disable", "servicelb")
+	}
+
+	nodeWaitTimeout, err := strconv.Atoi(getGlobalNodeWaitTimeout())
+	if err != nil {
+		return fmt.Errorf("Invalid node-wait-timeout value: %w", err)
+	}
+
+	serverURL := input.JoinServer
+	if input.JoinServer != "" {
+		if input.ServerIP != "" {
+			return fmt.Errorf("The --join-server and --server-ip flags cannot be used together")
+		}
+		if err := validateJoinServer(input.JoinServer); err != nil {
+			return err
+		}
+
+		serverURL = strings.TrimSuffix(input.JoinServer, "/")
+		common.LogVerboseQuiet(fmt.Sprintf("Using join server override: %s", serverURL))
+	} else if input.ServerIP == "" {
+		var err error
+		input.ServerIP, err = getJoinServerIP(context.Background(), clientset)
+		if err != nil {
+			return err
+		}
+
+		serverURL = fmt.Sprintf("https://%s", net.JoinHostPort(input.ServerIP, "6443"))
+	} else {
+		if err := validateServerIP(input.ServerIP); err != nil {
+			return err
+		}
+		if err := validateRoutableServerIP(input.ServerIP); err != nil {
+			return err
+		}
+
+		serverURL = fmt.Sprintf("https://%s", net.JoinHostPort(input.ServerIP, "6443"))
+		common.LogVerboseQuiet(fmt.Sprintf("Using server ip address override: %s", input.ServerIP))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	if input.Role == "server" && !input.TaintScheduling {
+		tainted, err := hasTaintedControlPlane(ctx, clientset)
+		if err != nil {
+			return err
+		}
+		if tainted {
+			common.LogWarn("Existing control-plane nodes are tainted, but this server will be added without a taint and will run app workloads")
+			common.LogWarn("Use --taint-scheduling to only run critical cluster components on this server")
+		}
+	}
+
+	// join with the version the servers are running unless a version is pinned
+	serverVersion, err := getServerK3sVersion(ctx, clientset)
+	if err != nil {
+		return err
+	}
+	common.LogDebug(fmt.Sprintf("k3s server version: %s", serverVersion))
+
+	installVersion := getGlobalK3sVersion()
+	if installVersion == "" {
+		installVersion = serverVersion
+	} else if installVersion != serverVersion {
+		common.LogWarn(fmt.Sprintf("The k3s-version property (%s) differs from the version the server nodes are running (%s), the node will join with %s", installVersion, serverVersion, installVersion))
+	}
+	if !k3sVersionRegex.MatchString(installVersion) {
+		return fmt.Errorf("Invalid k3s version to install: %s", installVersion)
+	}
+	common.LogVerboseQuiet(fmt.Sprintf("Installing k3s version: %s", installVersion))
+
+	u, err := url.Parse(input.RemoteHost)
+	if err != nil {
+		return fmt.Errorf("failed to parse remote host: %w", err)
+	}
+
+	nodeName := u.Hostname()
+	n := 5
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Errorf("Unable to generate random node name: %w", err)
+	}
+	nodeName = strings.NewReplacer(".", "-", ":", "-").Replace(strings.ToLower(fmt.Sprintf("ip-%s-%s", nodeName, fmt.Sprintf("%X", b))))
+
+	args := []string{
+		// set the flannel backend
+		fmt.Sprintf("--flannel-backend=%s", flannelBackend),
+		// specify the node name
+		"--node-name", nodeName,
+		// server to connect to as the main
+		"--server",
+		serverURL,
+		// specify a token
+		"--token",
+		token,
+	}
+	// disable local-storage, plus the components disabled at init time on servers
+	args = append(args, disableArgs...)
+
+	flannelConfig, err := getFlannelConfig(flannelBackend, wireguardPort, clusterCIDR)
+	if err != nil {
+		return err
+	}
+	if len(flannelConfig) > 0 {
+		// use the custom wireguard port from the flannel config
+		args = append(args, "--flannel-conf", FlannelConfigPath)
+	}
+
+	dnsResolvConf := []byte{}
+	if dnsUpstreamServers := getDNSUpstreamServers(); len(dnsUpstreamServers) > 0 {
+		// use the configured upstream dns servers for CoreDNS pods scheduled on the node
+		args = append(args, "--resolv-conf", DNSResolvConfPath)
+		dnsResolvConf = renderDNSResolvConf(dnsUpstreamServers)
+	}
+
+	if input.Role == "server" {
+		args = append([]string{"server"}, args...)
+		// expose etcd metrics
+		args = append(args, "--etcd-expose-metrics")
+		// configure controller-manager flags
+		args = append(args, kubeControllerManagerArgs...)
+		// configure pod and service cidrs for the ip-family
+		args = append(args, getIPFamilyArgs()...)
+		// bind proxy metrics to all interfaces
+		args = append(args, "--kube-proxy-arg", "metrics-bind-address=0.0.0.0")
+		// bind scheduler to all interfaces
+		args = append(args, "--kube-scheduler-arg", "bind-address=0.0.0.0")
+		// allow access for the dokku user
+		args = append(args, "--write-kubeconfig-mode", "0644")
 	} else {
 		// disable etcd on workers
 		args = append(args, "--disable-etcd")
@@ -604,78 +1112,2777 @@ func CommandClusterAdd(role string, remoteHost string, serverIP string, allowUkn
 		args = append(args, "--kube-proxy-arg", "metrics-bind-address=0.0.0.0")
 	}
 
-	if taintScheduling {
-		args = append(args, "--node-taint", "CriticalAddonsOnly=true:NoSchedule")
+	// store k3s state in a custom data directory
+	args = append(args, getK3sDataDirArgs()...)
+	args = append(args, kubeletArgs...)
+	if input.TaintScheduling {
+		args = append(args, "--node-taint", CriticalAddonsOnlyTaint)
+	}
+
+	installEnv := []string{fmt.Sprintf("INSTALL_K3S_VERSION=%s", installVersion)}
+	if getGlobalK3sBinaryPath() != "" {
+		// the binary is staged on the node, so the installer must not download it
+		installEnv = append(installEnv, "INSTALL_K3S_SKIP_DOWNLOAD=true")
+	}
+	for _, file := range getAirgapFiles() {
+		if err := validateAirgapFile(file.Source); err != nil {
+			return err
+		}
+	}
+
+	installerPath := getGlobalK3sInstallerPath()
+	downloadCommand := withProxyEnv([]string{"curl", "-o", "/tmp/k3s-installer.sh", "https://get.k3s.io"})
+	if installerPath != "" {
+		if err := validateK3sInstaller(installerPath); err != nil {
+			return err
+		}
+		downloadCommand = []string{"dd", "of=/tmp/k3s-installer.sh", "status=none", "<", installerPath}
+	} else if input.CacheInstaller && input.DryRun {
+		downloadCommand = []string{"dd", "of=/tmp/k3s-installer.sh", "status=none", "<", filepath.Join(common.GetDataDirectory("scheduler-k3s"), K3sInstallerCacheFilename)}
+	} else if input.CacheInstaller {
+		logger.Step("Caching k3s installer")
+		installerPath, err = getCachedK3sInstaller(ctx, input.RefreshInstaller)
+		if err != nil {
+			return err
+		}
+	}
+
+	if input.DryRun {
+		sshTarget := u.Hostname()
+		if u.User != nil {
+			sshTarget = fmt.Sprintf("%s@%s", u.User.Username(), u.Hostname())
+		}
+		sshCommand := []string{"ssh"}
+		if u.Port() != "" {
+			sshCommand = append(sshCommand, "-p", u.Port())
+		}
+		sshCommand = append(sshCommand, sshTarget)
+
+		commands := [][]string{}
+		if input.SkipDependencies {
+			commands = append(commands, append([]string{"sudo", "which"}, getK3sDependencyBinaries()...))
+		} else {
+			commands = append(commands,
+				append([]string{"sudo"}, withProxyEnv([]string{"apt-get", "update"})...),
+				append([]string{"sudo"}, withProxyEnv(append([]string{"apt-get", "-y", "install"}, getK3sDependencies()...))...),
+			)
+		}
+		if !input.SkipConnectivityCheck {
+			commands = append(commands, []string{"curl", "--silent", "--insecure", "--max-time", "10", "--output", "/dev/null", serverURL})
+		}
+		commands = append(commands,
+			downloadCommand,
+			[]string{"chmod", "0755", "/tmp/k3s-installer.sh"},
+		)
+		if len(flannelConfig) > 0 {
+			commands = append(commands,
+				[]string{"sudo", "mkdir", "-p", filepath.Dir(FlannelConfigPath)},
+				[]string{"sudo", "tee", FlannelConfigPath, "<", "flannel-net-conf.json"},
+			)
+		}
+		if len(dnsResolvConf) > 0 {
+			commands = append(commands,
+				[]string{"sudo", "mkdir", "-p", filepath.Dir(DNSResolvConfPath)},
+				[]string{"sudo", "tee", DNSResolvConfPath, "<", "resolv.conf"},
+			)
+		}
+		// the join token is a secret, so only print a redacted value
+		dryRunArgs := slices.Clone(args)
+		if index := slices.Index(dryRunArgs, "--token"); index != -1 && index+1 < len(dryRunArgs) {
+			dryRunArgs[index+1] = redactToken(token)
+		}
+		for _, file := range getAirgapFiles() {
+			commands = append(commands,
+				[]string{"sudo", "mkdir", "-p", filepath.Dir(file.Destination)},
+				[]string{"sudo", "dd", fmt.Sprintf("of=%s", file.Destination), "bs=1M", "status=none", "<", file.Source},
+				[]string{"sudo", "chmod", fmt.Sprintf("%04o", getAirgapFileMode(file.Destination)), file.Destination},
+			)
+		}
+		commands = append(commands, append([]string{"sudo"}, withProxyEnv(append(append([]string{"env"}, installEnv...), append([]string{"/tmp/k3s-installer.sh"}, dryRunArgs...)...))...))
+
+		common.LogInfo1(fmt.Sprintf("Dry run: commands to join %s to k3s cluster as %s", input.RemoteHost, input.Role))
+		common.LogVerboseQuiet(fmt.Sprintf("Node name: %s (a new name is generated on each run)", nodeName))
+		for key, value := range nodeLabels {
+			common.LogVerboseQuiet(fmt.Sprintf("Node label: %s=%s", key, value))
+		}
+		for _, command := range commands {
+			fmt.Println(strings.Join(append(sshCommand, command...), " "))
+		}
+		return nil
+	}
+
+	common.LogInfo1(fmt.Sprintf("Joining %s to k3s cluster as %s", input.RemoteHost, input.Role))
+	logger.Step("Checking ssh connectivity")
+	if err := checkSshConnection(input.RemoteHost, input.AllowUknownHosts); err != nil {
+		return err
+	}
+
+	if input.Arch != "" {
+		remoteArch, err := getRemoteArchitecture(ctx, input.RemoteHost, input.AllowUknownHosts)
+		if err != nil {
+			return err
+		}
+		if remoteArch != input.Arch {
+			common.LogWarn(fmt.Sprintf("Remote host architecture is %s, expected %s", remoteArch, input.Arch))
+			common.LogWarn("Images deployed to this node must be built for its architecture")
+		}
+	}
+
+	existingNodeName := ""
+	registryContents := []byte{}
+	installerStaged := false
+	defer func() {
+		// a failed or interrupted join leaves a partial installer behind on the remote host
+		if installerStaged {
+			removeRemoteInstaller(input.RemoteHost, input.AllowUknownHosts)
+		}
+	}()
+	if input.ForceReinstall {
+		common.LogWarn("Skipping check for an existing k3s installation")
+	} else {
+		existingNodeName, err = getExistingRemoteNode(ctx, clientset, input.RemoteHost, input.AllowUknownHosts)
+		if err != nil {
+			return err
+		}
+	}
+
+	if existingNodeName != "" {
+		logger.Step(fmt.Sprintf("Host is already joined to the cluster as %s, skipping k3s installation", existingNodeName))
+		nodeName = existingNodeName
+	} else {
+		if input.SkipDependencies {
+			logger.Step("Checking k3s dependencies")
+			if err := checkRemoteDependencies(ctx, input.RemoteHost, input.AllowUknownHosts); err != nil {
+				return err
+			}
+		} else {
+			logger.Step("Updating apt")
+			aptUpdateCommand := withProxyEnv([]string{"apt-get", "update"})
+			_, err := callRemoteStep(ctx, RemoteAptTimeout, "apt-get update command over ssh", common.SshCommandInput{
+				Command:          aptUpdateCommand[0],
+				Args:             aptUpdateCommand[1:],
+				AllowUknownHosts: input.AllowUknownHosts,
+				RemoteHost:       input.RemoteHost,
+				StreamStdio:      shouldStreamStdio(),
+				Sudo:             true,
+			})
+			if err != nil {
+				return err
+			}
+
+			logger.Step("Installing k3s dependencies")
+			aptInstallCommand := withProxyEnv(append([]string{"apt-get", "-y", "install"}, getK3sDependencies()...))
+			_, err = callRemoteStep(ctx, RemoteAptTimeout, "apt-get install command over ssh", common.SshCommandInput{
+				Command:          aptInstallCommand[0],
+				Args:             aptInstallCommand[1:],
+				AllowUknownHosts: input.AllowUknownHosts,
+				RemoteHost:       input.RemoteHost,
+				StreamStdio:      shouldStreamStdio(),
+				Sudo:             true,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if flannelBackend == "wireguard-native" {
+			logger.Step("Checking wireguard support")
+			if err := checkRemoteWireguardSupport(ctx, input.RemoteHost, input.AllowUknownHosts); err != nil {
+				return err
+			}
+		}
+
+		if input.SkipConnectivityCheck {
+			common.LogWarn("Skipping check that the remote host can reach the kubernetes api")
+		} else {
+			logger.Step("Checking kubernetes api is reachable from remote host")
+			if err := checkRemoteServerReachable(ctx, input.RemoteHost, input.AllowUknownHosts, serverURL); err != nil {
+				return err
+			}
+		}
+
+		if installerPath != "" {
+			logger.Step("Copying staged k3s installer")
+			installer, err := os.ReadFile(installerPath)
+			if err != nil {
+				return fmt.Errorf("Unable to read k3s installer: %w", err)
+			}
+
+			installerStaged = true
+			// dd is used rather than tee so a failed copy does not echo the whole installer into the error output
+			_, err = callRemoteStep(ctx, RemoteDownloadTimeout, "dd command over ssh", common.SshCommandInput{
+				Command:          "dd",
+				Args:             []string{"of=/tmp/k3s-installer.sh", "status=none"},
+				AllowUknownHosts: input.AllowUknownHosts,
+				RemoteHost:       input.RemoteHost,
+				Stdin:            bytes.NewReader(installer),
+			})
+			if err != nil {
+				return err
+			}
+
+			checksum := sha256.Sum256(installer)
+			if err := verifyRemoteInstallerChecksum(ctx, input.RemoteHost, input.AllowUknownHosts, hex.EncodeToString(checksum[:])); err != nil {
+				return err
+			}
+		} else {
+			logger.Step("Downloading k3s installer")
+			installerStaged = true
+			curlCommand := withProxyEnv([]string{"curl", "-o", "/tmp/k3s-installer.sh", "https://get.k3s.io"})
+			_, err := callRemoteStep(ctx, RemoteDownloadTimeout, "curl command over ssh", common.SshCommandInput{
+				Command:          curlCommand[0],
+				Args:             curlCommand[1:],
+				AllowUknownHosts: input.AllowUknownHosts,
+				RemoteHost:       input.RemoteHost,
+				StreamStdio:      shouldStreamStdio(),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		logger.Step("Setting k3s installer permissions")
+		_, err := callRemoteStep(ctx, RemoteCommandTimeout, "chmod command over ssh", common.SshCommandInput{
+			Command: "chmod",
+			Args: []string{
+				"0755",
+				"/tmp/k3s-installer.sh",
+			},
+			AllowUknownHosts: input.AllowUknownHosts,
+			RemoteHost:       input.RemoteHost,
+			StreamStdio:      shouldStreamStdio(),
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(flannelConfig) > 0 {
+			logger.Step("Copying flannel config")
+			err = copyFileToNode(ctx, CopyFileToNodeInput{
+				AllowUknownHosts: input.AllowUknownHosts,
+				Contents:         flannelConfig,
+				Path:             FlannelConfigPath,
+				RemoteHost:       input.RemoteHost,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(dnsResolvConf) > 0 {
+			logger.Step("Copying dns resolver config")
+			err = copyFileToNode(ctx, CopyFileToNodeInput{
+				AllowUknownHosts: input.AllowUknownHosts,
+				Contents:         dnsResolvConf,
+				Path:             DNSResolvConfPath,
+				RemoteHost:       input.RemoteHost,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		registryMirrors, err := getRegistryMirrors()
+		if err != nil {
+			return err
+		}
+		if len(registryMirrors) > 0 {
+			logger.Step("Copying registry mirrors")
+			registryContents, err = renderRegistryConfig(registryMirrors)
+			if err != nil {
+				return fmt.Errorf("Unable to render registry config: %w", err)
+			}
+			err = copyFileToNode(ctx, CopyFileToNodeInput{
+				AllowUknownHosts: input.AllowUknownHosts,
+				Contents:         registryContents,
+				Path:             RegistryConfigPath,
+				RemoteHost:       input.RemoteHost,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := common.PropertyWrite("scheduler-k3s", "--global", fmt.Sprintf("%s%s.role", NodePropertyPrefix, nodeName), input.Role); err != nil {
+		return fmt.Errorf("Unable to store node role: %w", err)
+	}
+	if err := common.PropertyWrite("scheduler-k3s", "--global", fmt.Sprintf("%s%s.remote-host", NodePropertyPrefix, nodeName), input.RemoteHost); err != nil {
+		return fmt.Errorf("Unable to store node remote host: %w", err)
+	}
+	if input.AllowUknownHosts {
+		if err := common.PropertyWrite("scheduler-k3s", "--global", fmt.Sprintf("%s%s.allow-unknown-hosts", NodePropertyPrefix, nodeName), "true"); err != nil {
+			return fmt.Errorf("Unable to store node allow-unknown-hosts setting: %w", err)
+		}
+	}
+
+	if existingNodeName == "" {
+		if len(getAirgapFiles()) > 0 {
+			logger.Step("Copying staged k3s airgap files")
+			if err := stageRemoteAirgapFiles(ctx, input.RemoteHost, input.AllowUknownHosts); err != nil {
+				return err
+			}
+		}
+
+		logger.Step(fmt.Sprintf("Adding %s k3s cluster", nodeName))
+		// sudo resets the environment, so the installer version and proxy settings are passed via env
+		joinCommand := withProxyEnv(append(append([]string{"env"}, installEnv...), append([]string{"/tmp/k3s-installer.sh"}, args...)...))
+		_, err := callRemoteStep(ctx, RemoteInstallTimeout, "k3s installer command over ssh", common.SshCommandInput{
+			Command:          joinCommand[0],
+			Args:             joinCommand[1:],
+			AllowUknownHosts: input.AllowUknownHosts,
+			RemoteHost:       input.RemoteHost,
+			StreamStdio:      shouldStreamStdio(),
+			Sudo:             true,
+		})
+		if err != nil {
+			return err
+		}
+		installerStaged = false
+	}
+
+	logger.Step("Waiting for node to exist")
+	nodes, err := waitForNodeToExist(ctx, WaitForNodeToExistInput{
+		Clientset: clientset,
+		NodeName:  nodeName,
+		Backoff:   1 * time.Second,
+		Timeout:   time.Duration(nodeWaitTimeout) * time.Second,
+	})
+	if err != nil {
+		return fmt.Errorf("Error waiting for node to exist: %w", err)
+	}
+	if len(nodes) == 0 {
+		return fmt.Errorf("Unable to find node after joining cluster, node will not be annotated/labeled appropriately access registry secrets")
+	}
+	common.LogVerboseQuiet(fmt.Sprintf("Node architecture: %s", nodes[0].Status.NodeInfo.Architecture))
+
+	// a resumed join keeps the time the node first joined the cluster
+	joinedAt := time.Now()
+	if nodes[0].Annotations[NodeJoinedAtAnnotation] != "" {
+		joinedAt = time.Time{}
+	}
+
+	err = completeNodeJoin(ctx, CompleteNodeJoinInput{
+		Clientset:  clientset,
+		JoinedAt:   joinedAt,
+		Labels:     nodeLabels,
+		NodeName:   nodes[0].Name,
+		RemoteHost: input.RemoteHost,
+		Role:       input.Role,
+	})
+	if err != nil {
+		return err
+	}
+
+	if input.NoWaitReady {
+		common.LogVerboseQuiet("Skipping wait for node to become ready")
+	} else {
+		logger.Step("Waiting for node to become ready")
+		err = waitForNodeReady(ctx, WaitForNodeReadyInput{
+			Clientset: clientset,
+			NodeName:  nodes[0].Name,
+			Backoff:   1 * time.Second,
+			Timeout:   time.Duration(nodeWaitTimeout) * time.Second,
+		})
+		if err != nil {
+			return fmt.Errorf("Node joined the cluster but is not ready: %w", err)
+		}
+	}
+
+	if len(registryContents) > 0 {
+		logger.Step("Verifying registry mirrors")
+		err = verifyRegistryOnNode(ctx, VerifyRegistryOnNodeInput{
+			AllowUknownHosts: input.AllowUknownHosts,
+			Contents:         registryContents,
+			RemoteHost:       input.RemoteHost,
+		})
+		if err != nil {
+			return fmt.Errorf("Node joined the cluster but the registry mirrors were not applied: %w", err)
+		}
+	}
+
+	if input.RegistryTestImage != "" {
+		logger.Step(fmt.Sprintf("Pulling %s on the node", input.RegistryTestImage))
+		err = pullImageOnNode(ctx, PullImageOnNodeInput{
+			AllowUknownHosts: input.AllowUknownHosts,
+			Image:            input.RegistryTestImage,
+			RemoteHost:       input.RemoteHost,
+		})
+		if err != nil {
+			return fmt.Errorf("Node joined the cluster but is unable to pull %s, the registry may not be reachable from the node: %w", input.RegistryTestImage, err)
+		}
+		common.LogVerboseQuiet("Registry is reachable from the node")
+	}
+
+	common.LogVerboseQuiet("Done")
+	return nil
+}
+
+// CommandClusterConfigShow displays the configuration the cluster was initialized with
+func CommandClusterConfigShow(format string) error {
+	if format != "stdout" && format != "json" {
+		return fmt.Errorf("Invalid format: %s", format)
+	}
+
+	config, ok, err := readInitConfig()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("No init config recorded at %s, the cluster was not initialized by this host or predates init config recording", getInitConfigPath())
+	}
+
+	if format == "stdout" {
+		flannelWireguardPort := ""
+		if config.FlannelWireguardPort > 0 {
+			flannelWireguardPort = strconv.Itoa(config.FlannelWireguardPort)
+		}
+
+		lines := []string{
+			fmt.Sprintf("initialized at|%s", config.InitializedAt.Format(time.RFC3339)),
+			fmt.Sprintf("k3s version|%s", config.K3sVersion),
+			fmt.Sprintf("k3s channel|%s", config.K3sChannel),
+			fmt.Sprintf("ingress class|%s", config.IngressClass),
+			fmt.Sprintf("server ip|%s", config.ServerIP),
+			fmt.Sprintf("taint scheduling|%t", config.TaintScheduling),
+			fmt.Sprintf("disable|%s", strings.Join(config.Disable, ",")),
+			fmt.Sprintf("flannel backend|%s", config.FlannelBackend),
+			fmt.Sprintf("flannel wireguard port|%s", flannelWireguardPort),
+			fmt.Sprintf("ip family|%s", config.IPFamily),
+			fmt.Sprintf("cluster cidr|%s", config.ClusterCIDR),
+			fmt.Sprintf("service cidr|%s", config.ServiceCIDR),
+			fmt.Sprintf("data dir|%s", config.DataDir),
+			fmt.Sprintf("expect nodes|%d", config.ExpectNodes),
+		}
+
+		columnized := columnize.SimpleFormat(lines)
+		fmt.Println(columnized)
+		return nil
+	}
+
+	b, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal json: %w", err)
+	}
+
+	fmt.Println(string(b))
+	return nil
+}
+
+// CommandClusterExport outputs the Dokku-managed metadata for the nodes in the cluster as json
+func CommandClusterExport() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	clientset, err := NewKubernetesClient()
+	if err != nil {
+		return fmt.Errorf("Unable to create kubernetes client: %w", err)
+	}
+
+	if err := clientset.Ping(); err != nil {
+		return fmt.Errorf("kubernetes api not available, cannot export cluster metadata: %w", err)
+	}
+
+	nodes, err := clientset.ListNodes(ctx, ListNodesInput{})
+	if err != nil {
+		return fmt.Errorf("Unable to list nodes: %w", err)
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Name < nodes[j].Name
+	})
+
+	export := ClusterExport{
+		ExportedAt: time.Now().UTC(),
+		Nodes:      []ClusterExportNode{},
+	}
+
+	config, ok, err := readInitConfig()
+	if err != nil {
+		return err
+	}
+	if ok {
+		export.InitConfig = &config
+	}
+
+	for _, node := range nodes {
+		export.Nodes = append(export.Nodes, getClusterExportNode(node))
+	}
+
+	b, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Unable to marshal json: %w", err)
+	}
+
+	fmt.Println(string(b))
+	return nil
+}
+
+// CommandClusterImport reapplies the Dokku-managed metadata from a cluster export to the matching nodes in the cluster
+func CommandClusterImport(filename string, dryRun bool) error {
+	var contents []byte
+	var err error
+	if filename == "" || filename == "-" {
+		contents, err = io.ReadAll(os.Stdin)
+	} else {
+		contents, err = os.ReadFile(filename)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to read cluster export: %w", err)
+	}
+
+	export := ClusterExport{}
+	if err := json.Unmarshal(contents, &export); err != nil {
+		return fmt.Errorf("Unable to parse cluster export: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	clientset, err := NewKubernetesClient()
+	if err != nil {
+		return fmt.Errorf("Unable to create kubernetes client: %w", err)
+	}
+
+	if err := clientset.Ping(); err != nil {
+		return fmt.Errorf("kubernetes api not available, cannot import cluster metadata: %w", err)
+	}
+
+	nodes, err := clientset.ListNodes(ctx, ListNodesInput{})
+	if err != nil {
+		return fmt.Errorf("Unable to list nodes: %w", err)
+	}
+
+	clusterNodes := map[string]corev1.Node{}
+	for _, node := range nodes {
+		clusterNodes[node.Name] = node
+	}
+
+	mismatches := []string{}
+	exportedNodes := map[string]bool{}
+	for _, exportNode := range export.Nodes {
+		exportedNodes[exportNode.Name] = true
+		node, ok := clusterNodes[exportNode.Name]
+		if !ok {
+			mismatches = append(mismatches, fmt.Sprintf("node %s is in the export but not in the cluster", exportNode.Name))
+			continue
+		}
+
+		if reason := validateClusterExportNode(exportNode, node); reason != "" {
+			mismatches = append(mismatches, fmt.Sprintf("node %s was skipped: %s", exportNode.Name, reason))
+			continue
+		}
+
+		keys := []string{}
+		for key := range exportNode.Annotations {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value := exportNode.Annotations[key]
+			if node.Annotations[key] == value {
+				continue
+			}
+
+			common.LogInfo2Quiet(fmt.Sprintf("Annotating node %s with %s=%s", node.Name, key, value))
+			if dryRun {
+				continue
+			}
+
+			err := clientset.AnnotateNode(ctx, AnnotateNodeInput{
+				Name:  node.Name,
+				Key:   key,
+				Value: value,
+			})
+			if err != nil {
+				return fmt.Errorf("Unable to patch node %s: %w", node.Name, err)
+			}
+		}
+
+		keys = []string{}
+		for key := range exportNode.Labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value := exportNode.Labels[key]
+			if node.Labels[key] == value {
+				continue
+			}
+
+			common.LogInfo2Quiet(fmt.Sprintf("Labeling node %s with %s=%s", node.Name, key, value))
+			if dryRun {
+				continue
+			}
+
+			err := clientset.LabelNode(ctx, LabelNodeInput{
+				Name:  node.Name,
+				Key:   key,
+				Value: value,
+			})
+			if err != nil {
+				return fmt.Errorf("Unable to patch node %s: %w", node.Name, err)
+			}
+		}
+
+		properties := map[string]string{
+			"role":        exportNode.Role,
+			"remote-host": exportNode.RemoteHost,
+		}
+		for _, property := range []string{"role", "remote-host"} {
+			value := properties[property]
+			key := fmt.Sprintf("%s%s.%s", NodePropertyPrefix, node.Name, property)
+			if value == "" || common.PropertyGet("scheduler-k3s", "--global", key) == value {
+				continue
+			}
+
+			common.LogInfo2Quiet(fmt.Sprintf("Setting %s property for node %s to %s", property, node.Name, value))
+			if dryRun {
+				continue
+			}
+
+			if err := common.PropertyWrite("scheduler-k3s", "--global", key, value); err != nil {
+				return fmt.Errorf("Unable to set %s property for node %s: %w", property, node.Name, err)
+			}
+		}
+	}
+
+	names := []string{}
+	for name := range clusterNodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !exportedNodes[name] {
+			mismatches = append(mismatches, fmt.Sprintf("node %s is in the cluster but not in the export", name))
+		}
+	}
+
+	if export.InitConfig != nil {
+		_, ok, err := readInitConfig()
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			common.LogVerboseQuiet("Keeping the existing init config recorded on this host")
+		} else {
+			common.LogInfo2Quiet(fmt.Sprintf("Restoring init config to %s", getInitConfigPath()))
+			if !dryRun {
+				if err := writeInitConfig(*export.InitConfig); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if len(mismatches) > 0 {
+		for _, mismatch := range mismatches {
+			common.LogWarn(mismatch)
+		}
+		return fmt.Errorf("Cluster export does not match the current cluster, %d mismatch(es) found", len(mismatches))
+	}
+
+	if dryRun {
+		common.LogInfo1Quiet("Dry run complete, no changes were made")
+		return nil
+	}
+
+	common.LogInfo1Quiet("Imported cluster metadata")
+	return nil
+}
+
+// CommandClusterInfo displays the control-plane and etcd quorum health of the cluster
+func CommandClusterInfo(format string) error {
+	if format != "stdout" && format != "json" {
+		return fmt.Errorf("Invalid format: %s", format)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	clientset, err := NewKubernetesClient()
+	if err != nil {
+		return fmt.Errorf("Unable to create kubernetes client: %w", err)
+	}
+
+	if err := clientset.Ping(); err != nil {
+		return fmt.Errorf("kubernetes api not available, cannot get cluster info: %w", err)
+	}
+
+	info, err := getClusterInfo(ctx, clientset)
+	if err != nil {
+		return err
+	}
+
+	if format == "stdout" {
+		lines := []string{
+			fmt.Sprintf("server nodes|%d", info.ServerNodes),
+			fmt.Sprintf("ready server nodes|%d", info.ReadyServerNodes),
+			fmt.Sprintf("etcd quorum|%d", info.Quorum),
+			fmt.Sprintf("has quorum|%t", info.HasQuorum),
+			fmt.Sprintf("failure tolerance|%d", info.FailureTolerance),
+			fmt.Sprintf("at risk|%t", info.AtRisk),
+		}
+
+		columnized := columnize.SimpleFormat(lines)
+		fmt.Println(columnized)
+
+		if !info.HasQuorum {
+			common.LogWarn("The cluster has lost etcd quorum")
+		} else if info.AtRisk {
+			common.LogWarn("The cluster is one server node failure away from losing etcd quorum")
+		}
+		return nil
+	}
+
+	b, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal json: %w", err)
+	}
+
+	fmt.Println(string(b))
+	return nil
+}
+
+// CommandClusterLabel applies the labels for a role to an existing node and removes the labels for the other role
+func CommandClusterLabel(nodeName string, role string) error {
+	if nodeName == "" {
+		return fmt.Errorf("Missing node name")
+	}
+
+	if role != "server" && role != "worker" {
+		return fmt.Errorf("Invalid role: %s", role)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	clientset, err := NewKubernetesClient()
+	if err != nil {
+		return fmt.Errorf("Unable to create kubernetes client: %w", err)
+	}
+
+	if err := clientset.Ping(); err != nil {
+		return fmt.Errorf("kubernetes api not available, cannot label node: %w", err)
+	}
+
+	nodes, err := clientset.ListNodes(ctx, ListNodesInput{})
+	if err != nil {
+		return fmt.Errorf("Unable to list nodes: %w", err)
+	}
+
+	var node *corev1.Node
+	for i := range nodes {
+		if nodes[i].Name == nodeName {
+			node = &nodes[i]
+			break
+		}
+	}
+	if node == nil {
+		return newKindError(ErrNodeNotFound, nil, fmt.Sprintf("Node %s not found", nodeName))
+	}
+
+	// k3s labels server nodes with the control-plane role, which reflects the process running on the node
+	labelRole := getNodeLabelRole(*node)
+	if role == "worker" && labelRole == "server" {
+		return fmt.Errorf("Node %s is running k3s as a server, and cannot be labeled as a worker", nodeName)
+	}
+	if role == "server" && labelRole == "worker" {
+		return fmt.Errorf("Node %s is running k3s as an agent, and cannot be labeled as a server", nodeName)
+	}
+
+	common.LogInfo1Quiet(fmt.Sprintf("Labeling node %s as %s", nodeName, role))
+	if _, err := applyRoleLabels(ctx, clientset, *node, role); err != nil {
+		return err
+	}
+
+	if err := common.PropertyWrite("scheduler-k3s", "--global", fmt.Sprintf("%s%s.role", NodePropertyPrefix, nodeName), role); err != nil {
+		return fmt.Errorf("Unable to store node role: %w", err)
+	}
+
+	return nil
+}
+
+// CommandClusterList lists the nodes in the k3s cluster
+func CommandClusterList(format string, complete bool, capacity bool, role string, ready string, extended bool, sortBy string) error {
+	if format != "stdout" && format != "json" {
+		return fmt.Errorf("Invalid format: %s", format)
+	}
+
+	validSorts := map[string]bool{"name": true, "ready": true, "role": true, "version": true}
+	if !validSorts[sortBy] {
+		return fmt.Errorf("Invalid sort value, expected name, role, ready, or version: %s", sortBy)
+	}
+
+	roleFilters := map[string][]string{
+		"server": {"control-plane", "master"},
+		"worker": {"worker"},
+	}
+	if _, ok := roleFilters[role]; role != "" && !ok {
+		return fmt.Errorf("Invalid role: %s", role)
+	}
+
+	readyFilter := false
+	if ready != "" {
+		var err error
+		readyFilter, err = strconv.ParseBool(ready)
+		if err != nil {
+			return fmt.Errorf("Invalid ready value, expected true or false: %s", ready)
+		}
+	}
+
+	// the capacity summary always covers every ready node, so node filters would be silently ignored
+	if capacity && (complete || extended || role != "" || ready != "") {
+		return fmt.Errorf("The --capacity flag cannot be combined with the --complete, --extended, --role, or --ready flags")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	clientset, err := NewKubernetesClient()
+	if err != nil {
+		return fmt.Errorf("Unable to create kubernetes client: %w", err)
+	}
+
+	if err := clientset.Ping(); err != nil {
+		return fmt.Errorf("kubernetes api not available, cannot list cluster nodes: %w", err)
+	}
+
+	if capacity {
+		clusterCapacity, err := getClusterCapacity(ctx, clientset)
+		if err != nil {
+			return fmt.Errorf("Unable to get cluster capacity: %w", err)
+		}
+
+		if format == "stdout" {
+			common.LogInfo2Quiet(fmt.Sprintf("Capacity across %d ready nodes", clusterCapacity.ReadyNodes))
+			lines := []string{"resource|allocatable|requested|headroom|free"}
+			for _, resourceCapacity := range clusterCapacity.Resources {
+				lines = append(lines, resourceCapacity.String())
+			}
+
+			columnized := columnize.SimpleFormat(lines)
+			fmt.Println(columnized)
+			return nil
+		}
+
+		b, err := json.Marshal(clusterCapacity)
+		if err != nil {
+			return fmt.Errorf("Unable to marshal json: %w", err)
+		}
+
+		fmt.Println(string(b))
+		return nil
+	}
+
+	nodes, err := clientset.ListNodes(ctx, ListNodesInput{})
+	if err != nil {
+		return fmt.Errorf("Unable to list nodes: %w", err)
+	}
+
+	if complete {
+		for _, node := range nodes {
+			if len(getNodeJoinIssues(node)) == 0 {
+				continue
+			}
+
+			common.LogInfo1Quiet(fmt.Sprintf("Completing join for %s", node.Name))
+			err := completeNodeJoin(ctx, CompleteNodeJoinInput{
+				Clientset:  clientset,
+				NodeName:   node.Name,
+				RemoteHost: getNodeRemoteHost(node),
+				Role:       getNodeRole(node),
+			})
+			if err != nil {
+				return fmt.Errorf("Unable to complete join for node %s: %w", node.Name, err)
+			}
+		}
+
+		nodes, err = clientset.ListNodes(ctx, ListNodesInput{})
+		if err != nil {
+			return fmt.Errorf("Unable to list nodes: %w", err)
+		}
+	}
+
+	output := []Node{}
+	cpuCapacity, cpuAllocatable := resource.Quantity{}, resource.Quantity{}
+	memoryCapacity, memoryAllocatable := resource.Quantity{}, resource.Quantity{}
+	for _, node := range nodes {
+		n := kubernetesNodeToNode(node)
+		if ready != "" && n.Ready != readyFilter {
+			continue
+		}
+
+		if role != "" {
+			hasRole := false
+			for _, nodeRole := range n.Roles {
+				if slices.Contains(roleFilters[role], nodeRole) {
+					hasRole = true
+					break
+				}
+			}
+			if !hasRole {
+				continue
+			}
+		}
+
+		output = append(output, n)
+		cpuCapacity.Add(node.Status.Capacity[corev1.ResourceCPU])
+		cpuAllocatable.Add(node.Status.Allocatable[corev1.ResourceCPU])
+		memoryCapacity.Add(node.Status.Capacity[corev1.ResourceMemory])
+		memoryAllocatable.Add(node.Status.Allocatable[corev1.ResourceMemory])
+	}
+
+	sortNodes(output, sortBy)
+
+	if format == "stdout" {
+		header := "name|ready|roles|version|age|join-status"
+		if extended {
+			header += "|internal-ip|external-ip|schedulable|architecture|pool|joined-at|managed-by|cpu-capacity|cpu-allocatable|memory-capacity|memory-allocatable"
+		}
+
+		lines := []string{header}
+		for _, node := range output {
+			if extended {
+				lines = append(lines, node.ExtendedString())
+			} else {
+				lines = append(lines, node.String())
+			}
+		}
+
+		if extended && len(output) > 1 {
+			// the name column is followed by the non-resource columns, which are left empty
+			totals := append([]string{"total"}, make([]string, 12)...)
+			totals = append(totals,
+				formatCPUQuantity(cpuCapacity),
+				formatCPUQuantity(cpuAllocatable),
+				formatMemoryQuantity(memoryCapacity),
+				formatMemoryQuantity(memoryAllocatable),
+			)
+			lines = append(lines, strings.Join(totals, "|"))
+		}
+
+		columnized := columnize.SimpleFormat(lines)
+		fmt.Println(columnized)
+		return nil
+	}
+
+	b, err := json.Marshal(output)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal json: %w", err)
+	}
+
+	fmt.Println(string(b))
+	return nil
+}
+
+// CommandClusterRelabelDefaults reapplies the role labels dokku manages to every node in the cluster
+func CommandClusterRelabelDefaults() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	clientset, err := NewKubernetesClient()
+	if err != nil {
+		return fmt.Errorf("Unable to create kubernetes client: %w", err)
+	}
+
+	if err := clientset.Ping(); err != nil {
+		return fmt.Errorf("kubernetes api not available, cannot relabel nodes: %w", err)
+	}
+
+	nodes, err := clientset.ListNodes(ctx, ListNodesInput{})
+	if err != nil {
+		return fmt.Errorf("Unable to list nodes: %w", err)
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Name < nodes[j].Name
+	})
+
+	corrected := []string{}
+	for _, node := range nodes {
+		// the k3s role labels reflect the process running on the node, so they take precedence over the stored role
+		changed, err := applyRoleLabels(ctx, clientset, node, getNodeLabelRole(node))
+		if err != nil {
+			return err
+		}
+		if changed {
+			corrected = append(corrected, node.Name)
+		}
+	}
+
+	if len(corrected) == 0 {
+		common.LogInfo1Quiet("All nodes have the expected role labels")
+		return nil
+	}
+
+	common.LogInfo1Quiet(fmt.Sprintf("Corrected role labels on %d node(s): %s", len(corrected), strings.Join(corrected, ", ")))
+	return nil
+}
+
+// CommandClusterRemove removes a node from the k3s cluster
+func CommandClusterRemove(nodeNames []string, selector string, force bool, allowDataLoss bool, format string, sshUser string, sshPort int, noDrain bool, drainGracePeriod int, drainTimeout int) error {
+	if len(nodeNames) == 0 && selector == "" {
+		return fmt.Errorf("Missing node name or --selector")
+	}
+
+	if format != "stdout" && format != "json" {
+		return fmt.Errorf("Invalid format specified, supported formats: json, stdout")
+	}
+
+	if err := isK3sInstalled(); err != nil {
+		return fmt.Errorf("k3s not installed, cannot remove node from cluster: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	clientset, err := NewKubernetesClient()
+	if err != nil {
+		return fmt.Errorf("Unable to create kubernetes client: %w", err)
+	}
+
+	if err := clientset.Ping(); err != nil {
+		return fmt.Errorf("kubernetes api not available: %w", err)
+	}
+
+	nodes, err := getNodesForRemoval(ctx, clientset, nodeNames, selector)
+	if err != nil {
+		return err
+	}
+
+	batch := len(nodeNames) > 1 || selector != ""
+	if batch && !force {
+		for _, node := range nodes {
+			if getNodeRole(node) == "server" {
+				return fmt.Errorf("Node %s is a server node, specify --force to remove server nodes in batch mode", node.Name)
+			}
+		}
+	}
+
+	summaries := []NodeRemovalSummary{}
+	for _, node := range nodes {
+		summary, err := getNodeRemovalSummary(ctx, clientset, node.Name)
+		if err != nil {
+			return fmt.Errorf("Unable to get workloads running on node %s: %w", node.Name, err)
+		}
+		summaries = append(summaries, summary)
+	}
+
+	if format == "json" {
+		b, err := json.Marshal(summaries)
+		if err != nil {
+			return fmt.Errorf("Unable to marshal json: %w", err)
+		}
+
+		fmt.Println(string(b))
+	} else {
+		for _, summary := range summaries {
+			if len(summary.Volumes) > 0 {
+				common.LogInfo2Quiet(fmt.Sprintf("Volumes with no healthy replica outside of node %s", summary.Node))
+				lines := []string{"volume|persistent-volume-claim|healthy-replicas|pods"}
+				for _, volume := range summary.Volumes {
+					lines = append(lines, fmt.Sprintf("%s|%s|%d|%s",
+						volume.Volume,
+						volume.PersistentVolumeClaim,
+						volume.HealthyReplicas,
+						strings.Join(volume.Pods, ","),
+					))
+				}
+
+				columnized := columnize.SimpleFormat(lines)
+				fmt.Println(columnized)
+			}
+
+			if len(summary.Workloads) == 0 {
+				common.LogInfo2Quiet(fmt.Sprintf("No workloads running on node %s", summary.Node))
+				continue
+			}
+
+			common.LogInfo2Quiet(fmt.Sprintf("Workloads running on node %s", summary.Node))
+			lines := []string{"namespace|pod|owner|pod-disruption-budget|volumes|risks"}
+			for _, workload := range summary.Workloads {
+				lines = append(lines, fmt.Sprintf("%s|%s|%s|%s|%s|%s",
+					workload.Namespace,
+					workload.Pod,
+					workload.Owner,
+					workload.PodDisruptionBudget,
+					strings.Join(workload.PersistentVolumeClaims, ","),
+					strings.Join(workload.Risks, "; "),
+				))
+			}
+
+			columnized := columnize.SimpleFormat(lines)
+			fmt.Println(columnized)
+		}
+	}
+
+	if !allowDataLoss {
+		for _, summary := range summaries {
+			if summary.Risky {
+				return fmt.Errorf("Node %s is storing volumes or running workloads at risk of data loss, specify --allow-data-loss to remove it anyway", summary.Node)
+			}
+		}
+	}
+
+	removed := []string{}
+	failed := map[string]error{}
+	for _, node := range nodes {
+		if ctx.Err() != nil {
+			failed[node.Name] = ctx.Err()
+			continue
+		}
+
+		common.LogInfo1Quiet(fmt.Sprintf("Removing %s from k3s cluster", node.Name))
+		err := removeClusterNode(ctx, RemoveClusterNodeInput{
+			Clientset:        clientset,
+			DrainGracePeriod: time.Duration(drainGracePeriod) * time.Second,
+			DrainTimeout:     time.Duration(drainTimeout) * time.Second,
+			NodeName:         node.Name,
+			NoDrain:          noDrain,
+			SshPort:          sshPort,
+			SshUser:          sshUser,
+		})
+		if err != nil {
+			if !batch {
+				return err
+			}
+
+			common.LogWarn(fmt.Sprintf("Unable to remove %s: %s", node.Name, err.Error()))
+			failed[node.Name] = err
+			continue
+		}
+
+		common.LogVerboseQuiet("Done")
+		removed = append(removed, node.Name)
+	}
+
+	if !batch {
+		return nil
+	}
+
+	common.LogInfo1Quiet(fmt.Sprintf("Removed %d of %d node(s)", len(removed), len(nodes)))
+	for _, nodeName := range removed {
+		common.LogVerboseQuiet(fmt.Sprintf("Removed: %s", nodeName))
+	}
+	for _, node := range nodes {
+		if err, ok := failed[node.Name]; ok {
+			common.LogVerboseQuiet(fmt.Sprintf("Failed: %s (%s)", node.Name, err.Error()))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("Unable to remove %d node(s)", len(failed))
+	}
+
+	return nil
+}
+
+// CommandClusterToken prints the token used to join nodes to the cluster, optionally rotating it first
+func CommandClusterToken(show bool, nodeToken bool, regenerate bool, force bool) error {
+	if err := isK3sInstalled(); err != nil {
+		return fmt.Errorf("k3s not installed, cannot show token: %w", err)
+	}
+
+	if regenerate && nodeToken {
+		return fmt.Errorf("The --regenerate flag cannot be used with --node-token")
+	}
+
+	if regenerate {
+		if err := CommandTokenRotate(force); err != nil {
+			return err
+		}
+	}
+
+	token := getGlobalGlobalToken()
+	if nodeToken {
+		nodeTokenPath := filepath.Join(getGlobalDataDir(), "server", "node-token")
+		b, err := os.ReadFile(nodeTokenPath)
+		if errors.Is(err, os.ErrPermission) {
+			return fmt.Errorf("Unable to read k3s node token %s, the command must be run as root: %w", nodeTokenPath, err)
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to read k3s node token %s, only server nodes have a node token: %w", nodeTokenPath, err)
+		}
+		token = strings.TrimSpace(string(b))
+	}
+
+	if len(token) == 0 {
+		return fmt.Errorf("Missing k3s token")
+	}
+
+	if !show {
+		common.LogWarn("The token is a secret that allows joining nodes to the cluster, specify --show to display it")
+		fmt.Println(redactToken(token))
+		return nil
+	}
+
+	fmt.Println(token)
+	return nil
+}
+
+// CommandClusterUpgrade upgrades k3s on all nodes in the cluster via the system-upgrade-controller
+func CommandClusterUpgrade(version string, concurrency int, noDrain bool, timeout int, order string) error {
+	if version == "" {
+		return fmt.Errorf("Missing k3s version")
+	}
+	if !k3sVersionRegex.MatchString(version) {
+		return fmt.Errorf("Invalid k3s version, expected a version such as v1.30.2+k3s1: %s", version)
+	}
+	if concurrency < 1 {
+		return fmt.Errorf("Invalid concurrency, expected a positive integer: %d", concurrency)
+	}
+	if timeout < 1 {
+		return fmt.Errorf("Invalid timeout, expected a positive integer: %d", timeout)
+	}
+	if order != "workers-first" && order != "servers-first" {
+		return fmt.Errorf("Invalid order specified, supported orders: workers-first, servers-first")
+	}
+
+	if err := isK3sInstalled(); err != nil {
+		return fmt.Errorf("k3s not installed, cannot upgrade cluster: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	clientset, err := NewKubernetesClient()
+	if err != nil {
+		return fmt.Errorf("Unable to create kubernetes client: %w", err)
+	}
+
+	if err := clientset.Ping(); err != nil {
+		return fmt.Errorf("kubernetes api not available, cannot upgrade cluster: %w", err)
+	}
+
+	nodes, err := clientset.ListNodes(ctx, ListNodesInput{})
+	if err != nil {
+		return fmt.Errorf("Unable to list nodes: %w", err)
+	}
+
+	pending := 0
+	for _, kubeNode := range nodes {
+		node := kubernetesNodeToNode(kubeNode)
+		comparison, err := compareK3sVersions(version, node.Version)
+		if err != nil {
+			return fmt.Errorf("Unable to compare version of node %s: %w", node.Name, err)
+		}
+		if comparison < 0 {
+			return fmt.Errorf("Refusing to downgrade node %s from %s to %s", node.Name, node.Version, version)
+		}
+		if comparison > 0 {
+			pending++
+		}
+	}
+
+	if pending == 0 {
+		common.LogInfo1Quiet(fmt.Sprintf("All nodes are already running k3s %s", version))
+		return nil
+	}
+
+	common.LogInfo1Quiet(fmt.Sprintf("Upgrading %d node(s) to k3s %s", pending, version))
+	for _, plan := range getK3sUpgradePlans(version, concurrency, !noDrain, order) {
+		common.LogVerboseQuiet(fmt.Sprintf("Applying upgrade plan %s", plan.Name))
+		if err := clientset.ApplyUpgradePlan(ctx, plan); err != nil {
+			return fmt.Errorf("Unable to apply upgrade plan %s: %w", plan.Name, err)
+		}
+	}
+
+	common.LogInfo1Quiet("Waiting for nodes to upgrade")
+	timeoutCtx, timeoutCancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer timeoutCancel()
+
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	nodeVersions := map[string]string{}
+	upgraded := 0
+	total := len(nodes)
+	for {
+		nodes, err := clientset.ListNodes(timeoutCtx, ListNodesInput{})
+		if err != nil && timeoutCtx.Err() == nil {
+			return fmt.Errorf("Unable to list nodes: %w", err)
+		}
+
+		if err == nil {
+			upgraded = 0
+			total = len(nodes)
+		}
+		for _, kubeNode := range nodes {
+			node := kubernetesNodeToNode(kubeNode)
+			if node.Version == version {
+				upgraded++
+			}
+
+			if previous, ok := nodeVersions[node.Name]; ok && previous == node.Version {
+				continue
+			}
+
+			status := "pending"
+			if node.Version == version {
+				status = "upgraded"
+			} else if !node.Schedulable {
+				status = "upgrading"
+			}
+			common.LogVerboseQuiet(fmt.Sprintf("%s: %s (%s)", node.Name, status, node.Version))
+			nodeVersions[node.Name] = node.Version
+		}
+
+		if err == nil && upgraded == total {
+			break
+		}
+
+		select {
+		case <-timeoutCtx.Done():
+			if ctx.Err() != nil {
+				return fmt.Errorf("Upgrade interrupted after %d of %d node(s), the upgrade plans remain applied and will continue in the cluster", upgraded, total)
+			}
+			return fmt.Errorf("Timed out waiting for upgrade after %d of %d node(s), the upgrade plans remain applied and will continue in the cluster", upgraded, total)
+		case <-ticker.C:
+		}
+	}
+
+	if getGlobalK3sVersion() != "" {
+		if err := common.PropertyWrite("scheduler-k3s", "--global", "k3s-version", version); err != nil {
+			return fmt.Errorf("Unable to update k3s-version property: %w", err)
+		}
+	}
+
+	common.LogInfo1Quiet(fmt.Sprintf("Upgraded all nodes to k3s %s", version))
+	return nil
+}
+
+// CommandCordon marks a node as unschedulable, optionally evicting the pods running on it
+func CommandCordon(nodeName string, drain bool, drainGracePeriod int, drainTimeout int) error {
+	if nodeName == "" {
+		return fmt.Errorf("Missing node name")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	clientset, err := NewKubernetesClient()
+	if err != nil {
+		return fmt.Errorf("Unable to create kubernetes client: %w", err)
+	}
+
+	if err := clientset.Ping(); err != nil {
+		return fmt.Errorf("kubernetes api not available, cannot cordon node: %w", err)
+	}
+
+	common.LogInfo1Quiet(fmt.Sprintf("Cordoning node %s", nodeName))
+	err = clientset.CordonNode(ctx, CordonNodeInput{
+		Name: nodeName,
+	})
+	if k8serrors.IsNotFound(err) {
+		return newKindError(ErrNodeNotFound, err, fmt.Sprintf("Node %s not found in the cluster", nodeName))
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to cordon node: %w", err)
+	}
+
+	if drain {
+		common.LogVerboseQuiet("Draining node")
+		drainOutput, err := clientset.DrainNode(ctx, DrainNodeInput{
+			Name:        nodeName,
+			GracePeriod: time.Duration(drainGracePeriod) * time.Second,
+			Timeout:     time.Duration(drainTimeout) * time.Second,
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to drain node, the node remains cordoned: %w", err)
+		}
+		common.LogVerboseQuiet(fmt.Sprintf("Evicted %d pod(s) from node", drainOutput.EvictedPods))
+	}
+
+	common.LogVerboseQuiet("Done")
+	return nil
+}
+
+// CommandDNSConfigure sets the upstream dns servers and stub domains used by CoreDNS
+func CommandDNSConfigure(upstreamServers []string, stubDomainValues []string, clearConfig bool) error {
+	if clearConfig && (len(upstreamServers) > 0 || len(stubDomainValues) > 0) {
+		return fmt.Errorf("The --clear flag cannot be used with --upstream or --stub-domain")
+	}
+	if !clearConfig && len(upstreamServers) == 0 && len(stubDomainValues) == 0 {
+		return fmt.Errorf("Specify at least one --upstream or --stub-domain, or --clear to remove the dns configuration")
+	}
+
+	for _, server := range upstreamServers {
+		if err := validateDNSServer(server); err != nil {
+			return err
+		}
+	}
+
+	stubDomains := []DNSStubDomain{}
+	for _, value := range stubDomainValues {
+		stubDomain, err := parseDNSStubDomain(value)
+		if err != nil {
+			return err
+		}
+		stubDomains = slices.DeleteFunc(stubDomains, func(existing DNSStubDomain) bool {
+			return existing.Domain == stubDomain.Domain
+		})
+		stubDomains = append(stubDomains, stubDomain)
+	}
+	slices.SortFunc(stubDomains, func(a, b DNSStubDomain) int {
+		return strings.Compare(a.Domain, b.Domain)
+	})
+
+	previousUpstreamServers := getDNSUpstreamServers()
+	if len(upstreamServers) == 0 {
+		if err := common.PropertyDelete("scheduler-k3s", "--global", DNSUpstreamServersProperty); err != nil {
+			return fmt.Errorf("Unable to clear dns upstream servers: %w", err)
+		}
+	} else if err := common.PropertyWrite("scheduler-k3s", "--global", DNSUpstreamServersProperty, strings.Join(upstreamServers, ",")); err != nil {
+		return fmt.Errorf("Unable to store dns upstream servers: %w", err)
+	}
+
+	if len(stubDomains) == 0 {
+		if err := common.PropertyDelete("scheduler-k3s", "--global", DNSStubDomainsProperty); err != nil {
+			return fmt.Errorf("Unable to clear dns stub domains: %w", err)
+		}
+	} else {
+		data, err := json.Marshal(stubDomains)
+		if err != nil {
+			return fmt.Errorf("Unable to marshal dns stub domains: %w", err)
+		}
+		if err := common.PropertyWrite("scheduler-k3s", "--global", DNSStubDomainsProperty, string(data)); err != nil {
+			return fmt.Errorf("Unable to store dns stub domains: %w", err)
+		}
+	}
+
+	if err := isK3sInstalled(); err != nil {
+		common.LogVerboseQuiet("k3s not installed, dns configuration will be applied on initialize")
+		return nil
+	}
+
+	// k3s applies the manifest cluster-wide, and an empty configmap removes previously configured stub domains
+	common.LogInfo1Quiet("Writing CoreDNS custom config")
+	contents, err := renderCoreDNSCustomConfig(stubDomains)
+	if err != nil {
+		return fmt.Errorf("Unable to render CoreDNS custom config: %w", err)
+	}
+	if err := writeCoreDNSCustomConfig(contents); err != nil {
+		return err
+	}
+
+	if len(upstreamServers) == 0 {
+		if len(previousUpstreamServers) > 0 {
+			common.LogWarn(fmt.Sprintf("Nodes started with --resolv-conf continue to use the last upstream servers written to %s until they are re-added to the cluster", DNSResolvConfPath))
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	clientset, err := NewKubernetesClient()
+	if err != nil {
+		return fmt.Errorf("Unable to create kubernetes client: %w", err)
+	}
+
+	if err := clientset.Ping(); err != nil {
+		return fmt.Errorf("kubernetes api not available, cannot apply dns upstream servers: %w", err)
+	}
+
+	nodes, err := clientset.ListNodes(ctx, ListNodesInput{})
+	if err != nil {
+		return fmt.Errorf("Unable to list nodes: %w", err)
+	}
+
+	resolvConf := renderDNSResolvConf(upstreamServers)
+	remoteErrs := []error{}
+	for _, node := range nodes {
+		isLocal, err := isLocalNode(node)
+		if err != nil {
+			return fmt.Errorf("Unable to determine if node is local: %w", err)
+		}
+		if isLocal {
+			continue
+		}
+
+		remoteHost := getNodeRemoteHost(node)
+		if remoteHost == "" {
+			common.LogWarn(fmt.Sprintf("Unable to find remote host for %s, skipping", node.Name))
+			continue
+		}
+
+		common.LogInfo2Quiet(fmt.Sprintf("Copying dns resolver config to %s", node.Name))
+		err = copyFileToNode(ctx, CopyFileToNodeInput{
+			AllowUknownHosts: getNodeAllowUknownHosts(node),
+			Contents:         resolvConf,
+			Path:             DNSResolvConfPath,
+			RemoteHost:       remoteHost,
+		})
+		if err != nil {
+			common.LogWarn(fmt.Sprintf("Unable to copy dns resolver config to %s: %s", node.Name, err.Error()))
+			remoteErrs = append(remoteErrs, fmt.Errorf("%s: %w", node.Name, err))
+		}
+	}
+
+	common.LogInfo2Quiet("Writing dns resolver config to local node")
+	if err := writeDNSResolvConf(resolvConf); err != nil {
+		return err
+	}
+
+	if len(previousUpstreamServers) == 0 {
+		common.LogWarn("Upstream servers only apply to nodes initialized or added while upstream servers are configured, existing nodes must be re-added to the cluster")
+	}
+	common.LogVerboseQuiet("CoreDNS pods use the new upstream servers once restarted via 'kubectl -n kube-system rollout restart deployment coredns'")
+
+	if len(remoteErrs) > 0 {
+		return fmt.Errorf("Unable to apply dns upstream servers to %d remote node(s): %w", len(remoteErrs), errors.Join(remoteErrs...))
+	}
+
+	return nil
+}
+
+// CommandDoctor runs diagnostic checks against the local k3s installation and the cluster
+func CommandDoctor(format string) error {
+	if format != "stdout" && format != "json" {
+		return fmt.Errorf("Invalid format: %s", format)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	checks := getDoctorChecks(ctx)
+	failed := 0
+	for _, check := range checks {
+		if !check.Passed {
+			failed++
+		}
+	}
+
+	if format == "stdout" {
+		lines := []string{"check|status|message"}
+		for _, check := range checks {
+			status := "pass"
+			if !check.Passed {
+				status = "fail"
+			}
+			lines = append(lines, fmt.Sprintf("%s|%s|%s", check.Name, status, check.Message))
+		}
+
+		columnized := columnize.SimpleFormat(lines)
+		fmt.Println(columnized)
+
+		for _, check := range checks {
+			if !check.Passed && check.Remediation != "" {
+				common.LogWarn(fmt.Sprintf("%s: %s", check.Name, check.Remediation))
+			}
+		}
+	} else {
+		b, err := json.Marshal(checks)
+		if err != nil {
+			return fmt.Errorf("Unable to marshal json: %w", err)
+		}
+
+		fmt.Println(string(b))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d check(s) failed", failed, len(checks))
+	}
+
+	return nil
+}
+
+// CommandImagePullSecretCreate creates or updates a docker-registry secret and records it as the image pull secret for an app
+func CommandImagePullSecretCreate(appName string, server string, username string, password string, name string) error {
+	if appName == "" {
+		return fmt.Errorf("Missing app name or --global")
+	}
+
+	if appName != "--global" {
+		if err := common.VerifyAppName(appName); err != nil {
+			return err
+		}
+	}
+
+	if server == "" {
+		return fmt.Errorf("Missing --server")
+	}
+	if username == "" {
+		return fmt.Errorf("Missing --username")
+	}
+	if password == "" {
+		return fmt.Errorf("Missing --password")
+	}
+
+	if name == "" {
+		name = "dokku-image-pull-secret"
+		if appName != "--global" {
+			name = fmt.Sprintf("%s-image-pull-secret", appName)
+		}
+	}
+
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return fmt.Errorf("Invalid secret name %s: %s", name, strings.Join(errs, ", "))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	clientset, err := NewKubernetesClient()
+	if err != nil {
+		return fmt.Errorf("Unable to create kubernetes client: %w", err)
+	}
+
+	if err := clientset.Ping(); err != nil {
+		return fmt.Errorf("kubernetes api not available, cannot create image pull secret: %w", err)
+	}
+
+	dockerConfig, err := renderDockerConfigJSON(server, username, password)
+	if err != nil {
+		return fmt.Errorf("Unable to render docker config: %w", err)
+	}
+
+	common.LogInfo1Quiet(fmt.Sprintf("Creating image pull secret %s", name))
+	for _, namespace := range getImagePullSecretNamespaces(appName) {
+		if err := createKubernetesNamespace(ctx, namespace); err != nil {
+			return fmt.Errorf("Unable to create namespace %s: %w", namespace, err)
+		}
+
+		common.LogVerboseQuiet(fmt.Sprintf("Writing secret to namespace %s", namespace))
+		_, err := clientset.CreateOrUpdateSecret(ctx, CreateOrUpdateSecretInput{
+			Secret: corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+					Labels: map[string]string{
+						"dokku.com/managed": "true",
+					},
+				},
+				Type: corev1.SecretTypeDockerConfigJson,
+				Data: map[string][]byte{
+					corev1.DockerConfigJsonKey: dockerConfig,
+				},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to write secret to namespace %s: %w", namespace, err)
+		}
+	}
+
+	if err := common.PropertyWrite("scheduler-k3s", appName, "image-pull-secrets", name); err != nil {
+		return fmt.Errorf("Unable to set image-pull-secrets property: %w", err)
+	}
+
+	common.LogVerboseQuiet("Image pull secret will be used on next deploy")
+	return nil
+}
+
+// CommandImagePullSecretDelete deletes a docker-registry secret, clearing the image pull secret for an app if it matches
+func CommandImagePullSecretDelete(appName string, name string) error {
+	if appName == "" {
+		return fmt.Errorf("Missing app name or --global")
+	}
+
+	if appName != "--global" {
+		if err := common.VerifyAppName(appName); err != nil {
+			return err
+		}
+	}
+
+	property := common.PropertyGet("scheduler-k3s", appName, "image-pull-secrets")
+	if name == "" {
+		name = property
+	}
+	if name == "" {
+		return fmt.Errorf("Missing secret name and no image-pull-secrets property is set")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	clientset, err := NewKubernetesClient()
+	if err != nil {
+		return fmt.Errorf("Unable to create kubernetes client: %w", err)
+	}
+
+	if err := clientset.Ping(); err != nil {
+		return fmt.Errorf("kubernetes api not available, cannot delete image pull secret: %w", err)
+	}
+
+	common.LogInfo1Quiet(fmt.Sprintf("Deleting image pull secret %s", name))
+	for _, namespace := range getImagePullSecretNamespaces(appName) {
+		common.LogVerboseQuiet(fmt.Sprintf("Deleting secret from namespace %s", namespace))
+		err := clientset.DeleteSecret(ctx, DeleteSecretInput{
+			Name:      name,
+			Namespace: namespace,
+		})
+		if k8serrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to delete secret from namespace %s: %w", namespace, err)
+		}
+	}
+
+	if property == name {
+		if err := common.PropertyDelete("scheduler-k3s", appName, "image-pull-secrets"); err != nil {
+			return fmt.Errorf("Unable to clear image-pull-secrets property: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// CommandLabelsSet set or clear a scheduler-k3s label for an app
+func CommandLabelsSet(appName string, processType string, resourceType string, key string, value string) error {
+	if resourceType == "" {
+		return fmt.Errorf("Missing resource-type")
+	}
+
+	if processType == "" {
+		processType = GlobalProcessType
+	}
+
+	property := fmt.Sprintf("labels.%s.%s", processType, resourceType)
+	labelsList, err := common.PropertyListGet("scheduler-k3s", appName, property)
+	if err != nil {
+		return fmt.Errorf("Unable to get property list: %w", err)
+	}
+
+	labels := []string{}
+	for _, annotation := range labelsList {
+		parts := strings.SplitN(annotation, ": ", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("Invalid annotation: %s", annotation)
+		}
+		if key == parts[0] {
+			continue
+		}
+
+		labels = append(labels, annotation)
+	}
+
+	if value != "" {
+		labels = append(labels, fmt.Sprintf("%s: %s", key, value))
+	}
+
+	sort.Strings(labels)
+	if err := common.PropertyListWrite("scheduler-k3s", appName, property, labels); err != nil {
+		return fmt.Errorf("Unable to write property list: %w", err)
+	}
+
+	return nil
+}
+
+// CommandLogs displays the logs of the k3s service on a node, or of the pods of a core component
+func CommandLogs(nodeName string, component string, lines int64, follow bool) error {
+	if lines < 0 {
+		return fmt.Errorf("Invalid lines value, expected a non-negative integer: %d", lines)
+	}
+
+	if component != "" && nodeName != "" {
+		return fmt.Errorf("A node name cannot be specified with --component")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	if component == "" && nodeName == "" {
+		if err := isK3sInstalled(); err != nil {
+			return fmt.Errorf("k3s not installed, cannot show logs: %w", err)
+		}
+
+		return streamServiceJournal(ctx, StreamServiceJournalInput{
+			Follow:  follow,
+			Lines:   lines,
+			Service: "k3s",
+		})
+	}
+
+	clientset, err := NewKubernetesClient()
+	if err != nil {
+		return fmt.Errorf("Unable to create kubernetes client: %w", err)
+	}
+
+	if err := clientset.Ping(); err != nil {
+		return fmt.Errorf("kubernetes api not available, cannot show logs: %w", err)
+	}
+
+	if component != "" {
+		index := slices.IndexFunc(HelmCharts, func(chart HelmChart) bool {
+			return chart.ReleaseName == component
+		})
+		if index == -1 {
+			components := []string{}
+			for _, chart := range HelmCharts {
+				components = append(components, chart.ReleaseName)
+			}
+			return fmt.Errorf("Invalid component, expected one of %s: %s", strings.Join(components, ", "), component)
+		}
+
+		namespace := HelmCharts[index].Namespace
+		pods, err := clientset.ListPods(ctx, ListPodsInput{
+			Namespace: namespace,
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to list pods in %s: %w", namespace, err)
+		}
+		if len(pods) == 0 {
+			return fmt.Errorf("No pods found for %s in %s", component, namespace)
+		}
+
+		errs, ctx := errgroup.WithContext(ctx)
+		for _, pod := range pods {
+			podName := pod.Name
+			if !follow {
+				if err := streamPodLogs(ctx, clientset, namespace, podName, lines, follow); err != nil {
+					return err
+				}
+				continue
+			}
+
+			errs.Go(func() error {
+				return streamPodLogs(ctx, clientset, namespace, podName, lines, follow)
+			})
+		}
+		return errs.Wait()
+	}
+
+	nodes, err := clientset.ListNodes(ctx, ListNodesInput{})
+	if err != nil {
+		return fmt.Errorf("Unable to list nodes: %w", err)
+	}
+
+	index := slices.IndexFunc(nodes, func(node corev1.Node) bool {
+		return node.Name == nodeName
+	})
+	if index == -1 {
+		return newKindError(ErrNodeNotFound, nil, fmt.Sprintf("Node %s not found in the cluster", nodeName))
+	}
+	node := nodes[index]
+
+	service := "k3s-agent"
+	if getNodeRole(node) == "server" {
+		service = "k3s"
+	}
+
+	isLocal, err := isLocalNode(node)
+	if err != nil {
+		return fmt.Errorf("Unable to determine if node is local: %w", err)
+	}
+	if isLocal {
+		return streamServiceJournal(ctx, StreamServiceJournalInput{
+			Follow:  follow,
+			Lines:   lines,
+			Service: service,
+		})
+	}
+
+	remoteHost := getNodeRemoteHost(node)
+	if remoteHost == "" {
+		return fmt.Errorf("Unable to find remote host for %s, the node was not added via scheduler-k3s:cluster-add", nodeName)
+	}
+
+	return streamServiceJournal(ctx, StreamServiceJournalInput{
+		AllowUknownHosts: getNodeAllowUknownHosts(node),
+		Follow:           follow,
+		Lines:            lines,
+		RemoteHost:       remoteHost,
+		Service:          service,
+	})
+}
+
+// CommandManifestDiff displays the changes applying the kubernetes manifests would make to the cluster
+func CommandManifestDiff(manifestName string) error {
+	manifests := KubernetesManifests
+	if manifestName != "" {
+		index := slices.IndexFunc(KubernetesManifests, func(manifest Manifest) bool {
+			return manifest.Name == manifestName
+		})
+		if index == -1 {
+			return fmt.Errorf("Manifest %s not found", manifestName)
+		}
+
+		manifests = []Manifest{KubernetesManifests[index]}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	clientset, err := NewKubernetesClient()
+	if err != nil {
+		return fmt.Errorf("Unable to create kubernetes client: %w", err)
+	}
+
+	if err := clientset.Ping(); err != nil {
+		return fmt.Errorf("kubernetes api not available, cannot diff manifests: %w", err)
+	}
+
+	for _, manifest := range manifests {
+		common.LogInfo1Quiet(fmt.Sprintf("Diffing %s@%s", manifest.Name, manifest.Version))
+		output, err := clientset.ApplyKubernetesManifest(ctx, ApplyKubernetesManifestInput{
+			DryRun:   true,
+			Manifest: manifest.Path,
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to diff kubernetes manifest: %w", err)
+		}
+
+		if !output.Changed {
+			common.LogVerboseQuiet("No changes")
+			continue
+		}
+
+		fmt.Println(output.Diff)
+	}
+
+	return nil
+}
+
+// CommandNodeAnnotationsList lists the annotations set on a node
+func CommandNodeAnnotationsList(nodeName string, format string) error {
+	if nodeName == "" {
+		return fmt.Errorf("Missing node name")
+	}
+
+	if format != "stdout" && format != "json" {
+		return fmt.Errorf("Invalid format: %s", format)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	clientset, err := NewKubernetesClient()
+	if err != nil {
+		return fmt.Errorf("Unable to create kubernetes client: %w", err)
+	}
+
+	if err := clientset.Ping(); err != nil {
+		return fmt.Errorf("kubernetes api not available, cannot list node annotations: %w", err)
+	}
+
+	nodes, err := clientset.ListNodes(ctx, ListNodesInput{})
+	if err != nil {
+		return fmt.Errorf("Unable to list nodes: %w", err)
+	}
+
+	var annotations map[string]string
+	for _, node := range nodes {
+		if node.Name == nodeName {
+			annotations = node.Annotations
+			if annotations == nil {
+				annotations = map[string]string{}
+			}
+			break
+		}
+	}
+
+	if annotations == nil {
+		return newKindError(ErrNodeNotFound, nil, fmt.Sprintf("Node %s not found in cluster", nodeName))
+	}
+
+	if format == "stdout" {
+		keys := []string{}
+		for key := range annotations {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		lines := []string{"key|value"}
+		for _, key := range keys {
+			lines = append(lines, fmt.Sprintf("%s|%s", key, annotations[key]))
+		}
+
+		columnized := columnize.SimpleFormat(lines)
+		fmt.Println(columnized)
+		return nil
+	}
+
+	b, err := json.Marshal(annotations)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal json: %w", err)
+	}
+
+	fmt.Println(string(b))
+	return nil
+}
+
+// CommandNodeAnnotationsSet sets an annotation on a node
+func CommandNodeAnnotationsSet(nodeName string, key string, value string) error {
+	if nodeName == "" {
+		return fmt.Errorf("Missing node name")
+	}
+
+	if key == "" {
+		return fmt.Errorf("Missing annotation key")
+	}
+
+	if value == "" {
+		return fmt.Errorf("Missing annotation value, use node-annotations:unset to remove an annotation")
+	}
+
+	if strings.HasPrefix(key, ProtectedAnnotationPrefix) {
+		return fmt.Errorf("Annotations prefixed with %s are managed by dokku and cannot be modified: %s", ProtectedAnnotationPrefix, key)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	clientset, err := NewKubernetesClient()
+	if err != nil {
+		return fmt.Errorf("Unable to create kubernetes client: %w", err)
+	}
+
+	if err := clientset.Ping(); err != nil {
+		return fmt.Errorf("kubernetes api not available, cannot set node annotation: %w", err)
+	}
+
+	common.LogInfo1Quiet(fmt.Sprintf("Annotating node %s with %s=%s", nodeName, key, value))
+	err = clientset.AnnotateNode(ctx, AnnotateNodeInput{
+		Name:  nodeName,
+		Key:   key,
+		Value: value,
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to annotate node: %w", err)
+	}
+
+	return nil
+}
+
+// CommandNodeAnnotationsUnset removes an annotation from a node
+func CommandNodeAnnotationsUnset(nodeName string, key string) error {
+	if nodeName == "" {
+		return fmt.Errorf("Missing node name")
+	}
+
+	if key == "" {
+		return fmt.Errorf("Missing annotation key")
+	}
+
+	if strings.HasPrefix(key, ProtectedAnnotationPrefix) {
+		return fmt.Errorf("Annotations prefixed with %s are managed by dokku and cannot be modified: %s", ProtectedAnnotationPrefix, key)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	clientset, err := NewKubernetesClient()
+	if err != nil {
+		return fmt.Errorf("Unable to create kubernetes client: %w", err)
+	}
+
+	if err := clientset.Ping(); err != nil {
+		return fmt.Errorf("kubernetes api not available, cannot unset node annotation: %w", err)
+	}
+
+	common.LogInfo1Quiet(fmt.Sprintf("Removing annotation %s from node %s", key, nodeName))
+	err = clientset.RemoveNodeAnnotation(ctx, RemoveNodeAnnotationInput{
+		Name: nodeName,
+		Key:  key,
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to remove node annotation: %w", err)
+	}
+
+	return nil
+}
+
+// CommandPendingPods lists all pending pods in the cluster along with the reason they have not been scheduled
+func CommandPendingPods(format string) error {
+	if format != "stdout" && format != "json" {
+		return fmt.Errorf("Invalid format: %s", format)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	clientset, err := NewKubernetesClient()
+	if err != nil {
+		return fmt.Errorf("Unable to create kubernetes client: %w", err)
+	}
+
+	if err := clientset.Ping(); err != nil {
+		return fmt.Errorf("kubernetes api not available, cannot list pending pods: %w", err)
+	}
+
+	pods, err := clientset.ListPods(ctx, ListPodsInput{})
+	if err != nil {
+		return fmt.Errorf("Unable to list pods: %w", err)
+	}
+
+	events, err := clientset.ListEvents(ctx, ListEventsInput{
+		FieldSelector: "involvedObject.kind=Pod,reason=FailedScheduling",
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to list events: %w", err)
+	}
+
+	latestEvents := map[string]corev1.Event{}
+	for _, event := range events {
+		key := fmt.Sprintf("%s/%s", event.InvolvedObject.Namespace, event.InvolvedObject.Name)
+		if latest, ok := latestEvents[key]; ok && latest.LastTimestamp.After(event.LastTimestamp.Time) {
+			continue
+		}
+		latestEvents[key] = event
+	}
+
+	output := []PendingPod{}
+	for _, pod := range pods {
+		if pod.Status.Phase != corev1.PodPending {
+			continue
+		}
+
+		reason := ""
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse {
+				reason = condition.Message
+				if reason == "" {
+					reason = condition.Reason
+				}
+			}
+		}
+
+		if event, ok := latestEvents[fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)]; ok && event.Message != "" {
+			reason = event.Message
+		}
+
+		if reason == "" {
+			reason = "scheduled, waiting for containers to start"
+		}
+
+		output = append(output, PendingPod{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+			AppName:   pod.Labels["app.kubernetes.io/part-of"],
+			Age:       duration.HumanDuration(time.Since(pod.CreationTimestamp.Time)),
+			Reason:    reason,
+		})
+	}
+
+	sort.Slice(output, func(i, j int) bool {
+		if output[i].Namespace != output[j].Namespace {
+			return output[i].Namespace < output[j].Namespace
+		}
+		return output[i].Name < output[j].Name
+	})
+
+	if format == "stdout" {
+		lines := []string{"namespace|name|app|age|reason"}
+		for _, pod := range output {
+			lines = append(lines, pod.String())
+		}
+
+		columnized := columnize.SimpleFormat(lines)
+		fmt.Println(columnized)
+		return nil
+	}
+
+	b, err := json.Marshal(output)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal json: %w", err)
+	}
+
+	fmt.Println(string(b))
+	return nil
+}
+
+// CommandPoolList lists the node pools in the cluster and the nodes in each pool
+func CommandPoolList(format string) error {
+	if format != "stdout" && format != "json" {
+		return fmt.Errorf("Invalid format: %s", format)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	clientset, err := NewKubernetesClient()
+	if err != nil {
+		return fmt.Errorf("Unable to create kubernetes client: %w", err)
+	}
+
+	if err := clientset.Ping(); err != nil {
+		return fmt.Errorf("kubernetes api not available, cannot list node pools: %w", err)
+	}
+
+	pools, err := getNodePools(ctx, clientset)
+	if err != nil {
+		return err
+	}
+
+	if format == "stdout" {
+		lines := []string{"pool|nodes|ready|node-names"}
+		for _, pool := range pools {
+			lines = append(lines, pool.String())
+		}
+
+		columnized := columnize.SimpleFormat(lines)
+		fmt.Println(columnized)
+		return nil
+	}
+
+	b, err := json.Marshal(pools)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal json: %w", err)
+	}
+
+	fmt.Println(string(b))
+	return nil
+}
+
+// CommandRegistryMirror adds a mirror endpoint for an upstream registry and applies it to all nodes in the cluster
+func CommandRegistryMirror(upstream string, endpoint string) error {
+	if endpoint == "" {
+		return fmt.Errorf("Missing registry mirror endpoint")
+	}
+
+	upstream = normalizeRegistryHost(upstream)
+	if err := validateRegistryMirrorEndpoint(endpoint); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	mirrors, err := getRegistryMirrors()
+	if err != nil {
+		return err
+	}
+
+	index := slices.IndexFunc(mirrors, func(mirror RegistryMirror) bool {
+		return mirror.Registry == upstream
+	})
+	if index == -1 {
+		common.LogInfo1Quiet(fmt.Sprintf("Setting registry mirror for %s to %s", upstream, endpoint))
+		mirrors = append(mirrors, RegistryMirror{
+			Registry: upstream,
+			Endpoint: endpoint,
+		})
+		return applyRegistryMirrors(ctx, mirrors)
+	}
+
+	if slices.Contains(mirrors[index].GetEndpoints(), endpoint) {
+		common.LogInfo1Quiet(fmt.Sprintf("Registry mirror %s is already configured for %s", endpoint, upstream))
+		return nil
+	}
+
+	common.LogInfo1Quiet(fmt.Sprintf("Adding registry mirror %s for %s", endpoint, upstream))
+	mirrors[index].ExtraEndpoints = append(mirrors[index].ExtraEndpoints, endpoint)
+	return applyRegistryMirrors(ctx, mirrors)
+}
+
+// CommandRegistrySet sets or clears a registry mirror and applies it to all nodes in the cluster
+func CommandRegistrySet(registry string, endpoint string, username string, password string) error {
+	if registry == "" {
+		return fmt.Errorf("Missing registry host")
+	}
+	registry = normalizeRegistryHost(registry)
+
+	if endpoint == "" && (username != "" || password != "") {
+		return fmt.Errorf("Credentials cannot be specified when clearing a registry mirror")
+	}
+
+	if (username == "") != (password == "") {
+		return fmt.Errorf("Both --username and --password must be specified")
+	}
+
+	if endpoint != "" {
+		if err := validateRegistryMirrorEndpoint(endpoint); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	mirrors, err := getRegistryMirrors()
+	if err != nil {
+		return err
+	}
+
+	mirrors = slices.DeleteFunc(mirrors, func(mirror RegistryMirror) bool {
+		return mirror.Registry == registry
+	})
+	if endpoint == "" {
+		common.LogInfo1Quiet(fmt.Sprintf("Removing registry mirror for %s", registry))
+	} else {
+		common.LogInfo1Quiet(fmt.Sprintf("Setting registry mirror for %s to %s", registry, endpoint))
+		mirrors = append(mirrors, RegistryMirror{
+			Registry: registry,
+			Endpoint: endpoint,
+			Username: username,
+			Password: password,
+		})
+	}
+	return applyRegistryMirrors(ctx, mirrors)
+}
+
+// CommandReport displays a scheduler-k3s report for one or more apps
+func CommandReport(appName string, format string, infoFlag string, parallel int) error {
+	if len(appName) == 0 {
+		if parallel == -1 {
+			parallel = runtime.NumCPU()
+		}
+		if parallel < 1 {
+			return fmt.Errorf("Invalid value %d for --parallel flag", parallel)
+		}
+
+		apps, err := common.DokkuApps()
+		if err != nil {
+			return err
+		}
+		sort.Strings(apps)
+
+		// reports are collected in parallel but displayed in app order
+		type appReport struct {
+			infoFlags map[string]string
+			flagKeys  []string
+			err       error
+		}
+		reports := make([]appReport, len(apps))
+		g := errgroup.Group{}
+		g.SetLimit(parallel)
+		for i, appName := range apps {
+			i, appName := i, appName
+			g.Go(func() error {
+				infoFlags, flagKeys, err := collectAppReport(appName, infoFlag)
+				reports[i] = appReport{
+					infoFlags: infoFlags,
+					flagKeys:  flagKeys,
+					err:       err,
+				}
+				return nil
+			})
+		}
+		_ = g.Wait()
+
+		reportErrs := []error{}
+		for i, appName := range apps {
+			err := reports[i].err
+			if err == nil {
+				err = displayAppReport(appName, format, infoFlag, reports[i].infoFlags, reports[i].flagKeys)
+			}
+			if err != nil {
+				common.LogWarn(fmt.Sprintf("Unable to report on %s: %s", appName, err.Error()))
+				reportErrs = append(reportErrs, fmt.Errorf("%s: %w", appName, err))
+			}
+		}
+
+		if len(reportErrs) > 0 {
+			return fmt.Errorf("Unable to report on %d app(s): %w", len(reportErrs), errors.Join(reportErrs...))
+		}
+		return nil
+	}
+
+	return ReportSingleApp(appName, format, infoFlag)
+}
+
+// CommandResourcesAudit lists app process types whose deployments are missing cpu or memory limits
+func CommandResourcesAudit(format string) error {
+	if format != "stdout" && format != "json" {
+		return fmt.Errorf("Invalid format: %s", format)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	clientset, err := NewKubernetesClient()
+	if err != nil {
+		return fmt.Errorf("Unable to create kubernetes client: %w", err)
+	}
+
+	if err := clientset.Ping(); err != nil {
+		return fmt.Errorf("kubernetes api not available, cannot audit resources: %w", err)
+	}
+
+	deployments, err := clientset.ListDeployments(ctx, ListDeploymentsInput{
+		LabelSelector: "app.kubernetes.io/part-of",
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to list deployments: %w", err)
+	}
+
+	output := []ResourceLimitsAudit{}
+	for _, deployment := range deployments {
+		audit := ResourceLimitsAudit{
+			AppName:     deployment.Labels["app.kubernetes.io/part-of"],
+			ProcessType: deployment.Labels["app.kubernetes.io/name"],
+			Namespace:   deployment.Namespace,
+		}
+
+		for _, container := range deployment.Spec.Template.Spec.Containers {
+			if _, ok := container.Resources.Limits[corev1.ResourceCPU]; !ok {
+				audit.MissingCPULimit = true
+			}
+			if _, ok := container.Resources.Limits[corev1.ResourceMemory]; !ok {
+				audit.MissingMemoryLimit = true
+			}
+		}
+
+		if audit.MissingCPULimit || audit.MissingMemoryLimit {
+			output = append(output, audit)
+		}
+	}
+
+	sort.Slice(output, func(i, j int) bool {
+		if output[i].AppName != output[j].AppName {
+			return output[i].AppName < output[j].AppName
+		}
+		return output[i].ProcessType < output[j].ProcessType
+	})
+
+	if format == "stdout" {
+		lines := []string{"app|process-type|namespace|cpu-limit|memory-limit"}
+		for _, audit := range output {
+			lines = append(lines, audit.String())
+		}
+
+		columnized := columnize.SimpleFormat(lines)
+		fmt.Println(columnized)
+		return nil
+	}
+
+	b, err := json.Marshal(output)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal json: %w", err)
+	}
+
+	fmt.Println(string(b))
+	return nil
+}
+
+// CommandSet set or clear a scheduler-k3s property for an app
+func CommandSet(appName string, property string, value string, force bool) error {
+	if err := validateProperty(appName, property, value); err != nil {
+		return err
+	}
+
+	if appName == "--global" && (property == "cluster-cidr" || property == "service-cidr") {
+		if err := isK3sInstalled(); err == nil {
+			return fmt.Errorf("Unable to change %s, the pod and service networks cannot be changed after the cluster is initialized", property)
+		}
+	}
+
+	if appName == "--global" && property == "token" {
+		if err := isK3sInstalled(); err == nil {
+			common.LogWarn("The cluster is already initialized, changing the stored token does not change the token used by the cluster and will break future node joins")
+			common.LogWarn("Use scheduler-k3s:token:rotate to rotate the token on every node instead")
+		}
 	}
 
-	common.LogInfo2Quiet(fmt.Sprintf("Adding %s k3s cluster", nodeName))
-	joinCmd, err := common.CallSshCommand(common.SshCommandInput{
-		Command:          "/tmp/k3s-installer.sh",
-		Args:             args,
-		AllowUknownHosts: allowUknownHosts,
-		RemoteHost:       remoteHost,
-		StreamStdio:      true,
-		Sudo:             true,
-	})
-	if err != nil {
-		return fmt.Errorf("Unable to call k3s installer command over ssh: %w", err)
-	}
-	if joinCmd.ExitCode != 0 {
-		return fmt.Errorf("Invalid exit code from k3s installer command over ssh: %d", joinCmd.ExitCode)
+	if appName == "--global" && property == "flannel-wireguard-port" {
+		if err := isK3sInstalled(); err == nil {
+			common.LogWarn("The cluster is already initialized and all nodes must use the same wireguard port. Existing nodes are not reconfigured, and cluster-add continues to use the port recorded when the cluster was initialized")
+		}
 	}
 
-	common.LogInfo2Quiet("Waiting for node to exist")
-	nodes, err := waitForNodeToExist(ctx, WaitForNodeToExistInput{
-		Clientset:  clientset,
-		NodeName:   nodeName,
-		RetryCount: 20,
-	})
-	if err != nil {
-		return fmt.Errorf("Error waiting for pod to exist: %w", err)
+	if appName == "--global" && property == "loadbalancer" && !force {
+		loadbalancer := value
+		if loadbalancer == "" {
+			loadbalancer = DefaultLoadbalancer
+		}
+		if err := isK3sInstalled(); err == nil && loadbalancer != getGlobalLoadbalancer() {
+			return fmt.Errorf("Refusing to change the loadbalancer of an initialized cluster from %s to %s, as servicelb and metallb are not reconfigured on existing nodes. use --force to change it anyway", getGlobalLoadbalancer(), loadbalancer)
+		}
 	}
-	if len(nodes) == 0 {
-		return fmt.Errorf("Unable to find node after joining cluster, node will not be annotated/labeled appropriately access registry secrets")
+
+	if appName == "--global" && property == "ingress-class" && !force {
+		ingressClass := value
+		if ingressClass == "" {
+			ingressClass = DefaultIngressClass
+		}
+		if err := isK3sInstalled(); err == nil && ingressClass != getGlobalIngressClass() {
+			return fmt.Errorf("Refusing to change the ingress-class of an initialized cluster from %s to %s, as the installed ingress controller is not migrated. use --force to change it anyway", getGlobalIngressClass(), ingressClass)
+		}
 	}
 
-	labels := ServerLabels
-	if role == "worker" {
-		labels = WorkerLabels
+	common.CommandPropertySet("scheduler-k3s", appName, property, value, DefaultProperties, GlobalProperties)
+
+	if property == "namespace" && value != "" {
+		if err := isKubernetesAvailable(); err != nil {
+			common.LogVerboseQuiet("Kubernetes api not available, the namespace will be created on the next deploy")
+			return nil
+		}
+
+		common.LogVerboseQuiet(fmt.Sprintf("Ensuring namespace %s exists", value))
+		if err := createKubernetesNamespace(context.Background(), value); err != nil {
+			return fmt.Errorf("Unable to create namespace %s: %w", value, err)
+		}
 	}
 
-	for key, value := range labels {
-		common.LogInfo2Quiet(fmt.Sprintf("Labeling node %s=%s", key, value))
+	if appName == "--global" && property == "metallb-address-pool" && value != "" && getGlobalLoadbalancer() == "metallb" {
+		if err := isKubernetesAvailable(); err != nil {
+			common.LogVerboseQuiet("Kubernetes api not available, the address pool will be configured on the next initialize")
+			return nil
+		}
+
+		clientset, err := NewKubernetesClient()
 		if err != nil {
 			return fmt.Errorf("Unable to create kubernetes client: %w", err)
 		}
 
-		err = clientset.LabelNode(ctx, LabelNodeInput{
-			Name:  nodeName,
-			Key:   key,
-			Value: value,
-		})
+		common.LogVerboseQuiet("Configuring metallb address pool")
+		if err := applyMetalLBAddressPool(context.Background(), clientset); err != nil {
+			return fmt.Errorf("Unable to configure metallb address pool: %w", err)
+		}
+	}
+
+	letsencryptProperties := map[string]bool{
+		"letsencrypt-email-prod": true,
+		"letsencrypt-email-stag": true,
+		"letsencrypt-server":     true,
+	}
+	if appName == "--global" && letsencryptProperties[property] {
+		return applyClusterIssuers(context.Background())
+	}
+
+	return nil
+}
+
+// CommandShowKubeconfig displays the kubeconfig file contents
+func CommandShowKubeconfig(serverURL string, detectServerURL bool, format string, includeSecrets bool) error {
+	if format != "stdout" && format != "json" {
+		return fmt.Errorf("Invalid format specified, supported formats: json, stdout")
+	}
+
+	kubeconfigPath := getKubeconfigPath()
+	if !common.FileExists(kubeconfigPath) {
+		return fmt.Errorf("Kubeconfig file does not exist: %s", kubeconfigPath)
+	}
+
+	b, err := os.ReadFile(kubeconfigPath)
+	if err != nil {
+		return fmt.Errorf("Unable to read kubeconfig file: %w", err)
+	}
+
+	if serverURL == "" && detectServerURL {
+		serverIP, err := getServerIP()
+		if err != nil {
+			return fmt.Errorf("Unable to get server ip address: %w", err)
+		}
+		serverURL = fmt.Sprintf("https://%s", net.JoinHostPort(serverIP, "6443"))
+	}
+
+	if serverURL != "" {
+		b, err = rewriteKubeconfigServer(b, serverURL, getKubeContext())
 		if err != nil {
-			return fmt.Errorf("Unable to patch node: %w", err)
+			return err
 		}
 	}
 
-	common.LogInfo2Quiet("Annotating node with connection information")
-	err = clientset.AnnotateNode(ctx, AnnotateNodeInput{
-		Name:  nodes[0].Name,
-		Key:   "dokku.com/remote-host",
-		Value: remoteHost,
-	})
+	if format == "stdout" {
+		fmt.Println(string(b))
+		return nil
+	}
+
+	summary, err := summarizeKubeconfig(b, getKubeContext(), includeSecrets)
 	if err != nil {
-		return fmt.Errorf("Unable to patch node: %w", err)
+		return err
 	}
 
-	common.LogVerboseQuiet("Done")
+	out, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal kubeconfig: %w", err)
+	}
+
+	fmt.Println(string(out))
 	return nil
 }
 
-// CommandClusterList lists the nodes in the k3s cluster
-func CommandClusterList(format string) error {
-	if format != "stdout" && format != "json" {
-		return fmt.Errorf("Invalid format: %s", format)
+// CommandTaintAdd adds a taint to a node
+func CommandTaintAdd(nodeName string, taintValue string) error {
+	if nodeName == "" {
+		return fmt.Errorf("Missing node name")
+	}
+
+	if taintValue == "" {
+		return fmt.Errorf("Missing taint")
+	}
+
+	taint, err := parseTaint(taintValue, true)
+	if err != nil {
+		return err
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -695,43 +3902,87 @@ func CommandClusterList(format string) error {
 	}
 
 	if err := clientset.Ping(); err != nil {
-		return fmt.Errorf("kubernetes api not available, cannot list cluster nodes: %w", err)
+		return fmt.Errorf("kubernetes api not available, cannot taint node: %w", err)
 	}
 
-	nodes, err := clientset.ListNodes(ctx, ListNodesInput{})
+	common.LogInfo1Quiet(fmt.Sprintf("Tainting node %s with %s", nodeName, taint.ToString()))
+	err = clientset.TaintNode(ctx, TaintNodeInput{
+		Name:  nodeName,
+		Taint: taint,
+	})
 	if err != nil {
-		return fmt.Errorf("Unable to list nodes: %w", err)
+		return fmt.Errorf("Unable to taint node: %w", err)
 	}
 
-	output := []Node{}
-	for _, node := range nodes {
-		output = append(output, kubernetesNodeToNode(node))
+	return nil
+}
+
+// CommandTaintRemove removes a taint from a node
+func CommandTaintRemove(nodeName string, taintValue string) error {
+	if nodeName == "" {
+		return fmt.Errorf("Missing node name")
 	}
 
-	if format == "stdout" {
-		lines := []string{"name|ready|roles|version"}
-		for _, node := range output {
-			lines = append(lines, node.String())
-		}
+	if taintValue == "" {
+		return fmt.Errorf("Missing taint")
+	}
 
-		columnized := columnize.SimpleFormat(lines)
-		fmt.Println(columnized)
-		return nil
+	taint, err := parseTaint(taintValue, false)
+	if err != nil {
+		return err
 	}
 
-	b, err := json.Marshal(output)
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	clientset, err := NewKubernetesClient()
 	if err != nil {
-		return fmt.Errorf("Unable to marshal json: %w", err)
+		return fmt.Errorf("Unable to create kubernetes client: %w", err)
+	}
+
+	if err := clientset.Ping(); err != nil {
+		return fmt.Errorf("kubernetes api not available, cannot remove node taint: %w", err)
+	}
+
+	common.LogInfo1Quiet(fmt.Sprintf("Removing taint %s from node %s", taintValue, nodeName))
+	err = clientset.UntaintNode(ctx, UntaintNodeInput{
+		Name:   nodeName,
+		Key:    taint.Key,
+		Effect: taint.Effect,
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to remove taint from node: %w", err)
 	}
 
-	fmt.Println(string(b))
 	return nil
 }
 
-// CommandClusterRemove removes a node from the k3s cluster
-func CommandClusterRemove(nodeName string) error {
+// CommandTokenRotate rotates the k3s token used to join nodes to the cluster
+func CommandTokenRotate(force bool) error {
 	if err := isK3sInstalled(); err != nil {
-		return fmt.Errorf("k3s not installed, cannot remove node from cluster: %w", err)
+		return fmt.Errorf("k3s not installed, cannot rotate token: %w", err)
+	}
+
+	if !force {
+		return fmt.Errorf("Rotating the k3s token invalidates the token used by in-progress node joins, specify --force to continue")
+	}
+
+	oldToken := getGlobalGlobalToken()
+	if len(oldToken) == 0 {
+		return fmt.Errorf("Missing k3s token")
+	}
+
+	nodeWaitTimeout, err := strconv.Atoi(getGlobalNodeWaitTimeout())
+	if err != nil {
+		return fmt.Errorf("Invalid node-wait-timeout value: %w", err)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -745,168 +3996,244 @@ func CommandClusterRemove(nodeName string) error {
 		cancel()
 	}()
 
-	common.LogInfo1Quiet(fmt.Sprintf("Removing %s from k3s cluster", nodeName))
 	clientset, err := NewKubernetesClient()
 	if err != nil {
 		return fmt.Errorf("Unable to create kubernetes client: %w", err)
 	}
 
 	if err := clientset.Ping(); err != nil {
-		return fmt.Errorf("kubernetes api not available: %w", err)
+		return fmt.Errorf("kubernetes api not available, cannot rotate token: %w", err)
 	}
 
-	common.LogVerboseQuiet("Getting node remote connection information")
-	node, err := clientset.GetNode(ctx, GetNodeInput{
-		Name: nodeName,
-	})
+	nodes, err := clientset.ListNodes(ctx, ListNodesInput{})
 	if err != nil {
-		return fmt.Errorf("Unable to get node: %w", err)
-	}
-
-	common.LogVerboseQuiet("Checking if node is a remote node managed by Dokku")
-	if node.RemoteHost == "" {
-		return fmt.Errorf("Node %s is not a remote node managed by Dokku", nodeName)
+		return fmt.Errorf("Unable to list nodes: %w", err)
 	}
 
-	common.LogVerboseQuiet("Uninstalling k3s on remote host")
-	removeCmd, err := common.CallSshCommand(common.SshCommandInput{
-		Command:          "/usr/local/bin/k3s-uninstall.sh",
-		Args:             []string{},
-		AllowUknownHosts: true,
-		RemoteHost:       node.RemoteHost,
-		StreamStdio:      true,
-		Sudo:             true,
-	})
+	newToken, err := generateToken()
 	if err != nil {
-		return fmt.Errorf("Unable to call k3s uninstall command over ssh: %w", err)
+		return err
 	}
 
-	if removeCmd.ExitCode != 0 {
-		return fmt.Errorf("Invalid exit code from k3s uninstall command over ssh: %d", removeCmd.ExitCode)
+	// store the new token before rotating, so a failed write never leaves the cluster with a token dokku does not know
+	if err := common.PropertyWrite("scheduler-k3s", "--global", "token", newToken); err != nil {
+		return fmt.Errorf("Unable to store new k3s token, the token was not rotated: %w", err)
 	}
 
-	common.LogVerboseQuiet("Deleting node from k3s cluster")
-	err = clientset.DeleteNode(ctx, DeleteNodeInput{
-		Name: nodeName,
+	common.LogInfo1Quiet("Rotating k3s token")
+	rotateCmd, err := common.CallExecCommand(common.ExecCommandInput{
+		Command: "/usr/local/bin/k3s",
+		Args:    append([]string{"token", "rotate", "--token", oldToken, "--new-token", newToken}, getK3sDataDirArgs()...),
 	})
+	if err == nil && rotateCmd.ExitCode != 0 {
+		err = fmt.Errorf("Invalid exit code from k3s token rotate command: %d %s", rotateCmd.ExitCode, strings.TrimSpace(rotateCmd.Stderr))
+	}
 	if err != nil {
-		return fmt.Errorf("Unable to delete node: %w", err)
+		if restoreErr := common.PropertyWrite("scheduler-k3s", "--global", "token", oldToken); restoreErr != nil {
+			return fmt.Errorf("Unable to rotate k3s token, and unable to restore the previously stored token, future node joins will fail until the token property matches the cluster token: %w", errors.Join(err, restoreErr))
+		}
+		return fmt.Errorf("Unable to rotate k3s token: %w", err)
 	}
 
-	common.LogVerboseQuiet("Done")
-
-	return nil
-}
-
-// CommandLabelsSet set or clear a scheduler-k3s label for an app
-func CommandLabelsSet(appName string, processType string, resourceType string, key string, value string) error {
-	if resourceType == "" {
-		return fmt.Errorf("Missing resource-type")
+	common.LogInfo2Quiet("Updating token on local node")
+	sedCmd, err := common.CallExecCommand(common.ExecCommandInput{
+		Command: "sed",
+		Args:    []string{"-i", fmt.Sprintf("s/%s/%s/g", oldToken, newToken), "/etc/systemd/system/k3s.service"},
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to call sed command: %w", err)
 	}
-
-	if processType == "" {
-		processType = GlobalProcessType
+	if sedCmd.ExitCode != 0 {
+		return fmt.Errorf("Invalid exit code from sed command: %d", sedCmd.ExitCode)
 	}
 
-	property := fmt.Sprintf("labels.%s.%s", processType, resourceType)
-	labelsList, err := common.PropertyListGet("scheduler-k3s", appName, property)
+	reloadCmd, err := common.CallExecCommand(common.ExecCommandInput{
+		Command: "systemctl",
+		Args:    []string{"daemon-reload"},
+	})
 	if err != nil {
-		return fmt.Errorf("Unable to get property list: %w", err)
+		return fmt.Errorf("Unable to call systemctl daemon-reload command: %w", err)
+	}
+	if reloadCmd.ExitCode != 0 {
+		return fmt.Errorf("Invalid exit code from systemctl daemon-reload command: %d", reloadCmd.ExitCode)
 	}
 
-	labels := []string{}
-	for _, annotation := range labelsList {
-		parts := strings.SplitN(annotation, ": ", 2)
-		if len(parts) != 2 {
-			return fmt.Errorf("Invalid annotation: %s", annotation)
+	remoteErrs := []error{}
+	for _, node := range nodes {
+		isLocal, err := isLocalNode(node)
+		if err != nil {
+			return fmt.Errorf("Unable to determine if node is local: %w", err)
 		}
-		if key == parts[0] {
+		if isLocal {
 			continue
 		}
 
-		labels = append(labels, annotation)
-	}
+		remoteHost := getNodeRemoteHost(node)
+		if remoteHost == "" {
+			common.LogWarn(fmt.Sprintf("Unable to find remote host for %s, the token must be updated manually", node.Name))
+			continue
+		}
 
-	if value != "" {
-		labels = append(labels, fmt.Sprintf("%s: %s", key, value))
-	}
+		// servers must be restarted to pick up the new token, while running agents remain connected
+		service := "k3s-agent"
+		restart := false
+		roles := kubernetesNodeToNode(node).Roles
+		if slices.Contains(roles, "control-plane") || slices.Contains(roles, "master") {
+			service = "k3s"
+			restart = true
+		}
 
-	sort.Strings(labels)
-	if err := common.PropertyListWrite("scheduler-k3s", appName, property, labels); err != nil {
-		return fmt.Errorf("Unable to write property list: %w", err)
+		common.LogInfo2Quiet(fmt.Sprintf("Updating token on %s", node.Name))
+		err = updateNodeToken(ctx, UpdateNodeTokenInput{
+			AllowUknownHosts: getNodeAllowUknownHosts(node),
+			NewToken:         newToken,
+			OldToken:         oldToken,
+			RemoteHost:       remoteHost,
+			Restart:          restart,
+			Service:          service,
+		})
+		if err != nil {
+			common.LogWarn(fmt.Sprintf("Unable to update token on %s: %s", node.Name, err.Error()))
+			remoteErrs = append(remoteErrs, fmt.Errorf("%s: %w", node.Name, err))
+		}
 	}
 
-	return nil
-}
-
-// CommandReport displays a scheduler-k3s report for one or more apps
-func CommandReport(appName string, format string, infoFlag string) error {
-	if len(appName) == 0 {
-		apps, err := common.DokkuApps()
+	common.LogInfo2Quiet("Waiting for nodes to remain ready")
+	notReady := []error{}
+	for _, node := range nodes {
+		err := waitForNodeReady(ctx, WaitForNodeReadyInput{
+			Clientset: clientset,
+			NodeName:  node.Name,
+			Backoff:   1 * time.Second,
+			Timeout:   time.Duration(nodeWaitTimeout) * time.Second,
+		})
 		if err != nil {
-			return err
-		}
-		for _, appName := range apps {
-			if err := ReportSingleApp(appName, format, infoFlag); err != nil {
-				return err
-			}
+			notReady = append(notReady, fmt.Errorf("%s: %w", node.Name, err))
 		}
-		return nil
 	}
 
-	return ReportSingleApp(appName, format, infoFlag)
-}
-
-// CommandSet set or clear a scheduler-k3s property for an app
-func CommandSet(appName string, property string, value string) error {
-	common.CommandPropertySet("scheduler-k3s", appName, property, value, DefaultProperties, GlobalProperties)
-
-	letsencryptProperties := map[string]bool{
-		"letsencrypt-email-prod": true,
-		"letsencrypt-email-stag": true,
-		"letsencrypt-server":     true,
+	common.LogWarn("The k3s token has been rotated, nodes must now be joined with the new token")
+	if len(remoteErrs) > 0 {
+		return fmt.Errorf("Unable to update token on %d remote node(s), update the token in their k3s service manually: %w", len(remoteErrs), errors.Join(remoteErrs...))
 	}
-	if appName == "--global" && letsencryptProperties[property] {
-		return applyClusterIssuers(context.Background())
+	if len(notReady) > 0 {
+		return fmt.Errorf("Token rotated but %d node(s) are not ready: %w", len(notReady), errors.Join(notReady...))
 	}
 
 	return nil
 }
 
-// CommandShowKubeconfig displays the kubeconfig file contents
-func CommandShowKubeconfig() error {
-	kubeconfigPath := getKubeconfigPath()
-	if !common.FileExists(kubeconfigPath) {
-		return fmt.Errorf("Kubeconfig file does not exist: %s", kubeconfigPath)
+// CommandUncordon marks a node as schedulable
+func CommandUncordon(nodeName string) error {
+	if nodeName == "" {
+		return fmt.Errorf("Missing node name")
 	}
 
-	b, err := os.ReadFile(kubeconfigPath)
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	clientset, err := NewKubernetesClient()
 	if err != nil {
-		return fmt.Errorf("Unable to read kubeconfig file: %w", err)
+		return fmt.Errorf("Unable to create kubernetes client: %w", err)
 	}
 
-	fmt.Println(string(b))
+	if err := clientset.Ping(); err != nil {
+		return fmt.Errorf("kubernetes api not available, cannot uncordon node: %w", err)
+	}
+
+	common.LogInfo1Quiet(fmt.Sprintf("Uncordoning node %s", nodeName))
+	err = clientset.UncordonNode(ctx, UncordonNodeInput{
+		Name: nodeName,
+	})
+	if k8serrors.IsNotFound(err) {
+		return newKindError(ErrNodeNotFound, err, fmt.Sprintf("Node %s not found in the cluster", nodeName))
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to uncordon node: %w", err)
+	}
 
 	return nil
 }
 
-func CommandUninstall() error {
+// CommandUninstall uninstalls k3s from the Dokku server, and optionally from all remote nodes
+func CommandUninstall(all bool, force bool) error {
 	if err := isK3sInstalled(); err != nil {
 		return fmt.Errorf("k3s not installed, cannot uninstall: %w", err)
 	}
 
+	if all && !force {
+		return fmt.Errorf("Uninstalling k3s from all nodes destroys the cluster, specify --force to continue")
+	}
+
+	remoteErrs := []error{}
+	if all {
+		ctx, cancel := context.WithCancel(context.Background())
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
+			syscall.SIGINT,
+			syscall.SIGQUIT,
+			syscall.SIGTERM)
+		go func() {
+			<-signals
+			cancel()
+		}()
+
+		clientset, err := NewKubernetesClient()
+		if err != nil {
+			return fmt.Errorf("Unable to create kubernetes client: %w", err)
+		}
+
+		if err := clientset.Ping(); err != nil {
+			return fmt.Errorf("kubernetes api not available, cannot uninstall remote nodes: %w", err)
+		}
+
+		nodes, err := clientset.ListNodes(ctx, ListNodesInput{})
+		if err != nil {
+			return fmt.Errorf("Unable to list nodes: %w", err)
+		}
+
+		for _, node := range nodes {
+			remoteHost := getNodeRemoteHost(node)
+			if remoteHost == "" {
+				continue
+			}
+
+			common.LogInfo1(fmt.Sprintf("Removing %s from k3s cluster", node.Name))
+			if err := removeRemoteNode(ctx, clientset, node.Name, remoteHost); err != nil {
+				common.LogWarn(fmt.Sprintf("Unable to remove %s: %s", node.Name, err.Error()))
+				remoteErrs = append(remoteErrs, fmt.Errorf("%s: %w", node.Name, err))
+			}
+		}
+	}
+
 	common.LogInfo1("Uninstalling k3s")
 	uninstallerCmd, err := common.CallExecCommand(common.ExecCommandInput{
 		Command:     "/usr/local/bin/k3s-uninstall.sh",
-		StreamStdio: true,
+		StreamStdio: shouldStreamStdio(),
 	})
 	if err != nil {
 		return fmt.Errorf("Unable to call k3s uninstaller command: %w", err)
 	}
 	if uninstallerCmd.ExitCode != 0 {
-		return fmt.Errorf("Invalid exit code from k3s uninstaller command: %d", uninstallerCmd.ExitCode)
+		return exitCodeError("k3s uninstaller command", uninstallerCmd.ExitCode, uninstallerCmd.Stdout, uninstallerCmd.Stderr)
 	}
 
 	common.LogInfo2Quiet("Removing k3s dependencies")
-	return uninstallHelperCommands(context.Background())
+	if err := uninstallHelperCommands(context.Background()); err != nil {
+		return err
+	}
+
+	if len(remoteErrs) > 0 {
+		return fmt.Errorf("Unable to remove %d remote node(s), k3s may need to be uninstalled manually: %w", len(remoteErrs), errors.Join(remoteErrs...))
+	}
+
+	return nil
 }
@@ -159,9 +159,9 @@ func TriggerSchedulerDeploy(scheduler string, appName string, imageTag string) e
 		return fmt.Errorf("Error getting deploying app image name: %w", err)
 	}
 
-	deployTimeout := getComputedDeployTimeout(appName)
-	if _, err := strconv.Atoi(deployTimeout); err == nil {
-		deployTimeout = fmt.Sprintf("%ss", deployTimeout)
+	deployTimeout, err := parseDeployTimeout(getComputedDeployTimeout(appName))
+	if err != nil {
+		return err
 	}
 
 	deployRollback := getComputedRollbackOnFailure(appName)
@@ -182,12 +182,9 @@ func TriggerSchedulerDeploy(scheduler string, appName string, imageTag string) e
 		return fmt.Errorf("Error loading environment for deployment: %w", err)
 	}
 
-	issuerName := "letsencrypt-stag"
-	server := getComputedLetsencryptServer(appName)
-	if server == "prod" || server == "production" {
-		issuerName = "letsencrypt-prod"
-	} else if server != "stag" && server != "staging" {
-		return fmt.Errorf("Invalid letsencrypt server config: %s", server)
+	issuerName, err := getLetsencryptIssuerName(getComputedLetsencryptServer(appName), getGlobalLetsencryptServer())
+	if err != nil {
+		return err
 	}
 
 	tlsEnabled := false
@@ -196,9 +193,13 @@ func TriggerSchedulerDeploy(scheduler string, appName string, imageTag string) e
 	if issuerName == "letsencrypt-stag" {
 		tlsEnabled = letsencryptEmailStag != ""
 	}
-	if issuerName == "letsencrypt-prod" {
+	if issuerName == "letsencrypt-prod" || issuerName == LetsencryptCustomIssuerName {
 		tlsEnabled = letsencryptEmailProd != ""
 	}
+	if tlsEnabled && !getGlobalInstallCertManager() {
+		common.LogWarn("Skipping letsencrypt certificates, install-cert-manager is false")
+		tlsEnabled = false
+	}
 
 	chartDir, err := os.MkdirTemp("", "dokku-chart-")
 	if err != nil {
@@ -329,6 +330,23 @@ func TriggerSchedulerDeploy(scheduler string, appName string, imageTag string) e
 		return fmt.Errorf("kubernetes api not available: %w", err)
 	}
 
+	if dependsOn := getDependsOn(appName); dependsOn != "" {
+		if err := common.VerifyAppName(dependsOn); err != nil {
+			return fmt.Errorf("Error verifying depends-on app: %w", err)
+		}
+
+		common.LogInfo1Quiet(fmt.Sprintf("Waiting for %s to be ready", dependsOn))
+		err = waitForAppDeploymentsReady(ctx, WaitForAppDeploymentsReadyInput{
+			AppName:   dependsOn,
+			Clientset: clientset,
+			Namespace: getComputedNamespace(dependsOn),
+			Timeout:   deployTimeout,
+		})
+		if err != nil {
+			return fmt.Errorf("Dependency %s is not ready: %w", dependsOn, err)
+		}
+	}
+
 	kedaValues, err := getKedaValues(ctx, clientset, appName)
 	if err != nil {
 		return fmt.Errorf("Error getting keda values: %w", err)
@@ -644,11 +662,6 @@ func TriggerSchedulerDeploy(scheduler string, appName string, imageTag string) e
 		return fmt.Errorf("Error getting chart path: %w", err)
 	}
 
-	timeoutDuration, err := time.ParseDuration(deployTimeout)
-	if err != nil {
-		return fmt.Errorf("Error parsing deploy timeout duration: %w", err)
-	}
-
 	ingresses, err := clientset.ListIngresses(ctx, ListIngressesInput{
 		Namespace:     namespace,
 		LabelSelector: fmt.Sprintf("app.kubernetes.io/instance=%s-web", appName),
@@ -687,11 +700,47 @@ func TriggerSchedulerDeploy(scheduler string, appName string, imageTag string) e
 		Namespace:         namespace,
 		ReleaseName:       appName,
 		RollbackOnFailure: allowRollbacks,
-		Timeout:           timeoutDuration,
+		Timeout:           deployTimeout,
 		Wait:              true,
 	})
 	if err != nil {
-		return err
+		if !isDeployTimeoutError(err) {
+			return err
+		}
+
+		if allowRollbacks {
+			return fmt.Errorf("Timed out after %s waiting for %s to become ready, the release has been rolled back: %w", deployTimeout, appName, err)
+		}
+
+		notReady, listErr := getNotReadyDeployments(ctx, clientset, appName, namespace)
+		if listErr != nil || len(notReady) == 0 {
+			return fmt.Errorf("Timed out after %s waiting for %s to become ready: %w", deployTimeout, appName, err)
+		}
+
+		return fmt.Errorf("Timed out after %s waiting for %s to become ready, resources not ready: %s: %w", deployTimeout, appName, strings.Join(notReady, ", "), err)
+	}
+
+	spreadCheck := getComputedSpreadCheck(appName)
+	if spreadCheck != "off" {
+		common.LogInfo2("Checking replica spread")
+		clustered, err := checkReplicaSpread(ctx, CheckReplicaSpreadInput{
+			AppName:      appName,
+			Clientset:    clientset,
+			DeploymentID: fmt.Sprint(deploymentId),
+			Namespace:    namespace,
+			Processes:    processes,
+		})
+		if err != nil {
+			return fmt.Errorf("Error checking replica spread: %w", err)
+		}
+
+		if len(clustered) > 0 {
+			message := fmt.Sprintf("Replicas for the following process types are not spread across all available nodes: %s", strings.Join(clustered, ", "))
+			if spreadCheck == "fail" {
+				return errors.New(message)
+			}
+			common.LogWarn(message)
+		}
 	}
 
 	common.LogInfo1("Running post-deploy")